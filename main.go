@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"zipcode/src/agent"
-	"zipcode/src/config"
+	"zipcode/src/bootstrap"
 	"zipcode/src/utils"
 	"zipcode/src/view"
 	"zipcode/src/workspace"
@@ -13,8 +16,21 @@ import (
 )
 
 func main() {
-	if err := config.Load(); err != nil {
-		log.Fatalf("failed to load config: %v", err)
+	flags, err := bootstrap.ParseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(bootstrap.ExitOK)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zipcode:", err)
+		os.Exit(bootstrap.ExitUsage)
+	}
+
+	if err := bootstrap.Startup(flags); err != nil {
+		log.Fatal(err)
+	}
+
+	if flags.Headless() {
+		os.Exit(bootstrap.RunHeadless(flags, os.Stdin, os.Stdout, os.Stderr))
 	}
 
 
@@ -41,22 +57,4 @@ func main() {
 
 	app := tuix.NewApp(width, height)
 	app.Run(view.App, tuix.Props{Values: map[string]any{"runtime": &runtime, "wd": dir}})
-
-	// agent debugging
-	// config.Cfg.Headless = true
-	// // dir, err := os.Getwd()
-
-	// // if err != nil {
-	// // 	log.Fatal("Failed to get current directory. Error: ", err.Error())
-	// // }
-
-	// workspace := workspace.Load("/Users/anirban/Documents/Code/zipcode")
-	// runtime := agent.NewRuntime(&workspace)
-	// _, err := runtime.Run("Read and analyze app.go file")
-	// // _, err := runtime.Run("The image routes randomly fail with a 500 error. Diagnose the issue and fix it.")
-	// // runtime.Run("What version of typescript is being used in this project?")
-	// // runtime.Run("Add the above information in a file packages.md")
-	// if err != nil {
-	// 	panic(err)
-	// }
 }
@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"zipcode/src/config"
 	"zipcode/src/credentials"
+	llmerrors "zipcode/src/llm/errors"
 	llm "zipcode/src/llm/provider"
 	"zipcode/src/tools"
 )
@@ -18,6 +21,57 @@ type Agent struct {
 	initial      bool
 	Validator    credentials.Validator
 	Config       config.Config
+	// OnStream receives partial output while a completion is in flight. When
+	// nil, or when the active provider cannot stream, Chat falls back to a
+	// blocking Complete call.
+	OnStream llm.StreamHandler
+	// Provider and Model override the active provider and model for this
+	// agent; sub-agents set them from their definition. Empty values fall
+	// back to the global configuration. MaxTokens and Temperature are sent
+	// when non-zero.
+	Provider    string
+	Model       string
+	MaxTokens   int
+	Temperature float64
+	// ReasoningEffort overrides the reasoning_effort configured for the
+	// model.
+	ReasoningEffort string
+	// OnFallback is called when the model failed with cause and the agent
+	// switched to the next model of its fallback chain.
+	OnFallback func(cause *llmerrors.ClassifiedError, next config.FallbackModel)
+}
+
+func (a *Agent) providerName() string {
+	if a.Provider != "" {
+		return a.Provider
+	}
+	return config.Cfg.ActiveProviderName
+}
+
+func (a *Agent) modelName() string {
+	if a.Model != "" {
+		return a.Model
+	}
+	return config.Cfg.CurrentModel
+}
+
+// reasoningEffort returns the effort sent with each request to model: the
+// agent's own, else the one configured for the model, else the model's
+// default.
+func (a *Agent) reasoningEffort(providerName, model string) (string, error) {
+	effort := a.ReasoningEffort
+	if effort == "" {
+		effort = config.Cfg.ReasoningEffort[model]
+	}
+	if effort == "" && a.Registry != nil {
+		effort = a.Registry.EffortFor(llm.ProviderName(providerName), model)
+	}
+
+	parsed, err := llm.ParseEffort(effort)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", model, err)
+	}
+	return parsed, nil
 }
 
 func NewAgent(
@@ -36,7 +90,7 @@ func NewAgent(
 }
 
 func (a *Agent) RestoreConversation(messages []llm.Message) {
-	seeded := []llm.Message{{Role: "system", Content: a.SystemPrompt}}
+	seeded := []llm.Message{llm.TextMessage("system", a.SystemPrompt)}
 	seeded = append(seeded, messages...)
 	a.Conversation = llm.Conversation{
 		Messages: seeded,
@@ -45,14 +99,11 @@ func (a *Agent) RestoreConversation(messages []llm.Message) {
 	a.initial = true
 }
 
-func (a *Agent) RunStep(messages ...llm.Message) (*llm.Conversation, error) {
+func (a *Agent) RunStep(ctx context.Context, messages ...llm.Message) (*llm.Conversation, error) {
 	if !a.initial {
 		a.Conversation = llm.Conversation{
 			Messages: []llm.Message{
-				{
-					Content: a.SystemPrompt,
-					Role:    "system",
-				},
+				llm.TextMessage("system", a.SystemPrompt),
 			},
 			Tools: *a.Tools,
 		}
@@ -63,31 +114,32 @@ func (a *Agent) RunStep(messages ...llm.Message) (*llm.Conversation, error) {
 	a.Conversation.Messages = append(a.Conversation.Messages, messages...)
 	a.Conversation.Tools = *a.Tools
 
-	if config.Cfg.ActiveProviderName == "" {
+	providerName := a.providerName()
+	if providerName == "" {
 		return nil, fmt.Errorf(
 			"Error: No active provider configured, configure a provider from /providers to proceed",
 		)
 	}
 
 	validationResult := a.Validator.ValidateLazy(
-		llm.ProviderName(config.Cfg.ActiveProviderName),
+		llm.ProviderName(providerName),
 	)
 
 	if validationResult.Status == credentials.Rejected {
 		return nil, fmt.Errorf(
 			"Error: Failed to validate credentials for %s",
-			config.Cfg.ActiveProviderName,
+			providerName,
 		)
 	}
 
 	if validationResult.Status == credentials.NotConfigured {
 		return nil, fmt.Errorf(
 			"Error: No credentials have been configured for %s",
-			config.Cfg.ActiveProviderName,
+			providerName,
 		)
 	}
 
-	conv, err := a.Chat(&a.Conversation)
+	conv, err := a.Chat(ctx, &a.Conversation)
 
 	if err != nil {
 		return nil, err
@@ -97,19 +149,11 @@ func (a *Agent) RunStep(messages ...llm.Message) (*llm.Conversation, error) {
 	return conv, nil
 }
 
-func (a *Agent) Chat(prev *llm.Conversation) (*llm.Conversation, error) {
-	var chatRequest llm.ChatRequest
-
-	chatRequest.Messages = prev.Messages
-	chatRequest.Model = config.Cfg.CurrentModel
-	chatRequest.Tools = prev.Tools
-
-	currentProvider := a.Registry.GetProvider(
-		llm.ProviderName(config.Cfg.ActiveProviderName),
-	)
-
-	value, err := currentProvider.Complete(chatRequest)
-
+func (a *Agent) Chat(ctx context.Context, prev *llm.Conversation) (*llm.Conversation, error) {
+	value, err := a.complete(ctx, prev, a.providerName(), a.modelName())
+	if err != nil {
+		value, err = a.fallback(ctx, prev, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -121,3 +165,68 @@ func (a *Agent) Chat(prev *llm.Conversation) (*llm.Conversation, error) {
 
 	return prev, nil
 }
+
+// CountTokens counts locally what the next request would send: the
+// conversation so far with extra appended, plus the tool schemas.
+func (a *Agent) CountTokens(extra ...llm.Message) llm.TokenCount {
+	messages := a.Conversation.Messages
+	if len(messages) == 0 {
+		messages = []llm.Message{llm.TextMessage("system", a.SystemPrompt)}
+	}
+	messages = append(messages[:len(messages):len(messages)], extra...)
+
+	var definitions []tools.Tool
+	if a.Tools != nil {
+		definitions = *a.Tools
+	}
+	if a.Registry == nil {
+		return llm.TokenCount{}
+	}
+	return a.Registry.CountTokens(
+		llm.ProviderName(a.providerName()),
+		a.modelName(),
+		messages,
+		definitions,
+	)
+}
+
+// Append adds messages to the conversation without sending a request.
+func (a *Agent) Append(messages ...llm.Message) {
+	a.Conversation.Messages = append(a.Conversation.Messages, messages...)
+}
+
+// CloseDanglingToolCalls answers every tool call in the last assistant turn
+// that has no matching tool result yet, using reason as the error. Providers
+// reject histories where a tool call is left unanswered, so this is needed
+// whenever a run stops between the model's request and the tool results.
+func (a *Agent) CloseDanglingToolCalls(reason string) {
+	msgs := a.Conversation.Messages
+	last := -1
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "assistant" {
+			last = i
+			break
+		}
+	}
+	if last < 0 || len(msgs[last].ToolUses()) == 0 {
+		return
+	}
+
+	answered := map[string]bool{}
+	for _, m := range msgs[last+1:] {
+		for _, result := range m.ToolResults() {
+			answered[result.ToolUseID] = true
+		}
+	}
+
+	content, _ := json.Marshal(map[string]any{"success": false, "error": reason})
+	for _, use := range msgs[last].ToolUses() {
+		if answered[use.ID] {
+			continue
+		}
+		a.Conversation.Messages = append(
+			a.Conversation.Messages,
+			llm.ToolResultMessage(use.ID, string(content), true),
+		)
+	}
+}
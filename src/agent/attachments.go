@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	llm "zipcode/src/llm/provider"
+	"zipcode/src/tools"
+)
+
+// visionEnabled reports whether the current model can be sent attachments.
+func (r *Runtime) visionEnabled() bool {
+	return r.Registry.SupportsVision(
+		llm.ProviderName(r.Agent.providerName()),
+		r.Agent.modelName(),
+	)
+}
+
+// toolResultMessage turns a tool result into the message sent back to the
+// model. Attachments are dropped, with a note, for models without vision.
+func (r *Runtime) toolResultMessage(result ToolResultRequestData) llm.Message {
+	if len(result.Attachments) > 0 && !r.visionEnabled() {
+		result.Content += fmt.Sprintf(
+			"\n\n%d attachment(s) were not sent: the current model cannot view images or documents.",
+			len(result.Attachments),
+		)
+		result.Attachments = nil
+	}
+
+	message := llm.ToolResultMessage(result.ToolCallID, result.Content, result.IsError)
+	toolResult := message.Content[0].ToolResult
+	for _, attachment := range result.Attachments {
+		toolResult.Content = append(toolResult.Content, llm.AttachmentBlock(attachment))
+	}
+	return message
+}
+
+// promptMessage builds the user message for prompt, attaching the images
+// and PDFs it mentions as @path/to/file. Paths are relative to the
+// workspace root. A mention that does not name an attachable file is left
+// as plain text.
+func (r *Runtime) promptMessage(prompt string) llm.Message {
+	message := llm.TextMessage("user", prompt)
+
+	paths := attachmentMentions(prompt)
+	if len(paths) == 0 {
+		return message
+	}
+	if !r.visionEnabled() {
+		r.notifyError(fmt.Sprintf(
+			"%s cannot view images or documents, attachments were not sent",
+			r.Agent.modelName(),
+		))
+		return message
+	}
+
+	root := ""
+	if r.Workspace != nil {
+		root = r.Workspace.RootPath
+	}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+
+		attachment, err := tools.LoadAttachment(path)
+		if err != nil {
+			r.notifyError(fmt.Sprintf("Failed to attach file. Error: %s", err.Error()))
+			continue
+		}
+		message.Content = append(message.Content, llm.AttachmentBlock(attachment))
+	}
+	return message
+}
+
+// attachmentMentions returns the paths of the @mentions in prompt that have
+// an attachable extension, in order and without duplicates.
+func attachmentMentions(prompt string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(prompt) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		path := strings.TrimRight(word[1:], ".,;:!?)]}\"'")
+		if _, ok := tools.AttachmentType(path); !ok || seen[path] {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (r *Runtime) notifyError(message string) {
+	go EventManager.WriteToChannel(
+		NOTIFICATION_CHANNEL,
+		Notification{Type: ERROR, Message: message},
+	)
+}
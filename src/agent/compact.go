@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"zipcode/src/config"
+	llm "zipcode/src/llm/provider"
+)
+
+// CompactThreshold is the context size, in input tokens, above which the
+// conversation is compacted before the next prompt: AutoCompactPercent of
+// the model's context window, or AutoCompactThreshold when the window is
+// unknown.
+func (r *Runtime) CompactThreshold() int {
+	percent := config.Cfg.AutoCompactPercent
+	if percent <= 0 || percent > 100 {
+		percent = 80
+	}
+
+	window := r.Registry.ContextWindowFor(
+		llm.ProviderName(r.Agent.providerName()),
+		r.Agent.modelName(),
+	)
+	if window <= 0 {
+		return AutoCompactThreshold
+	}
+	return window * percent / 100
+}
+
+// compactionModel returns the provider and model the summary is written
+// with: the configured compact_provider and compact_model when set, else
+// the agent's own.
+func (r *Runtime) compactionModel() (llm.ProviderName, llm.Provider, string) {
+	name := llm.ProviderName(r.Agent.providerName())
+	if config.Cfg.CompactProvider != "" {
+		name, _ = r.Registry.Lookup(config.Cfg.CompactProvider)
+	}
+	provider := r.Registry.GetProvider(name)
+
+	model := config.Cfg.CompactModel
+	if model == "" {
+		if config.Cfg.CompactProvider != "" && provider != nil {
+			model = defaultModelFor(r.Registry, name)
+		} else {
+			model = r.Agent.modelName()
+		}
+	}
+	return name, provider, model
+}
+
+// compactionSplit returns the index in messages (which exclude the system
+// prompt) where the verbatim tail starts. The tail holds the last keepTurns
+// turns, a turn starting at each user message. The split is moved earlier
+// so that no tool result is separated from the assistant message that
+// called it, and so that tool calls still waiting for a result stay in the
+// tail. A split of 0 means there is nothing old enough to summarize.
+func compactionSplit(messages []llm.Message, keepTurns int) int {
+	if keepTurns < 1 {
+		keepTurns = 1
+	}
+
+	var turnStarts []int
+	for i, m := range messages {
+		if m.Role == "user" {
+			turnStarts = append(turnStarts, i)
+		}
+	}
+	if len(turnStarts) <= keepTurns {
+		return 0
+	}
+	split := turnStarts[len(turnStarts)-keepTurns]
+
+	callers := map[string]int{}
+	answered := map[string]bool{}
+	for i, m := range messages {
+		for _, use := range m.ToolUses() {
+			callers[use.ID] = i
+		}
+		for _, result := range m.ToolResults() {
+			answered[result.ToolUseID] = true
+		}
+	}
+
+	earliest := split
+	for i, m := range messages {
+		if i < split {
+			for _, use := range m.ToolUses() {
+				if !answered[use.ID] {
+					earliest = min(earliest, i)
+				}
+			}
+			continue
+		}
+		for _, result := range m.ToolResults() {
+			if caller, ok := callers[result.ToolUseID]; ok {
+				earliest = min(earliest, caller)
+			}
+		}
+	}
+
+	// Start the tail at the turn that contains the earliest message it has
+	// to keep.
+	for j := len(turnStarts) - 1; j >= 0; j-- {
+		if turnStarts[j] <= earliest {
+			return turnStarts[j]
+		}
+	}
+	return 0
+}
@@ -1,6 +1,9 @@
 package agent
 
-import "zipcode/src/secrets"
+import (
+	"context"
+	"zipcode/src/secrets"
+)
 
 type EventsManager struct {
 	agentOutput   chan ResponseEvent
@@ -35,6 +38,8 @@ const (
 type Notification struct {
 	Type    NotificationType
 	Message string
+	// Details is the raw error behind Message, shown below it.
+	Details string
 }
 
 func (e *EventsManager) WriteToChannel(channelType ChannelType, data any) {
@@ -91,6 +96,18 @@ func (e *EventsManager) ReadFromChannel(channelType ChannelType) any {
 	return nil
 }
 
+// ReadInput waits for the user's answer on AGENT_INPUT_CHANNEL. It gives up
+// when ctx is cancelled so an aborted run doesn't stay parked on a prompt
+// nobody will answer.
+func (e *EventsManager) ReadInput(ctx context.Context) (string, error) {
+	select {
+	case answer := <-e.agentInput:
+		return answer, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
 var EventManager = CreateEventManager()
 
 func CreateEventManager() EventsManager {
@@ -1,16 +1,19 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"path"
 	"strings"
+	"sync"
 	"zipcode/src/config"
 	llm "zipcode/src/llm/provider"
 	"zipcode/src/secrets"
 	"zipcode/src/tools"
 	"zipcode/src/utils"
+	"zipcode/src/workspace"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
@@ -21,6 +24,16 @@ const (
 	Tool ResponseEventType = iota
 	Error
 	Message
+	// MessageDelta carries a fragment of an assistant message that is still
+	// streaming. The complete text follows as a Message event.
+	MessageDelta
+	// RunCancelled marks the end of a run the user aborted.
+	RunCancelled
+	// Thinking carries the reasoning behind an assistant message, sent
+	// before the message or tool calls it led to. ThinkingDelta carries a
+	// fragment of it while it is still streaming.
+	Thinking
+	ThinkingDelta
 )
 
 type ResponseEvent struct {
@@ -30,7 +43,10 @@ type ResponseEvent struct {
 	Message      string
 	SubAgent     bool
 	SubAgentName string
-	SkillName    string
+	// SubAgentID tells apart sub-agents running at the same time, including
+	// several runs of the same agent. It is the id of the subagent tool call.
+	SubAgentID string
+	SkillName  string
 }
 
 type FileChangeType int
@@ -55,7 +71,29 @@ type Executor struct {
 	Tools           []tools.Tool
 	SubAgentRunning bool
 	SubAgent        string
+	SubAgentID      string
 	ActiveSkill     string
+	// Policy decides which tool calls run, need confirmation or are
+	// refused. A nil Policy applies the built-in defaults.
+	Policy *Policy
+	// Handlers executes the tool calls that pass the policy.
+	Handlers *tools.Registry
+	// Workspace gives access to the session, where "always allow"
+	// answers are kept.
+	Workspace *workspace.Workspace
+	// Observer receives output events in headless mode, where nothing reads
+	// the output channel.
+	Observer func(ResponseEvent)
+	// stream holds back the streamed text that may still be part of a
+	// secret.
+	stream *deltaStream
+}
+
+// deltaStream redacts the streamed text of one kind, message or thinking,
+// at a time.
+type deltaStream struct {
+	kind     ResponseEventType
+	redactor secrets.StreamRedactor
 }
 
 func (e *Executor) IsSubagentTool(name string) bool {
@@ -107,6 +145,9 @@ type ToolResultRequestData struct {
 	ToolCallID string `json:"tool_call_id"`
 	Role       string `json:"role"`
 	Content    string `json:"content"`
+	IsError    bool   `json:"is_error"`
+	// Attachments are images or documents the tool returned.
+	Attachments []tools.Attachment `json:"-"`
 }
 
 type ToolCallResponseData struct {
@@ -132,9 +173,16 @@ type ExecutionAction struct {
 	ToolCall *ToolCallResponseData
 }
 
-func (e *Executor) SetSubAgentModeOn(mode bool, name string) {
-	e.SubAgent = name
-	e.SubAgentRunning = mode
+// ForSubAgent returns an executor for a child runtime. It shares the
+// channels, policy and handlers of e but labels its output with the
+// sub-agent, so children running in parallel keep separate state.
+func (e *Executor) ForSubAgent(name, id string) *Executor {
+	child := *e
+	child.SubAgentRunning = true
+	child.SubAgent = name
+	child.SubAgentID = id
+	child.ActiveSkill = ""
+	return &child
 }
 
 func (e *Executor) SetActiveSkill(name string) {
@@ -142,31 +190,37 @@ func (e *Executor) SetActiveSkill(name string) {
 }
 
 func (e *Executor) ProcessResponse(response llm.Message) ([]ExecutionAction, ExecutionResultStatus, error) {
-	if config.Cfg.Headless {
-		utils.PrintStruct(response)
+	utils.LogValue(response)
+
+	if reasoning := response.ReasoningText(); strings.TrimSpace(reasoning) != "" && !e.SubAgentRunning {
+		e.pushEvent(Thinking, reasoning)
 	}
 
-	if response.ToolCalls == nil && strings.TrimSpace(response.Content) == "" {
+	toolUses := response.ToolUses()
+	text := response.Text()
+
+	if toolUses == nil && strings.TrimSpace(text) == "" {
+		retry := llm.TextMessage("user", "retry")
 		return []ExecutionAction{
-			{Type: ActionMessage, Message: &llm.Message{Role: "user", Content: "retry"}},
+			{Type: ActionMessage, Message: &retry},
 		}, ExecutionSucceeded, nil
 	}
 
-	if response.ToolCalls == nil && strings.TrimSpace(response.Content) != "" {
+	if toolUses == nil && strings.TrimSpace(text) != "" {
 		if !e.SubAgentRunning {
-			e.pushEvent(Message, response.Content)
+			e.pushEvent(Message, text)
 		}
 		return nil, ExecutionCompleted, nil
 	}
 
-	if len(response.ToolCalls) > 0 {
+	if len(toolUses) > 0 {
 		results := []ExecutionAction{}
 
-		for _, toolCall := range response.ToolCalls {
+		for _, use := range toolUses {
 			tool := ToolCallResponseData{
-				Id:        toolCall.ID,
-				Name:      toolCall.Function.Name,
-				Arguments: json.RawMessage(toolCall.Function.Arguments),
+				Id:        use.ID,
+				Name:      use.Name,
+				Arguments: json.RawMessage(use.Input),
 			}
 
 			actionType := ActionToolCall
@@ -194,226 +248,366 @@ func (e *Executor) ProcessResponse(response llm.Message) ([]ExecutionAction, Exe
 }
 
 func (e *Executor) pushEvent(eventType ResponseEventType, value string) {
-	if config.Cfg.Headless {
-		return
+	// Whatever streamed before this event goes out first.
+	if eventType != MessageDelta && eventType != ThinkingDelta {
+		e.flushStream()
 	}
 
-	EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, ResponseEvent{
+	event := ResponseEvent{
 		EventType:    eventType,
 		Message:      secrets.RedactForDisplay(value),
 		SubAgent:     e.SubAgentRunning,
 		SubAgentName: e.SubAgent,
+		SubAgentID:   e.SubAgentID,
 		SkillName:    e.ActiveSkill,
-	})
-}
-
-func GetTool(path string, toolname string) (tools.Tool, error) {
-	name := strings.ReplaceAll(toolname, "_tool", "")
-	content, err := os.ReadFile(fmt.Sprintf("%s/%s/%s.json", path, name, name))
-
-	if err != nil {
-		return tools.Tool{}, errors.New("failed to read tool manifest")
 	}
 
-	var tool tools.Tool
-	err = json.Unmarshal([]byte(content), &tool)
-
-	if err != nil {
-		return tools.Tool{}, errors.New("invalid tool manifest")
+	if config.Cfg.Headless {
+		if e.Observer != nil {
+			e.Observer(event)
+		}
+		return
 	}
 
-	return tool, nil
+	EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, event)
 }
 
-func (e *Executor) GetToolCallCommand(input ToolCallResponseData) (string, error) {
-	internaltool, err1 := GetTool(config.Cfg.InternalToolPath, input.Name)
-	externaltool, err2 := GetTool(config.Cfg.ExternalToolPath, input.Name)
-	var toolPath string
-	var tool tools.Tool
-
-	if err1 != nil {
-		tool = externaltool
-		toolPath = config.Cfg.ExternalToolPath
-	} else if err2 != nil {
-		tool = internaltool
-		toolPath = config.Cfg.InternalToolPath
+// PushStreamDelta forwards streamed assistant text and reasoning to the
+// output pane. Tool call fragments are not shown until the call is
+// complete, and sub-agent output stays hidden the same way their final
+// messages are. A secret split across deltas is redacted as a whole:
+// the text that could still be one is held back until it is settled.
+func (e *Executor) PushStreamDelta(delta llm.StreamDelta) {
+	if delta.Text == "" || e.SubAgentRunning {
+		return
 	}
-
-	if err1 != nil && err2 != nil {
-		return "", errors.New("failed to get tool")
+	var kind ResponseEventType
+	switch delta.Type {
+	case llm.DeltaText:
+		kind = MessageDelta
+	case llm.DeltaReasoning:
+		kind = ThinkingDelta
+	default:
+		return
 	}
 
-	command := fmt.Sprintf("python3 %s/%s/%s.py", toolPath, input.Name, input.Name)
-
-	for _, param := range tool.Function.Parameters.Required {
-		var args map[string]any
-
-		if err := json.Unmarshal(input.Arguments, &args); err != nil {
-			fmt.Println("Error:", err)
-		}
-
-		command = fmt.Sprintf("%s --%s \"%s\"", command, param, strings.ReplaceAll(args[param].(string), "\"", "\\\""))
+	if e.stream == nil {
+		e.stream = &deltaStream{kind: kind}
+	}
+	if e.stream.kind != kind {
+		e.flushStream()
+		e.stream.kind = kind
+	}
+	if text := e.stream.redactor.Push(delta.Text); text != "" {
+		e.pushEvent(kind, text)
 	}
-	return command, nil
 }
 
-func (e *Executor) ProcessToolCall(input ToolCallResponseData) (*ToolResultRequestData, error) {
-	switch input.Name {
-	default:
-		command, err := e.GetToolCallCommand(input)
-		if err != nil {
-			return &ToolResultRequestData{
-				ToolCallID: input.Id,
-				Role:       "tool",
-				Content:    fmt.Sprintf("unknown tool: %s", input.Name),
-			}, nil
-		}
+// flushStream sends the streamed text held back so far.
+func (e *Executor) flushStream() {
+	if e.stream == nil || e.SubAgentRunning {
+		return
+	}
+	if text := e.stream.redactor.Flush(); text != "" {
+		e.pushEvent(e.stream.kind, text)
+	}
+}
 
-		utils.Log(command)
+// errInvalidToolInput marks tool arguments that cannot be decoded. They go
+// back to the model as a failed tool result, like other bad tool input.
+var errInvalidToolInput = errors.New("invalid tool input")
 
-		var args map[string]any
+func (e *Executor) ProcessToolCall(ctx context.Context, input ToolCallResponseData) (*ToolResultRequestData, error) {
+	request := e.Policy.Request(input.Name, input.Arguments)
+	decision := e.Policy.Evaluate(request)
 
-		if err := json.Unmarshal(input.Arguments, &args); err != nil {
-			return nil, err
+	if decision.Action == PolicyAsk {
+		if approval, ok := e.sessionApproval(request); ok {
+			decision = PolicyDecision{
+				Action: PolicyAllow,
+				Reason: "session approval for " + approval.Label(),
+			}
 		}
+	}
 
-		e.pushEvent(Tool, args["message"].(string))
-
-		result, err := tools.RunBashCommand(command)
-		utils.Log(result)
+	approved, err := e.authorize(ctx, input, request, decision)
+	if errors.Is(err, errInvalidToolInput) {
+		content, _ := json.Marshal(map[string]any{"success": false, "error": err.Error()})
+		return &ToolResultRequestData{
+			ToolCallID: input.Id,
+			Role:       "tool",
+			Content:    string(content),
+			IsError:    true,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Policy.Log(request, decision, approved)
 
-		if err != nil {
-			utils.Log(err.Error())
-			return nil, err
+	if !approved {
+		reason := "denied by user"
+		if decision.Action == PolicyDeny {
+			reason = "denied by policy: " + decision.Reason
 		}
+		content, _ := json.Marshal(map[string]any{"success": false, "error": reason})
+		return &ToolResultRequestData{
+			ToolCallID: input.Id,
+			Role:       "tool",
+			Content:    string(content),
+			IsError:    true,
+		}, nil
+	}
 
+	handler, ok := e.Handlers.Get(input.Name)
+	if !ok {
 		return &ToolResultRequestData{
 			ToolCallID: input.Id,
 			Role:       "tool",
-			Content:    string(result),
+			Content:    fmt.Sprintf("unknown tool: %s", input.Name),
+			IsError:    true,
 		}, nil
+	}
 
-	case "file_write":
-		var fileWriteInput tools.FileWriteInput
-		err := json.Unmarshal(input.Arguments, &fileWriteInput)
-		if err != nil {
-			return nil, err
+	result, err := handler.Run(ctx, input.Arguments)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		// Tool failures go back to the model, which can usually recover
+		// (wrong path, bad pattern) without ending the run.
+		result = tools.ToolResult{Content: err.Error(), IsError: true}
+	}
 
-		var msg string
-		var patches []tools.ParsedDiff
+	if len(result.Metadata) > 0 {
+		utils.Log(input.Name, "metadata:", result.Metadata)
+	}
+
+	content := result.Content
+	if result.IsError {
+		utils.Log(input.Name, "failed:", result.Content)
+		payload, _ := json.Marshal(map[string]any{"success": false, "error": result.Content})
+		content = string(payload)
+	}
 
-		for _, p := range fileWriteInput.Patches {
-			diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-				A:        difflib.SplitLines(p.Target),
-				B:        difflib.SplitLines(p.Content),
-				FromFile: fileWriteInput.FilePath,
-				ToFile:   fileWriteInput.FilePath,
-				Context:  3,
-			})
+	return &ToolResultRequestData{
+		ToolCallID:  input.Id,
+		Role:        "tool",
+		Content:     content,
+		IsError:     result.IsError,
+		Attachments: utils.If(result.IsError, nil, result.Attachments),
+	}, nil
+}
 
-			parsedDiff, _ := tools.ParseUnifiedDiff(diff)
-			patches = append(patches, parsedDiff)
+// authorize applies the policy decision to a tool call and announces it in
+// the output pane. Calls the policy wants confirmed are put to the user;
+// headless runs answer with the configured approval policy instead.
+func (e *Executor) authorize(
+	ctx context.Context,
+	input ToolCallResponseData,
+	request PolicyRequest,
+	decision PolicyDecision,
+) (bool, error) {
+	var args map[string]any
+	_ = json.Unmarshal(input.Arguments, &args)
+	message, _ := args["message"].(string)
+
+	switch decision.Action {
+	case PolicyAllow:
+		if message != "" {
+			e.pushEvent(Tool, message)
 		}
+		return true, nil
 
-		var changeType FileChangeType
+	case PolicyDeny:
+		e.pushEvent(Tool, fmt.Sprintf("Blocked %s: %s", input.Name, decision.Reason))
+		return false, nil
+	}
 
-		switch fileWriteInput.Operation {
-		case "append":
-			changeType = FileChange_Append
+	if message == "" {
+		message = input.Name
+	}
 
-		case "create":
-			changeType = FileChange_Create
+	if config.Cfg.Headless {
+		e.pushEvent(Tool, message)
+		return config.Cfg.HeadlessApproval == config.ApprovalAllow, nil
+	}
 
-		case "patch":
-			changeType = FileChange_Patch
+	question := fmt.Sprintf("Allow %s to run?", input.Name)
+	var change FileChangeEvent
 
+	if input.Name == "file_write" {
+		var fileWriteInput tools.FileWriteInput
+		if err := json.Unmarshal(input.Arguments, &fileWriteInput); err != nil {
+			return false, fmt.Errorf("%w: %s", errInvalidToolInput, err.Error())
 		}
+		change = fileChangeEvent(fileWriteInput)
+		question = "Do you want to make this change?"
+	} else if command, ok := args["command"].(string); ok && command != "" {
+		question = fmt.Sprintf("Run `%s`?", secrets.RedactForDisplay(command))
+	}
 
-		if !config.Cfg.Headless {
-			EventManager.WriteToChannel(FILE_DIFF_CHANNEL, FileChangeEvent{
-				FileName:   fileWriteInput.FilePath,
-				ChangeType: changeType,
-				Content:    fileWriteInput.Content,
-				Patches:    patches,
-			})
-
-			EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, ResponseEvent{
-				Question:  "Do you want to make this change?",
-				Options:   []string{"Yes", "No"},
-				EventType: Tool,
-				Message:   fileWriteInput.Message,
-			})
-
-			msg = EventManager.ReadFromChannel(AGENT_INPUT_CHANNEL).(string)
-		} else {
-			msg = "Yes"
-		}
+	if e.SubAgentRunning {
+		question = fmt.Sprintf("[%s] %s", e.SubAgent, question)
+	}
 
-		if msg == "Yes" || msg == "Yes, and do not ask again for this session" {
-			output, err := tools.RunFileWrite(fileWriteInput)
+	choices := e.approvalChoices(request)
+	options := []string{"Yes"}
+	for _, choice := range choices {
+		options = append(options, "Yes, and always allow "+choice.Label()+" this session")
+	}
+	options = append(options, "No")
 
-			if err != nil {
-				return nil, err
-			}
-			value, err := json.Marshal(output)
+	promptMu.Lock()
+	defer promptMu.Unlock()
 
-			return &ToolResultRequestData{
-				ToolCallID: input.Id,
-				Role:       "tool",
-				Content:    string(value),
-			}, nil
-		}
+	EventManager.WriteToChannel(FILE_DIFF_CHANNEL, change)
+	EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, ResponseEvent{
+		Question:     question,
+		Options:      options,
+		EventType:    Tool,
+		Message:      secrets.RedactForDisplay(message),
+		SubAgent:     e.SubAgentRunning,
+		SubAgentName: e.SubAgent,
+		SubAgentID:   e.SubAgentID,
+	})
 
-		return &ToolResultRequestData{
-			ToolCallID: input.Id,
-			Role:       "tool",
-			Content:    "denied",
-		}, nil
+	answer, err := EventManager.ReadInput(ctx)
+	if err != nil {
+		return false, err
+	}
 
-	case "question":
-		if config.Cfg.Headless {
-			return &ToolResultRequestData{
-				ToolCallID: input.Id,
-				Role:       "tool",
-				Content:    `{"error":"question tool is not available in headless mode"}`,
-			}, nil
-		}
+	if answer == "Yes" {
+		return true, nil
+	}
 
-		var questionInput tools.QuestionInput
-		err := json.Unmarshal(input.Arguments, &questionInput)
-		if err != nil {
-			return nil, err
+	for i, choice := range choices {
+		if answer != options[i+1] {
+			continue
 		}
-
-		if len(questionInput.Options) < 2 {
-			return &ToolResultRequestData{
-				ToolCallID: input.Id,
-				Role:       "tool",
-				Content:    `{"error":"question tool requires at least two options"}`,
-			}, nil
+		if err := e.Workspace.Session.Grant(choice); err != nil {
+			utils.Log(err.Error())
 		}
+		return true, nil
+	}
 
-		EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, ResponseEvent{
-			Question:  questionInput.Question,
-			Options:   questionInput.Options,
-			EventType: Tool,
-			Message:   questionInput.Question,
+	return false, nil
+}
+
+// sessionApproval looks for an earlier "always allow" answer covering the
+// call in the current session.
+func (e *Executor) sessionApproval(request PolicyRequest) (workspace.Approval, bool) {
+	if e.Workspace == nil || e.Workspace.Session == nil {
+		return workspace.Approval{}, false
+	}
+	return e.Workspace.Session.Approved(request.Tool, request.Paths)
+}
+
+// approvalChoices lists the "always allow" answers offered for a call:
+// the file and its directory when the call targets a single path, and the
+// tool as a whole.
+func (e *Executor) approvalChoices(request PolicyRequest) []workspace.Approval {
+	if e.Workspace == nil || e.Workspace.Session == nil {
+		return nil
+	}
+
+	choices := []workspace.Approval{}
+	if len(request.Paths) == 1 && request.Command == "" {
+		file := request.Paths[0]
+		choices = append(choices,
+			workspace.Approval{Scope: workspace.ApprovalFile, Tool: request.Tool, Path: file},
+			workspace.Approval{Scope: workspace.ApprovalDirectory, Tool: request.Tool, Path: path.Dir(file)},
+		)
+	}
+
+	return append(choices, workspace.Approval{Scope: workspace.ApprovalTool, Tool: request.Tool})
+}
+
+// fileChangeEvent builds the diff preview shown while a file write waits
+// for approval.
+func fileChangeEvent(input tools.FileWriteInput) FileChangeEvent {
+	var patches []tools.ParsedDiff
+
+	for _, p := range input.Patches {
+		diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(p.Target),
+			B:        difflib.SplitLines(p.Content),
+			FromFile: input.FilePath,
+			ToFile:   input.FilePath,
+			Context:  3,
 		})
 
-		answer := EventManager.ReadFromChannel(AGENT_INPUT_CHANNEL).(string)
+		parsedDiff, _ := tools.ParseUnifiedDiff(diff)
+		patches = append(patches, parsedDiff)
+	}
 
-		payload, err := json.Marshal(map[string]string{"selected": answer})
-		if err != nil {
-			return nil, err
-		}
+	var changeType FileChangeType
 
-		return &ToolResultRequestData{
-			ToolCallID: input.Id,
-			Role:       "tool",
-			Content:    string(payload),
+	switch input.Operation {
+	case "append":
+		changeType = FileChange_Append
+
+	case "create":
+		changeType = FileChange_Create
+
+	case "patch":
+		changeType = FileChange_Patch
+
+	}
+
+	return FileChangeEvent{
+		FileName:   input.FilePath,
+		ChangeType: changeType,
+		Content:    input.Content,
+		Patches:    patches,
+	}
+}
+
+// promptMu serializes questions to the user. Sub-agents running in
+// parallel would otherwise show prompts over each other and take each
+// other's answers.
+var promptMu sync.Mutex
+
+// questionHandler puts a multiple-choice question to the user and returns
+// the option they picked. It is only registered when there is a UI.
+type questionHandler struct{}
+
+func (questionHandler) Definition() tools.Tool {
+	return tools.QuestionTool
+}
+
+func (questionHandler) Run(ctx context.Context, arguments json.RawMessage) (tools.ToolResult, error) {
+	var questionInput tools.QuestionInput
+	if err := json.Unmarshal(arguments, &questionInput); err != nil {
+		return tools.ToolResult{}, err
+	}
+
+	if len(questionInput.Options) < 2 {
+		return tools.ToolResult{
+			Content: "question tool requires at least two options",
+			IsError: true,
 		}, nil
+	}
 
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	EventManager.WriteToChannel(FILE_DIFF_CHANNEL, FileChangeEvent{})
+	EventManager.WriteToChannel(AGENT_OUTPUT_CHANNEL, ResponseEvent{
+		Question:  questionInput.Question,
+		Options:   questionInput.Options,
+		EventType: Tool,
+		Message:   questionInput.Question,
+	})
+
+	answer, err := EventManager.ReadInput(ctx)
+	if err != nil {
+		return tools.ToolResult{}, err
 	}
 
+	payload, err := json.Marshal(map[string]string{"selected": answer})
+	if err != nil {
+		return tools.ToolResult{}, err
+	}
+	return tools.ToolResult{Content: string(payload)}, nil
 }
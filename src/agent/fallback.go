@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"zipcode/src/config"
+	"zipcode/src/credentials"
+	llmerrors "zipcode/src/llm/errors"
+	llm "zipcode/src/llm/provider"
+)
+
+// complete sends the conversation to model on the named provider.
+func (a *Agent) complete(
+	ctx context.Context,
+	prev *llm.Conversation,
+	providerName string,
+	model string,
+) (llm.ChatResponse, error) {
+	chatRequest := llm.ChatRequest{
+		Messages:    prev.Messages,
+		Model:       model,
+		Tools:       prev.Tools,
+		MaxTokens:   a.MaxTokens,
+		Temperature: a.Temperature,
+	}
+
+	effort, err := a.reasoningEffort(providerName, model)
+	if err != nil {
+		return llm.ChatResponse{}, err
+	}
+	chatRequest.ReasoningEffort = effort
+
+	provider := a.Registry.GetProvider(llm.ProviderName(providerName))
+	if provider == nil {
+		return llm.ChatResponse{}, fmt.Errorf("provider %q not registered", providerName)
+	}
+
+	if streamer, ok := provider.(llm.StreamingProvider); ok && a.OnStream != nil {
+		chatRequest.Stream = true
+		return streamer.CompleteStream(ctx, chatRequest, a.OnStream)
+	}
+	return provider.Complete(ctx, chatRequest)
+}
+
+// fallback retries a request that failed with cause on the fallback chain
+// configured for the agent's model, when the failure is one another model
+// can get around. The first model to answer replaces the agent's for the
+// rest of the session. When the whole chain fails, cause is returned.
+func (a *Agent) fallback(
+	ctx context.Context,
+	prev *llm.Conversation,
+	cause error,
+) (llm.ChatResponse, error) {
+	classified, ok := llmerrors.AsClassified(cause)
+	if !ok || !classified.Fallback() {
+		return llm.ChatResponse{}, cause
+	}
+
+	for _, next := range config.Cfg.Fallbacks[a.modelName()] {
+		if ctx.Err() != nil {
+			return llm.ChatResponse{}, ctx.Err()
+		}
+		providerName, provider := a.Registry.Lookup(next.Provider)
+		if provider == nil || !a.hasCredentials(providerName) {
+			continue
+		}
+
+		value, err := a.complete(ctx, prev, string(providerName), next.Model)
+		if err == nil {
+			next.Provider = string(providerName)
+			a.switchModel(next)
+			if a.OnFallback != nil {
+				a.OnFallback(classified, next)
+			}
+			return value, nil
+		}
+		if failed, ok := llmerrors.AsClassified(err); !ok || !failed.Fallback() {
+			return llm.ChatResponse{}, err
+		}
+	}
+	return llm.ChatResponse{}, cause
+}
+
+// hasCredentials reports whether a key is configured for the provider and
+// has not been rejected.
+func (a *Agent) hasCredentials(providerName llm.ProviderName) bool {
+	status := a.Validator.ValidateLazy(providerName).Status
+	return status != credentials.Rejected && status != credentials.NotConfigured
+}
+
+// switchModel makes next the agent's own model. It leaves the active
+// provider and model alone: sub-agents run in parallel, and one falling
+// back must not move the others or the main agent.
+func (a *Agent) switchModel(next config.FallbackModel) {
+	a.Provider = next.Provider
+	a.Model = next.Model
+}
+
+// reportFallback tells the user why the model was switched. The main
+// runtime also moves the active provider and model to next, from its own
+// run, so the status line and later prompts use it too; the saved
+// configuration is left alone. Its agent then follows them again.
+func (r *Runtime) reportFallback(cause *llmerrors.ClassifiedError, next config.FallbackModel) {
+	message := fmt.Sprintf("%s Switched to %s on %s.", cause.UserMessage, next.Model, next.Provider)
+	if r.ChildRuntime {
+		message = fmt.Sprintf("[%s] %s", r.Executor.SubAgent, message)
+	} else {
+		config.Cfg.ActiveProviderName = next.Provider
+		config.Cfg.CurrentModel = next.Model
+		r.Agent.Provider, r.Agent.Model = "", ""
+		r.CurrentProvider = r.Registry.GetProvider(llm.ProviderName(next.Provider))
+	}
+
+	go EventManager.WriteToChannel(
+		NOTIFICATION_CHANNEL,
+		Notification{Type: INFO, Message: message, Details: cause.RawDetails},
+	)
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"zipcode/src/config"
+	"zipcode/src/credentials"
+	llmerrors "zipcode/src/llm/errors"
+	llm "zipcode/src/llm/provider"
+	"zipcode/src/tools"
+)
+
+// flakyProvider stands in for the active provider. Prompts of "fail" run
+// out of credits on every model but backup.
+type flakyProvider struct{}
+
+func (flakyProvider) Name() llm.ProviderName                   { return llm.ScriptedProvider }
+func (flakyProvider) KeyOptional() bool                        { return true }
+func (flakyProvider) AuthCheck(string) llm.AuthResult          { return llm.AuthResult{Status: http.StatusOK} }
+func (flakyProvider) SetApiKey(string)                         {}
+func (flakyProvider) Models() []llm.ModelDescriptor            { return nil }
+func (flakyProvider) IsQuotaError(*http.Response, []byte) bool { return false }
+func (flakyProvider) Complete(_ context.Context, request llm.ChatRequest) (llm.ChatResponse, error) {
+	prompt := request.Messages[len(request.Messages)-1].Text()
+	if prompt == "fail" && request.Model != "backup" {
+		return llm.ChatResponse{}, llmerrors.NewClassifiedError(
+			llmerrors.BucketQuotaBilling, string(llm.ScriptedProvider), request.Model, http.StatusPaymentRequired, "",
+		)
+	}
+	return llm.ChatResponse{Message: llm.TextMessage("assistant", "answered by "+request.Model)}, nil
+}
+
+func TestFallbackStaysWithTheAgent(t *testing.T) {
+	registry := llm.NewRegistry()
+	if err := registry.Register(flakyProvider{}); err != nil {
+		t.Fatal(err)
+	}
+	validator := credentials.NewValidator(registry.Providers, credentials.NewStore())
+
+	active, model := config.Cfg.ActiveProviderName, config.Cfg.CurrentModel
+	fallbacks := config.Cfg.Fallbacks
+	config.Cfg.Fallbacks = map[string][]config.FallbackModel{
+		model: {{Provider: string(llm.ScriptedProvider), Model: "backup"}},
+	}
+	t.Cleanup(func() { config.Cfg.Fallbacks = fallbacks })
+
+	// Two sub-agents following the active model run side by side; one of
+	// them falls back.
+	var none []tools.Tool
+	children := []*Agent{}
+	for range 2 {
+		child := NewAgent("You are a sub-agent.", &none, &registry, validator)
+		children = append(children, &child)
+	}
+	prompts := []string{"fail", "succeed"}
+	replies := make([]string, len(children))
+	errs := make([]error, len(children))
+
+	var wg sync.WaitGroup
+	for i, child := range children {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conv, err := child.RunStep(context.Background(), llm.TextMessage("user", prompts[i]))
+			if err == nil {
+				replies[i] = conv.Messages[len(conv.Messages)-1].Text()
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("child %d: %v", i, err)
+		}
+	}
+	if replies[0] != "answered by backup" || replies[1] != "answered by "+model {
+		t.Errorf("replies = %q", replies)
+	}
+	if children[0].Provider != string(llm.ScriptedProvider) || children[0].Model != "backup" {
+		t.Errorf("failing child runs %s on %s, want backup", children[0].Model, children[0].Provider)
+	}
+	if children[1].Provider != "" || children[1].Model != "" {
+		t.Errorf("other child switched to %s on %s", children[1].Model, children[1].Provider)
+	}
+	if config.Cfg.ActiveProviderName != active || config.Cfg.CurrentModel != model {
+		t.Errorf("active model moved to %s on %s", config.Cfg.CurrentModel, config.Cfg.ActiveProviderName)
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"zipcode/src/config"
+	"zipcode/src/mcp"
+	"zipcode/src/utils"
+)
+
+// mcpStartTimeout bounds the handshake and tool listing of one server.
+const mcpStartTimeout = 15 * time.Second
+
+// startMCPServers launches the configured MCP servers in parallel and
+// registers their tools. A server that fails to start is reported and
+// skipped; the rest of the runtime works without it.
+func (r *Runtime) startMCPServers() {
+	names := make([]string, 0, len(config.Cfg.MCPServers))
+	for name, server := range config.Cfg.MCPServers {
+		if !server.Disabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	type started struct {
+		client *mcp.Client
+		tools  []mcp.Tool
+		err    error
+	}
+	results := make([]started, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), mcpStartTimeout)
+			defer cancel()
+
+			client, err := mcp.Start(ctx, name, config.Cfg.MCPServers[name])
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			list, err := client.ListTools(ctx)
+			if err != nil {
+				client.Close()
+				results[i].err = fmt.Errorf("mcp server %s: tools/list: %w", name, err)
+				return
+			}
+			results[i] = started{client: client, tools: list}
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result.err != nil {
+			utils.Log(result.err.Error())
+			go EventManager.WriteToChannel(
+				NOTIFICATION_CHANNEL,
+				Notification{
+					Type: ERROR,
+					Message: fmt.Sprintf(
+						"Failed to start MCP server. Error: %s",
+						result.err.Error(),
+					),
+				},
+			)
+			continue
+		}
+
+		r.MCPClients = append(r.MCPClients, result.client)
+		for _, handler := range mcp.Handlers(result.client, result.tools) {
+			if err := r.ToolRegistry.Register(handler); err != nil {
+				utils.Log(err.Error())
+				continue
+			}
+			r.Tools = append(r.Tools, handler.Definition())
+		}
+	}
+}
+
+// Close stops the MCP servers started by the runtime.
+func (r *Runtime) Close() {
+	for _, client := range r.MCPClients {
+		client.Close()
+	}
+	r.MCPClients = nil
+}
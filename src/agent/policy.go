@@ -1 +1,465 @@
 package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"zipcode/src/secrets"
+
+	"github.com/BurntSushi/toml"
+)
+
+// PolicyAction is what happens to a tool call: it runs, the user is asked
+// first, or it is refused.
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow"
+	PolicyAsk   PolicyAction = "ask"
+	PolicyDeny  PolicyAction = "deny"
+)
+
+// PolicyRule matches tool calls on tool name, touched paths and command
+// prefix. Empty fields match anything, so a rule with only an action
+// applies to every call.
+//
+//	[[rules]]
+//	action  = "allow"
+//	tool    = "bash"
+//	command = "go test ./..."
+//
+//	[[rules]]
+//	action = "ask"
+//	paths  = [".git/**", "**/.git/**"]
+type PolicyRule struct {
+	Action  PolicyAction `toml:"action"`
+	Tool    string       `toml:"tool"`
+	Paths   []string     `toml:"paths"`
+	Command string       `toml:"command"`
+	Reason  string       `toml:"reason"`
+	// Source is the file the rule was loaded from, or "default".
+	Source string `toml:"-"`
+}
+
+type policyFile struct {
+	Rules []PolicyRule `toml:"rules"`
+}
+
+// defaultPolicyRules apply when no configured rule matches. Read-only tools
+// run freely; anything else is confirmed with the user.
+var defaultPolicyRules = []PolicyRule{
+	{Action: PolicyAllow, Tool: "file_read", Source: "default"},
+	{Action: PolicyAllow, Tool: "code_search", Source: "default"},
+	{Action: PolicyAllow, Tool: "file_search", Source: "default"},
+	{Action: PolicyAllow, Tool: "question", Source: "default"},
+	{Action: PolicyAsk, Source: "default"},
+}
+
+// pathArgumentKeys are the tool arguments treated as paths the call touches.
+var pathArgumentKeys = []string{"path", "file_path", "working_directory"}
+
+// Policy decides whether a tool call may run. Rules are checked project
+// first, then global, then the built-in defaults; the first match wins,
+// except that a matching deny rule always wins.
+type Policy struct {
+	root    string
+	rules   []PolicyRule
+	logPath string
+	mu      sync.Mutex
+}
+
+// PolicyRequest is the part of a tool call the rules look at.
+type PolicyRequest struct {
+	Tool    string   `json:"tool"`
+	Command string   `json:"command,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+type PolicyDecision struct {
+	Action PolicyAction
+	Rule   *PolicyRule
+	Reason string
+}
+
+// LoadPolicy reads the global and project rule files. Missing files are
+// fine; relative paths are resolved against the workspace root.
+func LoadPolicy(root, globalPath, projectPath, logPath string) (*Policy, error) {
+	p := &Policy{root: root, logPath: logPath}
+
+	for _, file := range []string{projectPath, globalPath} {
+		if file == "" {
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(root, file)
+		}
+		rules, err := readPolicyFile(file)
+		if err != nil {
+			return p, err
+		}
+		p.rules = append(p.rules, rules...)
+	}
+
+	return p, nil
+}
+
+func readPolicyFile(file string) ([]PolicyRule, error) {
+	var parsed policyFile
+	if _, err := toml.DecodeFile(file, &parsed); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("policy %s: %w", file, err)
+	}
+
+	for i := range parsed.Rules {
+		rule := &parsed.Rules[i]
+		switch rule.Action {
+		case PolicyAllow, PolicyAsk, PolicyDeny:
+		default:
+			return nil, fmt.Errorf(
+				"policy %s: rule %d has invalid action %q",
+				file, i+1, rule.Action,
+			)
+		}
+		rule.Source = file
+	}
+
+	return parsed.Rules, nil
+}
+
+// Request extracts the command and paths from a tool call's arguments.
+// Paths inside the workspace are made relative to its root.
+func (p *Policy) Request(tool string, arguments json.RawMessage) PolicyRequest {
+	req := PolicyRequest{Tool: tool}
+
+	root := ""
+	if p != nil {
+		root = p.root
+	}
+
+	var args map[string]any
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return req
+	}
+
+	if command, ok := args["command"].(string); ok {
+		req.Command = strings.TrimSpace(command)
+	}
+
+	for _, key := range pathArgumentKeys {
+		if value, ok := args[key].(string); ok && value != "" {
+			req.Paths = append(req.Paths, normalizePolicyPath(root, value))
+		}
+	}
+
+	return req
+}
+
+// Evaluate returns the decision for req. A nil Policy uses the defaults.
+func (p *Policy) Evaluate(req PolicyRequest) PolicyDecision {
+	segments := splitCommand(req.Command)
+	if len(segments) <= 1 {
+		return p.evaluate(req)
+	}
+
+	// Chained commands are as strict as their strictest part, so an allowed
+	// prefix cannot smuggle in anything else.
+	var result PolicyDecision
+	for i, segment := range segments {
+		sub := req
+		sub.Command = segment
+		decision := p.evaluate(sub)
+		if i == 0 || policyRank(decision.Action) > policyRank(result.Action) {
+			result = decision
+		}
+	}
+	return result
+}
+
+func (p *Policy) evaluate(req PolicyRequest) PolicyDecision {
+	var configured []PolicyRule
+	if p != nil {
+		configured = p.rules
+	}
+
+	for i := range configured {
+		rule := &configured[i]
+		if rule.Action == PolicyDeny && rule.matches(req, true) {
+			return newPolicyDecision(rule)
+		}
+	}
+
+	for i := range configured {
+		rule := &configured[i]
+		if rule.Action != PolicyDeny && rule.matches(req, rule.Action == PolicyAsk) {
+			return newPolicyDecision(rule).guardSubstitution(req)
+		}
+	}
+
+	for i := range defaultPolicyRules {
+		rule := &defaultPolicyRules[i]
+		if rule.matches(req, rule.Action != PolicyAllow) {
+			return newPolicyDecision(rule).guardSubstitution(req)
+		}
+	}
+
+	return PolicyDecision{Action: PolicyAsk, Reason: "no matching rule"}
+}
+
+func newPolicyDecision(rule *PolicyRule) PolicyDecision {
+	reason := rule.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("%s rule from %s", rule.Action, rule.Source)
+	}
+	return PolicyDecision{Action: rule.Action, Rule: rule, Reason: reason}
+}
+
+// guardSubstitution downgrades an allow to ask when the command runs a
+// nested command the rule never saw.
+func (d PolicyDecision) guardSubstitution(req PolicyRequest) PolicyDecision {
+	if d.Action != PolicyAllow || req.Command == "" {
+		return d
+	}
+	if strings.Contains(req.Command, "$(") || strings.Contains(req.Command, "`") {
+		return PolicyDecision{
+			Action: PolicyAsk,
+			Rule:   d.Rule,
+			Reason: "command substitution needs confirmation",
+		}
+	}
+	return d
+}
+
+// matches reports whether the rule applies to req. Restrictive rules
+// (deny/ask) match when any touched path matches, and also look at the
+// words of a shell command; an allow rule needs every path to match.
+func (r *PolicyRule) matches(req PolicyRequest, restrictive bool) bool {
+	if r.Tool != "" {
+		if ok, _ := path.Match(r.Tool, req.Tool); !ok {
+			return false
+		}
+	}
+
+	if r.Command != "" {
+		if req.Command == "" || !hasCommandPrefix(req.Command, r.Command) {
+			return false
+		}
+	}
+
+	if len(r.Paths) == 0 {
+		return true
+	}
+
+	candidates := append([]string{}, req.Paths...)
+	if restrictive {
+		for _, word := range strings.Fields(req.Command) {
+			word = strings.Trim(word, `"'`)
+			if word != "" {
+				candidates = append(candidates, path.Clean(filepath.ToSlash(word)))
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	for _, candidate := range candidates {
+		matched := r.matchesPath(candidate)
+		if restrictive && matched {
+			return true
+		}
+		if !restrictive && !matched {
+			return false
+		}
+	}
+	return !restrictive
+}
+
+func (r *PolicyRule) matchesPath(name string) bool {
+	for _, pattern := range r.Paths {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Log appends the decision and its outcome to the policy log.
+func (p *Policy) Log(req PolicyRequest, decision PolicyDecision, approved bool) {
+	if p == nil || p.logPath == "" {
+		return
+	}
+
+	source := ""
+	if decision.Rule != nil {
+		source = decision.Rule.Source
+	}
+
+	entry := struct {
+		Time     time.Time    `json:"time"`
+		Tool     string       `json:"tool"`
+		Command  string       `json:"command,omitempty"`
+		Paths    []string     `json:"paths,omitempty"`
+		Action   PolicyAction `json:"action"`
+		Source   string       `json:"source,omitempty"`
+		Reason   string       `json:"reason"`
+		Approved bool         `json:"approved"`
+	}{
+		Time:     time.Now(),
+		Tool:     req.Tool,
+		Command:  secrets.RedactForDisplay(req.Command),
+		Paths:    req.Paths,
+		Action:   decision.Action,
+		Source:   source,
+		Reason:   decision.Reason,
+		Approved: approved,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(p.logPath), 0755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(p.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+func policyRank(action PolicyAction) int {
+	switch action {
+	case PolicyDeny:
+		return 2
+	case PolicyAsk:
+		return 1
+	}
+	return 0
+}
+
+// splitCommand breaks a shell command on &&, ||, ;, |, |&, a background &
+// and newlines. The & of a redirection such as 2>&1 or &> stays in its
+// command. It does not understand quoting, which only makes it split more
+// eagerly.
+func splitCommand(command string) []string {
+	segments := []string{}
+	var current strings.Builder
+	flush := func() {
+		if segment := strings.TrimSpace(current.String()); segment != "" {
+			segments = append(segments, segment)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(command)
+	next := func(i int) rune {
+		if i+1 < len(runes) {
+			return runes[i+1]
+		}
+		return 0
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case ';', '\n':
+			flush()
+		case '|':
+			flush()
+			if next(i) == '|' || next(i) == '&' {
+				i++
+			}
+		case '&':
+			redirect := next(i) == '>' || (i > 0 && (runes[i-1] == '>' || runes[i-1] == '<'))
+			if redirect {
+				current.WriteRune(r)
+				continue
+			}
+			flush()
+			if next(i) == '&' {
+				i++
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return segments
+}
+
+// hasCommandPrefix matches whole words, so "go test" matches
+// "go test ./..." but not "go testify".
+func hasCommandPrefix(command, prefix string) bool {
+	words := strings.Fields(command)
+	prefixWords := strings.Fields(prefix)
+	if len(prefixWords) == 0 || len(prefixWords) > len(words) {
+		return false
+	}
+	for i, word := range prefixWords {
+		if words[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizePolicyPath(root, name string) string {
+	if filepath.IsAbs(name) && root != "" {
+		if rel, err := filepath.Rel(root, name); err == nil &&
+			!strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+	}
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// matchGlob matches slash-separated paths where "**" spans any number of
+// directories and a trailing slash means everything below the directory.
+func matchGlob(pattern, name string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range len(name) + 1 {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"go test ./...", []string{"go test ./..."}},
+		{"go build && go test ./...", []string{"go build", "go test ./..."}},
+		{"make || echo failed", []string{"make", "echo failed"}},
+		{"cd src; ls", []string{"cd src", "ls"}},
+		{"cat go.mod | head", []string{"cat go.mod", "head"}},
+		{"go vet ./... |& tee vet.log", []string{"go vet ./...", "tee vet.log"}},
+		{"go build\ngo test", []string{"go build", "go test"}},
+		{"go test ./... 2>&1", []string{"go test ./... 2>&1"}},
+		{"go test ./... &> test.log", []string{"go test ./... &> test.log"}},
+		{"go test ./... &>> test.log", []string{"go test ./... &>> test.log"}},
+		{"go test ./... >&2", []string{"go test ./... >&2"}},
+		{"npm run dev &", []string{"npm run dev"}},
+		{"sleep 5 & rm -rf build", []string{"sleep 5", "rm -rf build"}},
+		{"go test ./... 2>&1 | tail -5", []string{"go test ./... 2>&1", "tail -5"}},
+		{"  ;; ", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCommand(tt.command); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateChainedCommands(t *testing.T) {
+	policy := &Policy{rules: []PolicyRule{
+		{Action: PolicyAllow, Tool: "bash", Command: "go test"},
+		{Action: PolicyAllow, Tool: "bash", Command: "tail"},
+		{Action: PolicyDeny, Tool: "bash", Command: "rm -rf"},
+	}}
+
+	tests := []struct {
+		command string
+		want    PolicyAction
+	}{
+		{"go test ./...", PolicyAllow},
+		{"go test ./... 2>&1", PolicyAllow},
+		{"go test ./... &> test.log", PolicyAllow},
+		{"go test ./... 2>&1 | tail -5", PolicyAllow},
+		{"go test ./... &", PolicyAllow},
+		{"go test ./... && go build", PolicyAsk},
+		{"go test ./... & rm -rf /", PolicyDeny},
+		{"go test ./... | sh", PolicyAsk},
+	}
+
+	for _, tt := range tests {
+		got := policy.Evaluate(PolicyRequest{Tool: "bash", Command: tt.command})
+		if got.Action != tt.want {
+			t.Errorf("Evaluate(%q) = %s (%s), want %s", tt.command, got.Action, got.Reason, tt.want)
+		}
+	}
+}
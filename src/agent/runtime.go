@@ -1,16 +1,23 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"zipcode/src/config"
 	"zipcode/src/credentials"
 	"zipcode/src/llm/prompts"
 	llm "zipcode/src/llm/provider"
+	"zipcode/src/mcp"
 	"zipcode/src/skills"
+	"zipcode/src/subagents"
 	"zipcode/src/tools"
 	"zipcode/src/utils"
 	"zipcode/src/workspace"
@@ -26,7 +33,9 @@ const (
 )
 
 // AutoCompactThreshold is the input-token count above which Run will
-// trigger a context compaction before processing the next prompt.
+// trigger a context compaction before processing the next prompt, used when
+// the context window of the model is unknown. Otherwise the threshold is
+// auto_compact_percent of the window.
 const AutoCompactThreshold = 200000
 
 const compactSummarizationPrompt = "Provide a concise but thorough summary of the conversation above. Preserve key context, decisions made, files touched, user preferences expressed, and the current state of any in-progress work. The summary will replace the full transcript so that the conversation can continue without losing context."
@@ -41,20 +50,41 @@ type Runtime struct {
 	CurrentProvider llm.Provider
 	Workspace         *workspace.Workspace
 	Tools             []tools.Tool
+	ToolRegistry      *tools.Registry
+	MCPClients        []*mcp.Client
 	InputTokens       int
 	CachedInputTokens int
-	OutputTokens      int
+	// CacheCreationInputTokens is the part of InputTokens written to the
+	// prompt cache.
+	CacheCreationInputTokens int
+	OutputTokens             int
 	Conversation      llm.Conversation
 	Agent           Agent
 	Session         string
 	ChildRuntime    bool
 	SkillRegistry   *skills.SkillRegistry
 	SkillWatcher    *skills.Watcher
+	SubagentRegistry *subagents.Registry
+	SubagentWatcher  *subagents.Watcher
 	CredStore       *credentials.Store
 	Validator       credentials.Validator
 	activePlan      *Plan
+	control         *runControl
+	subagentUsage   *subagentLedger
 }
 
+// runControl holds the cancel func of the run in flight. It lives behind a
+// pointer so Runtime values can still be copied around (NewRuntime returns
+// one by value) while every copy cancels the same run.
+type runControl struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// cancelledByUser is the tool error recorded for calls that never ran
+// because the user aborted the run.
+const cancelledByUser = "cancelled by user"
+
 func NewRuntime(workspace *workspace.Workspace) Runtime {
 	registry, watcher, _ := skills.Init(
 		config.Cfg.InternalSkillsPath,
@@ -63,6 +93,16 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 		config.Cfg.SkillsStatePath,
 	)
 
+	subagentRegistry, subagentWatcher, err := subagents.Init(
+		config.Cfg.InternalSubagentsPath,
+		config.Cfg.ExternalSubagentsPath,
+		config.Cfg.ProjectSubagentsPath,
+		config.Cfg.SubagentsStatePath,
+	)
+	if err != nil {
+		utils.Log(err.Error())
+	}
+
 	runtime := Runtime{
 		Status:    Idle,
 		Registry:  llm.NewRegistry(),
@@ -70,17 +110,35 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 		Executor: NewExecutor(prompts.MainSystemPrompt,
 			[]tools.Tool{
 				tools.FileWriteTool,
-				tools.SubAgentTool,
 				tools.InvokeSkillTool,
 				tools.CreatePlanTool,
 			},
 		),
 		SkillRegistry: registry,
 		SkillWatcher:  watcher,
+		SubagentRegistry: subagentRegistry,
+		SubagentWatcher:  subagentWatcher,
 		CredStore:     credentials.NewStore(),
+		ToolRegistry:  tools.NewRegistry(),
+		control:       &runControl{},
+		subagentUsage: &subagentLedger{},
 	}
+	runtime.Executor.Handlers = runtime.ToolRegistry
+	runtime.registerCustomProviders()
+	runtime.registerReplayProviders()
+	runtime.configureHTTP()
+
+	catalog, err := llm.NewModelCatalog(
+		config.Cfg.ModelCachePath,
+		config.Cfg.ModelOverridesPath,
+		time.Duration(config.Cfg.ModelCacheTTLHours)*time.Hour,
+	)
+	if err != nil {
+		runtime.notifyError(fmt.Sprintf("Failed to load model overrides. Error: %s", err.Error()))
+	}
+	runtime.Registry.Catalog = catalog
 
-	err := runtime.CredStore.Load()
+	err = runtime.CredStore.Load()
 
 	if err != nil {
 		go EventManager.WriteToChannel(
@@ -95,6 +153,33 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 		)
 	}
 
+	root := ""
+	if workspace != nil {
+		root = workspace.RootPath
+	}
+	policy, err := LoadPolicy(
+		root,
+		config.Cfg.GlobalPolicyPath,
+		config.Cfg.ProjectPolicyPath,
+		config.Cfg.PolicyLogPath,
+	)
+	runtime.Executor.Policy = policy
+	runtime.Executor.Workspace = workspace
+
+	if err != nil {
+		utils.Log(err.Error())
+		go EventManager.WriteToChannel(
+			NOTIFICATION_CHANNEL,
+			Notification{
+				Type: ERROR,
+				Message: fmt.Sprintf(
+					"Failed to load tool policy. Error: %s",
+					err.Error(),
+				),
+			},
+		)
+	}
+
 	runtime.Validator = *credentials.NewValidator(runtime.Registry.Providers, runtime.CredStore)
 
 	for name, prov := range runtime.Registry.Providers {
@@ -102,6 +187,7 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 			prov.SetApiKey(creds.APIKey)
 		}
 	}
+	runtime.RefreshModels(false)
 
 	if config.Cfg.ActiveProviderName != "" {
 		active := runtime.Registry.GetProvider(
@@ -111,13 +197,17 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 		if saved, ok := config.Cfg.ProviderModels[config.Cfg.ActiveProviderName]; ok && saved != "" {
 			config.Cfg.CurrentModel = saved
 		} else if active != nil {
-			if models := active.Models(); len(models) > 0 {
+			if models := runtime.Registry.Models(active.Name()); len(models) > 0 {
 				config.Cfg.CurrentModel = models[0].ID
 				if config.Cfg.ProviderModels == nil {
 					config.Cfg.ProviderModels = map[string]string{}
 				}
 				config.Cfg.ProviderModels[config.Cfg.ActiveProviderName] = models[0].ID
-				config.Cfg.Save()
+				// Headless runs may be configured with --config, which is
+				// not to be saved over the user's config.
+				if !config.Cfg.Headless {
+					config.Cfg.Save()
+				}
 			}
 		}
 	}
@@ -132,17 +222,27 @@ func NewRuntime(workspace *workspace.Workspace) Runtime {
 		&runtime.Registry,
 		&runtime.Validator,
 	)
+	runtime.Agent.OnStream = runtime.Executor.PushStreamDelta
+
+	for _, handler := range tools.Builtins() {
+		runtime.ToolRegistry.Register(handler)
+	}
+	if !config.Cfg.Headless {
+		runtime.ToolRegistry.Register(questionHandler{})
+	}
+	runtime.Tools = append(
+		runtime.Tools,
+		runtime.ToolRegistry.Definitions()...,
+	)
 	runtime.Tools = append(
 		runtime.Tools,
-		tools.FileWriteTool,
 		tools.InvokeSkillTool,
 		tools.CreatePlanTool,
+		subagents.Tool(runtime.SubagentRegistry.ListEnabled()),
 	)
-	if !config.Cfg.Headless {
-		runtime.Tools = append(runtime.Tools, tools.QuestionTool)
-	}
 	runtime.loadTools(config.Cfg.InternalToolPath)
 	runtime.loadTools(config.Cfg.ExternalToolPath)
+	runtime.startMCPServers()
 
 	return runtime
 }
@@ -167,7 +267,9 @@ func (r *Runtime) SetSession(session *workspace.Session) {
 }
 
 func (r *Runtime) persistSessionHistory() {
-	if r.Workspace == nil || r.Workspace.Session == nil {
+	// Sub-agents share the parent's session; their history is not the
+	// session's, and several may finish at once.
+	if r.ChildRuntime || r.Workspace == nil || r.Workspace.Session == nil {
 		return
 	}
 	messages := r.Agent.Conversation.Messages
@@ -178,14 +280,16 @@ func (r *Runtime) persistSessionHistory() {
 	_ = r.Workspace.Session.Save()
 }
 
-// maybeAutoCompact triggers a compaction if the most recent API call's input
-// token count exceeded AutoCompactThreshold. Failures are surfaced as
-// notifications so the run can continue with the uncompacted history.
-func (r *Runtime) maybeAutoCompact() {
+// maybeAutoCompact triggers a compaction if sending prompt with the current
+// history would exceed the compaction threshold of the model, as counted
+// locally. Failures are surfaced as notifications so the run can continue
+// with the uncompacted history.
+func (r *Runtime) maybeAutoCompact(ctx context.Context, prompt llm.Message) {
 	if r.ChildRuntime {
 		return
 	}
-	if r.Agent.Conversation.Usage.InputTokens <= AutoCompactThreshold {
+	next := r.Agent.CountTokens(prompt)
+	if next.Total() <= r.CompactThreshold() {
 		return
 	}
 
@@ -197,7 +301,8 @@ func (r *Runtime) maybeAutoCompact() {
 		},
 	)
 
-	if _, err := r.Compact(); err != nil {
+	summary, err := r.Compact(ctx)
+	if err != nil {
 		go EventManager.WriteToChannel(
 			NOTIFICATION_CHANNEL,
 			Notification{
@@ -210,6 +315,16 @@ func (r *Runtime) maybeAutoCompact() {
 		)
 		return
 	}
+	if summary == "" {
+		go EventManager.WriteToChannel(
+			NOTIFICATION_CHANNEL,
+			Notification{
+				Type:    INFO,
+				Message: "Nothing older than the recent turns to compact.",
+			},
+		)
+		return
+	}
 
 	go EventManager.WriteToChannel(
 		NOTIFICATION_CHANNEL,
@@ -225,169 +340,313 @@ func (r *Runtime) maybeAutoCompact() {
 func (r *Runtime) Clear() {
 	systemPrompt := r.Agent.SystemPrompt
 	r.Agent.Conversation.Messages = []llm.Message{
-		{Role: "system", Content: systemPrompt},
+		llm.TextMessage("system", systemPrompt),
 	}
 	r.Agent.Conversation.Usage = llm.Usage{}
 	r.InputTokens = 0
 	r.CachedInputTokens = 0
+	r.CacheCreationInputTokens = 0
 	r.OutputTokens = 0
+	r.resetSubagentUsage()
 	r.persistSessionHistory()
 }
 
-// Compact asks the active provider to summarize the current conversation and
-// replaces the message history with that summary, preserving the system prompt.
-// Returns the summary text (or an empty string and error if compaction fails).
-func (r *Runtime) Compact() (string, error) {
-	if config.Cfg.ActiveProviderName == "" {
+// Compact summarizes the older part of the conversation and replaces it
+// with that summary, preserving the system prompt. The last
+// compact_keep_turns turns, and any tool exchange they are part of, are kept
+// verbatim. The summary is written by compact_model when one is configured.
+// Returns the summary text (or an empty string and error if compaction
+// fails); an empty string and nil error mean there was nothing to compact.
+func (r *Runtime) Compact(ctx context.Context) (string, error) {
+	providerName, provider, model := r.compactionModel()
+	if providerName == "" {
 		return "", fmt.Errorf("no active provider configured")
 	}
-
-	provider := r.Registry.GetProvider(
-		llm.ProviderName(config.Cfg.ActiveProviderName),
-	)
 	if provider == nil {
-		return "", fmt.Errorf(
-			"provider %q not registered",
-			config.Cfg.ActiveProviderName,
-		)
+		return "", fmt.Errorf("provider %q not registered", providerName)
 	}
 
 	msgs := r.Agent.Conversation.Messages
 	if len(msgs) <= 1 {
 		return "", nil
 	}
+	history := msgs[1:]
 
-	requestMessages := append([]llm.Message{}, msgs...)
-	requestMessages = append(requestMessages, llm.Message{
-		Role:    "user",
-		Content: compactSummarizationPrompt,
-	})
+	split := compactionSplit(history, config.Cfg.CompactKeepTurns)
+	if split == 0 {
+		return "", nil
+	}
+	older := history[:split]
+	recent := append([]llm.Message{}, history[split:]...)
+
+	requestMessages := append([]llm.Message{msgs[0]}, older...)
+	requestMessages = append(requestMessages, llm.TextMessage("user", compactSummarizationPrompt))
 
-	resp, err := provider.Complete(llm.ChatRequest{
-		Model:    config.Cfg.CurrentModel,
+	resp, err := provider.Complete(ctx, llm.ChatRequest{
+		Model:    model,
 		Messages: requestMessages,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	summary := strings.TrimSpace(resp.Message.Content)
+	summary := strings.TrimSpace(resp.Message.Text())
 	if summary == "" {
 		return "", fmt.Errorf("provider returned empty summary")
 	}
 
-	r.Agent.Conversation.Messages = []llm.Message{
-		{Role: "system", Content: r.Agent.SystemPrompt},
-		{
-			Role:    "user",
-			Content: "Summary of prior conversation:\n\n" + summary,
-		},
-		{
-			Role:    "assistant",
-			Content: "Understood. I have the summary of our prior conversation and will continue from here.",
-		},
-	}
+	r.Agent.Conversation.Messages = append([]llm.Message{
+		llm.TextMessage("system", r.Agent.SystemPrompt),
+		llm.TextMessage("user", "Summary of prior conversation:\n\n"+summary),
+		llm.TextMessage(
+			"assistant",
+			"Understood. I have the summary of our prior conversation and will continue from here.",
+		),
+	}, recent...)
 	r.Agent.Conversation.Usage = llm.Usage{}
 	r.InputTokens = 0
 	r.CachedInputTokens = 0
+	r.CacheCreationInputTokens = 0
 	r.OutputTokens = 0
+	r.resetSubagentUsage()
 
 	r.persistSessionHistory()
 	return summary, nil
 }
 
-type SubAgentRequest struct {
-	AgentName   string
-	AgentPrompt string
-}
-
-type SubAgent struct {
-	Name             string   `json:"name"`
-	ShortDescription string   `json:"short_description"`
-	SystemPrompt     string   `json:"system_prompt"`
-	AllowedTools     []string `json:"allowed_tools"`
-}
-
-func GetToolsforSubAgent(toolNames []string) ([]tools.Tool, error) {
-	allowedTools := []tools.Tool{}
-
-	for _, toolName := range toolNames {
-		toolManifest, err := GetTool(config.Cfg.InternalToolPath, toolName)
-		if err != nil {
-			return nil, err
-		}
-
-		allowedTools = append(allowedTools, toolManifest)
-	}
-
-	return allowedTools, nil
-}
-
 func (r *Runtime) NewChildRuntime(
 	agentName string,
 	parent *Runtime,
 ) (*Runtime, error) {
-	content, err := os.ReadFile(
-		fmt.Sprintf("%s/%s.json", config.Cfg.InternalSubagentsPath, agentName),
-	)
-	if err != nil {
-		return nil, err
+	if r.SubagentRegistry == nil {
+		return nil, fmt.Errorf("no sub-agents are loaded")
+	}
+	definition, ok := r.SubagentRegistry.Get(agentName)
+	if !ok {
+		return nil, fmt.Errorf("unknown sub-agent %q", agentName)
+	}
+	if !definition.Enabled {
+		return nil, fmt.Errorf("sub-agent %q is disabled", agentName)
 	}
 
-	var subAgentDefinition SubAgent
-	err = json.Unmarshal(content, &subAgentDefinition)
+	tools, err := r.ToolRegistry.Lookup(definition.AllowedTools)
 	if err != nil {
 		return nil, err
 	}
 
-	tools, err := GetToolsforSubAgent(subAgentDefinition.AllowedTools)
-
 	runtime := &Runtime{
 		Status:        Idle,
 		Workspace:     r.Workspace,
-		Executor:      r.Executor,
+		Executor:      r.Executor.ForSubAgent(agentName, ""),
 		Session:       r.Session,
 		ChildRuntime:  true,
 		SkillRegistry: r.SkillRegistry,
+		SubagentRegistry: r.SubagentRegistry,
 		Registry:      parent.Registry,
+		ToolRegistry:  r.ToolRegistry,
 	}
 
 	childAgent := NewAgent(
-		subAgentDefinition.SystemPrompt,
+		definition.SystemPrompt,
 		&tools,
 		&runtime.Registry,
 		&r.Validator,
 	)
+	childAgent.Model = definition.Model
+	childAgent.MaxTokens = definition.MaxTokens
+	childAgent.Temperature = definition.Temperature
+	childAgent.ReasoningEffort = definition.ReasoningEffort
+
+	if definition.Provider != "" {
+		name, provider := runtime.Registry.Lookup(definition.Provider)
+		if provider == nil {
+			return nil, fmt.Errorf(
+				"sub-agent %q uses unknown provider %q",
+				agentName,
+				definition.Provider,
+			)
+		}
+		childAgent.Provider = string(name)
+		if childAgent.Model == "" {
+			childAgent.Model = defaultModelFor(runtime.Registry, name)
+		}
+	}
 
 	runtime.Agent = childAgent
 
 	return runtime, nil
 }
 
-func (r *Runtime) loadTools(path string) error {
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		return err
+// defaultModelFor returns the model last selected for a provider, or its
+// first model when none was.
+func defaultModelFor(registry llm.Registry, name llm.ProviderName) string {
+	if saved, ok := config.Cfg.ProviderModels[string(name)]; ok && saved != "" {
+		return saved
+	}
+	if models := registry.Models(name); len(models) > 0 {
+		return models[0].ID
 	}
+	return ""
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			content, err := os.ReadFile(
-				fmt.Sprintf("%s/%s/%s.json", path, entry.Name(), entry.Name()),
-			)
-			if err != nil {
-				fmt.Println("Error reading file:", err)
-				continue
+// registerCustomProviders adds the OpenAI-compatible servers from the
+// config to the registry, in name order, and tells the credential store
+// where their keys are.
+func (r *Runtime) registerCustomProviders() {
+	names := make([]string, 0, len(config.Cfg.CustomProviders))
+	for name := range config.Cfg.CustomProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		custom := config.Cfg.CustomProviders[name]
+		if custom.BaseURL == "" {
+			r.notifyError(fmt.Sprintf("Custom provider %s has no base_url, skipped", name))
+			continue
+		}
+
+		models := make([]llm.ModelDescriptor, len(custom.Models))
+		for i, m := range custom.Models {
+			models[i] = llm.ModelDescriptor{
+				ID:                   m.ID,
+				ContextWindow:        m.ContextWindow,
+				Vision:               m.Vision,
+				InputCostPerMillion:  m.InputCostPerMillion,
+				OutputCostPerMillion: m.OutputCostPerMillion,
 			}
+		}
 
-			var tool tools.Tool
-			err = json.Unmarshal([]byte(content), &tool)
+		provider := llm.NewOpenAICompatible(llm.CompatibleConfig{
+			Name:       llm.ProviderName(name),
+			BaseURL:    custom.BaseURL,
+			AuthHeader: custom.AuthHeader,
+			Models:     models,
+		})
+		if err := r.Registry.Register(provider); err != nil {
+			r.notifyError(fmt.Sprintf("Custom provider %s skipped: %s", name, err.Error()))
+			continue
+		}
+		if custom.APIKeyEnv != "" {
+			r.CredStore.SetEnvVar(provider.Name(), custom.APIKeyEnv)
+		}
+	}
+}
 
-			r.Tools = append(r.Tools, tool)
+// registerReplayProviders adds the Replay and Scripted providers when the
+// config sets a cassette or a script. They come after the custom providers
+// so that a custom provider can be recorded.
+func (r *Runtime) registerReplayProviders() {
+	if replay := config.Cfg.Replay; replay.Cassette != "" {
+		var provider *llm.Replay
+		var err error
+		if replay.Record != "" {
+			_, inner := r.Registry.Lookup(replay.Record)
+			if inner == nil {
+				err = fmt.Errorf("unknown provider %s to record", replay.Record)
+			} else {
+				provider = llm.NewRecorder(replay.Cassette, inner)
+			}
+		} else {
+			provider, err = llm.NewReplay(replay.Cassette, replay.Strict)
+		}
+		if err == nil {
+			err = r.Registry.Register(provider)
+		}
+		if err != nil {
+			r.notifyError(fmt.Sprintf("Replay provider skipped: %s", err.Error()))
 		}
 	}
 
-	return nil
+	if config.Cfg.ScriptPath != "" {
+		provider, err := llm.NewScripted(config.Cfg.ScriptPath)
+		if err == nil {
+			err = r.Registry.Register(provider)
+		}
+		if err != nil {
+			r.notifyError(fmt.Sprintf("Scripted provider skipped: %s", err.Error()))
+		}
+	}
+}
+
+// configureHTTP gives each provider that talks HTTP a client built from
+// the [http] config. A provider whose settings are invalid keeps the
+// default client, and the error is shown once for all providers it
+// affects.
+func (r *Runtime) configureHTTP() {
+	failed := map[string][]string{}
+	var messages []string
+	for _, name := range r.Registry.ProviderList() {
+		provider, ok := r.Registry.GetProvider(name).(llm.HTTPProvider)
+		if !ok {
+			continue
+		}
+
+		settings := config.Cfg.HTTP.ForProvider(string(name))
+		client, err := llm.NewHTTPClient(llm.HTTPSettings{
+			Provider:          name,
+			ConnectTimeout:    time.Duration(settings.ConnectTimeoutSeconds) * time.Second,
+			ResponseTimeout:   time.Duration(settings.ResponseTimeoutSeconds) * time.Second,
+			StreamIdleTimeout: time.Duration(settings.StreamIdleTimeoutSeconds) * time.Second,
+			Proxy:             settings.Proxy,
+			Headers:           settings.Headers,
+			CABundle:          settings.CABundle,
+		})
+		if err != nil {
+			if _, seen := failed[err.Error()]; !seen {
+				messages = append(messages, err.Error())
+			}
+			failed[err.Error()] = append(failed[err.Error()], string(name))
+			continue
+		}
+		provider.SetHTTPClient(client)
+	}
+
+	for _, message := range messages {
+		r.notifyError(fmt.Sprintf(
+			"Invalid HTTP settings for %s, using the defaults: %s",
+			strings.Join(failed[message], ", "),
+			message,
+		))
+	}
+}
+
+// RefreshModels fetches, in the background, the model lists that are older
+// than the cache TTL, or all of them when force is set. A provider that
+// cannot be reached keeps its cached or built-in list.
+func (r *Runtime) RefreshModels(force bool) {
+	registry := r.Registry
+	if registry.Catalog == nil {
+		return
+	}
+	go func() {
+		err := registry.Catalog.Refresh(context.Background(), registry.Providers, force)
+		if err != nil {
+			utils.Log(err.Error())
+		}
+	}()
+}
+
+// loadTools registers the script tools found in path and adds manifests
+// without a script to the tool list. Built-in tools keep their
+// names; a script tool trying to reuse one is skipped, as is an invalid
+// manifest.
+func (r *Runtime) loadTools(path string) error {
+	handlers, definitions, err := tools.LoadDir(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		utils.Log(err.Error())
+	}
+
+	for _, handler := range handlers {
+		if err := r.ToolRegistry.Register(handler); err != nil {
+			utils.Log(err.Error())
+			continue
+		}
+		r.Tools = append(r.Tools, handler.Definition())
+	}
+	r.Tools = append(r.Tools, definitions...)
+
+	return err
 }
 
 type SubagentToolArgs struct {
@@ -409,13 +668,13 @@ Rules:
 - Be specific: name files, functions, or symbols rather than vague pronouns.`
 
 func (r *Runtime) emitPlanStatus() {
-	if r.activePlan == nil {
+	if r.activePlan == nil || config.Cfg.Headless {
 		return
 	}
 	EventManager.WriteToChannel(PLAN_STATUS_CHANNEL, r.activePlan.snapshot())
 }
 
-func (r *Runtime) generateStepPrompt(idx int) (string, error) {
+func (r *Runtime) generateStepPrompt(ctx context.Context, idx int) (string, error) {
 	if r.activePlan == nil {
 		return "", fmt.Errorf("no active plan")
 	}
@@ -423,12 +682,13 @@ func (r *Runtime) generateStepPrompt(idx int) (string, error) {
 	if idx < 0 || idx >= len(plan.Steps) {
 		return "", fmt.Errorf("step index out of range")
 	}
-	if config.Cfg.ActiveProviderName == "" {
+	providerName := r.Agent.providerName()
+	if providerName == "" {
 		return "", fmt.Errorf("no active provider configured")
 	}
-	provider := r.Registry.GetProvider(llm.ProviderName(config.Cfg.ActiveProviderName))
+	provider := r.Registry.GetProvider(llm.ProviderName(providerName))
 	if provider == nil {
-		return "", fmt.Errorf("provider %q not registered", config.Cfg.ActiveProviderName)
+		return "", fmt.Errorf("provider %q not registered", providerName)
 	}
 
 	var sb strings.Builder
@@ -452,17 +712,17 @@ func (r *Runtime) generateStepPrompt(idx int) (string, error) {
 	}
 	fmt.Fprintf(&sb, "\nWrite the concrete prompt for step %d (%q).", idx+1, plan.Steps[idx].Outline)
 
-	resp, err := provider.Complete(llm.ChatRequest{
-		Model: config.Cfg.CurrentModel,
+	resp, err := provider.Complete(ctx, llm.ChatRequest{
+		Model: r.Agent.modelName(),
 		Messages: []llm.Message{
-			{Role: "system", Content: stepPromptSystem},
-			{Role: "user", Content: sb.String()},
+			llm.TextMessage("system", stepPromptSystem),
+			llm.TextMessage("user", sb.String()),
 		},
 	})
 	if err != nil {
 		return "", err
 	}
-	out := strings.TrimSpace(resp.Message.Content)
+	out := strings.TrimSpace(resp.Message.Text())
 	if out == "" {
 		return "", fmt.Errorf("provider returned empty step prompt")
 	}
@@ -470,107 +730,126 @@ func (r *Runtime) generateStepPrompt(idx int) (string, error) {
 }
 
 func (r *Runtime) handlePlanAction(
+	ctx context.Context,
 	tc *ToolCallResponseData,
 ) (llm.Message, *string, error) {
 	var args tools.CreatePlanInput
 	if err := json.Unmarshal(tc.Arguments, &args); err != nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tc.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tc.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"invalid plan args: %s"}`,
 				err.Error(),
 			),
-		}, nil, nil
+			true,
+		), nil, nil
 	}
 	if len(args.Steps) == 0 {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tc.Id,
-			Content:    `{"success":false,"error":"plan requires at least one step"}`,
-		}, nil, nil
+		return llm.ToolResultMessage(
+			tc.Id,
+			`{"success":false,"error":"plan requires at least one step"}`,
+			true,
+		), nil, nil
 	}
 	if r.activePlan != nil && r.activePlan.Active {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tc.Id,
-			Content:    `{"success":false,"error":"a plan is already active"}`,
-		}, nil, nil
+		return llm.ToolResultMessage(
+			tc.Id,
+			`{"success":false,"error":"a plan is already active"}`,
+			true,
+		), nil, nil
 	}
 
 	r.activePlan = newPlan(args.Title, args.Steps)
 	r.emitPlanStatus()
 
-	firstPrompt, err := r.generateStepPrompt(0)
+	firstPrompt, err := r.generateStepPrompt(ctx, 0)
 	if err != nil {
 		r.activePlan.Active = false
 		r.emitPlanStatus()
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tc.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tc.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"failed to generate first step prompt: %s"}`,
 				err.Error(),
 			),
-		}, nil, nil
+			true,
+		), nil, nil
 	}
 	r.activePlan.Steps[0].Prompt = firstPrompt
 	r.activePlan.Steps[0].Status = PlanStepRunning
 	r.emitPlanStatus()
 
-	ack := llm.Message{
-		Role:       "tool",
-		ToolCallId: tc.Id,
-		Content: fmt.Sprintf(
+	ack := llm.ToolResultMessage(
+		tc.Id,
+		fmt.Sprintf(
 			`{"success":true,"title":%q,"steps":%d}`,
 			args.Title,
 			len(args.Steps),
 		),
-	}
+		false,
+	)
 	return ack, &firstPrompt, nil
 }
 
 func (r *Runtime) InvokeSubAgent(
+	ctx context.Context,
 	tool ToolCallResponseData,
 ) (llm.Message, error) {
 	var args SubagentToolArgs
 	if err := json.Unmarshal(tool.Arguments, &args); err != nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tool.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"invalid subagent args: %s"}`,
 				err.Error(),
 			),
-		}, nil
+			true,
+		), nil
 	}
 
 	childRuntime, err := r.NewChildRuntime(args.AgentName, r)
 	if err != nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tool.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"failed to create subagent: %s"}`,
 				err.Error(),
 			),
-		}, nil
+			true,
+		), nil
 	}
 
-	r.Executor.SetSubAgentModeOn(true, args.AgentName)
-	output, err := childRuntime.Run(args.Task)
-	r.Executor.SetSubAgentModeOn(false, "")
+	childRuntime.Executor.SubAgentID = tool.Id
+	run := newSubagentRun(tool.Id, args, childRuntime)
+
+	output, err := childRuntime.Run(ctx, subagentPrompt(args))
+	if ctx.Err() != nil {
+		finishSubagentRun(&run, childRuntime, workspace.SubagentCancelled, nil)
+		r.recordSubagentRun(run)
+		return llm.Message{}, ctx.Err()
+	}
 	if err != nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content: fmt.Sprintf(
+		finishSubagentRun(&run, childRuntime, workspace.SubagentFailed, err)
+		r.recordSubagentRun(run)
+		childRuntime.Executor.pushEvent(Tool, "failed. /traces shows the transcript.")
+		return llm.ToolResultMessage(
+			tool.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"subagent failed: %s"}`,
 				err.Error(),
 			),
-		}, nil
+			true,
+		), nil
 	}
 
+	finishSubagentRun(&run, childRuntime, workspace.SubagentCompleted, nil)
+	r.recordSubagentRun(run)
+	childRuntime.Executor.pushEvent(Tool, fmt.Sprintf(
+		"done: %d\u2191 / %d\u2193 tokens. /traces shows the transcript.",
+		run.Usage.InputTokens,
+		run.Usage.OutputTokens,
+	))
+
 	result := map[string]any{
 		"success":    true,
 		"agent_type": args.AgentName,
@@ -579,11 +858,54 @@ func (r *Runtime) InvokeSubAgent(
 
 	content, _ := json.Marshal(result)
 
-	return llm.Message{
-		Role:       "tool",
-		ToolCallId: tool.Id,
-		Content:    string(content),
-	}, nil
+	return llm.ToolResultMessage(tool.Id, string(content), false), nil
+}
+
+// startSubagents runs the sub-agent calls among actions in the background,
+// at most MaxParallelSubagents at a time, each writing its result to the
+// slot of its action. The returned func waits for all of them and adds
+// their usage to the runtime's counters; a slot stays nil when its
+// sub-agent was cancelled.
+func (r *Runtime) startSubagents(
+	ctx context.Context,
+	actions []ExecutionAction,
+	slots []*llm.Message,
+) func() {
+	limit := config.Cfg.MaxParallelSubagents
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i, action := range actions {
+		if action.Type != ActionSubagent {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, call ToolCallResponseData) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			result, err := r.InvokeSubAgent(ctx, call)
+			if err != nil {
+				return
+			}
+			slots[i] = &result
+		}(i, *action.ToolCall)
+	}
+
+	return func() {
+		wg.Wait()
+		r.settleSubagentUsage()
+	}
 }
 
 // ParseSkillCommand scans the prompt for a /skill-name token (anywhere, not
@@ -667,7 +989,23 @@ func (r *Runtime) refreshSystemPrompt() {
 	r.Agent.SystemPrompt = prompt
 	if len(r.Agent.Conversation.Messages) > 0 &&
 		r.Agent.Conversation.Messages[0].Role == "system" {
-		r.Agent.Conversation.Messages[0].Content = prompt
+		r.Agent.Conversation.Messages[0] = llm.TextMessage("system", prompt)
+	}
+}
+
+// refreshSubagentTool rebuilds the subagent tool schema so sub-agents
+// added, edited or removed on disk are offered from the next prompt on.
+// The entry is replaced in place: the agent holds a pointer to the tool
+// list, so its length must not change.
+func (r *Runtime) refreshSubagentTool() {
+	if r.ChildRuntime || r.SubagentRegistry == nil {
+		return
+	}
+	for i, tool := range r.Tools {
+		if tool.Function.Name == subagents.ToolName {
+			r.Tools[i] = subagents.Tool(r.SubagentRegistry.ListEnabled())
+			return
+		}
 	}
 }
 
@@ -676,57 +1014,144 @@ func (r *Runtime) invokeSkill(
 ) (llm.Message, *string, error) {
 	var args SkillToolArgs
 	if err := json.Unmarshal(tool.Arguments, &args); err != nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tool.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"invalid skill args: %s"}`,
 				err.Error(),
 			),
-		}, nil, nil
+			true,
+		), nil, nil
 	}
 
 	if r.SkillRegistry == nil {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content:    `{"success":false,"error":"skills not available"}`,
-		}, nil, nil
+		return llm.ToolResultMessage(
+			tool.Id,
+			`{"success":false,"error":"skills not available"}`,
+			true,
+		), nil, nil
 	}
 
 	skill, ok := r.SkillRegistry.Get(args.SkillName)
 	if !ok || !skill.Enabled {
-		return llm.Message{
-			Role:       "tool",
-			ToolCallId: tool.Id,
-			Content: fmt.Sprintf(
+		return llm.ToolResultMessage(
+			tool.Id,
+			fmt.Sprintf(
 				`{"success":false,"error":"unknown or disabled skill: %s"}`,
 				args.SkillName,
 			),
-		}, nil, nil
+			true,
+		), nil, nil
 	}
 
 	resolved := skills.Resolve(skill.PromptTemplate, args.Args, r.Workspace)
 
-	ack := llm.Message{
-		Role:       "tool",
-		ToolCallId: tool.Id,
-		Content:    fmt.Sprintf(`{"success":true,"skill":"%s"}`, skill.Name),
-	}
+	ack := llm.ToolResultMessage(
+		tool.Id,
+		fmt.Sprintf(`{"success":true,"skill":"%s"}`, skill.Name),
+		false,
+	)
 	return ack, &resolved, nil
 }
 
-func (r *Runtime) Run(prompt string) (*llm.Message, error) {
+// Cancel aborts the run in flight, if any: the provider request and any
+// running tool subprocess are stopped and Run returns context.Canceled.
+// Reports whether there was a run to cancel.
+func (r *Runtime) Cancel() bool {
+	if r.control == nil {
+		return false
+	}
+	r.control.mu.Lock()
+	defer r.control.mu.Unlock()
+	if r.control.cancel == nil {
+		return false
+	}
+	r.control.cancel()
+	r.control.cancel = nil
+	return true
+}
+
+func (r *Runtime) setCancel(cancel context.CancelFunc) {
+	if r.control == nil {
+		return
+	}
+	r.control.mu.Lock()
+	r.control.cancel = cancel
+	r.control.mu.Unlock()
+}
+
+// Run sends prompt to the agent and drives the tool-calling loop until the
+// model returns a final message. The run stops early when ctx is cancelled or
+// Cancel is called; the conversation is then left valid for the next prompt
+// and the runtime returns to Idle.
+func (r *Runtime) Run(ctx context.Context, prompt string) (*llm.Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.setCancel(cancel)
+	defer func() {
+		r.setCancel(nil)
+		cancel()
+	}()
+
+	msg, err := r.run(ctx, prompt)
+	r.Executor.flushStream()
+	if err != nil {
+		if ctx.Err() != nil {
+			r.finishCancelled()
+			return nil, ctx.Err()
+		}
+		r.finishFailed(err)
+	}
+	return msg, err
+}
+
+// finishCancelled closes out an aborted run and tells the output pane.
+func (r *Runtime) finishCancelled() {
+	r.Status = Cancelled
+	r.closeOut(cancelledByUser)
+	if !r.ChildRuntime {
+		r.Executor.pushEvent(RunCancelled, "Run cancelled.")
+	}
+	r.Status = Idle
+}
+
+// finishFailed closes out a run stopped by an error, so that the next
+// request does not carry tool uses without results.
+func (r *Runtime) finishFailed(err error) {
+	r.closeOut("run failed: " + err.Error())
+	r.Status = Idle
+}
+
+// closeOut ends a run that stopped early: unanswered tool calls get an
+// error result with reason, an active plan is marked failed, and the
+// trimmed history is persisted so the session can be resumed.
+func (r *Runtime) closeOut(reason string) {
+	r.Agent.CloseDanglingToolCalls(reason)
+
+	if r.activePlan != nil && r.activePlan.Active {
+		if cur := r.activePlan.Current; cur < len(r.activePlan.Steps) {
+			r.activePlan.Steps[cur].Status = PlanStepFailed
+		}
+		r.activePlan.Active = false
+		r.emitPlanStatus()
+	}
+
+	if !r.ChildRuntime {
+		r.persistSessionHistory()
+		r.Executor.SetActiveSkill("")
+	}
+}
+
+func (r *Runtime) run(ctx context.Context, prompt string) (*llm.Message, error) {
 	r.Status = Running
 	r.Prompt = prompt
 	r.refreshSystemPrompt()
+	r.refreshSubagentTool()
+	r.Agent.OnFallback = r.reportFallback
 
-	r.maybeAutoCompact()
+	message := r.promptMessage(prompt)
+	r.maybeAutoCompact(ctx, message)
 
-	conv, err := r.Agent.RunStep(llm.Message{
-		Role:    "user",
-		Content: prompt,
-	})
+	conv, err := r.Agent.RunStep(ctx, message)
 	if err != nil {
 		return nil, err
 	}
@@ -736,7 +1161,7 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 		lastResponse := conv.Messages[lastResponseIndex]
 		actions, status, err := r.Executor.ProcessResponse(lastResponse)
 
-		utils.Log(lastResponse.Content)
+		utils.Log(lastResponse.Text())
 
 		if err != nil {
 			return nil, err
@@ -746,7 +1171,7 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 			if r.activePlan != nil && r.activePlan.Active {
 				cur := r.activePlan.Current
 				if cur < len(r.activePlan.Steps) {
-					r.activePlan.Steps[cur].Output = lastResponse.Content
+					r.activePlan.Steps[cur].Output = lastResponse.Text()
 					r.activePlan.Steps[cur].Status = PlanStepCompleted
 					r.activePlan.Current++
 					r.emitPlanStatus()
@@ -759,7 +1184,10 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 					break
 				}
 
-				nextPrompt, perr := r.generateStepPrompt(r.activePlan.Current)
+				nextPrompt, perr := r.generateStepPrompt(ctx, r.activePlan.Current)
+				if perr != nil && ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
 				if perr != nil {
 					r.activePlan.Steps[r.activePlan.Current].Status = PlanStepFailed
 					r.activePlan.Active = false
@@ -781,15 +1209,13 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 				r.activePlan.Steps[r.activePlan.Current].Status = PlanStepRunning
 				r.emitPlanStatus()
 
-				conv, err = r.Agent.RunStep(llm.Message{
-					Role:    "user",
-					Content: nextPrompt,
-				})
+				conv, err = r.Agent.RunStep(ctx, llm.TextMessage("user", nextPrompt))
 				if err != nil {
 					return nil, err
 				}
 				r.InputTokens += conv.Usage.InputTokens
 				r.CachedInputTokens += conv.Usage.CachedInputTokens
+				r.CacheCreationInputTokens += conv.Usage.CacheCreationInputTokens
 				r.OutputTokens += conv.Usage.OutputTokens
 				continue
 			}
@@ -798,46 +1224,59 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 			break
 		}
 
-		messages := []llm.Message{}
 		var pendingSkillPrompts []string
 		var pendingSkillNames []string
 		var pendingPlanPrompts []string
 
-		for _, action := range actions {
-			switch action.Type {
-			case ActionToolCall:
-
-				result, err := r.Executor.ProcessToolCall(*action.ToolCall)
-				if err != nil {
-					return nil, err
+		// Every action fills its slot, so results reach the model in
+		// tool-call order even though sub-agents finish in any order.
+		slots := make([]*llm.Message, len(actions))
+		waitSubagents := r.startSubagents(ctx, actions, slots)
+
+		collect := func() []llm.Message {
+			waitSubagents()
+			out := []llm.Message{}
+			for _, m := range slots {
+				if m != nil {
+					out = append(out, *m)
 				}
+			}
+			return out
+		}
 
-				messages = append(messages, llm.Message{
-					Role:       result.Role,
-					Content:    result.Content,
-					ToolCallId: result.ToolCallID,
-				})
+		// Results of tools that already ran must reach the history even if
+		// the user cancels or a tool fails partway through this batch, and
+		// the sub-agents still running must finish first.
+		keepCompleted := func(err error) (*llm.Message, error) {
+			r.Agent.Append(collect()...)
+			return nil, err
+		}
 
-			case ActionSubagent:
-				var args SubagentToolArgs
-				err := json.Unmarshal((*action.ToolCall).Arguments, &args)
-				if err != nil {
-					return nil, err
-				}
+		for i, action := range actions {
+			if ctx.Err() != nil {
+				return keepCompleted(ctx.Err())
+			}
 
-				result, err := r.InvokeSubAgent(*action.ToolCall)
+			switch action.Type {
+			case ActionToolCall:
+
+				result, err := r.Executor.ProcessToolCall(ctx, *action.ToolCall)
+				if err != nil && ctx.Err() != nil {
+					return keepCompleted(ctx.Err())
+				}
 				if err != nil {
-					return nil, err
+					return keepCompleted(err)
 				}
 
-				messages = append(messages, result)
+				message := r.toolResultMessage(*result)
+				slots[i] = &message
 
 			case ActionSkill:
 				ack, resolved, err := r.invokeSkill(*action.ToolCall)
 				if err != nil {
-					return nil, err
+					return keepCompleted(err)
 				}
-				messages = append(messages, ack)
+				slots[i] = &ack
 
 				if resolved != nil {
 					var args SkillToolArgs
@@ -850,39 +1289,40 @@ func (r *Runtime) Run(prompt string) (*llm.Message, error) {
 				}
 
 			case ActionPlan:
-				ack, firstPrompt, err := r.handlePlanAction(action.ToolCall)
+				ack, firstPrompt, err := r.handlePlanAction(ctx, action.ToolCall)
 				if err != nil {
-					return nil, err
+					return keepCompleted(err)
 				}
-				messages = append(messages, ack)
+				slots[i] = &ack
 				if firstPrompt != nil {
 					pendingPlanPrompts = append(pendingPlanPrompts, *firstPrompt)
 				}
 			}
 		}
 
+		messages := collect()
+
 		for i, body := range pendingSkillPrompts {
-			messages = append(messages, llm.Message{
-				Role:    "user",
-				Content: body,
-			})
+			messages = append(messages, llm.TextMessage("user", body))
 			r.Executor.SetActiveSkill(pendingSkillNames[i])
 		}
 
 		for _, body := range pendingPlanPrompts {
-			messages = append(messages, llm.Message{
-				Role:    "user",
-				Content: body,
-			})
+			messages = append(messages, llm.TextMessage("user", body))
+		}
+
+		if ctx.Err() != nil {
+			return keepCompleted(ctx.Err())
 		}
 
-		conv, err = r.Agent.RunStep(messages...)
+		conv, err = r.Agent.RunStep(ctx, messages...)
 		if err != nil {
 			return nil, err
 		}
 
 		r.InputTokens += conv.Usage.InputTokens
 		r.CachedInputTokens += conv.Usage.CachedInputTokens
+		r.CacheCreationInputTokens += conv.Usage.CacheCreationInputTokens
 		r.OutputTokens += conv.Usage.OutputTokens
 	}
 
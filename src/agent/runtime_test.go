@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"zipcode/src/config"
+	llm "zipcode/src/llm/provider"
+	"zipcode/src/workspace"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests loads the config once, in a home of its own, and runs headless
+// without debug so logs stay out of ./logs.txt. Runtimes refresh their
+// model lists in the background, reading the config as they go, so tests
+// only change the fields those refreshes do not read.
+func runTests(m *testing.M) int {
+	home, err := os.MkdirTemp("", "zipcode-agent-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("HOME", home)
+	if err := config.Load(); err != nil {
+		panic(err)
+	}
+
+	none := filepath.Join(home, "none")
+	config.Cfg.Headless = true
+	config.Cfg.HeadlessApproval = config.ApprovalAllow
+	config.Cfg.InternalToolPath = none
+	config.Cfg.InternalSkillsPath = none
+	config.Cfg.InternalSubagentsPath = none
+	config.Cfg.ActiveProviderName = string(llm.ScriptedProvider)
+	config.Cfg.ProviderModels = map[string]string{string(llm.ScriptedProvider): "scripted"}
+	return m.Run()
+}
+
+// newScriptedRuntime builds a headless runtime whose active provider
+// answers with script, in the workspace at root.
+func newScriptedRuntime(t *testing.T, root string, script llm.Script) *Runtime {
+	t.Helper()
+
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Cfg.ScriptPath = filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(config.Cfg.ScriptPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := workspace.Load(root)
+	runtime := NewRuntime(&ws)
+	t.Cleanup(runtime.Close)
+	return &runtime
+}
+
+func TestRunScriptedToolCall(t *testing.T) {
+	root := t.TempDir()
+	mainGo := filepath.Join(root, "main.go")
+	if err := os.WriteFile(mainGo, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input, _ := json.Marshal(map[string]string{"path": mainGo})
+
+	runtime := newScriptedRuntime(t, root, llm.Script{Steps: []llm.ScriptStep{
+		{
+			Text:      "Reading it.",
+			ToolCalls: []llm.ScriptedToolCall{{Name: "file_read", Input: input}},
+		},
+		{
+			Expect: "package main",
+			Text:   "It is a main package with an empty main.",
+		},
+	}})
+
+	var mu sync.Mutex
+	var events []ResponseEvent
+	runtime.Executor.Observer = func(ev ResponseEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}
+
+	msg, err := runtime.Run(context.Background(), "What is in main.go?")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if msg == nil || msg.Text() != "It is a main package with an empty main." {
+		t.Fatalf("final message = %+v", msg)
+	}
+
+	// system, prompt, tool call, tool result, answer
+	messages := runtime.Agent.Conversation.Messages
+	if len(messages) != 5 {
+		for i, m := range messages {
+			t.Logf("%d %s: %q", i, m.Role, m.Text())
+		}
+		t.Fatalf("transcript has %d messages, want 5", len(messages))
+	}
+	if messages[1].Role != "user" || messages[1].Text() != "What is in main.go?" {
+		t.Errorf("prompt = %s %q", messages[1].Role, messages[1].Text())
+	}
+
+	uses := messages[2].ToolUses()
+	if len(uses) != 1 || uses[0].Name != "file_read" || uses[0].ID != "call_1_1" {
+		t.Fatalf("tool uses = %+v", uses)
+	}
+	results := messages[3].ToolResults()
+	if len(results) != 1 || results[0].ToolUseID != "call_1_1" || results[0].IsError {
+		t.Fatalf("tool results = %+v", results)
+	}
+	if !strings.Contains(results[0].Text(), "package main") {
+		t.Errorf("tool result = %q, want the file", results[0].Text())
+	}
+	if messages[4].Role != "assistant" || messages[4].Text() != msg.Text() {
+		t.Errorf("answer = %s %q", messages[4].Role, messages[4].Text())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var streamed strings.Builder
+	for _, ev := range events {
+		if ev.EventType == MessageDelta {
+			streamed.WriteString(ev.Message)
+		}
+	}
+	if !strings.Contains(streamed.String(), "It is a main package") {
+		t.Errorf("streamed %q, want the answer", streamed.String())
+	}
+}
+
+func TestRunScriptedFailureClosesToolCalls(t *testing.T) {
+	input, _ := json.Marshal(map[string]string{"path": "missing.go"})
+	runtime := newScriptedRuntime(t, t.TempDir(), llm.Script{Steps: []llm.ScriptStep{
+		{ToolCalls: []llm.ScriptedToolCall{{Name: "file_read", Input: input}}},
+		{Error: &llm.ScriptedError{Type: "overloaded_error", Message: "try later"}},
+	}})
+
+	if _, err := runtime.Run(context.Background(), "Read missing.go"); err == nil {
+		t.Fatal("run succeeded, want the scripted error")
+	}
+
+	// Every tool use in the history has its result, so the next request
+	// is valid.
+	answered := map[string]bool{}
+	for _, m := range runtime.Agent.Conversation.Messages {
+		for _, r := range m.ToolResults() {
+			answered[r.ToolUseID] = true
+		}
+	}
+	for _, m := range runtime.Agent.Conversation.Messages {
+		for _, u := range m.ToolUses() {
+			if !answered[u.ID] {
+				t.Errorf("tool use %s has no result", u.ID)
+			}
+		}
+	}
+}
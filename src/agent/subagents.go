@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	llm "zipcode/src/llm/provider"
+	"zipcode/src/workspace"
+)
+
+// SubagentUsage is what one sub-agent spent on one model since the token
+// counters were last reset.
+type SubagentUsage struct {
+	Agent    string    `json:"agent"`
+	Provider string    `json:"provider,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Runs     int       `json:"runs"`
+	Usage    llm.Usage `json:"usage"`
+}
+
+// subagentLedger lives behind a pointer for the same reason as runControl;
+// parallel sub-agents add to it as they finish. Unsettled is the usage not
+// yet added to the runtime's counters, which only the run goroutine
+// touches.
+type subagentLedger struct {
+	mu        sync.Mutex
+	entries   []SubagentUsage
+	unsettled llm.Usage
+}
+
+// SubagentUsage returns the usage of every sub-agent since the counters
+// were last reset, sorted by agent. The runtime's InputTokens and
+// OutputTokens already include it.
+func (r *Runtime) SubagentUsage() []SubagentUsage {
+	if r.subagentUsage == nil {
+		return nil
+	}
+	r.subagentUsage.mu.Lock()
+	defer r.subagentUsage.mu.Unlock()
+
+	out := append([]SubagentUsage{}, r.subagentUsage.entries...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Agent != out[j].Agent {
+			return out[i].Agent < out[j].Agent
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
+
+func (r *Runtime) resetSubagentUsage() {
+	if r.subagentUsage == nil {
+		return
+	}
+	r.subagentUsage.mu.Lock()
+	r.subagentUsage.entries = nil
+	r.subagentUsage.unsettled = llm.Usage{}
+	r.subagentUsage.mu.Unlock()
+}
+
+// settleSubagentUsage adds the usage of the sub-agents that finished since
+// the last call to the runtime's counters. It runs on the run goroutine,
+// once the sub-agents have been waited for.
+func (r *Runtime) settleSubagentUsage() {
+	if r.subagentUsage == nil {
+		return
+	}
+	r.subagentUsage.mu.Lock()
+	usage := r.subagentUsage.unsettled
+	r.subagentUsage.unsettled = llm.Usage{}
+	r.subagentUsage.mu.Unlock()
+
+	r.InputTokens += usage.InputTokens
+	r.CachedInputTokens += usage.CachedInputTokens
+	r.CacheCreationInputTokens += usage.CacheCreationInputTokens
+	r.OutputTokens += usage.OutputTokens
+}
+
+// recordSubagentRun rolls the usage of a finished sub-agent run into the
+// ledger and saves its transcript with the session. Sub-agents run in
+// parallel, so the parent's counters are left to settleSubagentUsage.
+func (r *Runtime) recordSubagentRun(run workspace.SubagentRun) {
+	if r.subagentUsage != nil {
+		r.subagentUsage.mu.Lock()
+		found := false
+		for i := range r.subagentUsage.entries {
+			e := &r.subagentUsage.entries[i]
+			if e.Agent == run.Agent && e.Provider == run.Provider && e.Model == run.Model {
+				e.Runs++
+				addUsage(&e.Usage, run.Usage)
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.subagentUsage.entries = append(r.subagentUsage.entries, SubagentUsage{
+				Agent:    run.Agent,
+				Provider: run.Provider,
+				Model:    run.Model,
+				Runs:     1,
+				Usage:    run.Usage,
+			})
+		}
+		addUsage(&r.subagentUsage.unsettled, run.Usage)
+		r.subagentUsage.mu.Unlock()
+	}
+
+	if r.Workspace == nil || r.Workspace.Session == nil {
+		return
+	}
+	if err := r.Workspace.Session.RecordSubagentRun(run); err != nil {
+		go EventManager.WriteToChannel(
+			NOTIFICATION_CHANNEL,
+			Notification{
+				Type:    ERROR,
+				Message: "Failed to save sub-agent transcript: " + err.Error(),
+			},
+		)
+	}
+}
+
+// newSubagentRun starts the record of a sub-agent call.
+func newSubagentRun(id string, args SubagentToolArgs, child *Runtime) workspace.SubagentRun {
+	return workspace.SubagentRun{
+		ID:        id,
+		Agent:     args.AgentName,
+		Task:      args.Task,
+		Context:   args.Context,
+		Provider:  child.Agent.providerName(),
+		Model:     child.Agent.modelName(),
+		StartedAt: time.Now(),
+	}
+}
+
+// finishSubagentRun fills in the transcript and usage of the child's
+// conversation, leaving out its system prompt.
+func finishSubagentRun(run *workspace.SubagentRun, child *Runtime, status workspace.SubagentStatus, err error) {
+	run.Status = status
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	messages := child.Agent.Conversation.Messages
+	if len(messages) > 0 && messages[0].Role == "system" {
+		messages = messages[1:]
+	}
+	run.Messages = append([]llm.Message{}, messages...)
+
+	for _, m := range run.Messages {
+		if m.Usage != nil {
+			addUsage(&run.Usage, *m.Usage)
+		}
+	}
+}
+
+// subagentPrompt is the child's first turn: the task, preceded by the
+// context the main agent passed along, if any.
+func subagentPrompt(args SubagentToolArgs) string {
+	if args.Context == "" {
+		return args.Task
+	}
+	return "Context from the main agent:\n" + args.Context + "\n\nTask:\n" + args.Task
+}
+
+func addUsage(total *llm.Usage, u llm.Usage) {
+	total.InputTokens += u.InputTokens
+	total.CachedInputTokens += u.CachedInputTokens
+	total.CacheCreationInputTokens += u.CacheCreationInputTokens
+	total.ReasoningTokens += u.ReasoningTokens
+	total.OutputTokens += u.OutputTokens
+}
@@ -1,7 +1,83 @@
 package bootstrap
 
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"zipcode/src/config"
+)
+
+// Output formats accepted by --output in headless mode.
+const (
+	OutputText   = "text"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+)
+
 type StartupFlags struct {
 	Workspace string
 	Config    string
 	Debug     bool
+
+	// Prompt switches zipcode into headless mode: the prompt runs to
+	// completion without the TUI and the result goes to stdout.
+	Prompt   string
+	Model    string
+	Output   string
+	Approval string
+}
+
+// Headless reports whether the flags ask for a non-interactive run.
+func (f StartupFlags) Headless() bool {
+	return f.Prompt != ""
+}
+
+// ParseFlags reads the command line (without the program name). Usage and
+// parse errors are written to stderr.
+func ParseFlags(args []string, stderr io.Writer) (StartupFlags, error) {
+	var flags StartupFlags
+
+	fs := flag.NewFlagSet("zipcode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&flags.Prompt, "p", "", "run `prompt` headless and print the result")
+	fs.StringVar(&flags.Prompt, "prompt", "", "same as -p")
+	fs.StringVar(&flags.Workspace, "workspace", "", "workspace `dir` (default: current directory)")
+	fs.StringVar(&flags.Config, "config", "", "extra config `file` applied over ~/.zipcode/config.toml")
+	fs.StringVar(&flags.Model, "model", "", "model `id` to use for this run")
+	fs.StringVar(&flags.Output, "output", OutputText, "headless output `format`: text, json or ndjson")
+	fs.StringVar(&flags.Approval, "approval", "", "answer for tool calls that need approval in headless mode: allow or deny (default from config)")
+	fs.BoolVar(&flags.Debug, "debug", false, "print debug logs to stderr in headless mode")
+
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage:")
+		fmt.Fprintln(stderr, "  zipcode [--workspace dir]                  start the TUI")
+		fmt.Fprintln(stderr, "  zipcode -p \"prompt\" [flags] [< context]    run a prompt headless")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return flags, err
+	}
+
+	if fs.NArg() > 0 {
+		return flags, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	switch flags.Output {
+	case OutputText, OutputJSON, OutputNDJSON:
+	default:
+		return flags, fmt.Errorf("invalid --output %q: want text, json or ndjson", flags.Output)
+	}
+
+	switch flags.Approval {
+	case "", config.ApprovalAllow, config.ApprovalDeny:
+	default:
+		return flags, fmt.Errorf("invalid --approval %q: want allow or deny", flags.Approval)
+	}
+
+	return flags, nil
 }
@@ -0,0 +1,236 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"zipcode/src/agent"
+	"zipcode/src/config"
+	llmerrors "zipcode/src/llm/errors"
+	llm "zipcode/src/llm/provider"
+	"zipcode/src/secrets"
+	"zipcode/src/workspace"
+)
+
+// Exit codes of a headless run.
+const (
+	ExitOK        = 0
+	ExitFailure   = 1
+	ExitUsage     = 2
+	ExitCancelled = 130
+)
+
+// Result statuses reported by --output json and ndjson.
+const (
+	statusSuccess   = "success"
+	statusError     = "error"
+	statusCancelled = "cancelled"
+)
+
+// maxStdinContext bounds how much piped input is attached to the prompt.
+const maxStdinContext = 1 << 20
+
+// headlessEvent is one line of --output ndjson.
+type headlessEvent struct {
+	Type       string `json:"type"`
+	Message    string `json:"message,omitempty"`
+	SubAgent   string `json:"subagent,omitempty"`
+	SubAgentID string `json:"subagent_id,omitempty"`
+	Skill      string `json:"skill,omitempty"`
+}
+
+// headlessResult is the --output json document, and the last line of
+// --output ndjson.
+type headlessResult struct {
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Session  string    `json:"session_id,omitempty"`
+	Usage    llm.Usage `json:"usage"`
+	// Subagents breaks down the sub-agent share of Usage.
+	Subagents []agent.SubagentUsage `json:"subagents,omitempty"`
+	// ErrorType is the kind of provider failure behind Error: auth, quota,
+	// rate_limit, model_missing, network or unspecified.
+	ErrorType string `json:"error_type,omitempty"`
+}
+
+var eventTypeNames = map[agent.ResponseEventType]string{
+	agent.Tool:          "tool",
+	agent.Error:         "error",
+	agent.Message:       "message",
+	agent.MessageDelta:  "delta",
+	agent.RunCancelled:  "cancelled",
+	agent.Thinking:      "thinking",
+	agent.ThinkingDelta: "thinking_delta",
+}
+
+// RunHeadless runs flags.Prompt to completion in the current directory
+// without the TUI and returns the process exit code. Piped stdin is
+// attached to the prompt as extra context. SIGINT and SIGTERM cancel the run.
+func RunHeadless(flags StartupFlags, stdin io.Reader, stdout, stderr io.Writer) int {
+	prompt, err := headlessPrompt(flags.Prompt, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "zipcode:", err)
+		return ExitUsage
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(stderr, "zipcode: failed to get current directory:", err)
+		return ExitFailure
+	}
+
+	ws := workspace.Load(dir)
+	runtime := agent.NewRuntime(&ws)
+	defer runtime.Close()
+
+	if flags.Model != "" {
+		config.Cfg.SetCurrentModel(flags.Model)
+	}
+
+	// Sub-agents running in parallel report events concurrently.
+	var outputMu sync.Mutex
+	encoder := json.NewEncoder(stdout)
+	if flags.Output == OutputNDJSON {
+		runtime.Executor.Observer = func(ev agent.ResponseEvent) {
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			encoder.Encode(headlessEvent{
+				Type:       eventTypeNames[ev.EventType],
+				Message:    ev.Message,
+				SubAgent:   ev.SubAgentName,
+				SubAgentID: ev.SubAgentID,
+				Skill:      ev.SkillName,
+			})
+		}
+	} else if flags.Output == OutputText {
+		runtime.Executor.Observer = func(ev agent.ResponseEvent) {
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			if ev.EventType == agent.Tool && ev.Message != "" {
+				fmt.Fprintln(stderr, "•", ev.Message)
+			}
+		}
+	}
+
+	if name, _, ok := runtime.ParseSkillCommand(prompt); ok {
+		prompt = runtime.ExpandSkillCommand(prompt)
+		runtime.Executor.SetActiveSkill(name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	msg, runErr := runtime.Run(ctx, prompt)
+
+	result := headlessResult{
+		Type:      "result",
+		Status:    statusSuccess,
+		Provider:  config.Cfg.ActiveProviderName,
+		Model:     config.Cfg.CurrentModel,
+		Session:   runtime.Session,
+		Usage:     conversationUsage(runtime.Agent.Conversation.Messages),
+		Subagents: runtime.SubagentUsage(),
+	}
+	for _, s := range result.Subagents {
+		result.Usage.InputTokens += s.Usage.InputTokens
+		result.Usage.CachedInputTokens += s.Usage.CachedInputTokens
+		result.Usage.CacheCreationInputTokens += s.Usage.CacheCreationInputTokens
+		result.Usage.ReasoningTokens += s.Usage.ReasoningTokens
+		result.Usage.OutputTokens += s.Usage.OutputTokens
+	}
+
+	code := ExitOK
+	switch {
+	case runErr != nil && errors.Is(runErr, context.Canceled):
+		result.Status = statusCancelled
+		result.Error = "run cancelled"
+		code = ExitCancelled
+	case runErr != nil:
+		result.Status = statusError
+		result.Error = secrets.RedactForDisplay(runErr.Error())
+		if classified, ok := llmerrors.AsClassified(runErr); ok {
+			result.ErrorType = classified.Bucket.String()
+		}
+		code = ExitFailure
+	case msg != nil:
+		result.Result = secrets.RedactForDisplay(msg.Text())
+	}
+
+	switch flags.Output {
+	case OutputText:
+		if result.Error != "" {
+			fmt.Fprintln(stderr, "zipcode:", result.Error)
+		} else {
+			fmt.Fprintln(stdout, result.Result)
+		}
+	default:
+		if err := encoder.Encode(result); err != nil {
+			fmt.Fprintln(stderr, "zipcode:", err)
+			return ExitFailure
+		}
+	}
+
+	return code
+}
+
+// headlessPrompt appends piped stdin to the prompt. An interactive terminal
+// on stdin is ignored so `zipcode -p` never blocks waiting for input.
+func headlessPrompt(prompt string, stdin io.Reader) (string, error) {
+	if strings.TrimSpace(prompt) == "" {
+		return "", errors.New("prompt cannot be empty")
+	}
+
+	if stdin == nil {
+		return prompt, nil
+	}
+
+	if f, ok := stdin.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+			return prompt, nil
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(stdin, maxStdinContext+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if len(data) > maxStdinContext {
+		return "", fmt.Errorf("stdin context exceeds %d bytes", maxStdinContext)
+	}
+
+	extra := strings.TrimSpace(string(data))
+	if extra == "" {
+		return prompt, nil
+	}
+
+	return fmt.Sprintf("%s\n\nContext from stdin:\n```\n%s\n```", prompt, extra), nil
+}
+
+// conversationUsage sums the usage recorded on each assistant message.
+func conversationUsage(messages []llm.Message) llm.Usage {
+	var total llm.Usage
+	for _, m := range messages {
+		if m.Usage == nil {
+			continue
+		}
+		total.InputTokens += m.Usage.InputTokens
+		total.CachedInputTokens += m.Usage.CachedInputTokens
+		total.CacheCreationInputTokens += m.Usage.CacheCreationInputTokens
+		total.ReasoningTokens += m.Usage.ReasoningTokens
+		total.OutputTokens += m.Usage.OutputTokens
+	}
+	return total
+}
@@ -1 +1,37 @@
 package bootstrap
+
+import (
+	"fmt"
+	"os"
+
+	"zipcode/src/config"
+)
+
+// Startup loads the configuration and applies the startup flags to it. It
+// must run before the runtime is created: the headless switch decides which
+// tools are registered.
+func Startup(flags StartupFlags) error {
+	if err := config.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if flags.Config != "" {
+		if err := config.LoadFile(flags.Config); err != nil {
+			return fmt.Errorf("failed to load config %s: %w", flags.Config, err)
+		}
+	}
+
+	if flags.Workspace != "" {
+		if err := os.Chdir(flags.Workspace); err != nil {
+			return fmt.Errorf("failed to open workspace: %w", err)
+		}
+	}
+
+	config.Cfg.Headless = flags.Headless()
+	config.Cfg.Debug = config.Cfg.Debug || flags.Debug
+	if flags.Approval != "" {
+		config.Cfg.HeadlessApproval = flags.Approval
+	}
+
+	return nil
+}
@@ -11,14 +11,16 @@ import (
 )
 
 type Config struct {
-	Headless              bool     `toml:"headless"`
+	Headless              bool     `toml:"-"`
 	AppVersion            string   `toml:"app_version"`
-	ModelNames            []string `toml:"model_names"`
 	CurrentModel          string   `toml:"current_model"`
 	InternalToolPath      string   `toml:"internal_tool_path"`
 	ExternalToolPath      string   `toml:"external_tool_path"`
 	InternalSubagentsPath string   `toml:"internal_subagents_path"`
 	ExternalSubagentsPath string   `toml:"external_subagents_path"`
+	ProjectSubagentsPath  string   `toml:"project_subagents_path"`
+	SubagentsStatePath    string   `toml:"subagents_state_path"`
+	MaxParallelSubagents  int      `toml:"max_parallel_subagents"`
 	InternalSkillsPath    string   `toml:"internal_skills_path"`
 	GlobalSkillsPath      string   `toml:"global_skills_path"`
 	ProjectSkillsPath     string   `toml:"project_skills_path"`
@@ -28,39 +30,216 @@ type Config struct {
 	ConfigPath            string   `toml:"config_path"`
 	ActiveProviderName    string   `toml:"active_provider_name"`
 	ProviderModels        map[string]string `toml:"provider_models"`
+	Debug                 bool     `toml:"debug"`
+	HeadlessApproval      string   `toml:"headless_approval"`
+	GlobalPolicyPath      string   `toml:"global_policy_path"`
+	ProjectPolicyPath     string   `toml:"project_policy_path"`
+	PolicyLogPath         string   `toml:"policy_log_path"`
+	MCPServers            map[string]MCPServerConfig `toml:"mcp_servers"`
+	// AutoCompactPercent is how full the model's context window may get
+	// before the conversation is compacted.
+	AutoCompactPercent int `toml:"auto_compact_percent"`
+	// CompactKeepTurns is how many recent turns compaction keeps verbatim.
+	CompactKeepTurns int `toml:"compact_keep_turns"`
+	// CompactProvider and CompactModel pick a cheaper model for writing the
+	// summary. Empty means the model the conversation runs on.
+	CompactProvider string `toml:"compact_provider"`
+	CompactModel    string `toml:"compact_model"`
+	// TokenizersPath holds the .tiktoken files used to count tokens of
+	// OpenAI-family models exactly: cl100k_base.tiktoken and
+	// o200k_base.tiktoken, as published by OpenAI.
+	TokenizersPath string `toml:"tokenizers_path"`
+	// ReasoningEffort sets how much each model reasons, keyed by model id:
+	// none, minimal, low, medium or high. Models not listed use their
+	// default.
+	ReasoningEffort map[string]string `toml:"reasoning_effort"`
+	// Fallbacks lists, keyed by model id, the models to switch to in turn
+	// when that model fails for lack of credits, is unknown to its provider
+	// or cannot be reached.
+	Fallbacks map[string][]FallbackModel `toml:"fallbacks"`
+	// CustomProviders are OpenAI-compatible servers, such as Ollama, a
+	// llama.cpp server, vLLM or an internal gateway, keyed by the name
+	// shown in /providers.
+	CustomProviders map[string]CustomProvider `toml:"custom_providers"`
+	// ModelCachePath holds the model lists fetched from providers, which
+	// are fetched again once older than ModelCacheTTLHours.
+	ModelCachePath     string `toml:"model_cache_path"`
+	ModelCacheTTLHours int    `toml:"model_cache_ttl_hours"`
+	// ModelOverridesPath corrects, adds or hides models of the fetched
+	// lists; see llm.ModelOverride.
+	ModelOverridesPath string `toml:"model_overrides_path"`
+	// Replay records provider traffic to a cassette, or answers from one,
+	// as the Replay provider.
+	Replay ReplayConfig `toml:"replay"`
+	// ScriptPath registers the Scripted provider, which answers from the
+	// JSON script at this path; see llm.Script.
+	ScriptPath string `toml:"script_path"`
+	// HTTP configures how providers are reached: timeouts, proxy, extra
+	// headers and CA certificates.
+	HTTP HTTPConfig `toml:"http"`
 }
 
+// HTTPConfig configures the connections to providers, configured as
+//
+//	[http]
+//	connect_timeout_seconds  = 10
+//	response_timeout_seconds = 300
+//	proxy                    = "http://proxy.example.com:3128"
+//	ca_bundle                = "~/.zipcode/corp-ca.pem"
+//	headers                  = { "X-Team" = "platform" }
+//
+//	[http.providers.Anthropic]
+//	response_timeout_seconds = 600
+//
+// Settings under providers override the shared ones for that provider;
+// headers are merged.
+type HTTPConfig struct {
+	ConnectTimeoutSeconds int `toml:"connect_timeout_seconds"`
+	// ResponseTimeoutSeconds bounds the wait for a response to start. A
+	// streamed response may run longer.
+	ResponseTimeoutSeconds int `toml:"response_timeout_seconds"`
+	// StreamIdleTimeoutSeconds bounds the wait for the next data of a
+	// streamed response.
+	StreamIdleTimeoutSeconds int `toml:"stream_idle_timeout_seconds"`
+	// Proxy defaults to HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the
+	// environment.
+	Proxy string `toml:"proxy"`
+	// CABundle is a PEM file of certificates trusted on top of the
+	// system's.
+	CABundle  string                `toml:"ca_bundle"`
+	Headers   map[string]string     `toml:"headers"`
+	Providers map[string]HTTPConfig `toml:"providers"`
+}
+
+// ForProvider returns the settings of provider, its own over the shared
+// ones.
+func (c HTTPConfig) ForProvider(provider string) HTTPConfig {
+	merged := c
+	merged.Providers = nil
+	merged.Headers = map[string]string{}
+	for name, value := range c.Headers {
+		merged.Headers[name] = value
+	}
+
+	for name, own := range c.Providers {
+		if !strings.EqualFold(name, provider) {
+			continue
+		}
+		if own.ConnectTimeoutSeconds > 0 {
+			merged.ConnectTimeoutSeconds = own.ConnectTimeoutSeconds
+		}
+		if own.ResponseTimeoutSeconds > 0 {
+			merged.ResponseTimeoutSeconds = own.ResponseTimeoutSeconds
+		}
+		if own.StreamIdleTimeoutSeconds > 0 {
+			merged.StreamIdleTimeoutSeconds = own.StreamIdleTimeoutSeconds
+		}
+		if own.Proxy != "" {
+			merged.Proxy = own.Proxy
+		}
+		if own.CABundle != "" {
+			merged.CABundle = own.CABundle
+		}
+		for header, value := range own.Headers {
+			merged.Headers[header] = value
+		}
+	}
+	return merged
+}
+
+// ReplayConfig registers the Replay provider, configured as
+//
+//	[replay]
+//	cassette = "testdata/fix-bug.cassette.json"
+//	record   = "Anthropic"
+//
+// Without record it replays the cassette instead.
+type ReplayConfig struct {
+	Cassette string `toml:"cassette"`
+	// Record names the provider to send requests to and record from. The
+	// cassette is rewritten from scratch.
+	Record string `toml:"record"`
+	// Strict fails requests that match no recorded request, instead of
+	// answering them with the next recorded response.
+	Strict bool `toml:"strict"`
+}
+
+// CustomProvider describes an OpenAI-compatible server, configured as
+//
+//	[custom_providers.Ollama]
+//	base_url    = "http://localhost:11434/v1"
+//	auth_header = "none"
+//	models      = [{ id = "qwen2.5-coder:14b", context_window = 32768 }]
+type CustomProvider struct {
+	// BaseURL is the API root; requests go to BaseURL/chat/completions.
+	BaseURL string `toml:"base_url"`
+	// AuthHeader is the header carrying the API key: Authorization (the
+	// default) sends "Bearer <key>", any other header the key as is, and
+	// "none" no key at all.
+	AuthHeader string `toml:"auth_header"`
+	// APIKeyEnv names an environment variable holding the key. A key can
+	// also be entered in /providers.
+	APIKeyEnv string        `toml:"api_key_env"`
+	Models    []CustomModel `toml:"models"`
+}
+
+type CustomModel struct {
+	ID            string `toml:"id"`
+	ContextWindow int    `toml:"context_window"`
+	Vision        bool   `toml:"vision"`
+	// USD per 1M tokens; zero for local models.
+	InputCostPerMillion  float64 `toml:"input_cost_per_million"`
+	OutputCostPerMillion float64 `toml:"output_cost_per_million"`
+}
+
+// FallbackModel is one step of a fallback chain, configured as
+//
+//	[fallbacks]
+//	"claude-sonnet-4-6" = [
+//	  { provider = "OpenRouter", model = "anthropic/claude-sonnet-4.6" },
+//	  { provider = "OpenRouter", model = "minimax/minimax-m2.5" },
+//	]
+type FallbackModel struct {
+	Provider string `toml:"provider"`
+	Model    string `toml:"model"`
+}
+
+// MCPServerConfig describes a stdio MCP server, configured as
+//
+//	[mcp_servers.github]
+//	command = "github-mcp-server"
+//	args    = ["stdio"]
+//	env     = { GITHUB_TOKEN = "..." }
+type MCPServerConfig struct {
+	Command string            `toml:"command"`
+	Args    []string          `toml:"args"`
+	Env     map[string]string `toml:"env"`
+	// TimeoutSeconds bounds a single tools/call; 0 means no limit.
+	TimeoutSeconds int  `toml:"timeout_seconds"`
+	Disabled       bool `toml:"disabled"`
+}
+
+// Values accepted for HeadlessApproval. Headless runs have nobody to answer
+// approval prompts, so this answers them up front.
+const (
+	ApprovalAllow = "allow"
+	ApprovalDeny  = "deny"
+)
+
 var Cfg = &Config{}
 
 func defaults() *Config {
 	return &Config{
-		Headless:   false,
-		AppVersion: "0.0.1",
-		ModelNames: []string{
-			"openai/gpt-5.2",
-			"openai/gpt-5.5",
-			"minimax/minimax-m2.5",
-			"minimax/minimax-m2.7",
-			"anthropic/claude-sonnet-4.6",
-			"anthropic/claude-haiku-4.5",
-			"openai/gpt-5.1-codex-mini",
-			"moonshotai/kimi-k2.5",
-			"meta-llama/llama-3.3-70b-instruct",
-			"z-ai/glm-4.7",
-			"qwen/qwen3-coder-flash",
-			"openai/gpt-5-nano",
-			"z-ai/glm-5",
-			"openai/gpt-5.4-nano",
-			"deepseek/deepseek-v3.2",
-			"openai/gpt-5.4",
-			"openai/gpt-5.3-codex",
-			"z-ai/glm-5v-turbo",
-		},
+		Headless:              false,
+		AppVersion:            "0.0.1",
 		CurrentModel:          "minimax/minimax-m2.5",
 		InternalToolPath:      "/Users/anirban/Documents/Code/zipcode/src/tools",
 		ExternalToolPath:      "~/.zipcode/tools",
-		InternalSubagentsPath: "/Users/anirban/Documents/Code/zipcode/src/subagents",
-		ExternalSubagentsPath: "~/.zipcode/tools",
+		InternalSubagentsPath: "/Users/anirban/Documents/Code/zipcode/src/subagents/builtin",
+		ExternalSubagentsPath: "~/.zipcode/agents",
+		ProjectSubagentsPath:  ".zipcode/agents",
+		SubagentsStatePath:    "~/.zipcode/agents.state.json",
+		MaxParallelSubagents:  4,
 		InternalSkillsPath:    "/Users/anirban/Documents/Code/zipcode/src/skills/builtin",
 		GlobalSkillsPath:      "~/.zipcode/skills",
 		ProjectSkillsPath:     ".zipcode/skills",
@@ -69,6 +248,24 @@ func defaults() *Config {
 		CredentialsPath:       "~/.zipcode/credentials.toml",
 		ConfigPath:            "~/.zipcode/config.toml",
 		ProviderModels:        map[string]string{},
+		HeadlessApproval:      ApprovalDeny,
+		GlobalPolicyPath:      "~/.zipcode/policy.toml",
+		ProjectPolicyPath:     ".zipcode/policy.toml",
+		PolicyLogPath:         "~/.zipcode/policy.log",
+		AutoCompactPercent:    80,
+		CompactKeepTurns:      3,
+		TokenizersPath:        "~/.zipcode/tokenizers",
+		ReasoningEffort:       map[string]string{},
+		Fallbacks:             map[string][]FallbackModel{},
+		CustomProviders:       map[string]CustomProvider{},
+		ModelCachePath:        "~/.zipcode/models.cache.json",
+		ModelCacheTTLHours:    24,
+		ModelOverridesPath:    "~/.zipcode/models.toml",
+		HTTP: HTTPConfig{
+			ConnectTimeoutSeconds:    10,
+			ResponseTimeoutSeconds:   300,
+			StreamIdleTimeoutSeconds: 300,
+		},
 	}
 }
 
@@ -119,6 +316,20 @@ func Load() error {
 	return nil
 }
 
+// LoadFile decodes an extra TOML file over the already loaded config, for
+// the --config flag. Keys missing from the file keep their current values.
+func LoadFile(path string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	if _, err := toml.DecodeFile(expand(path, home), Cfg); err != nil {
+		return err
+	}
+	Cfg.expandPaths(home)
+	return nil
+}
+
 func (c *Config) Save() error {
 	dir, err := zipcodeDir()
 	if err != nil {
@@ -140,12 +351,25 @@ func (c *Config) expandPaths(home string) {
 	c.ExternalToolPath = expand(c.ExternalToolPath, home)
 	c.InternalSubagentsPath = expand(c.InternalSubagentsPath, home)
 	c.ExternalSubagentsPath = expand(c.ExternalSubagentsPath, home)
+	c.SubagentsStatePath = expand(c.SubagentsStatePath, home)
 	c.InternalSkillsPath = expand(c.InternalSkillsPath, home)
 	c.GlobalSkillsPath = expand(c.GlobalSkillsPath, home)
 	c.SkillsStatePath = expand(c.SkillsStatePath, home)
 	c.HomeDir = expand(c.HomeDir, home)
 	c.CredentialsPath = expand(c.CredentialsPath, home)
 	c.ConfigPath = expand(c.ConfigPath, home)
+	c.GlobalPolicyPath = expand(c.GlobalPolicyPath, home)
+	c.PolicyLogPath = expand(c.PolicyLogPath, home)
+	c.TokenizersPath = expand(c.TokenizersPath, home)
+	c.ModelCachePath = expand(c.ModelCachePath, home)
+	c.ModelOverridesPath = expand(c.ModelOverridesPath, home)
+	c.Replay.Cassette = expand(c.Replay.Cassette, home)
+	c.ScriptPath = expand(c.ScriptPath, home)
+	c.HTTP.CABundle = expand(c.HTTP.CABundle, home)
+	for name, own := range c.HTTP.Providers {
+		own.CABundle = expand(own.CABundle, home)
+		c.HTTP.Providers[name] = own
+	}
 }
 
 func expand(p, home string) string {
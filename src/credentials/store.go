@@ -20,6 +20,8 @@ const (
 type Store struct {
 	Providers map[llm.ProviderName]ProviderKey
 	Source    map[llm.ProviderName]CredSource // file | env
+	// envVars names the key variables of custom providers.
+	envVars map[llm.ProviderName]string
 }
 
 type ProviderCreds struct {
@@ -40,9 +42,15 @@ func NewStore() *Store {
 	return &Store{
 		Providers: make(map[llm.ProviderName]ProviderKey),
 		Source:    make(map[llm.ProviderName]CredSource),
+		envVars:   make(map[llm.ProviderName]string),
 	}
 }
 
+// SetEnvVar makes Load read the key of a custom provider from envVar.
+func (s *Store) SetEnvVar(name llm.ProviderName, envVar string) {
+	s.envVars[name] = envVar
+}
+
 func (s *Store) Load() error {
 	var cfg Config
 	_, err := toml.DecodeFile(config.Cfg.CredentialsPath, &cfg)
@@ -52,9 +60,10 @@ func (s *Store) Load() error {
 	}
 
 	for name, value := range cfg.Providers {
+		// Custom providers are stored under their configured name.
 		providerName, err := llm.GetProviderName(name)
 		if err != nil {
-			continue
+			providerName = llm.ProviderName(name)
 		}
 		s.Providers[providerName] = ProviderKey{
 			APIKey:        value.APIKey,
@@ -66,12 +75,17 @@ func (s *Store) Load() error {
 
 	//env override
 
-	supportedProviders := llm.GetSupportedProviders()
-	for _, provider := range supportedProviders {
-		envVar, err := llm.GetProviderEnvVar(provider)
-		if err != nil {
-			continue
+	envVars := make(map[llm.ProviderName]string)
+	for _, provider := range llm.GetSupportedProviders() {
+		if envVar, err := llm.GetProviderEnvVar(provider); err == nil {
+			envVars[provider] = envVar
 		}
+	}
+	for provider, envVar := range s.envVars {
+		envVars[provider] = envVar
+	}
+
+	for provider, envVar := range envVars {
 		envKey := os.Getenv(envVar)
 		if envKey == "" {
 			continue
@@ -2,6 +2,7 @@ package credentials
 
 import (
 	"fmt"
+	"sync"
 	"time"
 	llm "zipcode/src/llm/provider"
 )
@@ -10,6 +11,9 @@ type Validator struct {
 	providers map[llm.ProviderName]llm.Provider
 	cache     map[llm.ProviderName]ValidationResult
 	store     *Store
+	// mu guards cache. It is a pointer because Validators are copied by
+	// value into agents, and every copy shares the same cache.
+	mu *sync.Mutex
 }
 
 type ValidationStatus string
@@ -36,12 +40,17 @@ func NewValidator(
 		providers: providers,
 		cache:     make(map[llm.ProviderName]ValidationResult),
 		store:     store,
+		mu:        &sync.Mutex{},
 	}
 
 	for k := range providers {
 		providerKey, ok := store.Get(k)
 		if !ok {
-			validator.cache[k] = ValidationResult{Status: NotConfigured}
+			status := NotConfigured
+			if llm.KeyOptional(providers[k]) {
+				status = Unchecked
+			}
+			validator.cache[k] = ValidationResult{Status: status}
 			continue
 		}
 		status := ValidationStatus(providerKey.Status)
@@ -80,7 +89,7 @@ func (v *Validator) Validate(
 			LastError: authResult.ErrorMessage,
 		}
 
-		v.cache[name] = result
+		v.setCached(name, result)
 		return result
 	}
 
@@ -89,7 +98,7 @@ func (v *Validator) Validate(
 			Status:    Valid,
 			CheckedAt: time.Now().Format(time.RFC3339),
 		}
-		v.cache[name] = result
+		v.setCached(name, result)
 		return result
 	}
 
@@ -98,15 +107,24 @@ func (v *Validator) Validate(
 		CheckedAt: time.Now().Format(time.RFC3339),
 	}
 
-	v.cache[name] = result
+	v.setCached(name, result)
 	return result
 }
 
+func (v *Validator) setCached(name llm.ProviderName, result ValidationResult) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache[name] = result
+}
+
 func (v *Validator) ValidateLazy(name llm.ProviderName) ValidationResult {
-	result := v.cache[name]
+	result := v.Status(name)
 
 	if result.Status == Unchecked || result.Status == Unverified {
 		creds, ok := v.store.Get(name)
+		if !ok && llm.KeyOptional(v.providers[name]) {
+			return v.Validate(name, "")
+		}
 		if !ok {
 			return ValidationResult{
 				Status:    NotConfigured,
@@ -115,9 +133,7 @@ func (v *Validator) ValidateLazy(name llm.ProviderName) ValidationResult {
 			}
 		}
 
-		result := v.Validate(name, creds.APIKey)
-		v.cache[name] = result
-		return result
+		return v.Validate(name, creds.APIKey)
 
 	}
 
@@ -125,9 +141,13 @@ func (v *Validator) ValidateLazy(name llm.ProviderName) ValidationResult {
 }
 
 func (v *Validator) Invalidate(name llm.ProviderName) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	delete(v.cache, name)
 }
 
 func (v Validator) Status(name llm.ProviderName) ValidationResult {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	return v.cache[name]
 }
@@ -1,9 +1,15 @@
 package errors
 
 import (
+	"context"
+	goerrors "errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+
+	"zipcode/src/secrets"
 )
 
 type ErrorBucket int
@@ -17,79 +23,265 @@ const (
 	BucketUnspecified
 )
 
+var bucketNames = map[ErrorBucket]string{
+	BucketAuth:         "auth",
+	BucketQuotaBilling: "quota",
+	BucketRateLimit:    "rate_limit",
+	BucketModelMissing: "model_missing",
+	BucketNetwork:      "network",
+	BucketUnspecified:  "unspecified",
+}
+
+func (b ErrorBucket) String() string {
+	return bucketNames[b]
+}
+
+// ClassifiedError is what providers return when a request fails: the
+// bucket decides what the runtime does about it, UserMessage tells the
+// user.
 type ClassifiedError struct {
 	Bucket      ErrorBucket
 	Provider    string
+	Model       string
+	StatusCode  int
 	UserMessage string // plain-language, includes the next-step hint
 	RawDetails  string // post-redaction copy of the raw error for the collapsed Show details
 	Retryable   bool
 }
 
+func (e *ClassifiedError) Error() string {
+	if e.RawDetails == "" {
+		return e.UserMessage
+	}
+	return e.UserMessage + " (" + e.RawDetails + ")"
+}
+
+// Fallback reports whether another model could serve the request: this
+// one is out of credits, unknown or unreachable. Auth and rate limit
+// errors are about the key or the moment, and bad requests would fail
+// anywhere.
+func (e *ClassifiedError) Fallback() bool {
+	switch e.Bucket {
+	case BucketQuotaBilling, BucketModelMissing, BucketNetwork:
+		return true
+	}
+	return false
+}
+
 var messageTemplates map[ErrorBucket]string = map[ErrorBucket]string{
 	BucketAuth:         "%s rejected this key. It may be revoked, expired, or pasted incorrectly. Run /providers to update it.",
 	BucketModelMissing: "%s doesn't recognize '{model}'. Switch with /models.",
 	BucketRateLimit:    "%s is rate-limiting this key. Retried 3 times. Try again in a minute.",
-	BucketNetwork:      "Couldn't reach %s (timed out after 30s). Check your connection, then retry.",
+	BucketNetwork:      "Couldn't reach %s. Check your connection, then retry.",
 	BucketQuotaBilling: "%s reports this key is out of credits or over its spend cap. Add credits, or switch providers with /models.",
-	BucketUnspecified:  "Unknown error occurred, please retry or restart the app",
+	BucketUnspecified:  "%s returned an error. Retry, or restart the app if it keeps happening.",
+}
+
+func userMessage(bucket ErrorBucket, provider, model string) string {
+	message := fmt.Sprintf(messageTemplates[bucket], provider)
+	return strings.ReplaceAll(message, "{model}", model)
+}
+
+func newClassifiedError(bucket ErrorBucket, provider, model string, status int, details string) *ClassifiedError {
+	return &ClassifiedError{
+		Bucket:      bucket,
+		Provider:    provider,
+		Model:       model,
+		StatusCode:  status,
+		UserMessage: userMessage(bucket, provider, model),
+		RawDetails:  secrets.RedactForDisplay(strings.TrimSpace(details)),
+		Retryable:   bucket == BucketRateLimit || bucket == BucketNetwork,
+	}
 }
 
-func (e ClassifiedError) GetErrorMessage(bucket ErrorBucket) string {
-	return fmt.Sprintf(messageTemplates[bucket], e.Provider)
+// NewClassifiedError builds the error for a failure that did not come
+// from a provider response, such as a replayed or scripted one.
+func NewClassifiedError(bucket ErrorBucket, provider, model string, status int, details string) *ClassifiedError {
+	return newClassifiedError(bucket, provider, model, status, details)
 }
 
-func (e ClassifiedError) ClassifyError(provider string, detector QuotaDetector, resp *http.Response, err error) ClassifiedError {
-	if resp.StatusCode == 401 || resp.StatusCode == 403 {
-		return ClassifiedError{
-			Bucket:      BucketAuth,
-			Provider:    provider,
-			UserMessage: e.GetErrorMessage(BucketAuth),
-			RawDetails:  resp.Status,
-			Retryable:   false,
+// ParseBucket returns the bucket named name, as written by String.
+func ParseBucket(name string) (ErrorBucket, bool) {
+	for bucket, bucketName := range bucketNames {
+		if bucketName == name {
+			return bucket, true
 		}
 	}
+	return BucketUnspecified, false
+}
 
-	if resp.StatusCode > 500 || resp.StatusCode == 429 {
-		bucket := BucketRateLimit
+// modelMissingMarkers are how providers word an unknown model when they
+// answer 400 rather than 404.
+var modelMissingMarkers = []string{
+	"model_not_found",
+	"not a valid model",
+	"no endpoints found",
+	"unknown model",
+	"does not exist",
+}
 
-		retryable := true
+// ClassifyResponse classifies a failed response from provider. body is
+// the response body, already read.
+func ClassifyResponse(
+	provider, model string,
+	detector QuotaDetector,
+	resp *http.Response,
+	body []byte,
+) *ClassifiedError {
+	details := fmt.Sprintf("status %d: %s", resp.StatusCode, body)
+	if id := RequestID(resp); id != "" {
+		details += fmt.Sprintf(" (request id %s)", id)
+	}
+	lower := strings.ToLower(string(body))
 
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+	bucket := BucketUnspecified
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		bucket = BucketAuth
+	case detector.IsQuotaError(resp, body) || resp.StatusCode == http.StatusPaymentRequired:
+		bucket = BucketQuotaBilling
+	case resp.StatusCode == http.StatusTooManyRequests:
+		bucket = BucketRateLimit
+	case resp.StatusCode == http.StatusNotFound:
+		bucket = BucketModelMissing
+	case resp.StatusCode == http.StatusBadRequest && containsAny(lower, modelMissingMarkers):
+		bucket = BucketModelMissing
+	case resp.StatusCode >= 500:
+		bucket = BucketNetwork
+	}
+	return newClassifiedError(bucket, provider, model, resp.StatusCode, details)
+}
 
-		if detector.IsQuotaError(resp, body) {
-			bucket = BucketQuotaBilling
-			retryable = false
-		}
+// requestIDHeaders are where providers put the id their support asks for.
+var requestIDHeaders = []string{"x-request-id", "request-id"}
 
-		if resp.StatusCode > 500 {
-			bucket = BucketNetwork
+// RequestID returns the provider's id for the request resp answers, or ""
+// when it sent none.
+func RequestID(resp *http.Response) string {
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			return id
 		}
+	}
+	return ""
+}
 
-		return ClassifiedError{
-			Bucket:      bucket,
-			Provider:    provider,
-			UserMessage: e.GetErrorMessage(bucket),
-			RawDetails:  resp.Status,
-			Retryable:   retryable,
-		}
+// ClassifyReadError reads the body of a failed response and classifies
+// it.
+func ClassifyReadError(
+	provider, model string,
+	detector QuotaDetector,
+	resp *http.Response,
+) *ClassifiedError {
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return ClassifyResponse(provider, model, detector, resp, body)
+}
+
+// ClassifyTransportError classifies an error from sending a request or
+// reading its response. Cancellation is returned unchanged: it is the
+// user's doing, not the provider's.
+func ClassifyTransportError(provider, model string, err error) error {
+	if err == nil || goerrors.Is(err, context.Canceled) {
+		return err
+	}
+	var classified *ClassifiedError
+	if goerrors.As(err, &classified) {
+		return err
 	}
 
-	if resp.StatusCode == 404 {
-		return ClassifiedError{
-			Bucket:      BucketModelMissing,
-			Provider:    provider,
-			UserMessage: e.GetErrorMessage(BucketModelMissing),
-			RawDetails:  resp.Status,
-			Retryable:   false,
+	var netErr net.Error
+	if goerrors.As(err, &netErr) ||
+		goerrors.Is(err, context.DeadlineExceeded) ||
+		goerrors.Is(err, io.ErrUnexpectedEOF) {
+		return newClassifiedError(BucketNetwork, provider, model, 0, err.Error())
+	}
+	return newClassifiedError(BucketUnspecified, provider, model, 0, err.Error())
+}
+
+// streamErrorBuckets maps the error types and codes providers send in
+// error events and error bodies: Anthropic's error types, OpenAI's codes,
+// Gemini's statuses and the HTTP status codes OpenRouter uses as codes.
+var streamErrorBuckets = map[string]ErrorBucket{
+	"authentication_error": BucketAuth,
+	"permission_error":     BucketAuth,
+	"invalid_api_key":      BucketAuth,
+	"unauthenticated":      BucketAuth,
+	"permission_denied":    BucketAuth,
+	"401":                  BucketAuth,
+	"403":                  BucketAuth,
+
+	"billing_error":      BucketQuotaBilling,
+	"insufficient_quota": BucketQuotaBilling,
+	"402":                BucketQuotaBilling,
+
+	"rate_limit_error":    BucketRateLimit,
+	"rate_limit_exceeded": BucketRateLimit,
+	"resource_exhausted":  BucketRateLimit,
+	"429":                 BucketRateLimit,
+
+	"not_found_error": BucketModelMissing,
+	"model_not_found": BucketModelMissing,
+	"not_found":       BucketModelMissing,
+	"404":             BucketModelMissing,
+
+	"overloaded_error":  BucketNetwork,
+	"api_error":         BucketNetwork,
+	"timeout_error":     BucketNetwork,
+	"server_error":      BucketNetwork,
+	"unavailable":       BucketNetwork,
+	"internal":          BucketNetwork,
+	"deadline_exceeded": BucketNetwork,
+	"408":               BucketNetwork,
+	"500":               BucketNetwork,
+	"502":               BucketNetwork,
+	"503":               BucketNetwork,
+	"504":               BucketNetwork,
+	"529":               BucketNetwork,
+}
+
+// quotaMarkers are how providers word running out of credits under a
+// generic error type, such as Anthropic's invalid_request_error.
+var quotaMarkers = []string{
+	"credit balance",
+	"insufficient credits",
+	"billing",
+}
+
+// ClassifyStreamError classifies an error event received after a stream
+// has started, or an error body received with status 200. errorType is
+// the error type or code the provider sent, matched as a whole; only when
+// it is generic or unknown does the message decide.
+func ClassifyStreamError(provider, model, errorType, message string) *ClassifiedError {
+	details := strings.TrimSpace(errorType + ": " + message)
+
+	bucket, ok := streamErrorBuckets[strings.ToLower(strings.TrimSpace(errorType))]
+	if !ok {
+		lower := strings.ToLower(message)
+		switch {
+		case containsAny(lower, quotaMarkers):
+			bucket = BucketQuotaBilling
+		case containsAny(lower, modelMissingMarkers):
+			bucket = BucketModelMissing
+		default:
+			bucket = BucketUnspecified
 		}
 	}
+	return newClassifiedError(bucket, provider, model, 0, details)
+}
 
-	return ClassifiedError{
-		Bucket:      BucketUnspecified,
-		Provider:    provider,
-		UserMessage: e.GetErrorMessage(BucketUnspecified),
-		RawDetails:  resp.Status,
-		Retryable:   true,
+// AsClassified returns the ClassifiedError in err's chain, if any.
+func AsClassified(err error) (*ClassifiedError, bool) {
+	var classified *ClassifiedError
+	ok := goerrors.As(err, &classified)
+	return classified, ok
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, m := range markers {
+		if strings.Contains(s, m) {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,131 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// TestClassifyStreamError classifies error bodies as Anthropic and
+// OpenRouter send them, passing the type or code the way their providers
+// do.
+func TestClassifyStreamError(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    ErrorBucket
+	}{
+		// Anthropic
+		{
+			"anthropic overloaded",
+			`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			BucketNetwork,
+		},
+		{
+			"anthropic api error",
+			`{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`,
+			BucketNetwork,
+		},
+		{
+			"anthropic bad key",
+			`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			BucketAuth,
+		},
+		{
+			"anthropic permission",
+			`{"type":"error","error":{"type":"permission_error","message":"Your API key does not have permission to use the specified resource."}}`,
+			BucketAuth,
+		},
+		{
+			"anthropic rate limit",
+			`{"type":"error","error":{"type":"rate_limit_error","message":"This request would exceed the rate limit for your organization of 30,000 input tokens per minute."}}`,
+			BucketRateLimit,
+		},
+		{
+			"anthropic unknown model",
+			`{"type":"error","error":{"type":"not_found_error","message":"model: claude-sonnet-5"}}`,
+			BucketModelMissing,
+		},
+		{
+			"anthropic credit balance",
+			`{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low to access the Anthropic API. Please go to Plans & Billing to upgrade or purchase credits."}}`,
+			BucketQuotaBilling,
+		},
+		{
+			"anthropic token limit with a 403 in it",
+			`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: 140300 > 128000, which is the maximum allowed number of output tokens for claude-3-7-sonnet-20250219"}}`,
+			BucketUnspecified,
+		},
+		{
+			"anthropic prompt too long with a 500 in it",
+			`{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 215004 tokens > 200000 maximum"}}`,
+			BucketUnspecified,
+		},
+
+		// OpenRouter
+		{
+			"openrouter credits with a 403 in it",
+			`{"error":{"code":402,"message":"This request requires more credits, or fewer max_tokens. You requested up to 64000 tokens, but can only afford 4031."}}`,
+			BucketQuotaBilling,
+		},
+		{
+			"openrouter context length with a 401 in it",
+			`{"error":{"code":400,"message":"This endpoint's maximum context length is 200000 tokens. However, you requested about 250401 tokens (250401 of text input). Please reduce the length of either one, or use the \"middle-out\" transform to compress your prompt automatically."}}`,
+			BucketUnspecified,
+		},
+		{
+			"openrouter bad key",
+			`{"error":{"code":401,"message":"No auth credentials found"}}`,
+			BucketAuth,
+		},
+		{
+			"openrouter rate limit",
+			`{"error":{"code":429,"message":"Provider returned error","metadata":{"raw":"qwen/qwen3-coder:free is temporarily rate-limited upstream.","provider_name":"Chutes"}}}`,
+			BucketRateLimit,
+		},
+		{
+			"openrouter upstream down",
+			`{"error":{"code":502,"message":"Provider returned error","metadata":{"provider_name":"Anthropic"}}}`,
+			BucketNetwork,
+		},
+		{
+			"openrouter no endpoints",
+			`{"error":{"code":404,"message":"No endpoints found for qwen/qwen3-coder:free."}}`,
+			BucketModelMissing,
+		},
+		{
+			"openrouter invalid model",
+			`{"error":{"code":400,"message":"anthropic/claude-sonnet-5 is not a valid model ID"}}`,
+			BucketModelMissing,
+		},
+		{
+			"openrouter mid-stream",
+			`{"id":"gen-1","object":"chat.completion.chunk","error":{"code":"server_error","message":"Provider disconnected unexpectedly"},"choices":[{"index":0,"delta":{"content":""},"finish_reason":"error"}]}`,
+			BucketNetwork,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload struct {
+				Error struct {
+					Type    string `json:"type"`
+					Code    any    `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(tt.payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			errorType := payload.Error.Type
+			if payload.Error.Code != nil {
+				errorType = fmt.Sprint(payload.Error.Code)
+			}
+
+			got := ClassifyStreamError("Test", "model", errorType, payload.Error.Message)
+			if got.Bucket != tt.want {
+				t.Errorf("%s classified as %s, want %s", errorType, got.Bucket, tt.want)
+			}
+		})
+	}
+}
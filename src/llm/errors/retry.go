@@ -2,6 +2,7 @@ package errors
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"time"
@@ -17,7 +18,10 @@ type QuotaDetector interface {
 	IsQuotaError(*http.Response, []byte) bool
 }
 
-func RetryWithBackoff(detector QuotaDetector, fn func() (*http.Response, error)) (*http.Response, error) {
+// RetryWithBackoff calls fn, retrying transient rate limits with the
+// rateLimitBackoff schedule. The wait between attempts is abandoned as soon
+// as ctx is cancelled.
+func RetryWithBackoff(ctx context.Context, detector QuotaDetector, fn func() (*http.Response, error)) (*http.Response, error) {
 	var resp *http.Response
 	var err error
 
@@ -36,7 +40,14 @@ func RetryWithBackoff(detector QuotaDetector, fn func() (*http.Response, error))
 		}
 
 		drainAndClose(resp)
-		time.Sleep(rateLimitBackoff[attempt])
+
+		timer := time.NewTimer(rateLimitBackoff[attempt])
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
 	}
 }
 
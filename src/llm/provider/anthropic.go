@@ -2,16 +2,16 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
-	"zipcode/src/config"
 	"zipcode/src/llm/errors"
 	"zipcode/src/tools"
+	"zipcode/src/utils"
 )
 
 const anthropicAPIVersion = "2023-06-01"
@@ -21,6 +21,7 @@ type Anthropic struct {
 	Model      string
 	Tools      []tools.Tool
 	ApiKey     string
+	HTTPClient *http.Client
 }
 
 func NewAnthropicProvider() *Anthropic {
@@ -35,21 +36,47 @@ func (p *Anthropic) SetApiKey(key string) {
 	p.ApiKey = key
 }
 
+func (p *Anthropic) SetHTTPClient(client *http.Client) {
+	p.HTTPClient = client
+}
+
+// anthropicCacheControl marks the end of a prompt prefix to cache. The
+// default ephemeral cache lives for five minutes, refreshed on every hit.
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+var ephemeralCache = &anthropicCacheControl{Type: "ephemeral"}
+
 type anthropicTool struct {
-	Name        string             `json:"name"`
-	Description string             `json:"description,omitempty"`
-	InputSchema tools.JSONSchema   `json:"input_schema"`
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	InputSchema  tools.JSONSchema       `json:"input_schema"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type anthropicContentBlock struct {
-	Type       string          `json:"type"`
-	Text       string          `json:"text,omitempty"`
-	ID         string          `json:"id,omitempty"`
-	Name       string          `json:"name,omitempty"`
-	Input      json.RawMessage `json:"input,omitempty"`
-	ToolUseID  string          `json:"tool_use_id,omitempty"`
-	Content    string          `json:"content,omitempty"`
-	IsError    bool            `json:"is_error,omitempty"`
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text,omitempty"`
+	ID           string                 `json:"id,omitempty"`
+	Name         string                 `json:"name,omitempty"`
+	Input        json.RawMessage        `json:"input,omitempty"`
+	ToolUseID    string                 `json:"tool_use_id,omitempty"`
+	Content      any                    `json:"content,omitempty"` // string, or blocks in a tool_result
+	IsError      bool                   `json:"is_error,omitempty"`
+	Source       *anthropicSource       `json:"source,omitempty"`
+	Title        string                 `json:"title,omitempty"`
+	Thinking     string                 `json:"thinking,omitempty"`
+	Signature    string                 `json:"signature,omitempty"`
+	Data         string                 `json:"data,omitempty"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// anthropicSource carries the data of an image or document block.
+type anthropicSource struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
 }
 
 type anthropicMessage struct {
@@ -58,12 +85,57 @@ type anthropicMessage struct {
 }
 
 type anthropicRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	System    string             `json:"system,omitempty"`
-	Messages  []anthropicMessage `json:"messages"`
-	Tools     []anthropicTool    `json:"tools,omitempty"`
-	Temperature float64          `json:"temperature,omitempty"`
+	Model       string                  `json:"model"`
+	MaxTokens   int                     `json:"max_tokens"`
+	System      []anthropicContentBlock `json:"system,omitempty"`
+	Messages    []anthropicMessage      `json:"messages"`
+	Tools       []anthropicTool         `json:"tools,omitempty"`
+	Temperature float64                 `json:"temperature,omitempty"`
+	Stream      bool                    `json:"stream,omitempty"`
+	Thinking    *anthropicThinking      `json:"thinking,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// anthropicThinkingBudget maps a reasoning effort to budget_tokens; 0 means
+// thinking stays off. 1024 is the smallest budget the API accepts.
+func anthropicThinkingBudget(effort string) int {
+	switch effort {
+	case EffortMinimal:
+		return 1024
+	case EffortLow:
+		return 4096
+	case EffortMedium:
+		return 12288
+	case EffortHigh:
+		return 32768
+	}
+	return 0
+}
+
+// resumesToolUse reports whether the conversation ends with tool results
+// for an assistant turn that has no thinking block. With thinking enabled
+// the API rejects that turn, which happens when the history comes from
+// another model or from before thinking was turned on.
+func resumesToolUse(msgs []anthropicMessage) bool {
+	if len(msgs) < 2 {
+		return false
+	}
+	last := msgs[len(msgs)-1]
+	if last.Role != "user" || len(last.Content) == 0 || last.Content[0].Type != "tool_result" {
+		return false
+	}
+	for i := len(msgs) - 2; i >= 0; i-- {
+		if msgs[i].Role != "assistant" {
+			continue
+		}
+		first := msgs[i].Content[0].Type
+		return first != "thinking" && first != "redacted_thinking"
+	}
+	return false
 }
 
 type anthropicResponse struct {
@@ -86,7 +158,7 @@ type anthropicResponse struct {
 }
 
 func (p *Anthropic) AuthCheck(key string) AuthResult {
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := authCheckClient(p.HTTPClient)
 	req, err := http.NewRequest(
 		http.MethodGet,
 		"https://api.anthropic.com/v1/models",
@@ -114,7 +186,7 @@ func (p *Anthropic) AuthCheck(key string) AuthResult {
 	return result
 }
 
-func (p Anthropic) Complete(request ChatRequest) (ChatResponse, error) {
+func (p Anthropic) post(ctx context.Context, request ChatRequest) (*http.Response, error) {
 	system, msgs := convertMessagesToAnthropic(request.Messages)
 
 	maxTokens := request.MaxTokens
@@ -122,20 +194,41 @@ func (p Anthropic) Complete(request ChatRequest) (ChatResponse, error) {
 		maxTokens = 8192
 	}
 
+	// The thinking budget comes out of max_tokens, so it is added on top to
+	// leave the configured room for the answer. Thinking does not allow a
+	// custom temperature.
+	var thinking *anthropicThinking
+	temperature := request.Temperature
+	if budget := anthropicThinkingBudget(request.ReasoningEffort); budget > 0 && !resumesToolUse(msgs) {
+		thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		maxTokens += budget
+		temperature = 0
+	}
+
+	var systemBlocks []anthropicContentBlock
+	if system != "" {
+		systemBlocks = []anthropicContentBlock{{Type: "text", Text: system}}
+	}
+	toolDefs := convertToolsToAnthropic(request.Tools)
+	markCacheBreakpoints(toolDefs, systemBlocks, msgs)
+
 	body, err := json.Marshal(anthropicRequest{
-		Model:       config.Cfg.CurrentModel,
+		Model:       request.Model,
 		MaxTokens:   maxTokens,
-		System:      system,
+		System:      systemBlocks,
 		Messages:    msgs,
-		Tools:       convertToolsToAnthropic(request.Tools),
-		Temperature: request.Temperature,
+		Tools:       toolDefs,
+		Temperature: temperature,
+		Stream:      request.Stream,
+		Thinking:    thinking,
 	})
 	if err != nil {
-		return ChatResponse{}, err
+		return nil, err
 	}
 
-	res, err := errors.RetryWithBackoff(p, func() (*http.Response, error) {
-		req, err := http.NewRequest(
+	res, err := errors.RetryWithBackoff(ctx, p, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(
+			ctx,
 			http.MethodPost,
 			"https://api.anthropic.com/v1/messages",
 			bytes.NewReader(body),
@@ -146,24 +239,30 @@ func (p Anthropic) Complete(request ChatRequest) (ChatResponse, error) {
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("x-api-key", p.ApiKey)
 		req.Header.Set("anthropic-version", anthropicAPIVersion)
-		return http.DefaultClient.Do(req)
+		return httpClientOr(p.HTTPClient).Do(req)
 	})
 	if err != nil {
-		return ChatResponse{}, err
+		return nil, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
 	}
 
-	respBody, err := io.ReadAll(res.Body)
-	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return nil, errors.ClassifyReadError(string(p.Name()), request.Model, p, res)
+	}
+
+	return res, nil
+}
+
+func (p Anthropic) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	request.Stream = false
+	res, err := p.post(ctx, request)
 	if err != nil {
 		return ChatResponse{}, err
 	}
 
-	if res.StatusCode >= 400 {
-		return ChatResponse{}, fmt.Errorf(
-			"anthropic: status %d: %s",
-			res.StatusCode,
-			string(respBody),
-		)
+	respBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
 	}
 
 	var parsed anthropicResponse
@@ -172,32 +271,55 @@ func (p Anthropic) Complete(request ChatRequest) (ChatResponse, error) {
 	}
 
 	if parsed.Error != nil {
-		return ChatResponse{}, fmt.Errorf(
-			"anthropic: %s: %s",
+		return ChatResponse{}, errors.ClassifyStreamError(
+			string(p.Name()),
+			request.Model,
 			parsed.Error.Type,
 			parsed.Error.Message,
 		)
 	}
 
-	textParts := []string{}
-	toolCalls := []ToolCall{}
+	return parsed.toChatResponse(), nil
+}
+
+// toChatResponse maps Anthropic content blocks onto message blocks in the
+// order they came: thinking blocks become reasoning, keeping what is needed
+// to send them back.
+func (parsed anthropicResponse) toChatResponse() ChatResponse {
+	var content []ContentBlock
 	for i, block := range parsed.Content {
 		switch block.Type {
 		case "text":
-			textParts = append(textParts, block.Text)
+			content = append(content, TextBlock(block.Text))
+		case "thinking":
+			content = append(content, ContentBlock{
+				Type: BlockReasoning,
+				Reasoning: &ReasoningDetails{
+					Type:      ReasoningText,
+					Text:      block.Thinking,
+					Signature: block.Signature,
+					Format:    anthropicReasoningFormat,
+					Index:     i,
+				},
+			})
+		case "redacted_thinking":
+			content = append(content, ContentBlock{
+				Type: BlockReasoning,
+				Reasoning: &ReasoningDetails{
+					Type:   ReasoningEncrypted,
+					Data:   block.Data,
+					Format: anthropicReasoningFormat,
+					Index:  i,
+				},
+			})
 		case "tool_use":
 			args := string(block.Input)
 			if args == "" {
 				args = "{}"
 			}
-			toolCalls = append(toolCalls, ToolCall{
-				Type:  "function",
-				Index: i,
-				ID:    block.ID,
-				Function: ToolCallFunction{
-					Name:      block.Name,
-					Arguments: args,
-				},
+			content = append(content, ContentBlock{
+				Type:    BlockToolUse,
+				ToolUse: &ToolUse{ID: block.ID, Name: block.Name, Input: args},
 			})
 		}
 	}
@@ -213,21 +335,152 @@ func (p Anthropic) Complete(request ChatRequest) (ChatResponse, error) {
 		StopReason: parsed.StopReason,
 		Usage: Usage{
 			// Normalize so InputTokens represents *total* input (matches OpenAI
-			// semantics) while CachedInputTokens is the cached-read portion.
-			// Anthropic's cache_creation is folded into the regular input
-			// bucket; we don't currently price it separately.
+			// semantics) while CachedInputTokens is the cached-read portion
+			// and CacheCreationInputTokens the portion written to the cache,
+			// which is priced separately.
 			InputTokens: parsed.Usage.InputTokens +
 				parsed.Usage.CacheCreationInputTokens +
 				parsed.Usage.CacheReadInputTokens,
-			CachedInputTokens: parsed.Usage.CacheReadInputTokens,
-			OutputTokens:      parsed.Usage.OutputTokens,
+			CachedInputTokens:        parsed.Usage.CacheReadInputTokens,
+			CacheCreationInputTokens: parsed.Usage.CacheCreationInputTokens,
+			OutputTokens:             parsed.Usage.OutputTokens,
 		},
-		Message: Message{
-			Role:      role,
-			Content:   strings.Join(textParts, ""),
-			ToolCalls: toolCalls,
-		},
-	}, nil
+		Message: Message{Role: role, Content: content},
+	}
+}
+
+type anthropicStreamEvent struct {
+	Type         string                 `json:"type"`
+	Index        int                    `json:"index"`
+	Message      *anthropicResponse     `json:"message,omitempty"`
+	ContentBlock *anthropicContentBlock `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// CompleteStream consumes the Messages API event stream. Content blocks are
+// rebuilt by index (text appended from text_delta, tool input from
+// input_json_delta) into an anthropicResponse so the final result goes
+// through the same conversion as Complete.
+func (p Anthropic) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	request.Stream = true
+	res, err := p.post(ctx, request)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer res.Body.Close()
+
+	var parsed anthropicResponse
+	blocks := map[int]*anthropicContentBlock{}
+	inputs := map[int]*strings.Builder{}
+	order := []int{}
+
+	err = readSSE(res.Body, func(_ string, data string) error {
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("anthropic: invalid stream event: %w", err)
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				parsed = *ev.Message
+				parsed.Content = nil
+			}
+
+		case "content_block_start":
+			if ev.ContentBlock == nil {
+				return nil
+			}
+			block := *ev.ContentBlock
+			block.Input = nil
+			blocks[ev.Index] = &block
+			inputs[ev.Index] = &strings.Builder{}
+			order = append(order, ev.Index)
+			if block.Type == "text" && block.Text != "" {
+				onDelta(StreamDelta{Type: DeltaText, Text: block.Text})
+			}
+
+		case "content_block_delta":
+			block, ok := blocks[ev.Index]
+			if !ok {
+				return nil
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				block.Text += ev.Delta.Text
+				onDelta(StreamDelta{Type: DeltaText, Text: ev.Delta.Text})
+			case "thinking_delta":
+				block.Thinking += ev.Delta.Thinking
+				onDelta(StreamDelta{Type: DeltaReasoning, Text: ev.Delta.Thinking})
+			case "signature_delta":
+				block.Signature += ev.Delta.Signature
+			case "input_json_delta":
+				inputs[ev.Index].WriteString(ev.Delta.PartialJSON)
+				onDelta(StreamDelta{
+					Type: DeltaToolCall,
+					ToolCall: ToolCall{
+						Type:  "function",
+						Index: ev.Index,
+						ID:    block.ID,
+						Function: ToolCallFunction{
+							Name:      block.Name,
+							Arguments: inputs[ev.Index].String(),
+						},
+					},
+				})
+			}
+
+		case "message_delta":
+			if ev.Delta.StopReason != "" {
+				parsed.StopReason = ev.Delta.StopReason
+			}
+			if ev.Usage != nil {
+				parsed.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+
+		case "error":
+			if ev.Error != nil {
+				return errors.ClassifyStreamError(
+					string(p.Name()),
+					request.Model,
+					ev.Error.Type,
+					ev.Error.Message,
+				)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	for _, idx := range order {
+		block := blocks[idx]
+		if block.Type == "tool_use" {
+			block.Input = json.RawMessage(inputs[idx].String())
+		}
+		parsed.Content = append(parsed.Content, *block)
+	}
+
+	return parsed.toChatResponse(), nil
 }
 
 func (p Anthropic) Models() []ModelDescriptor {
@@ -243,18 +496,53 @@ func (p Anthropic) Models() []ModelDescriptor {
 	}
 	descriptors := make([]ModelDescriptor, len(entries))
 	for i, e := range entries {
+		// Five-minute cache writes cost 1.25x the input rate, reads 0.1x.
 		descriptors[i] = ModelDescriptor{
-			ID:                   e.id,
-			DisplayName:          e.id,
-			ProviderName:         string(AnthropicProvider),
-			ContextWindow:        e.contextWindow,
-			InputCostPerMillion:  e.inputCost,
-			OutputCostPerMillion: e.outputCost,
+			ID:                       e.id,
+			DisplayName:              e.id,
+			ProviderName:             string(AnthropicProvider),
+			ContextWindow:            e.contextWindow,
+			InputCostPerMillion:      e.inputCost,
+			OutputCostPerMillion:     e.outputCost,
+			CacheReadCostPerMillion:  e.inputCost * 0.10,
+			CacheWriteCostPerMillion: e.inputCost * 1.25,
+			Vision:                   true,
 		}
 	}
 	return descriptors
 }
 
+// FetchModels lists the Claude models available to the key. Every Claude
+// model accepts images and documents.
+func (p Anthropic) FetchModels(ctx context.Context) ([]ModelDescriptor, error) {
+	if p.ApiKey == "" {
+		return nil, errNoAPIKey
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	err := getModelList(ctx, p.HTTPClient, "https://api.anthropic.com/v1/models?limit=1000", func(req *http.Request) {
+		req.Header.Set("x-api-key", p.ApiKey)
+		req.Header.Set("anthropic-version", anthropicAPIVersion)
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]ModelDescriptor, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, ModelDescriptor{
+			ID:           m.ID,
+			DisplayName:  m.ID,
+			ProviderName: string(AnthropicProvider),
+			Vision:       true,
+		})
+	}
+	return models, nil
+}
+
 func (p Anthropic) IsQuotaError(resp *http.Response, body []byte) bool {
 	if resp.StatusCode != http.StatusTooManyRequests &&
 		resp.StatusCode != http.StatusPaymentRequired &&
@@ -293,10 +581,111 @@ func convertToolsToAnthropic(in []tools.Tool) []anthropicTool {
 	return out
 }
 
-// convertMessagesToAnthropic adapts the internal OpenAI-shaped message list
-// to Anthropic's messages API. System turns are hoisted into the top-level
-// system field; tool results (role="tool") become user messages with
-// tool_result blocks; assistant tool calls become tool_use blocks.
+// markCacheBreakpoints places cache_control on the end of the tool list, the
+// system prompt and the conversation, the order in which Anthropic builds
+// the cached prefix. The fourth breakpoint goes on the last user message
+// before the latest assistant reply: it ended the previous request, so this
+// request reads back exactly what that one wrote, however many tool results
+// the latest exchange added.
+func markCacheBreakpoints(
+	toolDefs []anthropicTool,
+	system []anthropicContentBlock,
+	msgs []anthropicMessage,
+) {
+	if len(toolDefs) > 0 {
+		toolDefs[len(toolDefs)-1].CacheControl = ephemeralCache
+	}
+	if len(system) > 0 {
+		system[len(system)-1].CacheControl = ephemeralCache
+	}
+
+	mark := func(m anthropicMessage) {
+		if len(m.Content) > 0 {
+			m.Content[len(m.Content)-1].CacheControl = ephemeralCache
+		}
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	mark(msgs[len(msgs)-1])
+
+	i := len(msgs) - 1
+	for i >= 0 && msgs[i].Role != "assistant" {
+		i--
+	}
+	for i >= 0 && msgs[i].Role != "user" {
+		i--
+	}
+	if i >= 0 {
+		mark(msgs[i])
+	}
+}
+
+// anthropicThinkingBlock rebuilds a thinking block from its reasoning
+// details. Thinking blocks have to lead an assistant turn, unchanged, for
+// the API to accept the tool results that follow. Reasoning from other
+// providers is dropped.
+func anthropicThinkingBlock(d ReasoningDetails) (anthropicContentBlock, bool) {
+	if d.Format != anthropicReasoningFormat {
+		return anthropicContentBlock{}, false
+	}
+	switch d.Type {
+	case ReasoningText:
+		return anthropicContentBlock{
+			Type:      "thinking",
+			Thinking:  d.Text,
+			Signature: d.Signature,
+		}, true
+	case ReasoningEncrypted:
+		return anthropicContentBlock{
+			Type: "redacted_thinking",
+			Data: d.Data,
+		}, true
+	}
+	return anthropicContentBlock{}, false
+}
+
+// emptyContentPlaceholder is sent for a user turn with no content.
+const emptyContentPlaceholder = "(empty)"
+
+// anthropicBlocks converts the content of a user turn or tool result: text,
+// image and document blocks, in order.
+func anthropicBlocks(content []ContentBlock) []anthropicContentBlock {
+	blocks := []anthropicContentBlock{}
+	for _, b := range content {
+		switch b.Type {
+		case BlockText:
+			if b.Text != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: b.Text})
+			}
+		case BlockImage, BlockDocument:
+			if b.Media == nil {
+				continue
+			}
+			blocks = append(blocks, anthropicContentBlock{
+				Type: string(b.Type),
+				Source: &anthropicSource{
+					Type:      "base64",
+					MediaType: b.Media.MediaType,
+					Data:      b.Media.Data,
+				},
+				Title: utils.If(b.Type == BlockDocument, b.Media.Name, ""),
+			})
+		}
+	}
+	// The API rejects empty content and empty text blocks alike, so a
+	// placeholder stands in. Dropping the turn instead could leave the
+	// conversation starting or ending with an assistant turn.
+	if len(blocks) == 0 {
+		blocks = append(blocks, anthropicContentBlock{Type: "text", Text: emptyContentPlaceholder})
+	}
+	return blocks
+}
+
+// convertMessagesToAnthropic adapts messages to Anthropic's messages API,
+// which has the same blocks under other names. System turns are hoisted
+// into the top-level system field and tool messages become user messages
+// of tool_result blocks.
 func convertMessagesToAnthropic(msgs []Message) (string, []anthropicMessage) {
 	var system string
 	out := make([]anthropicMessage, 0, len(msgs))
@@ -307,35 +696,60 @@ func convertMessagesToAnthropic(msgs []Message) (string, []anthropicMessage) {
 			if system != "" {
 				system += "\n\n"
 			}
-			system += m.Content
+			system += m.Text()
 		case "tool":
-			out = append(out, anthropicMessage{
-				Role: "user",
-				Content: []anthropicContentBlock{{
-					Type:      "tool_result",
-					ToolUseID: m.ToolCallId,
-					Content:   m.Content,
-				}},
-			})
-		case "assistant":
 			blocks := []anthropicContentBlock{}
-			if m.Content != "" {
+			for _, result := range m.ToolResults() {
+				// A text-only result is sent as a string, and an empty one
+				// without content, which the API accepts.
+				var content any = anthropicBlocks(result.Content)
+				if _, media := splitMedia(result.Content); len(media) == 0 {
+					content = nil
+					if text := result.Text(); text != "" {
+						content = text
+					}
+				}
 				blocks = append(blocks, anthropicContentBlock{
-					Type: "text",
-					Text: m.Content,
+					Type:      "tool_result",
+					ToolUseID: result.ToolUseID,
+					Content:   content,
+					IsError:   result.IsError,
 				})
 			}
-			for _, tc := range m.ToolCalls {
-				input := json.RawMessage(tc.Function.Arguments)
-				if len(input) == 0 {
-					input = json.RawMessage("{}")
+			if len(blocks) == 0 {
+				continue
+			}
+			out = append(out, anthropicMessage{Role: "user", Content: blocks})
+		case "assistant":
+			blocks := []anthropicContentBlock{}
+			for _, b := range m.Content {
+				switch b.Type {
+				case BlockReasoning:
+					if b.Reasoning == nil {
+						continue
+					}
+					if block, ok := anthropicThinkingBlock(*b.Reasoning); ok {
+						blocks = append(blocks, block)
+					}
+				case BlockText:
+					if b.Text != "" {
+						blocks = append(blocks, anthropicContentBlock{Type: "text", Text: b.Text})
+					}
+				case BlockToolUse:
+					if b.ToolUse == nil {
+						continue
+					}
+					input := json.RawMessage(b.ToolUse.Input)
+					if !json.Valid(input) {
+						input = json.RawMessage("{}")
+					}
+					blocks = append(blocks, anthropicContentBlock{
+						Type:  "tool_use",
+						ID:    b.ToolUse.ID,
+						Name:  b.ToolUse.Name,
+						Input: input,
+					})
 				}
-				blocks = append(blocks, anthropicContentBlock{
-					Type:  "tool_use",
-					ID:    tc.ID,
-					Name:  tc.Function.Name,
-					Input: input,
-				})
 			}
 			if len(blocks) == 0 {
 				continue
@@ -346,11 +760,8 @@ func convertMessagesToAnthropic(msgs []Message) (string, []anthropicMessage) {
 			})
 		default:
 			out = append(out, anthropicMessage{
-				Role: "user",
-				Content: []anthropicContentBlock{{
-					Type: "text",
-					Text: m.Content,
-				}},
+				Role:    "user",
+				Content: anthropicBlocks(m.Content),
 			})
 		}
 	}
@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConvertMessagesToAnthropic(t *testing.T) {
+	image := ContentBlock{Type: BlockImage, Media: &Media{MediaType: "image/png", Data: "iVBORw0KGgo="}}
+	msgs := []Message{
+		TextMessage("system", "You are a coding assistant."),
+		{Role: "user"},
+		{Role: "assistant", Content: []ContentBlock{
+			TextBlock(""),
+			{Type: BlockToolUse, ToolUse: &ToolUse{ID: "toolu_1", Name: "file_read", Input: `{"path":"main.go"}`}},
+			{Type: BlockToolUse, ToolUse: &ToolUse{ID: "toolu_2", Name: "screenshot", Input: ""}},
+			{Type: BlockToolUse, ToolUse: &ToolUse{ID: "toolu_3", Name: "noop", Input: "{}"}},
+		}},
+		ToolResultMessage("toolu_1", "open main.go: no such file", true),
+		{Role: "tool", Content: []ContentBlock{{
+			Type:       BlockToolResult,
+			ToolResult: &ToolResult{ToolUseID: "toolu_2", Content: []ContentBlock{TextBlock("Screen:"), image}},
+		}}},
+		ToolResultMessage("toolu_3", "", false),
+		{Role: "assistant", Content: []ContentBlock{TextBlock("")}},
+	}
+
+	system, out := convertMessagesToAnthropic(msgs)
+	if system != "You are a coding assistant." {
+		t.Errorf("system = %q", system)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tool messages stay separate user turns here; the empty assistant turn
+	// is dropped and the empty user turn gets a placeholder.
+	want := `[` +
+		`{"role":"user","content":[{"type":"text","text":"(empty)"}]},` +
+		`{"role":"assistant","content":[` +
+		`{"type":"tool_use","id":"toolu_1","name":"file_read","input":{"path":"main.go"}},` +
+		`{"type":"tool_use","id":"toolu_2","name":"screenshot","input":{}},` +
+		`{"type":"tool_use","id":"toolu_3","name":"noop","input":{}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"open main.go: no such file","is_error":true}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_2","content":[` +
+		`{"type":"text","text":"Screen:"},` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_3"}]}` +
+		`]`
+	if string(data) != want {
+		t.Errorf("converted\n%s\nwant\n%s", data, want)
+	}
+	if strings.Contains(string(data), `{"type":"text"}`) || strings.Contains(string(data), `"text":""`) {
+		t.Errorf("converted messages have an empty text block: %s", data)
+	}
+}
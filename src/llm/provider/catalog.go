@@ -0,0 +1,366 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CatalogProvider is implemented by providers that can list the models
+// they serve. The list may leave out what the endpoint does not report,
+// such as prices; the built-in table fills those in.
+type CatalogProvider interface {
+	Provider
+	FetchModels(ctx context.Context) ([]ModelDescriptor, error)
+}
+
+// ConfiguredModelsProvider is implemented by providers whose built-in
+// table is the user's configuration rather than a vendor's list. The
+// catalog keeps those models even when the fetched list leaves them out,
+// as servers often list only some of what they serve.
+type ConfiguredModelsProvider interface {
+	Provider
+	ModelsConfigured() bool
+}
+
+var errNoAPIKey = errors.New("no API key configured")
+
+// catalogFetchTimeout bounds one model list request.
+const catalogFetchTimeout = 15 * time.Second
+
+// ModelOverride corrects or adds one model, configured in the overrides
+// file as
+//
+//	[[models]]
+//	provider                    = "OpenRouter"
+//	id                          = "minimax/minimax-m2.5"
+//	context_window              = 196608
+//	input_cost_per_million      = 0.15
+//	output_cost_per_million     = 1.15
+//	cache_read_cost_per_million = 0.03
+//
+// Zero fields leave the catalog's value; Hidden removes the model.
+type ModelOverride struct {
+	Provider                 string  `toml:"provider"`
+	ID                       string  `toml:"id"`
+	DisplayName              string  `toml:"display_name"`
+	ContextWindow            int     `toml:"context_window"`
+	InputCostPerMillion      float64 `toml:"input_cost_per_million"`
+	OutputCostPerMillion     float64 `toml:"output_cost_per_million"`
+	CacheReadCostPerMillion  float64 `toml:"cache_read_cost_per_million"`
+	CacheWriteCostPerMillion float64 `toml:"cache_write_cost_per_million"`
+	Vision                   *bool   `toml:"vision"`
+	Hidden                   bool    `toml:"hidden"`
+}
+
+type catalogEntry struct {
+	FetchedAt time.Time         `json:"fetched_at"`
+	Models    []ModelDescriptor `json:"models"`
+}
+
+// ModelCatalog holds the model lists fetched from providers, cached on
+// disk at cachePath and refreshed once older than ttl.
+type ModelCatalog struct {
+	cachePath string
+	ttl       time.Duration
+	overrides []ModelOverride
+	// mu guards entries, which refreshes update in the background.
+	mu      *sync.Mutex
+	entries map[ProviderName]catalogEntry
+}
+
+// NewModelCatalog loads the cached lists at cachePath and the overrides at
+// overridesPath; either file may be missing. An unreadable cache is
+// treated as empty, an invalid overrides file is reported.
+func NewModelCatalog(cachePath, overridesPath string, ttl time.Duration) (*ModelCatalog, error) {
+	catalog := &ModelCatalog{
+		cachePath: cachePath,
+		ttl:       ttl,
+		mu:        &sync.Mutex{},
+		entries:   map[ProviderName]catalogEntry{},
+	}
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		_ = json.Unmarshal(data, &catalog.entries)
+	}
+
+	if overridesPath == "" {
+		return catalog, nil
+	}
+	var file struct {
+		Models []ModelOverride `toml:"models"`
+	}
+	if _, err := toml.DecodeFile(overridesPath, &file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return catalog, nil
+		}
+		return catalog, fmt.Errorf("model overrides %s: %w", overridesPath, err)
+	}
+	catalog.overrides = file.Models
+	return catalog, nil
+}
+
+// FetchedAt returns when the provider's list was last fetched, and false
+// when the built-in table is all there is.
+func (c *ModelCatalog) FetchedAt(name ProviderName) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	return entry.FetchedAt, ok && len(entry.Models) > 0
+}
+
+// Models merges the provider's fetched list with its built-in table. The
+// built-in models come first, in their order, so the default model stays
+// put; those the provider no longer lists are dropped, unless configured
+// is set because the table is the user's own. Fetched values win over
+// built-in ones they do not leave empty, and the rest of the fetched
+// models follow by id. Overrides apply last. Without a fetched list the
+// built-in table is used as is.
+func (c *ModelCatalog) Models(name ProviderName, builtin []ModelDescriptor, configured bool) []ModelDescriptor {
+	c.mu.Lock()
+	live := c.entries[name].Models
+	c.mu.Unlock()
+
+	models := builtin
+	if len(live) > 0 {
+		byID := make(map[string]ModelDescriptor, len(live))
+		for _, m := range live {
+			byID[m.ID] = m
+		}
+
+		models = make([]ModelDescriptor, 0, len(live))
+		for _, b := range builtin {
+			fetched, ok := byID[b.ID]
+			if !ok {
+				if configured {
+					models = append(models, b)
+				}
+				continue
+			}
+			models = append(models, mergeDescriptor(b, fetched))
+			delete(byID, b.ID)
+		}
+
+		var rest []ModelDescriptor
+		for _, m := range byID {
+			rest = append(rest, m)
+		}
+		sort.Slice(rest, func(i, j int) bool { return rest[i].ID < rest[j].ID })
+		models = append(models, rest...)
+	}
+
+	return c.applyOverrides(name, models)
+}
+
+// mergeDescriptor overlays the fields of fetched that are set onto base.
+func mergeDescriptor(base, fetched ModelDescriptor) ModelDescriptor {
+	if fetched.DisplayName != "" {
+		base.DisplayName = fetched.DisplayName
+	}
+	if fetched.ContextWindow > 0 {
+		base.ContextWindow = fetched.ContextWindow
+	}
+	if fetched.InputCostPerMillion > 0 || fetched.OutputCostPerMillion > 0 {
+		base.InputCostPerMillion = fetched.InputCostPerMillion
+		base.OutputCostPerMillion = fetched.OutputCostPerMillion
+	}
+	if fetched.CacheReadCostPerMillion > 0 {
+		base.CacheReadCostPerMillion = fetched.CacheReadCostPerMillion
+	}
+	if fetched.CacheWriteCostPerMillion > 0 {
+		base.CacheWriteCostPerMillion = fetched.CacheWriteCostPerMillion
+	}
+	base.Vision = base.Vision || fetched.Vision
+	return base
+}
+
+func (c *ModelCatalog) applyOverrides(name ProviderName, models []ModelDescriptor) []ModelDescriptor {
+	if len(c.overrides) == 0 {
+		return models
+	}
+
+	out := make([]ModelDescriptor, 0, len(models))
+	index := map[string]int{}
+	for _, m := range models {
+		index[m.ID] = len(out)
+		out = append(out, m)
+	}
+
+	hidden := map[string]bool{}
+	for _, o := range c.overrides {
+		if !strings.EqualFold(o.Provider, string(name)) {
+			continue
+		}
+		if o.Hidden {
+			hidden[o.ID] = true
+			continue
+		}
+
+		i, ok := index[o.ID]
+		if !ok {
+			index[o.ID] = len(out)
+			i = len(out)
+			out = append(out, ModelDescriptor{
+				ID:           o.ID,
+				DisplayName:  o.ID,
+				ProviderName: string(name),
+			})
+		}
+		m := &out[i]
+		if o.DisplayName != "" {
+			m.DisplayName = o.DisplayName
+		}
+		if o.ContextWindow > 0 {
+			m.ContextWindow = o.ContextWindow
+		}
+		if o.InputCostPerMillion > 0 {
+			m.InputCostPerMillion = o.InputCostPerMillion
+		}
+		if o.OutputCostPerMillion > 0 {
+			m.OutputCostPerMillion = o.OutputCostPerMillion
+		}
+		if o.CacheReadCostPerMillion > 0 {
+			m.CacheReadCostPerMillion = o.CacheReadCostPerMillion
+		}
+		if o.CacheWriteCostPerMillion > 0 {
+			m.CacheWriteCostPerMillion = o.CacheWriteCostPerMillion
+		}
+		if o.Vision != nil {
+			m.Vision = *o.Vision
+		}
+	}
+
+	if len(hidden) == 0 {
+		return out
+	}
+	visible := out[:0]
+	for _, m := range out {
+		if !hidden[m.ID] {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// Refresh fetches the lists of the providers whose cached list is missing
+// or older than the TTL, or of all of them when force is set, and saves
+// the cache. Providers that fail keep their previous list; the errors are
+// returned together.
+func (c *ModelCatalog) Refresh(ctx context.Context, providers map[ProviderName]Provider, force bool) error {
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+	updated := false
+
+	for name, provider := range providers {
+		catalogProvider, ok := provider.(CatalogProvider)
+		if !ok {
+			continue
+		}
+		if fetchedAt, ok := c.FetchedAt(name); ok && !force && time.Since(fetchedAt) < c.ttl {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name ProviderName, provider CatalogProvider) {
+			defer wg.Done()
+			fetchCtx, cancel := context.WithTimeout(ctx, catalogFetchTimeout)
+			defer cancel()
+
+			models, err := provider.FetchModels(fetchCtx)
+			if errors.Is(err, errNoAPIKey) {
+				return
+			}
+			if err == nil && len(models) == 0 {
+				err = errors.New("empty model list")
+			}
+
+			errsMu.Lock()
+			defer errsMu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			c.mu.Lock()
+			c.entries[name] = catalogEntry{FetchedAt: time.Now().UTC(), Models: models}
+			c.mu.Unlock()
+			updated = true
+		}(name, catalogProvider)
+	}
+	wg.Wait()
+
+	if updated {
+		if err := c.save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ModelCatalog) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.cachePath), 0755); err != nil {
+		return err
+	}
+	tmp := c.cachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.cachePath)
+}
+
+// getModelList requests a model list endpoint with client and decodes its
+// JSON body into out.
+func getModelList(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	authorize func(req *http.Request),
+	out any,
+) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	authorize(req)
+
+	resp, err := httpClientOr(client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// openAIModelList is the model list of OpenAI and the servers compatible
+// with it: ids only.
+type openAIModelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
@@ -0,0 +1,242 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogPaths gives the test a home of its own and returns the cache and
+// overrides paths under it.
+func catalogPaths(t *testing.T) (cachePath, overridesPath string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".zipcode")
+	return filepath.Join(dir, "models.cache.json"), filepath.Join(dir, "models.toml")
+}
+
+// localProvider is a keyless compatible server configured with one model
+// it lists and one it does not.
+func localProvider(server *compatibleServer) *OpenAICompatible {
+	return NewOpenAICompatible(CompatibleConfig{
+		Name:       "Local",
+		BaseURL:    server.URL + "/v1",
+		AuthHeader: NoAuth,
+		Models: []ModelDescriptor{
+			{ID: "qwen2.5-coder:14b", ContextWindow: 32768},
+			{ID: "custom:7b", ContextWindow: 8192},
+		},
+	})
+}
+
+func (s *compatibleServer) modelListRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r.URL.Path == "/v1/models" {
+			n++
+		}
+	}
+	return n
+}
+
+func modelIDs(models []ModelDescriptor) string {
+	ids := make([]string, len(models))
+	for i, m := range models {
+		ids[i] = m.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestCatalogRefreshCachesLists(t *testing.T) {
+	cachePath, overridesPath := catalogPaths(t)
+	server := newCompatibleServer(t)
+	p := localProvider(server)
+	providers := map[ProviderName]Provider{p.Name(): p}
+	ctx := context.Background()
+
+	catalog, err := NewModelCatalog(cachePath, overridesPath, time.Hour)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	if _, ok := catalog.FetchedAt(p.Name()); ok {
+		t.Fatal("fresh catalog has a fetched list")
+	}
+	if err := catalog.Refresh(ctx, providers, false); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("cache not written: %v", err)
+	}
+
+	// Configured models come first and stay even when the server does
+	// not list them; their context windows survive the fetched list,
+	// which has none.
+	models := catalog.Models(p.Name(), p.Models(), true)
+	if got := modelIDs(models); got != "qwen2.5-coder:14b,custom:7b,llava:13b" {
+		t.Fatalf("models = %s", got)
+	}
+	if models[0].ContextWindow != 32768 || models[2].ProviderName != "Local" {
+		t.Errorf("models = %+v", models)
+	}
+	// A built-in table that is not configuration drops what the provider
+	// no longer lists.
+	if got := modelIDs(catalog.Models(p.Name(), p.Models(), false)); got != "qwen2.5-coder:14b,llava:13b" {
+		t.Errorf("unconfigured models = %s", got)
+	}
+
+	// Within the TTL, refreshes use the cache, also after a restart.
+	if err := catalog.Refresh(ctx, providers, false); err != nil {
+		t.Fatal(err)
+	}
+	restarted, _ := NewModelCatalog(cachePath, overridesPath, time.Hour)
+	if err := restarted.Refresh(ctx, providers, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.modelListRequests(); n != 1 {
+		t.Errorf("fetched the list %d times within the TTL, want once", n)
+	}
+	if got := modelIDs(restarted.Models(p.Name(), p.Models(), true)); got != "qwen2.5-coder:14b,custom:7b,llava:13b" {
+		t.Errorf("models from the cache = %s", got)
+	}
+
+	// Once the TTL has passed, or when forced, the list is fetched again.
+	expired, _ := NewModelCatalog(cachePath, overridesPath, 0)
+	if err := expired.Refresh(ctx, providers, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.Refresh(ctx, providers, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.modelListRequests(); n != 3 {
+		t.Errorf("fetched the list %d times, want 3", n)
+	}
+}
+
+func TestCatalogOffline(t *testing.T) {
+	cachePath, overridesPath := catalogPaths(t)
+	server := newCompatibleServer(t)
+	p := localProvider(server)
+	providers := map[ProviderName]Provider{p.Name(): p}
+	ctx := context.Background()
+	server.Close()
+
+	// Without a list, the built-in table is used as is.
+	catalog, _ := NewModelCatalog(cachePath, overridesPath, time.Hour)
+	err := catalog.Refresh(ctx, providers, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "Local: ") {
+		t.Errorf("err = %v, want the provider's fetch error", err)
+	}
+	if _, ok := catalog.FetchedAt(p.Name()); ok {
+		t.Error("failed fetch recorded a list")
+	}
+	if got := modelIDs(catalog.Models(p.Name(), p.Models(), true)); got != "qwen2.5-coder:14b,custom:7b" {
+		t.Errorf("offline models = %s, want the built-in table", got)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("failed refresh wrote the cache: %v", err)
+	}
+
+	// A cached list outlives a failed refresh.
+	online := newCompatibleServer(t)
+	p.Config.BaseURL = online.URL + "/v1"
+	if err := catalog.Refresh(ctx, providers, true); err != nil {
+		t.Fatal(err)
+	}
+	online.Close()
+	if err := catalog.Refresh(ctx, providers, true); err == nil {
+		t.Error("refresh against a closed server succeeded")
+	}
+	if got := modelIDs(catalog.Models(p.Name(), p.Models(), true)); got != "qwen2.5-coder:14b,custom:7b,llava:13b" {
+		t.Errorf("models after a failed refresh = %s, want the cached list", got)
+	}
+
+	// Providers without a key are skipped quietly.
+	keyed := NewOpenAICompatible(CompatibleConfig{Name: "Gateway", BaseURL: online.URL})
+	if err := catalog.Refresh(ctx, map[ProviderName]Provider{keyed.Name(): keyed}, true); err != nil {
+		t.Errorf("keyless refresh = %v", err)
+	}
+}
+
+func TestCatalogOverrides(t *testing.T) {
+	cachePath, overridesPath := catalogPaths(t)
+	if err := os.MkdirAll(filepath.Dir(overridesPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	overrides := `
+[[models]]
+provider                     = "local"
+id                           = "qwen2.5-coder:14b"
+display_name                 = "Qwen Coder"
+context_window               = 131072
+input_cost_per_million       = 0.15
+cache_read_cost_per_million  = 0.03
+cache_write_cost_per_million = 0.2
+vision                       = false
+
+[[models]]
+provider = "Local"
+id       = "custom:7b"
+hidden   = true
+
+[[models]]
+provider               = "Local"
+id                     = "deepseek-r1:32b"
+context_window         = 65536
+
+[[models]]
+provider       = "OpenRouter"
+id             = "qwen2.5-coder:14b"
+context_window = 1
+`
+	if err := os.WriteFile(overridesPath, []byte(overrides), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := NewModelCatalog(cachePath, overridesPath, time.Hour)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	builtin := []ModelDescriptor{
+		{ID: "qwen2.5-coder:14b", ProviderName: "Local", ContextWindow: 32768, OutputCostPerMillion: 0.6, Vision: true},
+		{ID: "custom:7b", ProviderName: "Local"},
+	}
+
+	// Overrides apply to the built-in table too, without a fetched list.
+	models := catalog.Models("Local", builtin, true)
+	if got := modelIDs(models); got != "qwen2.5-coder:14b,deepseek-r1:32b" {
+		t.Fatalf("models = %s", got)
+	}
+	want := ModelDescriptor{
+		ID:                       "qwen2.5-coder:14b",
+		DisplayName:              "Qwen Coder",
+		ProviderName:             "Local",
+		ContextWindow:            131072,
+		InputCostPerMillion:      0.15,
+		OutputCostPerMillion:     0.6,
+		CacheReadCostPerMillion:  0.03,
+		CacheWriteCostPerMillion: 0.2,
+	}
+	if models[0] != want {
+		t.Errorf("overridden model = %+v, want %+v", models[0], want)
+	}
+	added := ModelDescriptor{ID: "deepseek-r1:32b", DisplayName: "deepseek-r1:32b", ProviderName: "Local", ContextWindow: 65536}
+	if models[1] != added {
+		t.Errorf("added model = %+v, want %+v", models[1], added)
+	}
+	if builtin[0].ContextWindow != 32768 {
+		t.Error("overrides changed the built-in table")
+	}
+
+	if err := os.WriteFile(overridesPath, []byte("[[models]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewModelCatalog(cachePath, overridesPath, time.Hour); err == nil {
+		t.Error("invalid overrides file loaded without an error")
+	}
+}
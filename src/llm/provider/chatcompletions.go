@@ -0,0 +1,167 @@
+package llm
+
+import "fmt"
+
+// chatMessage is a message as the OpenAI-compatible chat completions APIs
+// take it. Content is a string, or a list of parts when the message carries
+// attachments.
+type chatMessage struct {
+	Role             string             `json:"role"`
+	Content          any                `json:"content"`
+	ToolCalls        []ToolCall         `json:"tool_calls,omitempty"`
+	ToolCallId       string             `json:"tool_call_id,omitempty"`
+	Reasoning        string             `json:"reasoning,omitempty"`
+	ReasoningDetails []ReasoningDetails `json:"reasoning_details,omitempty"`
+}
+
+type chatContentPart struct {
+	Type     string        `json:"type"` // text | image_url | file
+	Text     string        `json:"text,omitempty"`
+	ImageURL *ImageURLPart `json:"image_url,omitempty"`
+	File     *FilePart     `json:"file,omitempty"`
+}
+
+type ImageURLPart struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"` // auto | low | high
+}
+
+type FilePart struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data"`
+}
+
+// toChatMessages converts messages for the chat completions APIs. Reasoning
+// is only kept for APIs that accept it back. Each tool result becomes a
+// tool message of its own, and since those can only hold text, images and
+// documents returned by tools follow the batch of results in a user message.
+func toChatMessages(messages []Message, keepReasoning bool) []chatMessage {
+	out := make([]chatMessage, 0, len(messages))
+	var pending []ContentBlock
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		blocks := append(
+			[]ContentBlock{TextBlock("Attachments returned by the tool calls above:")},
+			pending...,
+		)
+		out = append(out, chatMessage{Role: "user", Content: toChatContent(blocks)})
+		pending = nil
+	}
+
+	for _, m := range messages {
+		if m.Role == "tool" {
+			for _, result := range m.ToolResults() {
+				text, media := splitMedia(result.Content)
+				out = append(out, chatMessage{
+					Role:       "tool",
+					Content:    blocksText(text),
+					ToolCallId: result.ToolUseID,
+				})
+				pending = append(pending, media...)
+			}
+			continue
+		}
+		flush()
+
+		if m.Role != "assistant" {
+			out = append(out, chatMessage{Role: m.Role, Content: toChatContent(m.Content)})
+			continue
+		}
+
+		msg := chatMessage{Role: m.Role, Content: m.Text()}
+		for i, use := range m.ToolUses() {
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				Type:     "function",
+				Index:    i,
+				ID:       use.ID,
+				Function: ToolCallFunction{Name: use.Name, Arguments: use.Input},
+			})
+		}
+		if keepReasoning {
+			msg.Reasoning = m.ReasoningText()
+			msg.ReasoningDetails = m.Reasoning()
+		}
+		out = append(out, msg)
+	}
+	flush()
+
+	return out
+}
+
+// fromChatMessage builds an assistant message from a chat completions
+// reply: its reasoning, then its text, then its tool calls.
+func fromChatMessage(
+	role, content string,
+	toolCalls []ToolCall,
+	reasoning string,
+	details []ReasoningDetails,
+) Message {
+	if role == "" {
+		role = "assistant"
+	}
+	msg := Message{Role: role, Content: reasoningBlocks(reasoning, details)}
+	if content != "" {
+		msg.Content = append(msg.Content, TextBlock(content))
+	}
+	for _, tc := range toolCalls {
+		msg.Content = append(msg.Content, ContentBlock{
+			Type: BlockToolUse,
+			ToolUse: &ToolUse{
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: tc.Function.Arguments,
+			},
+		})
+	}
+	return msg
+}
+
+// toChatContent returns the blocks as a plain string when they are all
+// text, and as a list of parts otherwise.
+func toChatContent(blocks []ContentBlock) any {
+	text, media := splitMedia(blocks)
+	if len(media) == 0 {
+		return blocksText(text)
+	}
+
+	out := []chatContentPart{}
+	for _, b := range blocks {
+		switch b.Type {
+		case BlockText:
+			out = append(out, chatContentPart{Type: "text", Text: b.Text})
+		case BlockImage:
+			out = append(out, chatContentPart{
+				Type:     "image_url",
+				ImageURL: &ImageURLPart{URL: dataURI(b.Media)},
+			})
+		case BlockDocument:
+			out = append(out, chatContentPart{
+				Type: "file",
+				File: &FilePart{Filename: b.Media.Name, FileData: dataURI(b.Media)},
+			})
+		}
+	}
+	return out
+}
+
+// splitMedia separates text blocks from image and document blocks.
+func splitMedia(blocks []ContentBlock) (text, media []ContentBlock) {
+	for _, b := range blocks {
+		switch b.Type {
+		case BlockText:
+			text = append(text, b)
+		case BlockImage, BlockDocument:
+			if b.Media != nil {
+				media = append(media, b)
+			}
+		}
+	}
+	return text, media
+}
+
+func dataURI(m *Media) string {
+	return fmt.Sprintf("data:%s;base64,%s", m.MediaType, m.Data)
+}
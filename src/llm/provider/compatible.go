@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// NoAuth is the AuthHeader of servers that take no API key, such as a
+// local Ollama or llama.cpp server.
+const NoAuth = "none"
+
+// CompatibleConfig describes a server that speaks the OpenAI chat
+// completions API at BaseURL, e.g. http://localhost:11434/v1.
+type CompatibleConfig struct {
+	Name    ProviderName
+	BaseURL string
+	// AuthHeader is the header carrying the API key. Authorization, the
+	// default, sends "Bearer <key>"; any other header sends the key as is,
+	// and NoAuth sends nothing.
+	AuthHeader string
+	Models     []ModelDescriptor
+}
+
+// OpenAICompatible is a user-configured provider for a self-hosted model
+// server or gateway.
+type OpenAICompatible struct {
+	Config     CompatibleConfig
+	ApiKey     string
+	HTTPClient *http.Client
+}
+
+func NewOpenAICompatible(cfg CompatibleConfig) *OpenAICompatible {
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.AuthHeader == "" {
+		cfg.AuthHeader = "Authorization"
+	}
+	return &OpenAICompatible{Config: cfg}
+}
+
+func (p OpenAICompatible) Name() ProviderName {
+	return p.Config.Name
+}
+
+func (p *OpenAICompatible) SetApiKey(key string) {
+	p.ApiKey = key
+}
+
+func (p *OpenAICompatible) SetHTTPClient(client *http.Client) {
+	p.HTTPClient = client
+}
+
+// KeyOptional reports whether the server can be used without an API key.
+func (p OpenAICompatible) KeyOptional() bool {
+	return p.Config.AuthHeader == NoAuth
+}
+
+func (p OpenAICompatible) authorize(req *http.Request, key string) {
+	switch {
+	case p.Config.AuthHeader == NoAuth || key == "":
+	case strings.EqualFold(p.Config.AuthHeader, "Authorization"):
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
+	default:
+		req.Header.Set(p.Config.AuthHeader, key)
+	}
+}
+
+func (p *OpenAICompatible) AuthCheck(key string) AuthResult {
+	client := authCheckClient(p.HTTPClient)
+	req, err := http.NewRequest(http.MethodGet, p.Config.BaseURL+"/models", nil)
+	if err != nil {
+		return AuthResult{Status: 0, ErrorMessage: err.Error()}
+	}
+	p.authorize(req, key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return AuthResult{Status: 0, ErrorMessage: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	result := AuthResult{Status: resp.StatusCode}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		result.ErrorMessage = string(body)
+	} else {
+		p.ApiKey = key
+	}
+	return result
+}
+
+func (p OpenAICompatible) endpoint() openAIEndpoint {
+	return openAIEndpoint{
+		name: p.Name(),
+		url:  p.Config.BaseURL + "/chat/completions",
+		authorize: func(req *http.Request) {
+			p.authorize(req, p.ApiKey)
+		},
+		detector: p,
+		client:   p.HTTPClient,
+	}
+}
+
+func (p OpenAICompatible) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	return p.endpoint().complete(ctx, request)
+}
+
+func (p OpenAICompatible) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	return p.endpoint().completeStream(ctx, request, onDelta)
+}
+
+func (p OpenAICompatible) Models() []ModelDescriptor {
+	descriptors := make([]ModelDescriptor, len(p.Config.Models))
+	for i, m := range p.Config.Models {
+		m.ProviderName = string(p.Config.Name)
+		if m.DisplayName == "" {
+			m.DisplayName = m.ID
+		}
+		descriptors[i] = m
+	}
+	return descriptors
+}
+
+// ModelsConfigured reports that the models come from the configuration,
+// so the catalog keeps them when the server does not list them.
+func (p OpenAICompatible) ModelsConfigured() bool {
+	return true
+}
+
+// FetchModels lists the models the server serves. Context windows and
+// prices come from the configured models only.
+func (p OpenAICompatible) FetchModels(ctx context.Context) ([]ModelDescriptor, error) {
+	if p.ApiKey == "" && !p.KeyOptional() {
+		return nil, errNoAPIKey
+	}
+	var list openAIModelList
+	err := getModelList(ctx, p.HTTPClient, p.Config.BaseURL+"/models", func(req *http.Request) {
+		p.authorize(req, p.ApiKey)
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]ModelDescriptor, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, ModelDescriptor{
+			ID:           m.ID,
+			DisplayName:  m.ID,
+			ProviderName: string(p.Config.Name),
+		})
+	}
+	return models, nil
+}
+
+// IsQuotaError recognizes OpenAI's insufficient_quota, which gateways in
+// front of OpenAI pass on.
+func (p OpenAICompatible) IsQuotaError(resp *http.Response, body []byte) bool {
+	return OpenAI{}.IsQuotaError(resp, body)
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"zipcode/src/config"
+)
+
+func TestMain(m *testing.M) {
+	// Headless without debug keeps the request log out of ./logs.txt.
+	config.Cfg.Headless = true
+	os.Exit(m.Run())
+}
+
+// compatibleServer is a fake OpenAI-compatible server under /v1 that
+// records the requests it gets.
+type compatibleServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]any
+}
+
+func newCompatibleServer(t *testing.T) *compatibleServer {
+	t.Helper()
+	s := &compatibleServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *compatibleServer) handle(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		json.Unmarshal(data, &body)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	s.mu.Unlock()
+
+	if r.Header.Get("Authorization") == "Bearer bad-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"invalid key"}}`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/models":
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"qwen2.5-coder:14b"},{"id":"llava:13b"}]}`)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/chat/completions" && body["stream"] == true:
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"id":"chatcmpl-2","model":"qwen2.5-coder:14b","choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"choices":[{"delta":{"content":"lo"}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/chat/completions":
+		fmt.Fprint(w, `{
+			"id": "chatcmpl-1",
+			"model": "qwen2.5-coder:14b",
+			"choices": [{"message": {"role": "assistant", "content": "Hello"}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 1}
+		}`)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *compatibleServer) last() (*http.Request, map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil, nil
+	}
+	return s.requests[len(s.requests)-1], s.bodies[len(s.bodies)-1]
+}
+
+func TestCompatibleAuthHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		authHeader string
+		header     string
+		want       string
+	}{
+		{"bearer by default", "", "Authorization", "Bearer secret"},
+		{"custom header", "X-Api-Key", "X-Api-Key", "secret"},
+		{"no auth", NoAuth, "Authorization", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCompatibleServer(t)
+			p := NewOpenAICompatible(CompatibleConfig{
+				Name:       "Local",
+				BaseURL:    server.URL + "/v1/",
+				AuthHeader: tt.authHeader,
+			})
+
+			if result := p.AuthCheck("secret"); result.Status != http.StatusOK {
+				t.Fatalf("auth check: %+v", result)
+			}
+			req, _ := server.last()
+			if req.URL.Path != "/v1/models" {
+				t.Errorf("auth check went to %s, want /v1/models", req.URL.Path)
+			}
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+			if tt.authHeader == NoAuth && req.Header.Get("X-Api-Key")+req.Header.Get("Authorization") != "" {
+				t.Errorf("no auth sent a key: %v", req.Header)
+			}
+
+			if _, err := p.Complete(context.Background(), ChatRequest{Model: "qwen2.5-coder:14b"}); err != nil {
+				t.Fatalf("complete: %v", err)
+			}
+			req, _ = server.last()
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("completion %s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompatibleAuthCheckRejectedKey(t *testing.T) {
+	server := newCompatibleServer(t)
+	p := NewOpenAICompatible(CompatibleConfig{Name: "Gateway", BaseURL: server.URL + "/v1"})
+
+	result := p.AuthCheck("bad-key")
+	if result.Status != http.StatusUnauthorized || !strings.Contains(result.ErrorMessage, "invalid key") {
+		t.Fatalf("auth check = %+v, want 401 with the server's message", result)
+	}
+	if p.ApiKey != "" {
+		t.Errorf("rejected key was kept")
+	}
+	if p.KeyOptional() {
+		t.Errorf("Authorization provider reports the key as optional")
+	}
+}
+
+func TestCompatibleFetchModels(t *testing.T) {
+	server := newCompatibleServer(t)
+	p := NewOpenAICompatible(CompatibleConfig{Name: "Local", BaseURL: server.URL + "/v1/", AuthHeader: NoAuth})
+
+	models, err := p.FetchModels(context.Background())
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "qwen2.5-coder:14b" || models[0].ProviderName != "Local" {
+		t.Fatalf("models = %+v", models)
+	}
+}
+
+func TestCompatibleComplete(t *testing.T) {
+	server := newCompatibleServer(t)
+	p := NewOpenAICompatible(CompatibleConfig{Name: "Local", BaseURL: server.URL + "/v1/", AuthHeader: NoAuth})
+
+	response, err := p.Complete(context.Background(), ChatRequest{
+		Model:    "qwen2.5-coder:14b",
+		Messages: []Message{TextMessage("user", "Say hello")},
+	})
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	req, body := server.last()
+	if req.URL.Path != "/v1/chat/completions" {
+		t.Errorf("path = %s", req.URL.Path)
+	}
+	if body["model"] != "qwen2.5-coder:14b" || body["stream"] == true {
+		t.Errorf("request body = %v", body)
+	}
+	if response.Message.Text() != "Hello" || response.StopReason != "stop" {
+		t.Errorf("response = %+v", response)
+	}
+	if response.Usage.InputTokens != 10 || response.Usage.OutputTokens != 1 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+}
+
+func TestCompatibleCompleteStream(t *testing.T) {
+	server := newCompatibleServer(t)
+	p := NewOpenAICompatible(CompatibleConfig{Name: "Local", BaseURL: server.URL + "/v1/", AuthHeader: NoAuth})
+
+	var deltas []string
+	response, err := p.CompleteStream(context.Background(), ChatRequest{
+		Model:    "qwen2.5-coder:14b",
+		Messages: []Message{TextMessage("user", "Say hello")},
+	}, func(d StreamDelta) {
+		if d.Type == DeltaText {
+			deltas = append(deltas, d.Text)
+		}
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	req, body := server.last()
+	if req.URL.Path != "/v1/chat/completions" || body["stream"] != true {
+		t.Errorf("request = %s %v", req.URL.Path, body)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if response.Message.Text() != "Hello" || response.StopReason != "stop" || response.ID != "chatcmpl-2" {
+		t.Errorf("response = %+v", response)
+	}
+	if response.Usage.InputTokens != 12 || response.Usage.OutputTokens != 2 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+}
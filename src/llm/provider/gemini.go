@@ -0,0 +1,734 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"zipcode/src/llm/errors"
+	"zipcode/src/tools"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// geminiReasoningFormat marks thought signatures from Gemini. They have to
+// go back on the same part of the model turn for Gemini to keep its
+// reasoning across function calls.
+const geminiReasoningFormat = "google-gemini-v1"
+
+type Gemini struct {
+	ProviderId string
+	Model      string
+	Tools      []tools.Tool
+	ApiKey     string
+	HTTPClient *http.Client
+}
+
+func (p Gemini) Name() ProviderName {
+	return GeminiProvider
+}
+
+func (p *Gemini) SetApiKey(key string) {
+	p.ApiKey = key
+}
+
+func (p *Gemini) SetHTTPClient(client *http.Client) {
+	p.HTTPClient = client
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// geminiFunctionResponse carries a tool result. Response has to be an
+// object: the result goes under "output", or "error" when the tool failed.
+type geminiFunctionResponse struct {
+	Name     string            `json:"name"`
+	Response map[string]string `json:"response"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"` // user | model
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiFunctionDeclaration takes the tool schema as JSON Schema, which
+// unlike the OpenAPI subset of "parameters" accepts MCP tool schemas as
+// they are.
+type geminiFunctionDeclaration struct {
+	Name                 string           `json:"name"`
+	Description          string           `json:"description,omitempty"`
+	ParametersJSONSchema tools.JSONSchema `json:"parametersJsonSchema"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens int                   `json:"maxOutputTokens,omitempty"`
+	Temperature     float64               `json:"temperature,omitempty"`
+	ThinkingConfig  *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent         `json:"contents"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+}
+
+// toUsage counts thoughts as output, which is how they are billed, and
+// implicitly cached prompt tokens as cached input.
+func (u geminiUsage) toUsage() Usage {
+	return Usage{
+		InputTokens:       u.PromptTokenCount,
+		CachedInputTokens: u.CachedContentTokenCount,
+		OutputTokens:      u.CandidatesTokenCount + u.ThoughtsTokenCount,
+		ReasoningTokens:   u.ThoughtsTokenCount,
+	}
+}
+
+type geminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Details []struct {
+		Reason     string `json:"reason"`
+		Violations []struct {
+			QuotaID string `json:"quotaId"`
+		} `json:"violations"`
+	} `json:"details"`
+}
+
+type geminiCandidate struct {
+	Content      geminiContent `json:"content"`
+	FinishReason string        `json:"finishReason"`
+}
+
+type geminiResponse struct {
+	Candidates     []geminiCandidate `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback,omitempty"`
+	UsageMetadata geminiUsage  `json:"usageMetadata"`
+	ModelVersion  string       `json:"modelVersion"`
+	ResponseID    string       `json:"responseId"`
+	Error         *geminiError `json:"error,omitempty"`
+}
+
+// geminiThinkingBudget maps a reasoning effort to thinkingBudget; -1 leaves
+// the model's default. A budget of 0 turns thinking off, which only the
+// Flash models allow.
+func geminiThinkingBudget(effort string) int {
+	switch effort {
+	case EffortNone:
+		return 0
+	case EffortMinimal:
+		return 512
+	case EffortLow:
+		return 2048
+	case EffortMedium:
+		return 8192
+	case EffortHigh:
+		return 24576
+	}
+	return -1
+}
+
+// geminiKeyRejected reports whether an error body is Gemini turning down
+// the API key, which it answers with 400 rather than 401.
+func geminiKeyRejected(body []byte) bool {
+	text := string(body)
+	return strings.Contains(text, "API_KEY_INVALID") ||
+		strings.Contains(text, "API key not valid")
+}
+
+func (p *Gemini) AuthCheck(key string) AuthResult {
+	client := authCheckClient(p.HTTPClient)
+	req, err := http.NewRequest(http.MethodGet, geminiBaseURL+"/models", nil)
+	if err != nil {
+		return AuthResult{Status: 0, ErrorMessage: err.Error()}
+	}
+	req.Header.Set("x-goog-api-key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return AuthResult{Status: 0, ErrorMessage: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	result := AuthResult{Status: resp.StatusCode}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		result.ErrorMessage = string(body)
+		if geminiKeyRejected(body) {
+			result.Status = http.StatusUnauthorized
+		}
+	} else {
+		p.ApiKey = key
+	}
+	return result
+}
+
+func (p Gemini) post(ctx context.Context, request ChatRequest) (*http.Response, error) {
+	system, contents := convertMessagesToGemini(request.Messages)
+
+	payload := geminiRequest{
+		Contents: contents,
+		Tools:    convertToolsToGemini(request.Tools),
+		GenerationConfig: &geminiGenerationConfig{
+			MaxOutputTokens: request.MaxTokens,
+			Temperature:     request.Temperature,
+		},
+	}
+	if system != "" {
+		payload.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	if budget := geminiThinkingBudget(request.ReasoningEffort); budget >= 0 {
+		payload.GenerationConfig.ThinkingConfig = &geminiThinkingConfig{
+			ThinkingBudget:  budget,
+			IncludeThoughts: budget > 0,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/models/%s:generateContent", geminiBaseURL, request.Model)
+	if request.Stream {
+		url = fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", geminiBaseURL, request.Model)
+	}
+
+	res, err := errors.RetryWithBackoff(ctx, p, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			url,
+			bytes.NewReader(body),
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-goog-api-key", p.ApiKey)
+		return httpClientOr(p.HTTPClient).Do(req)
+	})
+	if err != nil {
+		return nil, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	if res.StatusCode >= 400 {
+		errBody, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusBadRequest && geminiKeyRejected(errBody) {
+			res.StatusCode = http.StatusUnauthorized
+		}
+		return nil, errors.ClassifyResponse(string(p.Name()), request.Model, p, res, errBody)
+	}
+
+	return res, nil
+}
+
+func (p Gemini) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	request.Stream = false
+	res, err := p.post(ctx, request)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	respBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	var parsed geminiResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return ChatResponse{}, err
+	}
+
+	if parsed.Error != nil {
+		return ChatResponse{}, errors.ClassifyStreamError(
+			string(p.Name()),
+			request.Model,
+			parsed.Error.Status,
+			parsed.Error.Message,
+		)
+	}
+
+	return parsed.toChatResponse(request.Model)
+}
+
+// geminiBlockReasons are the finish reasons of a candidate Gemini withheld.
+var geminiBlockReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+// toChatResponse maps the first candidate's parts onto message blocks in
+// order. A thought signature becomes a reasoning block just before the
+// block of the part it came on. Gemini does not always id its function
+// calls, so calls without one are given ids from the response id.
+func (parsed geminiResponse) toChatResponse(model string) (ChatResponse, error) {
+	if len(parsed.Candidates) == 0 {
+		if parsed.PromptFeedback != nil && parsed.PromptFeedback.BlockReason != "" {
+			return ChatResponse{}, fmt.Errorf(
+				"gemini: the prompt was blocked by safety filters (%s)",
+				parsed.PromptFeedback.BlockReason,
+			)
+		}
+		return ChatResponse{}, fmt.Errorf("gemini: no candidates in response")
+	}
+	candidate := parsed.Candidates[0]
+	if len(candidate.Content.Parts) == 0 && geminiBlockReasons[candidate.FinishReason] {
+		return ChatResponse{}, fmt.Errorf(
+			"gemini: the response was blocked by safety filters (%s)",
+			candidate.FinishReason,
+		)
+	}
+
+	var content []ContentBlock
+	for i, part := range candidate.Content.Parts {
+		if part.Thought {
+			content = append(content, ContentBlock{
+				Type: BlockReasoning,
+				Reasoning: &ReasoningDetails{
+					Type:      ReasoningText,
+					Text:      part.Text,
+					Signature: part.ThoughtSignature,
+					Format:    geminiReasoningFormat,
+					Index:     i,
+				},
+			})
+			continue
+		}
+		if part.ThoughtSignature != "" {
+			content = append(content, ContentBlock{
+				Type: BlockReasoning,
+				Reasoning: &ReasoningDetails{
+					Type:      ReasoningEncrypted,
+					Signature: part.ThoughtSignature,
+					Format:    geminiReasoningFormat,
+					Index:     i,
+				},
+			})
+		}
+		switch {
+		case part.FunctionCall != nil:
+			args := string(part.FunctionCall.Args)
+			if args == "" || args == "null" {
+				args = "{}"
+			}
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = geminiCallID(parsed.ResponseID, i)
+			}
+			content = append(content, ContentBlock{
+				Type:    BlockToolUse,
+				ToolUse: &ToolUse{ID: id, Name: part.FunctionCall.Name, Input: args},
+			})
+		case part.Text != "":
+			content = append(content, TextBlock(part.Text))
+		}
+	}
+
+	responseModel := parsed.ModelVersion
+	if responseModel == "" {
+		responseModel = model
+	}
+
+	return ChatResponse{
+		ID:         parsed.ResponseID,
+		Model:      responseModel,
+		StopReason: candidate.FinishReason,
+		Usage:      parsed.UsageMetadata.toUsage(),
+		Message:    Message{Role: "assistant", Content: content},
+	}, nil
+}
+
+func geminiCallID(responseID string, index int) string {
+	if responseID == "" {
+		responseID = fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("call_%s_%d", responseID, index)
+}
+
+// CompleteStream consumes streamGenerateContent as server-sent events. Each
+// event is a partial response: text and thoughts are appended to the last
+// part of their kind, function calls arrive whole. The parts are gathered
+// into one geminiResponse so the result goes through the same conversion
+// as Complete.
+func (p Gemini) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	request.Stream = true
+	res, err := p.post(ctx, request)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer res.Body.Close()
+
+	var parsed geminiResponse
+	var parts []geminiPart
+	finishReason := ""
+
+	err = readSSE(res.Body, func(_ string, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("gemini: invalid stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return errors.ClassifyStreamError(
+				string(p.Name()),
+				request.Model,
+				chunk.Error.Status,
+				chunk.Error.Message,
+			)
+		}
+
+		if chunk.ResponseID != "" {
+			parsed.ResponseID = chunk.ResponseID
+		}
+		if chunk.ModelVersion != "" {
+			parsed.ModelVersion = chunk.ModelVersion
+		}
+		if chunk.UsageMetadata != (geminiUsage{}) {
+			parsed.UsageMetadata = chunk.UsageMetadata
+		}
+		if chunk.PromptFeedback != nil {
+			parsed.PromptFeedback = chunk.PromptFeedback
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+
+		candidate := chunk.Candidates[0]
+		if candidate.FinishReason != "" {
+			finishReason = candidate.FinishReason
+		}
+		for _, part := range candidate.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				parts = append(parts, part)
+				args := string(part.FunctionCall.Args)
+				if args == "" || args == "null" {
+					args = "{}"
+				}
+				onDelta(StreamDelta{
+					Type: DeltaToolCall,
+					ToolCall: ToolCall{
+						Type:  "function",
+						Index: len(parts) - 1,
+						ID:    part.FunctionCall.ID,
+						Function: ToolCallFunction{
+							Name:      part.FunctionCall.Name,
+							Arguments: args,
+						},
+					},
+				})
+				continue
+			case part.Thought:
+				onDelta(StreamDelta{Type: DeltaReasoning, Text: part.Text})
+			case part.Text != "":
+				onDelta(StreamDelta{Type: DeltaText, Text: part.Text})
+			}
+
+			last := len(parts) - 1
+			if last >= 0 && parts[last].FunctionCall == nil && parts[last].Thought == part.Thought {
+				parts[last].Text += part.Text
+				if part.ThoughtSignature != "" {
+					parts[last].ThoughtSignature = part.ThoughtSignature
+				}
+				continue
+			}
+			parts = append(parts, part)
+		}
+		return nil
+	})
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	if len(parts) > 0 || finishReason != "" {
+		parsed.Candidates = append(parsed.Candidates, geminiCandidate{
+			Content:      geminiContent{Role: "model", Parts: parts},
+			FinishReason: finishReason,
+		})
+	}
+
+	return parsed.toChatResponse(request.Model)
+}
+
+func (p Gemini) Models() []ModelDescriptor {
+	entries := []struct {
+		id            string
+		contextWindow int
+		inputCost     float64
+		outputCost    float64
+	}{
+		{"gemini-3-pro-preview", 1_048_576, 2.00, 12.00},
+		{"gemini-3-flash-preview", 1_048_576, 0.50, 3.00},
+		{"gemini-2.5-pro", 1_048_576, 1.25, 10.00},
+		{"gemini-2.5-flash", 1_048_576, 0.30, 2.50},
+		{"gemini-2.5-flash-lite", 1_048_576, 0.10, 0.40},
+	}
+	descriptors := make([]ModelDescriptor, len(entries))
+	for i, e := range entries {
+		// Implicit cache hits cost a tenth of the input rate; there is no
+		// charge for writing them.
+		descriptors[i] = ModelDescriptor{
+			ID:                       e.id,
+			DisplayName:              e.id,
+			ProviderName:             string(GeminiProvider),
+			ContextWindow:            e.contextWindow,
+			InputCostPerMillion:      e.inputCost,
+			OutputCostPerMillion:     e.outputCost,
+			CacheReadCostPerMillion:  e.inputCost * 0.10,
+			CacheWriteCostPerMillion: e.inputCost,
+			Vision:                   true,
+		}
+	}
+	return descriptors
+}
+
+// FetchModels lists the Gemini models that can generate content, with
+// their input limits.
+func (p Gemini) FetchModels(ctx context.Context) ([]ModelDescriptor, error) {
+	if p.ApiKey == "" {
+		return nil, errNoAPIKey
+	}
+	var list struct {
+		Models []struct {
+			Name                       string   `json:"name"`
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	err := getModelList(ctx, p.HTTPClient, geminiBaseURL+"/models?pageSize=1000", func(req *http.Request) {
+		req.Header.Set("x-goog-api-key", p.ApiKey)
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []ModelDescriptor
+	for _, m := range list.Models {
+		id := strings.TrimPrefix(m.Name, "models/")
+		if !strings.HasPrefix(id, "gemini") || !slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+			continue
+		}
+		models = append(models, ModelDescriptor{
+			ID:            id,
+			DisplayName:   id,
+			ProviderName:  string(GeminiProvider),
+			ContextWindow: m.InputTokenLimit,
+			Vision:        true,
+		})
+	}
+	return models, nil
+}
+
+// IsQuotaError tells a spent quota from a passing rate limit. Gemini
+// answers both with 429 RESOURCE_EXHAUSTED; only a daily quota will not
+// clear by retrying. Projects without billing where the free tier is not
+// offered get 400 FAILED_PRECONDITION.
+func (p Gemini) IsQuotaError(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	var parsed struct {
+		Error geminiError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return false
+	}
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return parsed.Error.Status == "FAILED_PRECONDITION" &&
+			strings.Contains(strings.ToLower(parsed.Error.Message), "billing")
+	}
+	for _, detail := range parsed.Error.Details {
+		for _, violation := range detail.Violations {
+			if strings.Contains(violation.QuotaID, "PerDay") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func convertToolsToGemini(in []tools.Tool) []geminiTool {
+	if len(in) == 0 {
+		return nil
+	}
+	declarations := make([]geminiFunctionDeclaration, 0, len(in))
+	for _, t := range in {
+		declarations = append(declarations, geminiFunctionDeclaration{
+			Name:                 t.Function.Name,
+			Description:          t.Function.Description,
+			ParametersJSONSchema: t.Function.Parameters,
+		})
+	}
+	return []geminiTool{{FunctionDeclarations: declarations}}
+}
+
+// geminiParts converts the content of a user turn or tool result: text,
+// then images and documents inline.
+func geminiParts(content []ContentBlock) []geminiPart {
+	var parts []geminiPart
+	for _, b := range content {
+		switch b.Type {
+		case BlockText:
+			if b.Text != "" {
+				parts = append(parts, geminiPart{Text: b.Text})
+			}
+		case BlockImage, BlockDocument:
+			if b.Media == nil {
+				continue
+			}
+			parts = append(parts, geminiPart{InlineData: &geminiInlineData{
+				MimeType: b.Media.MediaType,
+				Data:     b.Media.Data,
+			}})
+		}
+	}
+	return parts
+}
+
+// convertMessagesToGemini adapts messages to generateContent. System turns
+// become the system instruction, assistant turns are "model" turns and
+// tool results are function responses in a user turn, named after the
+// call they answer since Gemini matches them by name. Images and documents
+// returned by tools follow the responses in the same turn. Consecutive
+// turns of one role are merged, as Gemini expects all the responses to a
+// model turn's calls together.
+func convertMessagesToGemini(msgs []Message) (string, []geminiContent) {
+	var system string
+	callNames := map[string]string{}
+	for _, m := range msgs {
+		for _, use := range m.ToolUses() {
+			callNames[use.ID] = use.Name
+		}
+	}
+
+	var out []geminiContent
+	add := func(role string, parts []geminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+		if len(out) > 0 && out[len(out)-1].Role == role {
+			out[len(out)-1].Parts = append(out[len(out)-1].Parts, parts...)
+			return
+		}
+		out = append(out, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, m := range msgs {
+		switch m.Role {
+		case "system":
+			if system != "" {
+				system += "\n\n"
+			}
+			system += m.Text()
+		case "tool":
+			var parts, media []geminiPart
+			for _, result := range m.ToolResults() {
+				name := callNames[result.ToolUseID]
+				if name == "" {
+					name = result.ToolUseID
+				}
+				key := "output"
+				if result.IsError {
+					key = "error"
+				}
+				_, attached := splitMedia(result.Content)
+				parts = append(parts, geminiPart{FunctionResponse: &geminiFunctionResponse{
+					Name:     name,
+					Response: map[string]string{key: result.Text()},
+				}})
+				media = append(media, geminiParts(attached)...)
+			}
+			add("user", append(parts, media...))
+		case "assistant":
+			var parts []geminiPart
+			signature := ""
+			for _, b := range m.Content {
+				switch b.Type {
+				case BlockReasoning:
+					// Only signatures go back; they ride on the next part.
+					if b.Reasoning != nil && b.Reasoning.Format == geminiReasoningFormat {
+						signature = b.Reasoning.Signature
+					}
+				case BlockText:
+					if b.Text == "" {
+						continue
+					}
+					parts = append(parts, geminiPart{Text: b.Text, ThoughtSignature: signature})
+					signature = ""
+				case BlockToolUse:
+					if b.ToolUse == nil {
+						continue
+					}
+					args := json.RawMessage(b.ToolUse.Input)
+					if !json.Valid(args) {
+						args = json.RawMessage("{}")
+					}
+					parts = append(parts, geminiPart{
+						FunctionCall:     &geminiFunctionCall{Name: b.ToolUse.Name, Args: args},
+						ThoughtSignature: signature,
+					})
+					signature = ""
+				}
+			}
+			add("model", parts)
+		default:
+			add("user", geminiParts(m.Content))
+		}
+	}
+
+	return system, out
+}
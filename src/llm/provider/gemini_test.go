@@ -0,0 +1,372 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"zipcode/src/llm/errors"
+)
+
+// redirectTransport sends every request to target instead of the host it
+// was made for, so providers with a fixed API URL can talk to httptest.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// geminiServer answers generateContent with complete and
+// streamGenerateContent with the stream events, and records the last
+// request.
+type geminiServer struct {
+	*httptest.Server
+	complete string
+	stream   []string
+	status   int
+
+	mu      sync.Mutex
+	request *http.Request
+	body    map[string]any
+}
+
+func newGeminiServer(t *testing.T) (*geminiServer, *Gemini) {
+	t.Helper()
+	s := &geminiServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	target, _ := url.Parse(s.URL)
+	p := &Gemini{ApiKey: "gemini-key"}
+	p.SetHTTPClient(&http.Client{Transport: redirectTransport{target: target}})
+	return s, p
+}
+
+func (s *geminiServer) handle(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		json.Unmarshal(data, &body)
+	}
+	s.mu.Lock()
+	s.request, s.body = r, body
+	s.mu.Unlock()
+
+	if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range s.stream {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", event)
+			w.(http.Flusher).Flush()
+		}
+		return
+	}
+	w.WriteHeader(s.status)
+	fmt.Fprint(w, s.complete)
+}
+
+func (s *geminiServer) last() (*http.Request, map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.request, s.body
+}
+
+func TestConvertMessagesToGemini(t *testing.T) {
+	image := ContentBlock{Type: BlockImage, Media: &Media{MediaType: "image/png", Data: "iVBORw0KGgo="}}
+	msgs := []Message{
+		TextMessage("system", "You are a coding assistant."),
+		TextMessage("user", "Look at main.go and the screen."),
+		{Role: "assistant", Content: []ContentBlock{
+			{Type: BlockReasoning, Reasoning: &ReasoningDetails{Type: ReasoningEncrypted, Signature: "sig-1", Format: geminiReasoningFormat}},
+			TextBlock("Reading both."),
+			{Type: BlockToolUse, ToolUse: &ToolUse{ID: "call_r_1", Name: "file_read", Input: `{"path":"main.go"}`}},
+			{Type: BlockToolUse, ToolUse: &ToolUse{ID: "call_r_2", Name: "screenshot", Input: "not json"}},
+		}},
+		ToolResultMessage("call_r_1", "package main", false),
+		{Role: "tool", Content: []ContentBlock{{
+			Type:       BlockToolResult,
+			ToolResult: &ToolResult{ToolUseID: "call_r_2", Content: []ContentBlock{TextBlock("no display"), image}, IsError: true},
+		}}},
+		ToolResultMessage("call_unknown", "orphan", false),
+		TextMessage("user", "Go on."),
+	}
+
+	system, contents := convertMessagesToGemini(msgs)
+	if system != "You are a coding assistant." {
+		t.Errorf("system = %q", system)
+	}
+	data, err := json.Marshal(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tool results, their image and the next prompt are one user turn;
+	// responses are named after the calls, or the id when the call is
+	// unknown.
+	want := `[` +
+		`{"role":"user","parts":[{"text":"Look at main.go and the screen."}]},` +
+		`{"role":"model","parts":[` +
+		`{"text":"Reading both.","thoughtSignature":"sig-1"},` +
+		`{"functionCall":{"name":"file_read","args":{"path":"main.go"}}},` +
+		`{"functionCall":{"name":"screenshot","args":{}}}]},` +
+		`{"role":"user","parts":[` +
+		`{"functionResponse":{"name":"file_read","response":{"output":"package main"}}},` +
+		`{"functionResponse":{"name":"screenshot","response":{"error":"no display"}}},` +
+		`{"inlineData":{"mimeType":"image/png","data":"iVBORw0KGgo="}},` +
+		`{"functionResponse":{"name":"call_unknown","response":{"output":"orphan"}}},` +
+		`{"text":"Go on."}]}` +
+		`]`
+	if string(data) != want {
+		t.Errorf("converted\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestGeminiComplete(t *testing.T) {
+	server, p := newGeminiServer(t)
+	server.complete = `{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "Checking the file.", "thought": true},
+				{"text": "Let me read it.", "thoughtSignature": "sig-a"},
+				{"functionCall": {"name": "file_read", "args": {"path": "main.go"}}},
+				{"functionCall": {"id": "fc-7", "name": "list_dir"}}
+			]},
+			"finishReason": "STOP"
+		}],
+		"usageMetadata": {"promptTokenCount": 100, "candidatesTokenCount": 20, "thoughtsTokenCount": 5, "cachedContentTokenCount": 40},
+		"modelVersion": "gemini-2.5-flash-001",
+		"responseId": "resp-1"
+	}`
+
+	response, err := p.Complete(context.Background(), ChatRequest{
+		Model:           "gemini-2.5-flash",
+		Messages:        []Message{TextMessage("system", "Be brief."), TextMessage("user", "Read main.go")},
+		ReasoningEffort: EffortLow,
+	})
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	req, body := server.last()
+	if req.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+		t.Errorf("path = %s", req.URL.Path)
+	}
+	if req.Header.Get("x-goog-api-key") != "gemini-key" {
+		t.Errorf("key header = %q", req.Header.Get("x-goog-api-key"))
+	}
+	thinking, _ := json.Marshal(body["generationConfig"])
+	if string(thinking) != `{"thinkingConfig":{"includeThoughts":true,"thinkingBudget":2048}}` {
+		t.Errorf("generationConfig = %s", thinking)
+	}
+
+	var types []BlockType
+	for _, b := range response.Message.Content {
+		types = append(types, b.Type)
+	}
+	wantTypes := []BlockType{BlockReasoning, BlockReasoning, BlockText, BlockToolUse, BlockToolUse}
+	if fmt.Sprint(types) != fmt.Sprint(wantTypes) {
+		t.Fatalf("blocks = %v, want %v", types, wantTypes)
+	}
+	if signature := response.Message.Content[1].Reasoning; signature.Signature != "sig-a" || signature.Format != geminiReasoningFormat {
+		t.Errorf("signature block = %+v", signature)
+	}
+	uses := response.Message.ToolUses()
+	if uses[0].ID != geminiCallID("resp-1", 2) || uses[0].Input != `{"path": "main.go"}` {
+		t.Errorf("first call = %+v, want an id from the response id", uses[0])
+	}
+	if uses[1].ID != "fc-7" || uses[1].Input != "{}" {
+		t.Errorf("second call = %+v, want Gemini's id and empty arguments", uses[1])
+	}
+	if response.ID != "resp-1" || response.Model != "gemini-2.5-flash-001" || response.StopReason != "STOP" {
+		t.Errorf("response = %s %s %s", response.ID, response.Model, response.StopReason)
+	}
+	want := Usage{InputTokens: 100, CachedInputTokens: 40, OutputTokens: 25, ReasoningTokens: 5}
+	if response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+}
+
+func TestGeminiCallID(t *testing.T) {
+	if id := geminiCallID("resp-1", 3); id != "call_resp-1_3" {
+		t.Errorf("id = %q", id)
+	}
+	// Without a response id the ids are still unique per response.
+	if id := geminiCallID("", 3); !strings.HasPrefix(id, "call_") || !strings.HasSuffix(id, "_3") || id == "call__3" {
+		t.Errorf("id = %q", id)
+	}
+}
+
+func TestGeminiBlockedResponse(t *testing.T) {
+	server, p := newGeminiServer(t)
+	server.complete = `{"promptFeedback": {"blockReason": "SAFETY"}}`
+
+	_, err := p.Complete(context.Background(), ChatRequest{Model: "gemini-2.5-flash"})
+	if err == nil || !strings.Contains(err.Error(), "blocked by safety filters (SAFETY)") {
+		t.Errorf("err = %v, want the block reason", err)
+	}
+}
+
+func TestGeminiCompleteStream(t *testing.T) {
+	server, p := newGeminiServer(t)
+	server.stream = []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Plan","thought":true}]}}],"responseId":"resp-2","modelVersion":"gemini-2.5-pro"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"ning.","thought":true}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel","thoughtSignature":"sig-b"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo."}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"file_read","args":{"path":"a.go"}}}]},"finishReason":"STOP"}],` +
+			`"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":4}}`,
+	}
+
+	var deltas []string
+	response, err := p.CompleteStream(context.Background(), ChatRequest{
+		Model:    "gemini-2.5-pro",
+		Messages: []Message{TextMessage("user", "Say hello")},
+	}, func(d StreamDelta) {
+		switch d.Type {
+		case DeltaReasoning:
+			deltas = append(deltas, "thought:"+d.Text)
+		case DeltaText:
+			deltas = append(deltas, "text:"+d.Text)
+		case DeltaToolCall:
+			deltas = append(deltas, "call:"+d.ToolCall.Function.Name)
+		}
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	req, body := server.last()
+	if req.URL.Path != "/v1beta/models/gemini-2.5-pro:streamGenerateContent" || req.URL.Query().Get("alt") != "sse" {
+		t.Errorf("url = %s", req.URL)
+	}
+	if _, ok := body["generationConfig"].(map[string]any)["thinkingConfig"]; ok {
+		t.Errorf("thinkingConfig sent without an effort: %v", body)
+	}
+
+	want := []string{"thought:Plan", "thought:ning.", "text:Hel", "text:lo.", "call:file_read"}
+	if fmt.Sprint(deltas) != fmt.Sprint(want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+	if got := response.Message.ReasoningText(); got != "Planning." {
+		t.Errorf("reasoning = %q", got)
+	}
+	if got := response.Message.Text(); got != "Hello." {
+		t.Errorf("text = %q", got)
+	}
+	// The fragments merge into one thought part and one text part, so the
+	// call is part 2.
+	uses := response.Message.ToolUses()
+	if len(uses) != 1 || uses[0].ID != geminiCallID("resp-2", 2) {
+		t.Errorf("tool uses = %+v", uses)
+	}
+	if response.StopReason != "STOP" || response.Usage.InputTokens != 12 || response.Usage.OutputTokens != 4 {
+		t.Errorf("response = %+v", response)
+	}
+}
+
+func TestGeminiStreamError(t *testing.T) {
+	server, p := newGeminiServer(t)
+	server.stream = []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}]}`,
+		`{"error":{"code":503,"message":"The model is overloaded. Please try again later.","status":"UNAVAILABLE"}}`,
+	}
+
+	_, err := p.CompleteStream(context.Background(), ChatRequest{Model: "gemini-2.5-pro"}, func(StreamDelta) {})
+	if classified, ok := errors.AsClassified(err); !ok || classified.Bucket != errors.BucketNetwork {
+		t.Errorf("err = %v, want a network error", err)
+	}
+}
+
+// Error bodies as Gemini sends them.
+const (
+	geminiDailyQuota = `{"error":{"code":429,"message":"You exceeded your current quota, please check your plan and billing details.","status":"RESOURCE_EXHAUSTED",` +
+		`"details":[{"@type":"type.googleapis.com/google.rpc.QuotaFailure","violations":[{"quotaMetric":"generativelanguage.googleapis.com/generate_content_free_tier_requests",` +
+		`"quotaId":"GenerateRequestsPerDayPerProjectPerModel-FreeTier"}]},{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"38s"}]}}`
+	geminiMinuteQuota = `{"error":{"code":429,"message":"You exceeded your current quota.","status":"RESOURCE_EXHAUSTED",` +
+		`"details":[{"@type":"type.googleapis.com/google.rpc.QuotaFailure","violations":[{"quotaId":"GenerateRequestsPerMinutePerProjectPerModel-FreeTier"}]}]}}`
+	geminiNoBilling = `{"error":{"code":400,"message":"Gemini API free tier is not available in your country. Please enable billing on your project in Google AI Studio.","status":"FAILED_PRECONDITION"}}`
+	geminiBadKey    = `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT",` +
+		`"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"API_KEY_INVALID"}]}}`
+)
+
+func TestGeminiIsQuotaError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"daily quota", http.StatusTooManyRequests, geminiDailyQuota, true},
+		{"per-minute limit", http.StatusTooManyRequests, geminiMinuteQuota, false},
+		{"no billing", http.StatusBadRequest, geminiNoBilling, true},
+		{"bad key", http.StatusBadRequest, geminiBadKey, false},
+		{"server error", http.StatusInternalServerError, geminiDailyQuota, false},
+		{"not json", http.StatusTooManyRequests, "Too Many Requests", false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status}
+		if got := (Gemini{}).IsQuotaError(resp, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s: IsQuotaError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGeminiErrorResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   errors.ErrorBucket
+	}{
+		{"daily quota", http.StatusTooManyRequests, geminiDailyQuota, errors.BucketQuotaBilling},
+		{"no billing", http.StatusBadRequest, geminiNoBilling, errors.BucketQuotaBilling},
+		{"bad key", http.StatusBadRequest, geminiBadKey, errors.BucketAuth},
+	}
+	for _, tt := range tests {
+		server, p := newGeminiServer(t)
+		server.status, server.complete = tt.status, tt.body
+
+		_, err := p.Complete(context.Background(), ChatRequest{Model: "gemini-2.5-flash"})
+		if classified, ok := errors.AsClassified(err); !ok || classified.Bucket != tt.want {
+			t.Errorf("%s: err = %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGeminiThinkingBudget(t *testing.T) {
+	tests := map[string]int{
+		"":            -1,
+		EffortNone:    0,
+		EffortMinimal: 512,
+		EffortLow:     2048,
+		EffortMedium:  8192,
+		EffortHigh:    24576,
+	}
+	for effort, want := range tests {
+		if got := geminiThinkingBudget(effort); got != want {
+			t.Errorf("geminiThinkingBudget(%q) = %d, want %d", effort, got, want)
+		}
+	}
+
+	// A zero budget turns thinking off and asks for no thoughts.
+	server, p := newGeminiServer(t)
+	server.complete = `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`
+	if _, err := p.Complete(context.Background(), ChatRequest{Model: "gemini-2.5-flash", ReasoningEffort: EffortNone}); err != nil {
+		t.Fatal(err)
+	}
+	_, body := server.last()
+	config, _ := json.Marshal(body["generationConfig"])
+	if string(config) != `{"thinkingConfig":{"thinkingBudget":0}}` {
+		t.Errorf("generationConfig = %s", config)
+	}
+}
@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+
+	"zipcode/src/tools"
+)
+
+type BlockType string
+
+const (
+	BlockText       BlockType = "text"
+	BlockImage      BlockType = "image"
+	BlockDocument   BlockType = "document"
+	BlockToolUse    BlockType = "tool_use"
+	BlockToolResult BlockType = "tool_result"
+	BlockReasoning  BlockType = "reasoning"
+)
+
+// ContentBlock is one piece of a message. Exactly one of the fields that
+// go with its Type is set: Text for text, Media for images and documents,
+// ToolUse, ToolResult or Reasoning for the others.
+type ContentBlock struct {
+	Type       BlockType         `json:"type"`
+	Text       string            `json:"text,omitempty"`
+	Media      *Media            `json:"media,omitempty"`
+	ToolUse    *ToolUse          `json:"tool_use,omitempty"`
+	ToolResult *ToolResult       `json:"tool_result,omitempty"`
+	Reasoning  *ReasoningDetails `json:"reasoning,omitempty"`
+}
+
+// Media is an image or document carried as base64 Data of MediaType.
+type Media struct {
+	Name      string `json:"name,omitempty"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type ToolUse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Input is the JSON arguments as the model wrote them.
+	Input string `json:"input"`
+}
+
+// ToolResult answers the ToolUse with the same ID. Content holds text,
+// image and document blocks.
+type ToolResult struct {
+	ToolUseID string         `json:"tool_use_id"`
+	Content   []ContentBlock `json:"content"`
+	IsError   bool           `json:"is_error,omitempty"`
+}
+
+// Text returns the text blocks of the result.
+func (r ToolResult) Text() string {
+	return blocksText(r.Content)
+}
+
+// Message is one turn of a conversation, as ordered content blocks. Roles
+// are system, user, assistant and tool; tool messages hold the results of
+// the previous assistant turn's tool uses.
+type Message struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+	// Usage is the API-reported token usage for the call that produced this
+	// message. Only set on assistant messages returned by the provider; nil
+	// for user/tool/system messages.
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+func TextBlock(text string) ContentBlock {
+	return ContentBlock{Type: BlockText, Text: text}
+}
+
+// AttachmentBlock turns a file attachment into an image or document block.
+func AttachmentBlock(a tools.Attachment) ContentBlock {
+	block := ContentBlock{
+		Type:  BlockDocument,
+		Media: &Media{Name: a.Name, MediaType: a.MediaType, Data: a.Data},
+	}
+	if a.IsImage() {
+		block.Type = BlockImage
+	}
+	return block
+}
+
+// TextMessage returns a message holding a single text block.
+func TextMessage(role, text string) Message {
+	return Message{Role: role, Content: []ContentBlock{TextBlock(text)}}
+}
+
+// ToolResultMessage returns the tool message answering one tool use.
+func ToolResultMessage(toolUseID, content string, isError bool) Message {
+	return Message{
+		Role: "tool",
+		Content: []ContentBlock{{
+			Type: BlockToolResult,
+			ToolResult: &ToolResult{
+				ToolUseID: toolUseID,
+				Content:   []ContentBlock{TextBlock(content)},
+				IsError:   isError,
+			},
+		}},
+	}
+}
+
+// Text returns the text blocks of the message, concatenated.
+func (m Message) Text() string {
+	return blocksText(m.Content)
+}
+
+func (m Message) ToolUses() []ToolUse {
+	var uses []ToolUse
+	for _, b := range m.Content {
+		if b.Type == BlockToolUse && b.ToolUse != nil {
+			uses = append(uses, *b.ToolUse)
+		}
+	}
+	return uses
+}
+
+func (m Message) ToolResults() []ToolResult {
+	var results []ToolResult
+	for _, b := range m.Content {
+		if b.Type == BlockToolResult && b.ToolResult != nil {
+			results = append(results, *b.ToolResult)
+		}
+	}
+	return results
+}
+
+// Reasoning returns the reasoning blocks of the message.
+func (m Message) Reasoning() []ReasoningDetails {
+	var details []ReasoningDetails
+	for _, b := range m.Content {
+		if b.Type == BlockReasoning && b.Reasoning != nil {
+			details = append(details, *b.Reasoning)
+		}
+	}
+	return details
+}
+
+// ReasoningText returns the readable part of the message's reasoning.
+func (m Message) ReasoningText() string {
+	var parts []string
+	for _, d := range m.Reasoning() {
+		text := d.Text
+		if text == "" {
+			text = d.Summary
+		}
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func blocksText(blocks []ContentBlock) string {
+	var sb strings.Builder
+	for _, b := range blocks {
+		if b.Type == BlockText {
+			sb.WriteString(b.Text)
+		}
+	}
+	return sb.String()
+}
+
+// reasoningBlocks wraps reasoning details as blocks. A provider that only
+// returns readable reasoning gets it kept as a single text detail.
+func reasoningBlocks(reasoning string, details []ReasoningDetails) []ContentBlock {
+	if len(details) == 0 && reasoning != "" {
+		details = []ReasoningDetails{{Type: ReasoningText, Text: reasoning}}
+	}
+	blocks := make([]ContentBlock, 0, len(details))
+	for i := range details {
+		blocks = append(blocks, ContentBlock{Type: BlockReasoning, Reasoning: &details[i]})
+	}
+	return blocks
+}
+
+// legacyMessage is the shape messages were saved in before they were made
+// of content blocks, modelled on the OpenAI chat format.
+type legacyMessage struct {
+	Role             string             `json:"role"`
+	Content          string             `json:"content"`
+	ToolCalls        []ToolCall         `json:"tool_calls"`
+	ToolCallId       string             `json:"tool_call_id"`
+	Usage            *Usage             `json:"usage"`
+	Reasoning        string             `json:"reasoning"`
+	ReasoningDetails []ReasoningDetails `json:"reasoning_details"`
+	Parts            []struct {
+		Type      string `json:"type"`
+		Text      string `json:"text"`
+		Name      string `json:"name"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"parts"`
+}
+
+// UnmarshalJSON reads messages in either format, so sessions saved before
+// content blocks load unchanged; they are written back as blocks.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	content := strings.TrimSpace(string(probe.Content))
+	if strings.HasPrefix(content, "[") {
+		type plain Message
+		return json.Unmarshal(data, (*plain)(m))
+	}
+
+	var legacy legacyMessage
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	*m = legacy.upgrade()
+	return nil
+}
+
+func (l legacyMessage) upgrade() Message {
+	var body []ContentBlock
+	if l.Content != "" {
+		body = append(body, TextBlock(l.Content))
+	}
+	for _, p := range l.Parts {
+		if p.Type == string(BlockText) {
+			body = append(body, TextBlock(p.Text))
+			continue
+		}
+		body = append(body, ContentBlock{
+			Type:  BlockType(p.Type),
+			Media: &Media{Name: p.Name, MediaType: p.MediaType, Data: p.Data},
+		})
+	}
+
+	msg := Message{Role: l.Role, Usage: l.Usage}
+	if l.Role == "tool" {
+		msg.Content = []ContentBlock{{
+			Type: BlockToolResult,
+			ToolResult: &ToolResult{
+				ToolUseID: l.ToolCallId,
+				Content:   body,
+				IsError:   legacyToolError(l.Content),
+			},
+		}}
+		return msg
+	}
+
+	msg.Content = append(reasoningBlocks(l.Reasoning, l.ReasoningDetails), body...)
+	for _, tc := range l.ToolCalls {
+		msg.Content = append(msg.Content, ContentBlock{
+			Type: BlockToolUse,
+			ToolUse: &ToolUse{
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: tc.Function.Arguments,
+			},
+		})
+	}
+	return msg
+}
+
+// legacyToolError recognises the {"success":false,...} payload failed tool
+// calls used to be reported with, the only trace of the error they leave.
+func legacyToolError(content string) bool {
+	var payload struct {
+		Success *bool `json:"success"`
+	}
+	if err := json.Unmarshal([]byte(content), &payload); err != nil {
+		return false
+	}
+	return payload.Success != nil && !*payload.Success
+}
@@ -2,12 +2,12 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
-	"zipcode/src/config"
+	"strings"
 	"zipcode/src/llm/errors"
 	"zipcode/src/tools"
 )
@@ -17,6 +17,7 @@ type OpenAI struct {
 	Model      string
 	Tools      []tools.Tool
 	ApiKey     string
+	HTTPClient *http.Client
 }
 
 func (p OpenAI) Name() ProviderName {
@@ -27,8 +28,12 @@ func (p *OpenAI) SetApiKey(key string) {
 	p.ApiKey = key
 }
 
+func (p *OpenAI) SetHTTPClient(client *http.Client) {
+	p.HTTPClient = client
+}
+
 func (p *OpenAI) AuthCheck(key string) AuthResult {
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := authCheckClient(p.HTTPClient)
 	req, err := http.NewRequest(
 		http.MethodGet,
 		"https://api.openai.com/v1/models",
@@ -56,11 +61,43 @@ func (p *OpenAI) AuthCheck(key string) AuthResult {
 }
 
 type openAIRequest struct {
-	Model       string       `json:"model"`
-	Messages    []Message    `json:"messages"`
-	Tools       []tools.Tool `json:"tools,omitempty"`
-	MaxTokens   int          `json:"max_tokens,omitempty"`
-	Temperature float64      `json:"temperature,omitempty"`
+	Model         string               `json:"model"`
+	Messages      []chatMessage        `json:"messages"`
+	Tools         []tools.Tool         `json:"tools,omitempty"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Temperature   float64              `json:"temperature,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+	// MaxCompletionTokens replaces MaxTokens for reasoning models, which
+	// reject max_tokens.
+	MaxCompletionTokens int `json:"max_completion_tokens,omitempty"`
+	// ReasoningEffort is only accepted by reasoning models. Chat completions
+	// do not return the reasoning itself, only its token count.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+func (u openAIUsage) toUsage() Usage {
+	return Usage{
+		InputTokens:       u.PromptTokens,
+		CachedInputTokens: u.PromptTokensDetails.CachedTokens,
+		OutputTokens:      u.CompletionTokens,
+		ReasoningTokens:   u.CompletionTokensDetails.ReasoningTokens,
+	}
 }
 
 type openAIResponse struct {
@@ -74,56 +111,122 @@ type openAIResponse struct {
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens        int `json:"prompt_tokens"`
-		CompletionTokens    int `json:"completion_tokens"`
-		PromptTokensDetails struct {
-			CachedTokens int `json:"cached_tokens"`
-		} `json:"prompt_tokens_details"`
-	} `json:"usage"`
-}
-
-func (p OpenAI) Complete(request ChatRequest) (ChatResponse, error) {
-	body, err := json.Marshal(openAIRequest{
-		Model:       config.Cfg.CurrentModel,
-		Messages:    request.Messages,
-		Tools:       request.Tools,
-		MaxTokens:   request.MaxTokens,
-		Temperature: request.Temperature,
-	})
+	Usage openAIUsage `json:"usage"`
+}
+
+type openAIStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIEndpoint is where an OpenAI-style chat completions request is
+// sent and how it is authorized. OpenAI and the OpenAI-compatible servers
+// share everything else.
+type openAIEndpoint struct {
+	name      ProviderName
+	url       string
+	authorize func(req *http.Request)
+	detector  errors.QuotaDetector
+	client    *http.Client
+	// reasoningModel reports the models that take max_completion_tokens
+	// and no temperature. Nil means every model takes both.
+	reasoningModel func(model string) bool
+}
+
+func (p OpenAI) endpoint() openAIEndpoint {
+	return openAIEndpoint{
+		name: p.Name(),
+		url:  "https://api.openai.com/v1/chat/completions",
+		authorize: func(req *http.Request) {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.ApiKey))
+		},
+		detector:       p,
+		client:         p.HTTPClient,
+		reasoningModel: openAIReasoningModel,
+	}
+}
+
+func (p OpenAI) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	return p.endpoint().complete(ctx, request)
+}
+
+func (p OpenAI) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	return p.endpoint().completeStream(ctx, request, onDelta)
+}
+
+func (e openAIEndpoint) post(ctx context.Context, request ChatRequest) (*http.Response, error) {
+	payload := openAIRequest{
+		Model:           request.Model,
+		Messages:        toChatMessages(request.Messages, false),
+		Tools:           request.Tools,
+		MaxTokens:       request.MaxTokens,
+		Temperature:     request.Temperature,
+		ReasoningEffort: request.ReasoningEffort,
+	}
+	if e.reasoningModel != nil && e.reasoningModel(request.Model) {
+		payload.MaxCompletionTokens = payload.MaxTokens
+		payload.MaxTokens = 0
+		payload.Temperature = 0
+	}
+	if request.Stream {
+		payload.Stream = true
+		payload.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return ChatResponse{}, err
+		return nil, err
 	}
 
-	res, err := errors.RetryWithBackoff(p, func() (*http.Response, error) {
-		req, err := http.NewRequest(
+	res, err := errors.RetryWithBackoff(ctx, e.detector, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(
+			ctx,
 			http.MethodPost,
-			"https://api.openai.com/v1/chat/completions",
+			e.url,
 			bytes.NewReader(body),
 		)
 		if err != nil {
 			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.ApiKey))
-		return http.DefaultClient.Do(req)
+		e.authorize(req)
+		return httpClientOr(e.client).Do(req)
 	})
 	if err != nil {
-		return ChatResponse{}, err
+		return nil, errors.ClassifyTransportError(string(e.name), request.Model, err)
 	}
 
-	respBody, err := io.ReadAll(res.Body)
-	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return nil, errors.ClassifyReadError(string(e.name), request.Model, e.detector, res)
+	}
+
+	return res, nil
+}
+
+func (e openAIEndpoint) complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	request.Stream = false
+	res, err := e.post(ctx, request)
 	if err != nil {
 		return ChatResponse{}, err
 	}
 
-	if res.StatusCode >= 400 {
-		return ChatResponse{}, fmt.Errorf(
-			"openai: status %d: %s",
-			res.StatusCode,
-			string(respBody),
-		)
+	respBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(e.name), request.Model, err)
 	}
 
 	var parsed openAIResponse
@@ -132,25 +235,82 @@ func (p OpenAI) Complete(request ChatRequest) (ChatResponse, error) {
 	}
 
 	if len(parsed.Choices) == 0 {
-		return ChatResponse{}, fmt.Errorf("openai: no choices in response")
+		return ChatResponse{}, fmt.Errorf("%s: no choices in response", e.name)
 	}
 
 	return ChatResponse{
-		ID:    parsed.ID,
-		Model: parsed.Model,
-		Usage: Usage{
-			InputTokens:       parsed.Usage.PromptTokens,
-			CachedInputTokens: parsed.Usage.PromptTokensDetails.CachedTokens,
-			OutputTokens:      parsed.Usage.CompletionTokens,
-		},
-		Message: Message{
-			Role:      parsed.Choices[0].Message.Role,
-			Content:   parsed.Choices[0].Message.Content,
-			ToolCalls: parsed.Choices[0].Message.ToolCalls,
-		},
+		ID:         parsed.ID,
+		Model:      parsed.Model,
+		StopReason: parsed.Choices[0].FinishReason,
+		Usage:      parsed.Usage.toUsage(),
+		Message: fromChatMessage(
+			parsed.Choices[0].Message.Role,
+			parsed.Choices[0].Message.Content,
+			parsed.Choices[0].Message.ToolCalls,
+			"",
+			nil,
+		),
 	}, nil
 }
 
+func (e openAIEndpoint) completeStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	request.Stream = true
+	res, err := e.post(ctx, request)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer res.Body.Close()
+
+	var response ChatResponse
+	var content strings.Builder
+	calls := newToolCallAccumulator()
+
+	err = readSSE(res.Body, func(_ string, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("%s: invalid stream chunk: %w", e.name, err)
+		}
+
+		if chunk.ID != "" {
+			response.ID = chunk.ID
+		}
+		if chunk.Model != "" {
+			response.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			response.Usage = chunk.Usage.toUsage()
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				response.StopReason = choice.FinishReason
+			}
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(StreamDelta{Type: DeltaText, Text: choice.Delta.Content})
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				onDelta(StreamDelta{Type: DeltaToolCall, ToolCall: calls.add(tc)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(e.name), request.Model, err)
+	}
+
+	response.Message = fromChatMessage("assistant", content.String(), calls.result(), "", nil)
+	return response, nil
+}
+
 func (p OpenAI) Models() []ModelDescriptor {
 	entries := []struct {
 		id            string
@@ -175,11 +335,68 @@ func (p OpenAI) Models() []ModelDescriptor {
 			ContextWindow:        e.contextWindow,
 			InputCostPerMillion:  e.inputCost,
 			OutputCostPerMillion: e.outputCost,
+			Vision:               true,
 		}
 	}
 	return descriptors
 }
 
+// FetchModels lists the chat models of the key's organization. The list
+// has ids only.
+func (p OpenAI) FetchModels(ctx context.Context) ([]ModelDescriptor, error) {
+	if p.ApiKey == "" {
+		return nil, errNoAPIKey
+	}
+	var list openAIModelList
+	err := getModelList(ctx, p.HTTPClient, "https://api.openai.com/v1/models", func(req *http.Request) {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.ApiKey))
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []ModelDescriptor
+	for _, m := range list.Data {
+		if !openAIChatModel(m.ID) {
+			continue
+		}
+		models = append(models, ModelDescriptor{
+			ID:           m.ID,
+			DisplayName:  m.ID,
+			ProviderName: string(OpenAIProvider),
+			Vision:       true,
+		})
+	}
+	return models, nil
+}
+
+// openAIChatModel tells the chat models in OpenAI's list from the audio,
+// image, embedding and moderation ones listed with them.
+func openAIChatModel(id string) bool {
+	if !strings.HasPrefix(id, "gpt-") && !strings.HasPrefix(id, "o1") &&
+		!strings.HasPrefix(id, "o3") && !strings.HasPrefix(id, "o4") &&
+		!strings.HasPrefix(id, "codex") {
+		return false
+	}
+	for _, kind := range []string{"audio", "realtime", "tts", "transcribe", "image", "search", "embedding", "instruct"} {
+		if strings.Contains(id, kind) {
+			return false
+		}
+	}
+	return true
+}
+
+// openAIReasoningModel tells the reasoning models, the gpt-5, o-series
+// and codex families, which all of Models are.
+func openAIReasoningModel(id string) bool {
+	for _, prefix := range []string{"gpt-5", "o1", "o3", "o4", "codex"} {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p OpenAI) IsQuotaError(resp *http.Response, body []byte) bool {
 	if resp.StatusCode != http.StatusTooManyRequests &&
 		resp.StatusCode != http.StatusPaymentRequired {
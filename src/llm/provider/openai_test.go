@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOpenAIRequestTokenLimits(t *testing.T) {
+	tests := []struct {
+		model     string
+		reasoning bool
+	}{
+		{"gpt-5.2", true},
+		{"gpt-5.3-codex", true},
+		{"gpt-5-nano", true},
+		{"o4-mini", true},
+		{"codex-mini-latest", true},
+		{"gpt-4.1", false},
+		{"gpt-4o-mini", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			server := newCompatibleServer(t)
+			endpoint := OpenAI{ApiKey: "secret"}.endpoint()
+			endpoint.url = server.URL + "/v1/chat/completions"
+
+			_, err := endpoint.complete(context.Background(), ChatRequest{
+				Model:       tt.model,
+				Messages:    []Message{TextMessage("user", "Say hello")},
+				MaxTokens:   4096,
+				Temperature: 0.2,
+			})
+			if err != nil {
+				t.Fatalf("complete: %v", err)
+			}
+
+			_, body := server.last()
+			if tt.reasoning {
+				if body["max_completion_tokens"] != 4096.0 {
+					t.Errorf("max_completion_tokens = %v, want 4096", body["max_completion_tokens"])
+				}
+				for _, field := range []string{"max_tokens", "temperature"} {
+					if _, ok := body[field]; ok {
+						t.Errorf("reasoning model request has %s: %v", field, body)
+					}
+				}
+			} else {
+				if body["max_tokens"] != 4096.0 || body["temperature"] != 0.2 {
+					t.Errorf("request = %v, want max_tokens and temperature", body)
+				}
+				if _, ok := body["max_completion_tokens"]; ok {
+					t.Errorf("request has max_completion_tokens: %v", body)
+				}
+			}
+		})
+	}
+
+	// Every built-in OpenAI model is a reasoning model.
+	for _, m := range (OpenAI{}).Models() {
+		if !openAIReasoningModel(m.ID) {
+			t.Errorf("%s is not treated as a reasoning model", m.ID)
+		}
+	}
+}
+
+func TestCompatibleKeepsMaxTokens(t *testing.T) {
+	server := newCompatibleServer(t)
+	p := NewOpenAICompatible(CompatibleConfig{Name: "Local", BaseURL: server.URL + "/v1", AuthHeader: NoAuth})
+
+	// A compatible server hosting a model named like OpenAI's still gets
+	// the plain fields.
+	if _, err := p.Complete(context.Background(), ChatRequest{Model: "gpt-5.2", MaxTokens: 512, Temperature: 0.5}); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	_, body := server.last()
+	if body["max_tokens"] != 512.0 || body["temperature"] != 0.5 {
+		t.Errorf("request = %v, want max_tokens and temperature", body)
+	}
+}
@@ -2,13 +2,15 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+	"slices"
+	"strconv"
+	"strings"
 
-	"zipcode/src/config"
 	"zipcode/src/llm/errors"
 	"zipcode/src/tools"
 	"zipcode/src/utils"
@@ -21,6 +23,7 @@ type OpenRouterProvider struct {
 	Model      string
 	Tools      []tools.Tool
 	ApiKey     string
+	HTTPClient *http.Client
 }
 
 func NewOpenRouterProvider() *OpenRouterProvider {
@@ -29,7 +32,7 @@ func NewOpenRouterProvider() *OpenRouterProvider {
 
 type OpenRouterRequest struct {
 	Model               string                 `json:"model,omitempty"`
-	Messages            []Message              `json:"messages"`
+	Messages            []chatMessage          `json:"messages"`
 	Provider            *ProviderConfig        `json:"provider,omitempty"`
 	Temperature         float64                `json:"temperature,omitempty"`
 	TopP                *float64               `json:"top_p,omitempty"`
@@ -46,12 +49,13 @@ type OpenRouterRequest struct {
 	ToolChoice          interface{}            `json:"tool_choice,omitempty"`
 	Tools               []tools.Tool           `json:"tools,omitempty"`
 	Extra               map[string]interface{} `json:"extra,omitempty"` // forward compatibility
+	Reasoning           *ReasoningConfig       `json:"reasoning,omitempty"`
 }
 
-type ContentPart struct {
-	Type     string        `json:"type"` // text | image_url | input_text | input_image
-	Text     string        `json:"text,omitempty"`
-	ImageURL *ImageURLPart `json:"image_url,omitempty"`
+// ReasoningConfig is OpenRouter's unified reasoning setting, translated
+// upstream into each model's own (thinking budgets, reasoning_effort).
+type ReasoningConfig struct {
+	Effort string `json:"effort,omitempty"`
 }
 
 type ProviderConfig struct {
@@ -75,11 +79,6 @@ type ToolFunction struct {
 	Parameters  map[string]interface{} `json:"parameters,omitempty"` // JSON Schema
 }
 
-type ImageURLPart struct {
-	URL    string `json:"url"`
-	Detail string `json:"detail,omitempty"` // auto | low | high
-}
-
 type OpenRouterResponse struct {
 	ID                string    `json:"id"`
 	Provider          string    `json:"provider"`
@@ -94,32 +93,29 @@ type OpenRouterResponse struct {
 		PromptTokensDetails struct {
 			CachedTokens int `json:"cached_tokens"`
 		} `json:"prompt_tokens_details"`
+		CompletionTokensDetails CompletionTokensDetails `json:"completion_tokens_details"`
 	} `json:"usage"`
 }
-type ReasoningDetails struct {
-	Format any    `json:"format"`
-	Index  int    `json:"index"`
-	Type   string `json:"type"`
-	Text   string `json:"text"`
-}
-
 type Choices struct {
 	FinishReason       string `json:"finish_reason"`
 	NativeFinishReason string `json:"native_finish_reason"`
 	Index              int    `json:"index"`
 	Message            struct {
-		Content   string     `json:"content"`
-		Role      string     `json:"role"`
-		ToolCalls []ToolCall `json:"tool_calls"`
+		Content          string             `json:"content"`
+		Role             string             `json:"role"`
+		ToolCalls        []ToolCall         `json:"tool_calls"`
+		Reasoning        string             `json:"reasoning"`
+		ReasoningDetails []ReasoningDetails `json:"reasoning_details"`
 	} `json:"message"`
 	Delta MessageDelta `json:"delta"`
 }
 
 type MessageDelta struct {
-	Content   string     `json:"content"`
-	Role      string     `json:"role"`
-	Reasoning string     `json:"reasoning"`
-	ToolCalls []ToolCall `json:"tool_calls"`
+	Content          string             `json:"content"`
+	Role             string             `json:"role"`
+	Reasoning        string             `json:"reasoning"`
+	ReasoningDetails []ReasoningDetails `json:"reasoning_details"`
+	ToolCalls        []ToolCall         `json:"tool_calls"`
 }
 
 type PromptTokensDetails struct {
@@ -145,7 +141,7 @@ func (p *OpenRouterProvider) SetModel(model string, nitro bool) {
 }
 
 func (p *OpenRouterProvider) AuthCheck(key string) AuthResult {
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := authCheckClient(p.HTTPClient)
 	req, err := http.NewRequest(
 		http.MethodGet,
 		"https://openrouter.ai/api/v1/key",
@@ -187,25 +183,26 @@ func (p OpenRouterProvider) Models() []ModelDescriptor {
 		contextWindow int
 		inputCost     float64
 		outputCost    float64
+		vision        bool
 	}{
-		{"openai/gpt-5.2", 400_000, 1.75, 14.00},
-		{"openai/gpt-5.5", 1_000_000, 5.00, 30.00},
-		{"minimax/minimax-m2.5", 196_608, 0.15, 1.15},
-		{"minimax/minimax-m2.7", 196_608, 0.279, 1.20},
-		{"anthropic/claude-sonnet-4.6", 1_000_000, 3.00, 15.00},
-		{"anthropic/claude-haiku-4.5", 200_000, 1.00, 5.00},
-		{"openai/gpt-5.1-codex-mini", 400_000, 0.25, 2.00},
-		{"moonshotai/kimi-k2.5", 262_144, 0.40, 1.90},
-		{"meta-llama/llama-3.3-70b-instruct", 128_000, 0.10, 0.32},
-		{"z-ai/glm-4.7", 200_000, 0.40, 1.75},
-		{"qwen/qwen3-coder-flash", 1_000_000, 0.195, 0.975},
-		{"openai/gpt-5-nano", 400_000, 0.05, 0.40},
-		{"z-ai/glm-5", 200_000, 0.60, 1.92},
-		{"openai/gpt-5.4-nano", 400_000, 0.20, 1.25},
-		{"deepseek/deepseek-v3.2", 200_000, 0.252, 0.378},
-		{"openai/gpt-5.4", 272_000, 2.50, 15.00},
-		{"openai/gpt-5.3-codex", 400_000, 1.75, 14.00},
-		{"z-ai/glm-5v-turbo", 202_752, 1.20, 4.00},
+		{"openai/gpt-5.2", 400_000, 1.75, 14.00, true},
+		{"openai/gpt-5.5", 1_000_000, 5.00, 30.00, true},
+		{"minimax/minimax-m2.5", 196_608, 0.15, 1.15, false},
+		{"minimax/minimax-m2.7", 196_608, 0.279, 1.20, false},
+		{"anthropic/claude-sonnet-4.6", 1_000_000, 3.00, 15.00, true},
+		{"anthropic/claude-haiku-4.5", 200_000, 1.00, 5.00, true},
+		{"openai/gpt-5.1-codex-mini", 400_000, 0.25, 2.00, true},
+		{"moonshotai/kimi-k2.5", 262_144, 0.40, 1.90, true},
+		{"meta-llama/llama-3.3-70b-instruct", 128_000, 0.10, 0.32, false},
+		{"z-ai/glm-4.7", 200_000, 0.40, 1.75, false},
+		{"qwen/qwen3-coder-flash", 1_000_000, 0.195, 0.975, false},
+		{"openai/gpt-5-nano", 400_000, 0.05, 0.40, true},
+		{"z-ai/glm-5", 200_000, 0.60, 1.92, false},
+		{"openai/gpt-5.4-nano", 400_000, 0.20, 1.25, true},
+		{"deepseek/deepseek-v3.2", 200_000, 0.252, 0.378, false},
+		{"openai/gpt-5.4", 272_000, 2.50, 15.00, true},
+		{"openai/gpt-5.3-codex", 400_000, 1.75, 14.00, true},
+		{"z-ai/glm-5v-turbo", 202_752, 1.20, 4.00, true},
 	}
 	descriptors := make([]ModelDescriptor, len(entries))
 	for i, e := range entries {
@@ -216,11 +213,71 @@ func (p OpenRouterProvider) Models() []ModelDescriptor {
 			ContextWindow:        e.contextWindow,
 			InputCostPerMillion:  e.inputCost,
 			OutputCostPerMillion: e.outputCost,
+			Vision:               e.vision,
 		}
 	}
 	return descriptors
 }
 
+// FetchModels lists OpenRouter's models that can call tools, with their
+// context lengths and prices. The list is public; the key is sent when
+// there is one.
+func (p OpenRouterProvider) FetchModels(ctx context.Context) ([]ModelDescriptor, error) {
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+			Pricing       struct {
+				Prompt          string `json:"prompt"`
+				Completion      string `json:"completion"`
+				InputCacheRead  string `json:"input_cache_read"`
+				InputCacheWrite string `json:"input_cache_write"`
+			} `json:"pricing"`
+			Architecture struct {
+				InputModalities []string `json:"input_modalities"`
+			} `json:"architecture"`
+			SupportedParameters []string `json:"supported_parameters"`
+		} `json:"data"`
+	}
+	err := getModelList(ctx, p.HTTPClient, "https://openrouter.ai/api/v1/models", func(req *http.Request) {
+		if p.ApiKey != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.ApiKey))
+		}
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []ModelDescriptor
+	for _, m := range list.Data {
+		if !slices.Contains(m.SupportedParameters, "tools") {
+			continue
+		}
+		models = append(models, ModelDescriptor{
+			ID:                       m.ID,
+			DisplayName:              m.ID,
+			ProviderName:             string(OpenRouterAPIProvider),
+			ContextWindow:            m.ContextLength,
+			InputCostPerMillion:      perMillion(m.Pricing.Prompt),
+			OutputCostPerMillion:     perMillion(m.Pricing.Completion),
+			CacheReadCostPerMillion:  perMillion(m.Pricing.InputCacheRead),
+			CacheWriteCostPerMillion: perMillion(m.Pricing.InputCacheWrite),
+			Vision:                   slices.Contains(m.Architecture.InputModalities, "image"),
+		})
+	}
+	return models, nil
+}
+
+// perMillion converts OpenRouter's USD per token, a decimal string, to USD
+// per 1M tokens. Variable prices, given as negative, count as unknown.
+func perMillion(perToken string) float64 {
+	price, err := strconv.ParseFloat(perToken, 64)
+	if err != nil || price < 0 {
+		return 0
+	}
+	return price * 1_000_000
+}
+
 func (p OpenRouterProvider) Name() ProviderName {
 	return OpenRouterAPIProvider
 }
@@ -229,49 +286,77 @@ func (p *OpenRouterProvider) SetApiKey(key string) {
 	p.ApiKey = key
 }
 
+func (p *OpenRouterProvider) SetHTTPClient(client *http.Client) {
+	p.HTTPClient = client
+}
+
+func (p *OpenRouterProvider) post(ctx context.Context, request ChatRequest) (*http.Response, error) {
+	requestBody := OpenRouterRequest{
+		Model:               request.Model,
+		Messages:            toChatMessages(request.Messages, true),
+		Stream:              request.Stream,
+		Tools:               request.Tools,
+		Temperature:         request.Temperature,
+		MaxTokens:           8192,
+		MaxCompletionTokens: 2048,
+	}
+	if request.MaxTokens > 0 {
+		requestBody.MaxTokens = request.MaxTokens
+		requestBody.MaxCompletionTokens = request.MaxTokens
+	}
+	if request.ReasoningEffort != "" {
+		requestBody.Reasoning = &ReasoningConfig{Effort: request.ReasoningEffort}
+	}
+
+	value, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// debug code
+	utils.LogValue(request.Messages[len(request.Messages)-1])
+	// debug code
+
+	res, err := errors.RetryWithBackoff(ctx, p, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			"https://openrouter.ai/api/v1/chat/completions",
+			bytes.NewReader(value),
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(
+			"Authorization",
+			fmt.Sprintf("Bearer %s", p.ApiKey),
+		)
+		return httpClientOr(p.HTTPClient).Do(req)
+	})
+	if err != nil {
+		return nil, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, errors.ClassifyReadError(string(p.Name()), request.Model, p, res)
+	}
+
+	return res, nil
+}
+
 func (p *OpenRouterProvider) Complete(
+	ctx context.Context,
 	request ChatRequest,
 ) (ChatResponse, error) {
 	godotenv.Load()
 
 	retry := true
 	var finalResponse OpenRouterResponse
+	request.Stream = false
 
 	for retry {
-		requestBody := OpenRouterRequest{
-			Model:               config.Cfg.CurrentModel,
-			Messages:            request.Messages,
-			Stream:              false,
-			Tools:               request.Tools,
-			MaxTokens:           8192,
-			MaxCompletionTokens: 2048,
-		}
-
-		value, err := json.Marshal(requestBody)
-		if err != nil {
-			return ChatResponse{}, err
-		}
-
-		// debug code
-		utils.LogValue(request.Messages[len(request.Messages)-1])
-		// debug code
-
-		res, err := errors.RetryWithBackoff(p, func() (*http.Response, error) {
-			req, err := http.NewRequest(
-				http.MethodPost,
-				"https://openrouter.ai/api/v1/chat/completions",
-				bytes.NewReader(value),
-			)
-			if err != nil {
-				return nil, err
-			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set(
-				"Authorization",
-				fmt.Sprintf("Bearer %s", p.ApiKey),
-			)
-			return http.DefaultClient.Do(req)
-		})
+		res, err := p.post(ctx, request)
 		if err != nil {
 			return ChatResponse{}, err
 		}
@@ -279,17 +364,28 @@ func (p *OpenRouterProvider) Complete(
 		body, err := io.ReadAll(res.Body)
 		res.Body.Close()
 		if err != nil {
-			return ChatResponse{}, err
+			return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
 		}
 
 		if err := json.Unmarshal(body, &finalResponse); err != nil {
 			return ChatResponse{}, err
 		}
 
+		// Failures of the upstream provider can come back with status 200.
+		var upstreamErr openRouterStreamError
+		if json.Unmarshal(body, &upstreamErr) == nil && upstreamErr.Error != nil {
+			return ChatResponse{}, errors.ClassifyStreamError(
+				string(p.Name()),
+				request.Model,
+				fmt.Sprint(upstreamErr.Error.Code),
+				upstreamErr.Error.Message,
+			)
+		}
+
 		if len(finalResponse.Choices) > 0 {
 			retry = false
 		} else {
-			fmt.Println("retrying", string(body))
+			utils.Log("retrying", string(body))
 		}
 	}
 
@@ -299,9 +395,114 @@ func (p *OpenRouterProvider) Complete(
 	chatResponse.Usage.InputTokens = finalResponse.Usage.PromptTokens
 	chatResponse.Usage.CachedInputTokens = finalResponse.Usage.PromptTokensDetails.CachedTokens
 	chatResponse.Usage.OutputTokens = finalResponse.Usage.CompletionTokens
-	chatResponse.Message.Role = finalResponse.Choices[0].Message.Role
-	chatResponse.Message.Content = finalResponse.Choices[0].Message.Content
-	chatResponse.Message.ToolCalls = finalResponse.Choices[0].Message.ToolCalls
+	chatResponse.Usage.ReasoningTokens = finalResponse.Usage.CompletionTokensDetails.ReasoningTokens
+	choice := finalResponse.Choices[0].Message
+	chatResponse.Message = fromChatMessage(
+		choice.Role,
+		choice.Content,
+		choice.ToolCalls,
+		choice.Reasoning,
+		choice.ReasoningDetails,
+	)
 
 	return chatResponse, nil
 }
+
+// openRouterStreamError is the shape OpenRouter uses when a request fails
+// after the stream has already started, or upstream after OpenRouter
+// accepted it (HTTP status is 200 by then).
+type openRouterStreamError struct {
+	Error *struct {
+		Code    any    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// CompleteStream reads OpenRouter's OpenAI-compatible SSE stream. Each chunk
+// decodes into OpenRouterResponse with Choices[].Delta populated; the final
+// chunk carries usage.
+func (p *OpenRouterProvider) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	request.Stream = true
+	res, err := p.post(ctx, request)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer res.Body.Close()
+
+	var chatResponse ChatResponse
+	var content strings.Builder
+	var reasoning strings.Builder
+	calls := newToolCallAccumulator()
+	details := newReasoningAccumulator()
+
+	err = readSSE(res.Body, func(_ string, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var streamErr openRouterStreamError
+		if json.Unmarshal([]byte(data), &streamErr) == nil && streamErr.Error != nil {
+			return errors.ClassifyStreamError(
+				string(p.Name()),
+				request.Model,
+				fmt.Sprint(streamErr.Error.Code),
+				streamErr.Error.Message,
+			)
+		}
+
+		var chunk OpenRouterResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("openrouter: invalid stream chunk: %w", err)
+		}
+
+		if chunk.ID != "" {
+			chatResponse.ID = chunk.ID
+		}
+		if chunk.Model != "" {
+			chatResponse.Model = chunk.Model
+		}
+		if chunk.Usage.PromptTokens > 0 || chunk.Usage.CompletionTokens > 0 {
+			chatResponse.Usage.InputTokens = chunk.Usage.PromptTokens
+			chatResponse.Usage.CachedInputTokens = chunk.Usage.PromptTokensDetails.CachedTokens
+			chatResponse.Usage.OutputTokens = chunk.Usage.CompletionTokens
+			chatResponse.Usage.ReasoningTokens = chunk.Usage.CompletionTokensDetails.ReasoningTokens
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				chatResponse.StopReason = choice.FinishReason
+			}
+			if choice.Delta.Reasoning != "" {
+				reasoning.WriteString(choice.Delta.Reasoning)
+				onDelta(StreamDelta{Type: DeltaReasoning, Text: choice.Delta.Reasoning})
+			}
+			for _, d := range choice.Delta.ReasoningDetails {
+				details.add(d)
+			}
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(StreamDelta{Type: DeltaText, Text: choice.Delta.Content})
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				onDelta(StreamDelta{Type: DeltaToolCall, ToolCall: calls.add(tc)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ChatResponse{}, errors.ClassifyTransportError(string(p.Name()), request.Model, err)
+	}
+
+	chatResponse.Message = fromChatMessage(
+		"assistant",
+		content.String(),
+		calls.result(),
+		reasoning.String(),
+		details.result(),
+	)
+	return chatResponse, nil
+}
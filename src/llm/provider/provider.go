@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
@@ -13,10 +14,11 @@ const (
 	OpenAIProvider        ProviderName = "OpenAI"
 	AnthropicProvider     ProviderName = "Anthropic"
 	OpenRouterAPIProvider ProviderName = "OpenRouter"
+	GeminiProvider        ProviderName = "Gemini"
 )
 
 func GetSupportedProviders() []ProviderName {
-	return []ProviderName{OpenAIProvider, OpenRouterAPIProvider, AnthropicProvider}
+	return []ProviderName{OpenAIProvider, OpenRouterAPIProvider, AnthropicProvider, GeminiProvider}
 }
 
 func GetProviderEnvVar(provider ProviderName) (string, error) {
@@ -32,6 +34,10 @@ func GetProviderEnvVar(provider ProviderName) (string, error) {
 		return "ANTHROPIC_API_KEY", nil
 	}
 
+	if provider == GeminiProvider {
+		return "GEMINI_API_KEY", nil
+	}
+
 	return "", errors.New("unsupported provider")
 }
 
@@ -43,6 +49,8 @@ func GetProviderName(provider string) (ProviderName, error) {
 		return AnthropicProvider, nil
 	case "openrouter":
 		return OpenRouterAPIProvider, nil
+	case "gemini":
+		return GeminiProvider, nil
 	}
 
 	return "", errors.New("unsupported provider")
@@ -54,36 +62,15 @@ type ModelDescriptor struct {
 	ProviderName          string
 	ContextWindow         int
 	Effort                string
+	// Vision is set for models that accept image and document input.
+	Vision bool
 	// USD per 1M tokens.
 	InputCostPerMillion  float64
 	OutputCostPerMillion float64
-}
-
-type BlockType string
-
-const (
-	BlockText       BlockType = "text"
-	BlockToolUse    BlockType = "tool_use"
-	BlockToolResult BlockType = "tool_result"
-)
-
-type ToolUse struct {
-	ID    string
-	Name  string
-	Input map[string]any
-}
-
-type ToolResult struct {
-	ToolUseID string
-	Content   string
-	IsError   bool
-}
-
-type ContentBlock struct {
-	Type       BlockType
-	Text       string
-	ToolUse    *ToolUse
-	ToolResult *ToolResult
+	// Prompt cache rates, USD per 1M tokens. Zero means reads cost a
+	// tenth of the input rate and writes the input rate.
+	CacheReadCostPerMillion  float64
+	CacheWriteCostPerMillion float64
 }
 
 type ToolCall struct {
@@ -98,17 +85,6 @@ type ToolCallFunction struct {
 	Arguments string `json:"arguments"`
 }
 
-type Message struct {
-	Role       string     `json:"role"`
-	Content    string     `json:"content"`
-	ToolCalls  []ToolCall `json:"tool_calls"`
-	ToolCallId string     `json:"tool_call_id,omitempty"`
-	// Usage is the API-reported token usage for the call that produced this
-	// message. Only set on assistant messages returned by the provider; nil
-	// for user/tool/system messages.
-	Usage *Usage `json:"usage,omitempty"`
-}
-
 type ContextUsage struct {
 	InputTokens  int
 	OutputTokens int
@@ -121,12 +97,22 @@ type ChatRequest struct {
 	MaxTokens   int
 	Temperature float64
 	Stream      bool
+	// ReasoningEffort is one of the Effort constants; empty leaves the
+	// provider's default.
+	ReasoningEffort string
 }
 
+// Usage counts a call's tokens. InputTokens is the whole input;
+// CachedInputTokens is the part read from the prompt cache and
+// CacheCreationInputTokens the part written to it, both included in
+// InputTokens. ReasoningTokens is the part of OutputTokens spent thinking,
+// when the provider reports it.
 type Usage struct {
-	InputTokens       int `json:"input_tokens"`
-	CachedInputTokens int `json:"cached_input_tokens"`
-	OutputTokens      int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	CachedInputTokens        int `json:"cached_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	ReasoningTokens          int `json:"reasoning_tokens"`
 }
 
 type ChatResponse struct {
@@ -152,7 +138,43 @@ type Provider interface {
 	Name() ProviderName
 	AuthCheck(key string) AuthResult
 	SetApiKey(key string)
-	Complete(req ChatRequest) (ChatResponse, error)
+	Complete(ctx context.Context, req ChatRequest) (ChatResponse, error)
 	Models() []ModelDescriptor // static list of models this provider serves
 	IsQuotaError(resp *http.Response, body []byte) bool
 }
+
+// KeyOptional reports whether provider can be used without an API key.
+func KeyOptional(provider Provider) bool {
+	optional, ok := provider.(interface{ KeyOptional() bool })
+	return ok && optional.KeyOptional()
+}
+
+type StreamDeltaType int
+
+const (
+	DeltaText StreamDeltaType = iota
+	DeltaToolCall
+	DeltaReasoning
+)
+
+// StreamDelta is one incremental update from a streaming completion. Text
+// and reasoning deltas carry only the newly generated fragment; tool call
+// deltas carry the call as assembled so far, so Arguments may still be
+// incomplete JSON.
+type StreamDelta struct {
+	Type     StreamDeltaType
+	Text     string
+	ToolCall ToolCall
+}
+
+type StreamHandler func(StreamDelta)
+
+// StreamingProvider is implemented by providers that can deliver a
+// completion incrementally. CompleteStream invokes onDelta as fragments
+// arrive and returns the fully assembled response once the stream ends, so
+// callers can treat the result exactly like the output of Complete.
+// Cancelling ctx aborts the underlying HTTP request mid-stream.
+type StreamingProvider interface {
+	Provider
+	CompleteStream(ctx context.Context, req ChatRequest, onDelta StreamHandler) (ChatResponse, error)
+}
@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reasoning effort levels. Each provider maps them to its own setting:
+// Anthropic thinking budgets, OpenAI reasoning_effort and OpenRouter
+// reasoning.effort. EffortNone turns reasoning off for models that reason
+// by default; an empty effort leaves the provider default.
+const (
+	EffortNone    = "none"
+	EffortMinimal = "minimal"
+	EffortLow     = "low"
+	EffortMedium  = "medium"
+	EffortHigh    = "high"
+)
+
+// ParseEffort validates a configured effort, accepting "off" for "none".
+func ParseEffort(value string) (string, error) {
+	effort := strings.ToLower(strings.TrimSpace(value))
+	switch effort {
+	case "", EffortNone, EffortMinimal, EffortLow, EffortMedium, EffortHigh:
+		return effort, nil
+	case "off":
+		return EffortNone, nil
+	}
+	return "", fmt.Errorf(
+		"invalid reasoning effort %q: expected none, minimal, low, medium or high",
+		value,
+	)
+}
+
+// Types of ReasoningDetails, as OpenRouter names them.
+const (
+	ReasoningText      = "reasoning.text"
+	ReasoningSummary   = "reasoning.summary"
+	ReasoningEncrypted = "reasoning.encrypted"
+)
+
+// anthropicReasoningFormat marks details that came from Anthropic thinking
+// blocks. Only those can be sent back to Anthropic: the signature does not
+// verify for reasoning produced by any other model.
+const anthropicReasoningFormat = "anthropic-claude-v1"
+
+// ReasoningDetails is one piece of a model's reasoning in the form it has to
+// be sent back: Anthropic rejects a tool-use turn whose thinking blocks,
+// signatures included, are missing, and OpenRouter forwards the same
+// details to the upstream model.
+type ReasoningDetails struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Index     int    `json:"index"`
+}
+
+// reasoningAccumulator merges streamed reasoning detail fragments, which
+// arrive keyed by index like tool call deltas.
+type reasoningAccumulator struct {
+	details []ReasoningDetails
+	byIndex map[int]int
+}
+
+func newReasoningAccumulator() *reasoningAccumulator {
+	return &reasoningAccumulator{byIndex: map[int]int{}}
+}
+
+func (a *reasoningAccumulator) add(delta ReasoningDetails) {
+	pos, ok := a.byIndex[delta.Index]
+	if !ok {
+		a.details = append(a.details, delta)
+		a.byIndex[delta.Index] = len(a.details) - 1
+		return
+	}
+
+	d := &a.details[pos]
+	d.Text += delta.Text
+	d.Summary += delta.Summary
+	d.Data += delta.Data
+	d.Signature += delta.Signature
+	if d.Type == "" {
+		d.Type = delta.Type
+	}
+	if d.ID == "" {
+		d.ID = delta.ID
+	}
+	if d.Format == "" {
+		d.Format = delta.Format
+	}
+}
+
+func (a *reasoningAccumulator) result() []ReasoningDetails {
+	return a.details
+}
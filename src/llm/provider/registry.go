@@ -1,7 +1,21 @@
 package llm
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"zipcode/src/llm/tokenizer"
+	"zipcode/src/tools"
+)
+
 type Registry struct {
 	Providers map[ProviderName]Provider
+	// Catalog, when set, adds the model lists fetched from providers to
+	// their built-in tables.
+	Catalog *ModelCatalog
+	// custom lists the providers added with Register, in order.
+	custom []ProviderName
 }
 
 func NewRegistry() Registry {
@@ -9,6 +23,7 @@ func NewRegistry() Registry {
 		OpenAIProvider:        &OpenAI{},
 		OpenRouterAPIProvider: &OpenRouterProvider{},
 		AnthropicProvider:     &Anthropic{},
+		GeminiProvider:        &Gemini{},
 	}
 
 	return Registry{
@@ -20,8 +35,48 @@ func (r Registry) GetProvider(name ProviderName) Provider {
 	return r.Providers[name]
 }
 
+// Register adds a provider after the built-in ones. A provider cannot
+// take a name already registered.
+func (r *Registry) Register(provider Provider) error {
+	name := provider.Name()
+	for existing := range r.Providers {
+		if strings.EqualFold(string(existing), string(name)) {
+			return fmt.Errorf("provider %q is already registered", name)
+		}
+	}
+	r.Providers[name] = provider
+	r.custom = append(r.custom, name)
+	return nil
+}
+
 func (r Registry) ProviderList() []ProviderName {
-	return []ProviderName{OpenAIProvider, OpenRouterAPIProvider, AnthropicProvider}
+	providers := []ProviderName{OpenAIProvider, OpenRouterAPIProvider, AnthropicProvider, GeminiProvider}
+	return append(providers, r.custom...)
+}
+
+// Models returns the models a provider serves: its fetched catalog merged
+// with its built-in table, or the table alone when nothing was fetched.
+func (r Registry) Models(name ProviderName) []ModelDescriptor {
+	provider := r.GetProvider(name)
+	if provider == nil {
+		return nil
+	}
+	if r.Catalog == nil {
+		return provider.Models()
+	}
+	configured, ok := provider.(ConfiguredModelsProvider)
+	return r.Catalog.Models(name, provider.Models(), ok && configured.ModelsConfigured())
+}
+
+// Lookup finds a registered provider by name, ignoring case so "openrouter"
+// finds OpenRouter.
+func (r Registry) Lookup(name string) (ProviderName, Provider) {
+	for _, candidate := range r.ProviderList() {
+		if strings.EqualFold(string(candidate), name) {
+			return candidate, r.GetProvider(candidate)
+		}
+	}
+	return "", nil
 }
 
 func (r Registry) ContextWindowFor(providerName ProviderName, modelID string) int {
@@ -29,7 +84,7 @@ func (r Registry) ContextWindowFor(providerName ProviderName, modelID string) in
 	if provider == nil {
 		return 0
 	}
-	for _, m := range provider.Models() {
+	for _, m := range r.Models(providerName) {
 		if m.ID == modelID {
 			return m.ContextWindow
 		}
@@ -37,6 +92,37 @@ func (r Registry) ContextWindowFor(providerName ProviderName, modelID string) in
 	return 0
 }
 
+// EffortFor returns the default reasoning effort of the given provider+model,
+// or "" when the model does not set one.
+func (r Registry) EffortFor(providerName ProviderName, modelID string) string {
+	provider := r.GetProvider(providerName)
+	if provider == nil {
+		return ""
+	}
+	for _, m := range r.Models(providerName) {
+		if m.ID == modelID {
+			return m.Effort
+		}
+	}
+	return ""
+}
+
+// SupportsVision reports whether the given provider+model accepts image and
+// document input. Models the registry does not list are given the benefit
+// of the doubt; the provider rejects the request if they cannot.
+func (r Registry) SupportsVision(providerName ProviderName, modelID string) bool {
+	provider := r.GetProvider(providerName)
+	if provider == nil {
+		return true
+	}
+	for _, m := range r.Models(providerName) {
+		if m.ID == modelID {
+			return m.Vision
+		}
+	}
+	return true
+}
+
 // CostFor returns the input/output cost per 1M tokens (USD) for the given
 // provider+model. Returns zeros if the model is not registered or pricing
 // is unknown.
@@ -45,10 +131,146 @@ func (r Registry) CostFor(providerName ProviderName, modelID string) (inputPerMi
 	if provider == nil {
 		return 0, 0
 	}
-	for _, m := range provider.Models() {
+	for _, m := range r.Models(providerName) {
 		if m.ID == modelID {
 			return m.InputCostPerMillion, m.OutputCostPerMillion
 		}
 	}
 	return 0, 0
 }
+
+// CacheCostFor returns the prompt cache read/write cost per 1M tokens (USD)
+// for the given provider+model, falling back to a tenth of the input rate
+// for reads and the input rate for writes when the model does not set them.
+func (r Registry) CacheCostFor(providerName ProviderName, modelID string) (readPerMillion, writePerMillion float64) {
+	provider := r.GetProvider(providerName)
+	if provider == nil {
+		return 0, 0
+	}
+	for _, m := range r.Models(providerName) {
+		if m.ID != modelID {
+			continue
+		}
+		readPerMillion, writePerMillion = m.CacheReadCostPerMillion, m.CacheWriteCostPerMillion
+		if readPerMillion == 0 {
+			readPerMillion = m.InputCostPerMillion * 0.10
+		}
+		if writePerMillion == 0 {
+			writePerMillion = m.InputCostPerMillion
+		}
+		return readPerMillion, writePerMillion
+	}
+	return 0, 0
+}
+
+// TokenCount is the local count of a request's tokens.
+type TokenCount struct {
+	Messages int
+	Tools    int
+	// Exact is false when the model's own tokenizer is not available and
+	// the figures are estimates.
+	Exact bool
+}
+
+func (c TokenCount) Total() int {
+	return c.Messages + c.Tools
+}
+
+// Per-message framing the chat formats add around the content, and the
+// tokens that prime the reply, as documented for OpenAI chat models.
+const (
+	tokensPerMessage  = 3
+	tokensPerToolCall = 3
+	tokensReplyPrimer = 3
+
+	imageTokens           = 1600
+	bytesPerDocumentToken = 50
+)
+
+// CountTokens counts what sending messages and tools to a provider's model
+// would cost in input tokens, without sending anything. Tool schemas are
+// counted in their JSON form.
+func (r Registry) CountTokens(
+	providerName ProviderName,
+	modelID string,
+	messages []Message,
+	tools []tools.Tool,
+) TokenCount {
+	model := tokenizerModel(providerName, modelID)
+
+	count := TokenCount{Exact: true}
+	add := func(text string) int {
+		n, exact := tokenizer.Count(model, text)
+		count.Exact = count.Exact && exact
+		return n
+	}
+
+	// Reasoning is left out: providers drop it from all but the latest
+	// turn, or do not take it back at all.
+	var addBlocks func(blocks []ContentBlock) int
+	addBlocks = func(blocks []ContentBlock) int {
+		n := 0
+		for _, b := range blocks {
+			switch b.Type {
+			case BlockText:
+				n += add(b.Text)
+			case BlockImage, BlockDocument:
+				n += mediaTokens(b)
+				count.Exact = false
+			case BlockToolUse:
+				if b.ToolUse != nil {
+					n += tokensPerToolCall + add(b.ToolUse.Name) + add(b.ToolUse.Input)
+				}
+			case BlockToolResult:
+				if b.ToolResult != nil {
+					n += addBlocks(b.ToolResult.Content)
+				}
+			}
+		}
+		return n
+	}
+
+	for _, m := range messages {
+		count.Messages += tokensPerMessage + add(m.Role) + addBlocks(m.Content)
+	}
+	if len(messages) > 0 {
+		count.Messages += tokensReplyPrimer
+	}
+
+	if len(tools) > 0 {
+		if data, err := json.Marshal(tools); err == nil {
+			count.Tools = add(string(data))
+		}
+	}
+
+	return count
+}
+
+// mediaTokens estimates what an image or document costs. Providers price
+// images by their pixel size, about 1,600 tokens for one that fills the
+// largest size they accept; documents cost roughly their text plus an
+// image per page, which their byte size only hints at.
+func mediaTokens(b ContentBlock) int {
+	if b.Media == nil {
+		return 0
+	}
+	if b.Type == BlockImage {
+		return imageTokens
+	}
+	return len(b.Media.Data) * 3 / 4 / bytesPerDocumentToken
+}
+
+// CountText counts a piece of text on its own, without message framing, and
+// reports whether the count is exact.
+func (r Registry) CountText(providerName ProviderName, modelID, text string) (int, bool) {
+	return tokenizer.Count(tokenizerModel(providerName, modelID), text)
+}
+
+// tokenizerModel qualifies bare Anthropic model ids with their vendor so
+// the tokenizer picks the right family.
+func tokenizerModel(providerName ProviderName, modelID string) string {
+	if providerName == AnthropicProvider && !strings.Contains(modelID, "/") {
+		return "anthropic/" + modelID
+	}
+	return modelID
+}
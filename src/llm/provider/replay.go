@@ -0,0 +1,366 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"zipcode/src/llm/errors"
+	"zipcode/src/secrets"
+)
+
+const ReplayProvider ProviderName = "Replay"
+
+const cassetteVersion = 1
+
+// Cassette is a recording of requests and the responses they got, in the
+// order they were made.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request with its response, or the error it
+// failed with.
+type Interaction struct {
+	Request  RecordedRequest `json:"request"`
+	Response *ChatResponse   `json:"response,omitempty"`
+	Error    *RecordedError  `json:"error,omitempty"`
+}
+
+// RecordedError is a failed request. Bucket is set for classified errors,
+// which replay as classified errors so that fallbacks still apply.
+type RecordedError struct {
+	Bucket  string `json:"bucket,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+// RecordedRequest is what a request is matched on. Tools are kept by name
+// only, and Key hashes the rest so a lookup need not compare messages.
+type RecordedRequest struct {
+	Key             string    `json:"key"`
+	Provider        string    `json:"provider"`
+	Model           string    `json:"model"`
+	Messages        []Message `json:"messages"`
+	Tools           []string  `json:"tools,omitempty"`
+	ReasoningEffort string    `json:"reasoning_effort,omitempty"`
+}
+
+// Replay answers requests from a cassette. In record mode it passes them
+// to Inner instead and writes each exchange to the cassette, with secrets
+// redacted. In replay mode a request gets the first unused response
+// recorded for an identical request; when there is none it gets the next
+// unused one in recorded order, unless Strict is set.
+type Replay struct {
+	Path   string
+	Inner  Provider
+	Strict bool
+
+	mu       *sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewReplay loads the cassette at path to replay it.
+func NewReplay(path string, strict bool) (*Replay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	if cassette.Version > cassetteVersion {
+		return nil, fmt.Errorf("cassette %s: version %d is newer than supported", path, cassette.Version)
+	}
+	return &Replay{
+		Path:     path,
+		Strict:   strict,
+		mu:       &sync.Mutex{},
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+	}, nil
+}
+
+// NewRecorder records the requests sent to inner into a new cassette at
+// path, replacing any cassette already there.
+func NewRecorder(path string, inner Provider) *Replay {
+	return &Replay{
+		Path:     path,
+		Inner:    inner,
+		mu:       &sync.Mutex{},
+		cassette: Cassette{Version: cassetteVersion},
+	}
+}
+
+func (p *Replay) recording() bool {
+	return p.Inner != nil
+}
+
+func (p *Replay) Name() ProviderName {
+	return ReplayProvider
+}
+
+// SetApiKey does nothing: a recorder uses the key of the provider it
+// records through.
+func (p *Replay) SetApiKey(key string) {}
+
+func (p *Replay) KeyOptional() bool {
+	return true
+}
+
+func (p *Replay) AuthCheck(key string) AuthResult {
+	return AuthResult{Status: http.StatusOK}
+}
+
+func (p *Replay) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	return p.CompleteStream(ctx, request, nil)
+}
+
+func (p *Replay) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	if p.recording() {
+		return p.record(ctx, request, onDelta)
+	}
+
+	interaction, err := p.next(recordRequest(p.Name(), request))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	if interaction.Error != nil {
+		return ChatResponse{}, interaction.Error.err(interaction.Request)
+	}
+	if interaction.Response == nil {
+		return ChatResponse{}, fmt.Errorf("replay: recorded interaction has no response")
+	}
+	if onDelta != nil {
+		streamMessage(interaction.Response.Message, onDelta)
+	}
+	return *interaction.Response, nil
+}
+
+// next claims the interaction that answers request.
+func (p *Replay) next(request RecordedRequest) (Interaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, interaction := range p.cassette.Interactions {
+		if !p.used[i] && interaction.Request.Key == request.Key {
+			p.used[i] = true
+			return interaction, nil
+		}
+	}
+	if !p.Strict {
+		for i, interaction := range p.cassette.Interactions {
+			if !p.used[i] {
+				p.used[i] = true
+				return interaction, nil
+			}
+		}
+	}
+	return Interaction{}, fmt.Errorf(
+		"replay: no recorded response for request %s to %s in %s",
+		request.Key[:12],
+		request.Model,
+		p.Path,
+	)
+}
+
+func (p *Replay) record(ctx context.Context, request ChatRequest, onDelta StreamHandler) (ChatResponse, error) {
+	var response ChatResponse
+	var err error
+	if streamer, ok := p.Inner.(StreamingProvider); ok && onDelta != nil {
+		response, err = streamer.CompleteStream(ctx, request, onDelta)
+	} else {
+		response, err = p.Inner.Complete(ctx, request)
+	}
+	// A cancelled request says nothing about the provider.
+	if goerrors.Is(err, context.Canceled) {
+		return response, err
+	}
+
+	interaction := Interaction{Request: recordRequest(p.Inner.Name(), request)}
+	if err != nil {
+		interaction.Error = recordError(err)
+	} else {
+		recorded := response
+		recorded.Message = redactMessage(response.Message)
+		interaction.Response = &recorded
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cassette.Interactions = append(p.cassette.Interactions, interaction)
+	if saveErr := p.save(); saveErr != nil && err == nil {
+		err = fmt.Errorf("replay: failed to save cassette: %w", saveErr)
+	}
+	return response, err
+}
+
+// save writes the cassette; the caller holds mu.
+func (p *Replay) save() error {
+	data, err := json.MarshalIndent(p.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(p.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := p.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.Path)
+}
+
+// Models lists the inner provider's models when recording, and the models
+// the cassette was recorded with when replaying.
+func (p *Replay) Models() []ModelDescriptor {
+	if p.recording() {
+		return p.Inner.Models()
+	}
+
+	var models []ModelDescriptor
+	seen := map[string]bool{}
+	for _, interaction := range p.cassette.Interactions {
+		id := interaction.Request.Model
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		models = append(models, ModelDescriptor{
+			ID:           id,
+			DisplayName:  id,
+			ProviderName: string(ReplayProvider),
+		})
+	}
+	return models
+}
+
+func (p *Replay) IsQuotaError(resp *http.Response, body []byte) bool {
+	if p.recording() {
+		return p.Inner.IsQuotaError(resp, body)
+	}
+	return false
+}
+
+func recordError(err error) *RecordedError {
+	if classified, ok := errors.AsClassified(err); ok {
+		return &RecordedError{
+			Bucket:  classified.Bucket.String(),
+			Status:  classified.StatusCode,
+			Message: classified.RawDetails,
+		}
+	}
+	return &RecordedError{Message: secrets.RedactForDisplay(err.Error())}
+}
+
+func (e RecordedError) err(request RecordedRequest) error {
+	bucket, ok := errors.ParseBucket(e.Bucket)
+	if !ok {
+		return goerrors.New(e.Message)
+	}
+	return errors.NewClassifiedError(bucket, request.Provider, request.Model, e.Status, e.Message)
+}
+
+// recordRequest redacts request and keys it by a hash of everything it is
+// matched on. Replayed requests are redacted the same way, so they hash
+// alike.
+func recordRequest(provider ProviderName, request ChatRequest) RecordedRequest {
+	recorded := RecordedRequest{
+		Provider:        string(provider),
+		Model:           request.Model,
+		Messages:        make([]Message, len(request.Messages)),
+		ReasoningEffort: request.ReasoningEffort,
+	}
+	for i, m := range request.Messages {
+		recorded.Messages[i] = redactMessage(m)
+		recorded.Messages[i].Usage = nil
+	}
+	for _, t := range request.Tools {
+		recorded.Tools = append(recorded.Tools, t.Function.Name)
+	}
+
+	data, _ := json.Marshal(struct {
+		Model           string    `json:"model"`
+		Messages        []Message `json:"messages"`
+		Tools           []string  `json:"tools"`
+		ReasoningEffort string    `json:"reasoning_effort"`
+	}{recorded.Model, recorded.Messages, recorded.Tools, recorded.ReasoningEffort})
+	sum := sha256.Sum256(data)
+	recorded.Key = hex.EncodeToString(sum[:])
+	return recorded
+}
+
+// redactMessage returns a copy of m with secrets in its text, tool inputs
+// and tool results redacted.
+func redactMessage(m Message) Message {
+	m.Content = redactBlocks(m.Content)
+	return m
+}
+
+func redactBlocks(blocks []ContentBlock) []ContentBlock {
+	out := make([]ContentBlock, len(blocks))
+	for i, b := range blocks {
+		switch {
+		case b.Type == BlockText:
+			b.Text = secrets.RedactForDisplay(b.Text)
+		case b.ToolUse != nil:
+			use := *b.ToolUse
+			use.Input = secrets.RedactForDisplay(use.Input)
+			b.ToolUse = &use
+		case b.ToolResult != nil:
+			result := *b.ToolResult
+			result.Content = redactBlocks(result.Content)
+			b.ToolResult = &result
+		}
+		out[i] = b
+	}
+	return out
+}
+
+// streamMessage replays a whole message as stream deltas, one per block.
+func streamMessage(m Message, onDelta StreamHandler) {
+	index := 0
+	for _, b := range m.Content {
+		switch b.Type {
+		case BlockReasoning:
+			if b.Reasoning != nil && b.Reasoning.Text != "" {
+				onDelta(StreamDelta{Type: DeltaReasoning, Text: b.Reasoning.Text})
+			}
+		case BlockText:
+			onDelta(StreamDelta{Type: DeltaText, Text: b.Text})
+		case BlockToolUse:
+			if b.ToolUse == nil {
+				continue
+			}
+			onDelta(StreamDelta{
+				Type: DeltaToolCall,
+				ToolCall: ToolCall{
+					Type:  "function",
+					Index: index,
+					ID:    b.ToolUse.ID,
+					Function: ToolCallFunction{
+						Name:      b.ToolUse.Name,
+						Arguments: b.ToolUse.Input,
+					},
+				},
+			})
+			index++
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipcode/src/llm/errors"
+)
+
+var testKey = "sk-ant-api03-" + strings.Repeat("a", 40)
+
+func writeScript(t *testing.T, script Script) string {
+	t.Helper()
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func userRequest(text string) ChatRequest {
+	return ChatRequest{Model: "scripted", Messages: []Message{TextMessage("user", text)}}
+}
+
+// recordCassette records three requests through a scripted provider: one
+// that mentions a key, a plain one, and one that fails with a quota error.
+func recordCassette(t *testing.T) string {
+	t.Helper()
+	inner, err := NewScripted(writeScript(t, Script{Steps: []ScriptStep{
+		{Text: "Saved " + testKey + " to .env."},
+		{Text: "It is a main package."},
+		{Error: &ScriptedError{Type: "insufficient_quota", Message: "out of credits"}},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cassettes", "run.json")
+	recorder := NewRecorder(path, inner)
+	ctx := context.Background()
+	if _, err := recorder.Complete(ctx, userRequest("Save my key "+testKey)); err != nil {
+		t.Fatalf("record first: %v", err)
+	}
+	if _, err := recorder.Complete(ctx, userRequest("What is main.go?")); err != nil {
+		t.Fatalf("record second: %v", err)
+	}
+	if _, err := recorder.Complete(ctx, userRequest("Continue")); err == nil {
+		t.Fatal("record third: want the scripted quota error")
+	}
+	return path
+}
+
+func TestRecorderRedactsSecrets(t *testing.T) {
+	path := recordCassette(t)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), testKey) {
+		t.Fatalf("cassette contains the key:\n%s", data)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 3 {
+		t.Fatalf("recorded %d interactions, want 3", len(cassette.Interactions))
+	}
+	if got := cassette.Interactions[0].Response.Message.Text(); got != "Saved --redacted-- to .env." {
+		t.Errorf("recorded response = %q", got)
+	}
+	if got := cassette.Interactions[2].Error; got == nil || got.Bucket != "quota" {
+		t.Errorf("recorded error = %+v, want a quota error", got)
+	}
+}
+
+func TestReplayMatchesRequestsByKey(t *testing.T) {
+	replay, err := NewReplay(recordCassette(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Out of recorded order: each request still gets its own response,
+	// and the one with the key matches its redacted recording.
+	response, err := replay.Complete(ctx, userRequest("What is main.go?"))
+	if err != nil || response.Message.Text() != "It is a main package." {
+		t.Fatalf("second request = %q, %v", response.Message.Text(), err)
+	}
+
+	var streamed strings.Builder
+	response, err = replay.CompleteStream(ctx, userRequest("Save my key "+testKey), func(d StreamDelta) {
+		streamed.WriteString(d.Text)
+	})
+	if err != nil || response.Message.Text() != "Saved --redacted-- to .env." {
+		t.Fatalf("first request = %q, %v", response.Message.Text(), err)
+	}
+	if streamed.String() != response.Message.Text() {
+		t.Errorf("streamed %q, want the response text", streamed.String())
+	}
+
+	_, err = replay.Complete(ctx, userRequest("Continue"))
+	if classified, ok := errors.AsClassified(err); !ok || classified.Bucket != errors.BucketQuotaBilling {
+		t.Errorf("third request err = %v, want the recorded quota error", err)
+	}
+}
+
+func TestReplayStrictRejectsUnrecordedRequests(t *testing.T) {
+	path := recordCassette(t)
+	ctx := context.Background()
+
+	strict, err := NewReplay(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.Complete(ctx, userRequest("Something new"))
+	if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Fatalf("strict err = %v, want no recorded response", err)
+	}
+	if _, err := strict.Complete(ctx, userRequest("What is main.go?")); err != nil {
+		t.Fatalf("strict recorded request: %v", err)
+	}
+	if _, err := strict.Complete(ctx, userRequest("What is main.go?")); err == nil {
+		t.Fatal("strict replayed the same interaction twice")
+	}
+
+	loose, err := NewReplay(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := loose.Complete(ctx, userRequest("Something new"))
+	if err != nil || response.Message.Text() != "Saved --redacted-- to .env." {
+		t.Fatalf("loose = %q, %v, want the next unused response", response.Message.Text(), err)
+	}
+}
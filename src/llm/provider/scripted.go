@@ -0,0 +1,204 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"zipcode/src/llm/errors"
+)
+
+const ScriptedProvider ProviderName = "Scripted"
+
+// Script is what the scripted provider answers with, one step per
+// request:
+//
+//	{
+//	  "model": "scripted",
+//	  "steps": [
+//	    {"text": "Reading it.", "tool_calls": [{"name": "file_read", "input": {"path": "main.go"}}]},
+//	    {"expect": "package main", "text": "It is a main package."}
+//	  ]
+//	}
+type Script struct {
+	Model         string       `json:"model"`
+	ContextWindow int          `json:"context_window"`
+	Steps         []ScriptStep `json:"steps"`
+}
+
+// ScriptStep is one response. Expect, when set, must appear in the text of
+// the request's last message. Error fails the request instead, classified
+// like a provider's error of that type, e.g. "quota" or "overloaded".
+type ScriptStep struct {
+	Expect    string             `json:"expect,omitempty"`
+	Reasoning string             `json:"reasoning,omitempty"`
+	Text      string             `json:"text,omitempty"`
+	ToolCalls []ScriptedToolCall `json:"tool_calls,omitempty"`
+	Usage     Usage              `json:"usage"`
+	Error     *ScriptedError     `json:"error,omitempty"`
+}
+
+type ScriptedToolCall struct {
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type ScriptedError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Scripted answers requests with the steps of a script, in order, and
+// fails once they run out.
+type Scripted struct {
+	Path   string
+	Script Script
+
+	mu   *sync.Mutex
+	next int
+}
+
+func NewScripted(path string) (*Scripted, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("script %s: %w", path, err)
+	}
+	if script.Model == "" {
+		script.Model = "scripted"
+	}
+	return &Scripted{Path: path, Script: script, mu: &sync.Mutex{}}, nil
+}
+
+func (p *Scripted) Name() ProviderName {
+	return ScriptedProvider
+}
+
+func (p *Scripted) SetApiKey(key string) {}
+
+func (p *Scripted) KeyOptional() bool {
+	return true
+}
+
+func (p *Scripted) AuthCheck(key string) AuthResult {
+	return AuthResult{Status: http.StatusOK}
+}
+
+func (p *Scripted) Complete(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	return p.CompleteStream(ctx, request, nil)
+}
+
+func (p *Scripted) CompleteStream(
+	ctx context.Context,
+	request ChatRequest,
+	onDelta StreamHandler,
+) (ChatResponse, error) {
+	p.mu.Lock()
+	n := p.next
+	p.next++
+	p.mu.Unlock()
+
+	if n >= len(p.Script.Steps) {
+		return ChatResponse{}, fmt.Errorf("scripted: %s has no step %d", p.Path, n+1)
+	}
+	step := p.Script.Steps[n]
+
+	if step.Expect != "" {
+		last := ""
+		if len(request.Messages) > 0 {
+			last = scriptedText(request.Messages[len(request.Messages)-1])
+		}
+		if !strings.Contains(last, step.Expect) {
+			return ChatResponse{}, fmt.Errorf(
+				"scripted: step %d expected %q in the last message, got %q",
+				n+1,
+				step.Expect,
+				last,
+			)
+		}
+	}
+	if step.Error != nil {
+		return ChatResponse{}, errors.ClassifyStreamError(
+			string(p.Name()),
+			request.Model,
+			step.Error.Type,
+			step.Error.Message,
+		)
+	}
+
+	message := Message{Role: "assistant"}
+	if step.Reasoning != "" {
+		message.Content = append(message.Content, ContentBlock{
+			Type:      BlockReasoning,
+			Reasoning: &ReasoningDetails{Type: "reasoning.text", Text: step.Reasoning},
+		})
+	}
+	if step.Text != "" {
+		message.Content = append(message.Content, TextBlock(step.Text))
+	}
+	for i, call := range step.ToolCalls {
+		input := string(call.Input)
+		if input == "" {
+			input = "{}"
+		}
+		message.Content = append(message.Content, ContentBlock{
+			Type: BlockToolUse,
+			ToolUse: &ToolUse{
+				ID:    fmt.Sprintf("call_%d_%d", n+1, i+1),
+				Name:  call.Name,
+				Input: input,
+			},
+		})
+	}
+	usage := step.Usage
+	message.Usage = &usage
+
+	if onDelta != nil {
+		streamMessage(message, onDelta)
+	}
+
+	stopReason := "stop"
+	if len(step.ToolCalls) > 0 {
+		stopReason = "tool_calls"
+	}
+	return ChatResponse{
+		ID:         fmt.Sprintf("scripted-%d", n+1),
+		Model:      request.Model,
+		Message:    message,
+		StopReason: stopReason,
+		Usage:      usage,
+	}, nil
+}
+
+// scriptedText is the text an expectation is checked against: the text
+// of the message and of any tool results in it.
+func scriptedText(m Message) string {
+	var parts []string
+	if text := m.Text(); text != "" {
+		parts = append(parts, text)
+	}
+	for _, r := range m.ToolResults() {
+		parts = append(parts, r.Text())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (p *Scripted) Models() []ModelDescriptor {
+	return []ModelDescriptor{{
+		ID:            p.Script.Model,
+		DisplayName:   p.Script.Model,
+		ProviderName:  string(ScriptedProvider),
+		ContextWindow: p.Script.ContextWindow,
+	}}
+}
+
+func (p *Scripted) IsQuotaError(resp *http.Response, body []byte) bool {
+	return false
+}
@@ -0,0 +1,110 @@
+package llm
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxSSELineSize bounds a single SSE line. Tool call argument chunks can be
+// large (full file contents for file_write), so the default 64KiB scanner
+// buffer is not enough.
+const maxSSELineSize = 8 * 1024 * 1024
+
+// readSSE scans a text/event-stream body and calls fn once per complete
+// event with the event name (empty when the server omits it) and the data
+// lines joined by newlines. Comment lines are skipped. Scanning stops at the
+// first error returned by fn.
+func readSSE(body io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+
+	var event string
+	var data []string
+
+	flush := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		payload := strings.Join(data, "\n")
+		name := event
+		event = ""
+		data = data[:0]
+		return fn(name, payload)
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// keep-alive / processing comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// toolCallAccumulator merges OpenAI-style tool call deltas, which arrive as
+// fragments keyed by index: the first fragment carries the id and function
+// name, later ones append to the arguments string.
+type toolCallAccumulator struct {
+	calls   []ToolCall
+	byIndex map[int]int
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{byIndex: map[int]int{}}
+}
+
+func (a *toolCallAccumulator) add(delta ToolCall) ToolCall {
+	pos, ok := a.byIndex[delta.Index]
+	if !ok {
+		a.calls = append(a.calls, ToolCall{Type: "function", Index: delta.Index})
+		pos = len(a.calls) - 1
+		a.byIndex[delta.Index] = pos
+	}
+
+	call := &a.calls[pos]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+
+	return *call
+}
+
+// result returns the assembled calls, or nil when none were streamed so
+// callers can keep relying on a nil ToolCalls meaning "plain message".
+func (a *toolCallAccumulator) result() []ToolCall {
+	if len(a.calls) == 0 {
+		return nil
+	}
+	out := make([]ToolCall, len(a.calls))
+	copy(out, a.calls)
+	for i := range out {
+		if strings.TrimSpace(out[i].Function.Arguments) == "" {
+			out[i].Function.Arguments = "{}"
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package llm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"zipcode/src/llm/errors"
+	"zipcode/src/utils"
+)
+
+const (
+	defaultConnectTimeout    = 10 * time.Second
+	defaultResponseTimeout   = 5 * time.Minute
+	defaultStreamIdleTimeout = 5 * time.Minute
+	// authCheckTimeout bounds a whole key check, body included.
+	authCheckTimeout = 10 * time.Second
+)
+
+// HTTPSettings configures the client a provider sends its requests with.
+// Zero fields take the defaults.
+type HTTPSettings struct {
+	// Provider names the provider in the request log.
+	Provider ProviderName
+	// ConnectTimeout bounds dialing and the TLS handshake.
+	ConnectTimeout time.Duration
+	// ResponseTimeout bounds the wait for the response headers once the
+	// request is sent. A streamed body may take longer.
+	ResponseTimeout time.Duration
+	// StreamIdleTimeout bounds the wait for the next data of a streamed
+	// body, so a stream that stalls fails instead of hanging.
+	StreamIdleTimeout time.Duration
+	// Proxy is the proxy URL. Empty uses HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY from the environment.
+	Proxy string
+	// Headers are added to every request, unless the provider sets them.
+	Headers map[string]string
+	// CABundle is a PEM file of certificates to trust on top of the
+	// system's, e.g. a corporate proxy's.
+	CABundle string
+}
+
+// HTTPProvider is implemented by providers that talk to their API over
+// HTTP, so that they can be given the client built from HTTPSettings.
+type HTTPProvider interface {
+	Provider
+	SetHTTPClient(client *http.Client)
+}
+
+var defaultHTTPClient, _ = NewHTTPClient(HTTPSettings{Provider: "http"})
+
+// NewHTTPClient builds a client from settings. It sets no overall
+// timeout, which would cut off long streams.
+func NewHTTPClient(settings HTTPSettings) (*http.Client, error) {
+	connectTimeout := settings.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	responseTimeout := settings.ResponseTimeout
+	if responseTimeout <= 0 {
+		responseTimeout = defaultResponseTimeout
+	}
+	streamIdleTimeout := settings.StreamIdleTimeout
+	if streamIdleTimeout <= 0 {
+		streamIdleTimeout = defaultStreamIdleTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if settings.Proxy != "" {
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", settings.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	var tlsConfig *tls.Config
+	if settings.CABundle != "" {
+		pem, err := os.ReadFile(settings.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s: no certificates found", settings.CABundle)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: responseTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Transport: loggingTransport{
+			provider:          settings.Provider,
+			headers:           settings.Headers,
+			streamIdleTimeout: streamIdleTimeout,
+			base:              transport,
+		},
+	}, nil
+}
+
+// httpClientOr returns client, or the default client when none was set.
+func httpClientOr(client *http.Client) *http.Client {
+	if client == nil {
+		return defaultHTTPClient
+	}
+	return client
+}
+
+// authCheckClient returns client with the overall timeout of a key check.
+func authCheckClient(client *http.Client) *http.Client {
+	check := *httpClientOr(client)
+	check.Timeout = authCheckTimeout
+	return &check
+}
+
+// loggingTransport adds the configured headers to requests and logs each
+// one with its status, duration and the provider's request id. Streamed
+// bodies get the idle timeout.
+type loggingTransport struct {
+	provider          ProviderName
+	headers           map[string]string
+	streamIdleTimeout time.Duration
+	base              http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for name, value := range t.headers {
+			if req.Header.Get(name) == "" {
+				req.Header.Set(name, value)
+			}
+		}
+	}
+
+	// The query is left out: it may carry a key.
+	target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		utils.Log(fmt.Sprintf("%s %s %s failed after %s: %s", t.provider, req.Method, target, elapsed, err))
+		return nil, err
+	}
+
+	requestID := errors.RequestID(resp)
+	if requestID == "" {
+		requestID = "-"
+	}
+	utils.Log(fmt.Sprintf(
+		"%s %s %s: %d in %s, request id %s",
+		t.provider,
+		req.Method,
+		target,
+		resp.StatusCode,
+		elapsed,
+		requestID,
+	))
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = newIdleTimeoutBody(resp.Body, t.streamIdleTimeout)
+	}
+	return resp, nil
+}
+
+// idleTimeoutBody closes a streamed body that sends nothing for timeout.
+// The timer restarts whenever a read returns data.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.idle.Store(true)
+		body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.idle.Load() {
+		return n, errStreamIdle{timeout: b.timeout}
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
+
+// errStreamIdle is a timeout, so it is classified as a network error.
+type errStreamIdle struct {
+	timeout time.Duration
+}
+
+func (e errStreamIdle) Error() string {
+	return fmt.Sprintf("stream stalled: no data for %s", e.timeout)
+}
+
+func (e errStreamIdle) Timeout() bool   { return true }
+func (e errStreamIdle) Temporary() bool { return false }
@@ -0,0 +1,78 @@
+package llm
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sseServer sends events data events gap apart, then stalls until the
+// client goes away.
+func sseServer(t *testing.T, events int, gap time.Duration) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range events {
+			if i > 0 {
+				time.Sleep(gap)
+			}
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func readEvents(t *testing.T, url string, idle time.Duration) (int, error) {
+	t.Helper()
+	client, err := NewHTTPClient(HTTPSettings{Provider: "test", StreamIdleTimeout: idle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received := 0
+	err = readSSE(resp.Body, func(_, _ string) error {
+		received++
+		return nil
+	})
+	return received, err
+}
+
+func TestStreamIdleTimeoutStopsStalledStream(t *testing.T) {
+	server := sseServer(t, 1, 0)
+
+	start := time.Now()
+	received, err := readEvents(t, server.URL, 100*time.Millisecond)
+	if received != 1 {
+		t.Errorf("received %d events before the stall, want 1", received)
+	}
+	var netErr net.Error
+	if !goerrors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled stream took %s to fail", elapsed)
+	}
+}
+
+func TestStreamIdleTimeoutResetsOnData(t *testing.T) {
+	// Four events 60ms apart take longer than the timeout in total, but
+	// never go quiet for that long.
+	server := sseServer(t, 4, 60*time.Millisecond)
+
+	received, err := readEvents(t, server.URL, 150*time.Millisecond)
+	if received != 4 {
+		t.Errorf("received %d events, want all 4 (err %v)", received, err)
+	}
+}
@@ -0,0 +1,109 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxPieceBytes bounds the input of one merge loop, which is quadratic.
+// Longer pieces (a base64 blob, a run of dashes) are counted in chunks; the
+// error this introduces is a token or two per chunk.
+const maxPieceBytes = 512
+
+// encoding is a byte-pair encoding loaded from a .tiktoken file: one
+// "<base64 token> <rank>" pair per line, as published for cl100k_base and
+// o200k_base.
+type encoding struct {
+	name  string
+	ranks map[string]int
+	split splitter
+}
+
+func loadEncoding(name, path string, split splitter) (*encoding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int, 200_000)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"<token> <rank>\"", path, line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ranks[string(decoded)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+
+	return &encoding{name: name, ranks: ranks, split: split}, nil
+}
+
+// count returns the number of tokens text encodes to.
+func (e *encoding) count(text string) int {
+	total := 0
+	e.split(text, func(piece string) {
+		for len(piece) > maxPieceBytes {
+			total += e.countPiece(piece[:maxPieceBytes])
+			piece = piece[maxPieceBytes:]
+		}
+		total += e.countPiece(piece)
+	})
+	return total
+}
+
+// countPiece runs the merge loop: starting from single bytes, the adjacent
+// pair whose concatenation has the lowest rank is merged until no pair is
+// in the vocabulary.
+func (e *encoding) countPiece(piece string) int {
+	if piece == "" {
+		return 0
+	}
+	if _, ok := e.ranks[piece]; ok {
+		return 1
+	}
+
+	// bounds[i] is where part i starts; the last entry is len(piece).
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := e.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	return len(bounds) - 1
+}
@@ -0,0 +1,212 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitter cuts text into the pieces BPE runs on. The tiktoken patterns use
+// a lookahead that Go's regexp does not support, so they are implemented
+// by hand:
+//
+//	cl100k_base
+//	  (?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}|
+//	  ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+//	o200k_base
+//	  [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	  [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	  \p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+type splitter func(text string, piece func(string))
+
+type scanner struct {
+	runes []rune
+}
+
+func (s *scanner) at(i int) rune {
+	if i < 0 || i >= len(s.runes) {
+		return utf8.RuneError
+	}
+	return s.runes[i]
+}
+
+func (s *scanner) in(i int) bool {
+	return i >= 0 && i < len(s.runes)
+}
+
+func isNewline(r rune) bool { return r == '\r' || r == '\n' }
+
+// isPrefix matches [^\r\n\p{L}\p{N}].
+func isPrefix(r rune) bool {
+	return !isNewline(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isSymbol matches [^\s\p{L}\p{N}].
+func isSymbol(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func isUpperish(r rune) bool {
+	return unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+func isLowerish(r rune) bool {
+	return unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// contraction returns the length of (?i:'s|'t|'re|'ve|'m|'ll|'d) at i, or 0.
+func (s *scanner) contraction(i int) int {
+	if s.at(i) != '\'' {
+		return 0
+	}
+	a := unicode.ToLower(s.at(i + 1))
+	b := unicode.ToLower(s.at(i + 2))
+	switch {
+	case a == 'r' && b == 'e', a == 'v' && b == 'e', a == 'l' && b == 'l':
+		return 3
+	case a == 's', a == 't', a == 'm', a == 'd':
+		return 2
+	}
+	return 0
+}
+
+// digits matches \p{N}{1,3}.
+func (s *scanner) digits(i int) int {
+	n := 0
+	for n < 3 && s.in(i+n) && unicode.IsNumber(s.at(i+n)) {
+		n++
+	}
+	return n
+}
+
+// symbols matches " ?[^\s\p{L}\p{N}]+" followed by any of trail.
+func (s *scanner) symbols(i int, trail func(rune) bool) int {
+	j := i
+	if s.at(j) == ' ' && s.in(j+1) && isSymbol(s.at(j+1)) {
+		j++
+	}
+	if !s.in(j) || !isSymbol(s.at(j)) {
+		return 0
+	}
+	for s.in(j) && isSymbol(s.at(j)) {
+		j++
+	}
+	for s.in(j) && trail(s.at(j)) {
+		j++
+	}
+	return j - i
+}
+
+// whitespace matches \s*[\r\n]+|\s+(?!\S)|\s+ at i.
+func (s *scanner) whitespace(i int) int {
+	end := i
+	lastNewline := -1
+	for s.in(end) && unicode.IsSpace(s.at(end)) {
+		if isNewline(s.at(end)) {
+			lastNewline = end
+		}
+		end++
+	}
+	if end == i {
+		return 0
+	}
+	if lastNewline >= 0 {
+		return lastNewline + 1 - i
+	}
+	// Leave the last space to prefix the word that follows.
+	if s.in(end) && end-i > 1 {
+		return end - 1 - i
+	}
+	return end - i
+}
+
+func splitCl100k(text string, piece func(string)) {
+	s := &scanner{runes: []rune(text)}
+	for i := 0; i < len(s.runes); {
+		n := s.contraction(i)
+		if n == 0 {
+			j := i
+			if isPrefix(s.at(j)) && unicode.IsLetter(s.at(j+1)) {
+				j++
+			}
+			for s.in(j) && unicode.IsLetter(s.at(j)) {
+				j++
+			}
+			if j > i && unicode.IsLetter(s.at(j-1)) {
+				n = j - i
+			}
+		}
+		if n == 0 {
+			n = s.digits(i)
+		}
+		if n == 0 {
+			n = s.symbols(i, isNewline)
+		}
+		if n == 0 {
+			n = s.whitespace(i)
+		}
+		if n == 0 {
+			n = 1
+		}
+		piece(string(s.runes[i : i+n]))
+		i += n
+	}
+}
+
+// word matches the two o200k word alternatives at i, starting the letters
+// at j.
+func (s *scanner) word(j int) int {
+	// [upper]*[lower]+ : the longest upper run after which a lower run
+	// can start.
+	k := j
+	for s.in(k) && isUpperish(s.at(k)) {
+		k++
+	}
+	for t := k; t >= j; t-- {
+		if s.in(t) && isLowerish(s.at(t)) {
+			end := t
+			for s.in(end) && isLowerish(s.at(end)) {
+				end++
+			}
+			return end - j + s.contraction(end)
+		}
+	}
+	// [upper]+[lower]*
+	if k > j {
+		end := k
+		for s.in(end) && isLowerish(s.at(end)) {
+			end++
+		}
+		return end - j + s.contraction(end)
+	}
+	return 0
+}
+
+func splitO200k(text string, piece func(string)) {
+	s := &scanner{runes: []rune(text)}
+	for i := 0; i < len(s.runes); {
+		n := 0
+		if isPrefix(s.at(i)) {
+			if w := s.word(i + 1); w > 0 {
+				n = w + 1
+			}
+		}
+		if n == 0 {
+			n = s.word(i)
+		}
+		if n == 0 {
+			n = s.digits(i)
+		}
+		if n == 0 {
+			n = s.symbols(i, func(r rune) bool { return isNewline(r) || r == '/' })
+		}
+		if n == 0 {
+			n = s.whitespace(i)
+		}
+		if n == 0 {
+			n = 1
+		}
+		piece(string(s.runes[i : i+n]))
+		i += n
+	}
+}
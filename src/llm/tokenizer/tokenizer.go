@@ -0,0 +1,222 @@
+// Package tokenizer counts tokens locally, so context use can be checked
+// before a request is sent.
+//
+// OpenAI-family models are counted exactly with their byte-pair encoding,
+// loaded from cl100k_base.tiktoken and o200k_base.tiktoken in the
+// tokenizers directory (~/.zipcode/tokenizers by default). Other models, and
+// OpenAI models when the file is missing, get an estimate: the count of a
+// reference encoding scaled by a per-family factor, or a piece-based
+// heuristic when no encoding is available at all.
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"zipcode/src/config"
+	"zipcode/src/utils"
+)
+
+const (
+	Cl100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+// encodingsURL is where OpenAI publishes the .tiktoken files.
+const encodingsURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+// family says how a model's tokens are counted. exact families use
+// encoding as is; others scale the reference count by scale.
+type family struct {
+	encoding string
+	exact    bool
+	scale    float64
+}
+
+// Scale factors relative to cl100k_base. They are rough averages over
+// English prose and source code, not per-model measurements.
+var (
+	familyO200k  = family{encoding: O200kBase, exact: true, scale: 1}
+	familyCl100k = family{encoding: Cl100kBase, exact: true, scale: 1}
+	familyClaude = family{encoding: Cl100kBase, scale: 1.15}
+	familyOther  = family{encoding: Cl100kBase, scale: 1.05}
+)
+
+// familyFor maps a model id, with or without an OpenRouter style
+// "vendor/" prefix, to its family.
+func familyFor(model string) family {
+	id := strings.ToLower(model)
+	vendor := ""
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		vendor, id = id[:i], id[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(id, "gpt-4o"), strings.HasPrefix(id, "chatgpt-4o"),
+		strings.HasPrefix(id, "gpt-4.1"), strings.HasPrefix(id, "gpt-4.5"),
+		strings.HasPrefix(id, "gpt-5"), strings.HasPrefix(id, "gpt-oss"),
+		strings.HasPrefix(id, "o1"), strings.HasPrefix(id, "o3"), strings.HasPrefix(id, "o4"),
+		strings.HasPrefix(id, "codex"):
+		return familyO200k
+	case strings.HasPrefix(id, "gpt-4"), strings.HasPrefix(id, "gpt-3.5"),
+		strings.HasPrefix(id, "text-embedding"):
+		return familyCl100k
+	case strings.HasPrefix(id, "claude"), vendor == "anthropic":
+		return familyClaude
+	}
+	return familyOther
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*encoding{}
+	// missing remembers encodings that failed to load so the file is not
+	// read again on every count.
+	missing = map[string]bool{}
+)
+
+func encodingNamed(name string) *encoding {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if e, ok := encodings[name]; ok {
+		return e
+	}
+	if missing[name] {
+		return nil
+	}
+
+	split := splitCl100k
+	if name == O200kBase {
+		split = splitO200k
+	}
+	path := filepath.Join(config.Cfg.TokenizersPath, name+".tiktoken")
+	e, err := loadEncoding(name, path, split)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			utils.Log(fmt.Sprintf(
+				"tokenizer: %s not found, token counts are estimated; download it from %s%s.tiktoken",
+				path,
+				encodingsURL,
+				name,
+			))
+		} else {
+			utils.Log("tokenizer: " + err.Error())
+		}
+		missing[name] = true
+		return nil
+	}
+	encodings[name] = e
+	return e
+}
+
+// Available reports whether the encoding file for model is installed, that
+// is whether Count is exact for it.
+func Available(model string) bool {
+	f := familyFor(model)
+	return f.exact && encodingNamed(f.encoding) != nil
+}
+
+// Count returns how many tokens text is for model, and whether the figure
+// comes from the model's own tokenizer rather than an estimate.
+func Count(model, text string) (int, bool) {
+	if text == "" {
+		return 0, true
+	}
+
+	f := familyFor(model)
+	if e := encodingNamed(f.encoding); e != nil {
+		n := cachedCount(e, text)
+		if f.exact {
+			return n, true
+		}
+		return scaled(n, f.scale), false
+	}
+
+	// Any installed encoding beats the heuristic as a reference.
+	for _, name := range []string{Cl100kBase, O200kBase} {
+		if e := encodingNamed(name); e != nil {
+			return scaled(cachedCount(e, text), f.scale), false
+		}
+	}
+	return scaled(estimate(text), f.scale), false
+}
+
+func scaled(n int, scale float64) int {
+	return int(float64(n)*scale + 0.5)
+}
+
+// estimate approximates cl100k_base without its vocabulary: a piece of
+// the split is one token when short, and long words, symbol runs and
+// non-ASCII text add more.
+func estimate(text string) int {
+	total := 0
+	splitCl100k(text, func(piece string) {
+		runes := utf8.RuneCountInString(piece)
+		ascii := len(piece) == runes
+		trimmed := strings.TrimLeft(piece, " ")
+
+		switch {
+		case !ascii:
+			// Non-Latin scripts run at about a token per character.
+			total += max(1, runes*4/5)
+		case strings.TrimSpace(piece) == "":
+			total += 1 + len(piece)/16
+		case isSymbol(rune(trimmed[0])):
+			total += 1 + (len(trimmed)-1)/3
+		default:
+			total += 1 + max(0, len(trimmed)-6)/4
+		}
+	})
+	return total
+}
+
+// The cache keeps counts of recently seen texts: the same messages are
+// counted on every render of the status line and /context. It is keyed on
+// a hash of the encoding and the text, so it does not hold on to the
+// texts themselves.
+const maxCacheEntries = 8192
+
+type cacheKey [sha256.Size]byte
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[cacheKey]int{}
+)
+
+func newCacheKey(encoding, text string) cacheKey {
+	h := sha256.New()
+	h.Write([]byte(encoding))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+func cachedCount(e *encoding, text string) int {
+	key := newCacheKey(e.name, text)
+
+	cacheMu.Lock()
+	n, ok := cache[key]
+	cacheMu.Unlock()
+	if ok {
+		return n
+	}
+
+	n = e.count(text)
+
+	cacheMu.Lock()
+	if len(cache) >= maxCacheEntries {
+		cache = map[cacheKey]int{}
+	}
+	cache[key] = n
+	cacheMu.Unlock()
+	return n
+}
@@ -0,0 +1,221 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zipcode/src/config"
+)
+
+func TestMain(m *testing.M) {
+	// Headless without debug keeps the missing-file log out of ./logs.txt.
+	config.Cfg.Headless = true
+	os.Exit(m.Run())
+}
+
+func split(s splitter, text string) []string {
+	var pieces []string
+	s(text, func(piece string) { pieces = append(pieces, piece) })
+	return pieces
+}
+
+func TestSplitCl100k(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"I'm here, don't go", []string{"I", "'m", " here", ",", " don", "'t", " go"}},
+		{"12345", []string{"123", "45"}},
+		{"foo  bar", []string{"foo", " ", " bar"}},
+		{"a\n\nb", []string{"a", "\n\n", "b"}},
+		{"x = y;\n", []string{"x", " =", " y", ";\n"}},
+		{"HelloWorld", []string{"HelloWorld"}},
+		{"http://x.io/a", []string{"http", "://", "x", ".io", "/a"}},
+		{"end  ", []string{"end", "  "}},
+		{"привет мир", []string{"привет", " мир"}},
+	}
+	for _, tt := range tests {
+		if got := split(splitCl100k, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCl100k(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitO200k(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"HelloWorld", []string{"Hello", "World"}},
+		{"URLs", []string{"URLs"}},
+		{"I'm here, don't go", []string{"I'm", " here", ",", " don't", " go"}},
+		{"12345", []string{"123", "45"}},
+		{"path/to/", []string{"path", "/to", "/"}},
+		{"a;/\nb", []string{"a", ";/\n", "b"}},
+	}
+	for _, tt := range tests {
+		if got := split(splitO200k, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitO200k(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// writeVocab writes ranks as a .tiktoken file named after encoding in dir.
+func writeVocab(t *testing.T, dir, encoding string, ranks ...string) {
+	t.Helper()
+	var b strings.Builder
+	for rank, token := range ranks {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	if err := os.WriteFile(filepath.Join(dir, encoding+".tiktoken"), []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var fixtureVocab = []string{"a", "b", "c", " ", "ab", "bc", "abc", "aa"}
+
+func TestCountPiece(t *testing.T) {
+	dir := t.TempDir()
+	writeVocab(t, dir, Cl100kBase, fixtureVocab...)
+	e, err := loadEncoding(Cl100kBase, filepath.Join(dir, Cl100kBase+".tiktoken"), splitCl100k)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	tests := []struct {
+		piece string
+		want  int
+	}{
+		{"", 0},
+		{"abc", 1},
+		// ab merges first (rank 4), then abc (rank 6): abc|ab.
+		{"abcab", 2},
+		// ab (rank 4) before bc (rank 5), and abbc is not a token: ab|bc.
+		{"abbc", 2},
+		{"aaaa", 2},
+		{"xyz", 3},
+	}
+	for _, tt := range tests {
+		if got := e.countPiece(tt.piece); got != tt.want {
+			t.Errorf("countPiece(%q) = %d, want %d", tt.piece, got, tt.want)
+		}
+	}
+
+	// Long pieces are counted in maxPieceBytes chunks: 256 + 244 pairs.
+	if got := e.count(strings.Repeat("a", 1000)); got != 500 {
+		t.Errorf("count of 1000 a's = %d, want 500", got)
+	}
+}
+
+func TestLoadEncodingErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"empty":     "",
+		"no rank":   "YQ==\n",
+		"bad token": "!!! 1\n",
+		"bad rank":  "YQ== one\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name+".tiktoken")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadEncoding(name, path, splitCl100k); err == nil {
+			t.Errorf("%s: loaded, want an error", name)
+		}
+	}
+}
+
+func TestFamilyFor(t *testing.T) {
+	tests := []struct {
+		model string
+		want  family
+	}{
+		{"gpt-4o-mini", familyO200k},
+		{"gpt-5.2", familyO200k},
+		{"openai/gpt-4.1", familyO200k},
+		{"o3-mini", familyO200k},
+		{"gpt-5.3-codex", familyO200k},
+		{"openai/gpt-oss-120b", familyO200k},
+		{"gpt-4-turbo", familyCl100k},
+		{"GPT-3.5-turbo", familyCl100k},
+		{"claude-sonnet-4-5", familyClaude},
+		{"anthropic/claude-haiku-4.5", familyClaude},
+		{"gemini-2.5-pro", familyOther},
+		{"qwen/qwen3-coder", familyOther},
+	}
+	for _, tt := range tests {
+		if got := familyFor(tt.model); got != tt.want {
+			t.Errorf("familyFor(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+// useTokenizersDir points the package at dir and forgets what it loaded.
+func useTokenizersDir(t *testing.T, dir string) {
+	t.Helper()
+	previous := config.Cfg.TokenizersPath
+	reset := func() {
+		encodingsMu.Lock()
+		encodings = map[string]*encoding{}
+		missing = map[string]bool{}
+		encodingsMu.Unlock()
+		cacheMu.Lock()
+		cache = map[cacheKey]int{}
+		cacheMu.Unlock()
+	}
+	config.Cfg.TokenizersPath = dir
+	reset()
+	t.Cleanup(func() {
+		config.Cfg.TokenizersPath = previous
+		reset()
+	})
+}
+
+func TestCountEstimatesWithoutEncodings(t *testing.T) {
+	useTokenizersDir(t, t.TempDir())
+	text := "func main() {\n\tfmt.Println(\"hello\")\n}\n"
+
+	if Available("gpt-4o") {
+		t.Error("gpt-4o is available without its encoding file")
+	}
+	n, exact := Count("gpt-4o", text)
+	if exact || n != estimate(text) {
+		t.Errorf("gpt-4o = %d, %v, want the estimate %d", n, exact, estimate(text))
+	}
+	n, exact = Count("claude-sonnet-4-5", text)
+	if exact || n != scaled(estimate(text), familyClaude.scale) {
+		t.Errorf("claude = %d, %v, want the scaled estimate", n, exact)
+	}
+	if n, exact := Count("gpt-4o", ""); n != 0 || !exact {
+		t.Errorf("empty text = %d, %v", n, exact)
+	}
+}
+
+func TestCountWithEncodings(t *testing.T) {
+	dir := t.TempDir()
+	useTokenizersDir(t, dir)
+	writeVocab(t, dir, Cl100kBase, fixtureVocab...)
+
+	// "abc abc" splits as "abc", " abc"; " abc" is " " + "abc".
+	if n, exact := Count("gpt-4-turbo", "abc abc"); n != 3 || !exact {
+		t.Errorf("gpt-4 = %d, %v, want an exact 3", n, exact)
+	}
+	if n, exact := Count("claude-sonnet-4-5", strings.Repeat("abc ", 20)); n != scaled(40, familyClaude.scale) || exact {
+		t.Errorf("claude = %d, %v, want 40 scaled", n, exact)
+	}
+	// o200k is missing, so gpt-4o falls back to cl100k as an estimate.
+	if Available("gpt-4o") || !Available("gpt-4") {
+		t.Error("availability does not follow the installed files")
+	}
+	if n, exact := Count("gpt-4o", "abc abc"); n != 3 || exact {
+		t.Errorf("gpt-4o = %d, %v, want 3 as an estimate", n, exact)
+	}
+}
@@ -0,0 +1,394 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"zipcode/src/config"
+	"zipcode/src/tools"
+)
+
+// protocolVersion is the MCP revision the client speaks. Servers answer
+// with the version they support; the subset used here is the same in all
+// released revisions.
+const protocolVersion = "2025-06-18"
+
+// maxMessageSize bounds one JSON-RPC line from a server.
+const maxMessageSize = 16 * 1024 * 1024
+
+// stderrTailSize is how much of a server's stderr is kept for error
+// messages.
+const stderrTailSize = 4096
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string    `json:"jsonrpc"`
+	ID      any       `json:"id"`
+	Result  any       `json:"result,omitempty"`
+	Error   *rpcError `json:"error,omitempty"`
+}
+
+type rpcIncoming struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Client talks to one MCP server over its stdin/stdout using
+// newline-delimited JSON-RPC.
+type Client struct {
+	Name   string
+	Config config.MCPServerConfig
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *tailBuffer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int64]chan rpcIncoming
+	nextID  int64
+
+	done    chan struct{}
+	exitErr error
+}
+
+// Start launches the server and performs the initialize handshake. The
+// server keeps running after ctx ends; ctx only bounds the handshake.
+func Start(ctx context.Context, name string, cfg config.MCPServerConfig) (*Client, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("mcp server %s: command is not set", name)
+	}
+
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = "."
+	// Like external tools, servers only see the base environment and what
+	// their configuration sets, not the keys in this process's.
+	cmd.Env = tools.InheritEnv(tools.BaseEnv)
+	for key, value := range cfg.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	c := &Client{
+		Name:    name,
+		Config:  cfg,
+		cmd:     cmd,
+		stderr:  &tailBuffer{limit: stderrTailSize},
+		pending: map[int64]chan rpcIncoming{},
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = c.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.stdin = stdin
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", name, err)
+	}
+
+	go c.readLoop(stdout)
+
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp server %s: initialize: %w", name, err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	params := map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "zipcode",
+			"version": config.Cfg.AppVersion,
+		},
+	}
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := c.call(ctx, "initialize", params, &result); err != nil {
+		return err
+	}
+
+	return c.notify("notifications/initialized", nil)
+}
+
+// Tool is an entry of a tools/list result.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// ListTools returns every tool the server offers, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var all []Tool
+	cursor := ""
+
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		all = append(all, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			return all, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// ContentBlock is one item of a tools/call result.
+type ContentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Resource *struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType,omitempty"`
+		Text     string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+type CallResult struct {
+	Content           []ContentBlock  `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError,omitempty"`
+}
+
+// CallTool invokes a tool by its server-side name.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (CallResult, error) {
+	if len(strings.TrimSpace(string(arguments))) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	if c.Config.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.Config.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	var result CallResult
+	err := c.call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": arguments,
+	}, &result)
+	return result, err
+}
+
+// Close ends the session by closing stdin, which is how stdio servers are
+// asked to exit, and kills the server if it does not.
+func (c *Client) Close() error {
+	c.stdin.Close()
+
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params any, out any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcIncoming, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if out == nil || len(msg.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, out)
+
+	case <-c.done:
+		return c.exitError()
+
+	case <-ctx.Done():
+		c.notify("notifications/cancelled", map[string]any{
+			"requestId": id,
+			"reason":    ctx.Err().Error(),
+		})
+		return ctx.Err()
+	}
+}
+
+func (c *Client) notify(method string, params any) error {
+	return c.write(rpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (c *Client) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.exitError()
+	default:
+	}
+
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (c *Client) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var msg rpcIncoming
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			continue
+		}
+
+		if msg.Method != "" {
+			if len(msg.ID) > 0 {
+				c.answerServerRequest(msg)
+			}
+			// Notifications (progress, logging, list_changed) are ignored.
+			continue
+		}
+
+		var id int64
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+
+	err := c.cmd.Wait()
+	if scanErr := scanner.Err(); scanErr != nil {
+		err = scanErr
+	}
+	c.exitErr = err
+	close(c.done)
+}
+
+// answerServerRequest replies to requests the server sends us. Only ping is
+// supported; the client declares no other capabilities.
+func (c *Client) answerServerRequest(msg rpcIncoming) {
+	var id any
+	_ = json.Unmarshal(msg.ID, &id)
+
+	response := rpcResponse{JSONRPC: "2.0", ID: id}
+	if msg.Method == "ping" {
+		response.Result = map[string]any{}
+	} else {
+		response.Error = &rpcError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+	c.write(response)
+}
+
+func (c *Client) exitError() error {
+	message := fmt.Sprintf("mcp server %s exited", c.Name)
+	if c.exitErr != nil {
+		message += ": " + c.exitErr.Error()
+	}
+	if tail := strings.TrimSpace(c.stderr.String()); tail != "" {
+		message += "\n" + tail
+	}
+	return errors.New(message)
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.limit; over > 0 {
+		b.data = b.data[over:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"zipcode/src/config"
+)
+
+// TestHelperServer is not a test: the tests below run the test binary
+// again with ZIPCODE_MCP_HELPER set, and it then acts as an MCP server.
+func TestHelperServer(t *testing.T) {
+	if os.Getenv("ZIPCODE_MCP_HELPER") != "1" {
+		return
+	}
+	serveHelper()
+	os.Exit(0)
+}
+
+func serveHelper() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var request struct {
+			ID     *int64          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil || request.ID == nil {
+			continue
+		}
+
+		var result any
+		switch request.Method {
+		case "initialize":
+			result = map[string]any{"protocolVersion": protocolVersion}
+		case "tools/list":
+			var params struct {
+				Cursor string `json:"cursor"`
+			}
+			json.Unmarshal(request.Params, &params)
+			if params.Cursor == "" {
+				result = map[string]any{
+					"tools":      []Tool{{Name: "echo"}, {Name: "fail"}},
+					"nextCursor": "page-2",
+				}
+			} else {
+				result = map[string]any{"tools": []Tool{{Name: "env"}, {Name: "image"}, {Name: "crash"}}}
+			}
+		case "tools/call":
+			var params struct {
+				Name      string            `json:"name"`
+				Arguments map[string]string `json:"arguments"`
+			}
+			json.Unmarshal(request.Params, &params)
+			switch params.Name {
+			case "echo":
+				result = CallResult{Content: []ContentBlock{{Type: "text", Text: params.Arguments["text"]}}}
+			case "fail":
+				result = CallResult{Content: []ContentBlock{{Type: "text", Text: "no such file"}}, IsError: true}
+			case "env":
+				result = CallResult{Content: []ContentBlock{{
+					Type: "text",
+					Text: os.Getenv("ZIPCODE_MCP_SECRET") + "|" + os.Getenv("ZIPCODE_MCP_CONFIGURED"),
+				}}}
+			case "image":
+				result = CallResult{Content: []ContentBlock{
+					{Type: "text", Text: "a screenshot"},
+					{Type: "image", Data: "iVBORw0KGgo=", MimeType: "image/png"},
+				}}
+			case "crash":
+				fmt.Fprintln(os.Stderr, "helper crashed")
+				os.Exit(3)
+			}
+		}
+
+		data, _ := json.Marshal(rpcResponse{JSONRPC: "2.0", ID: *request.ID, Result: result})
+		os.Stdout.Write(append(data, '\n'))
+	}
+}
+
+func startHelper(t *testing.T) *Client {
+	t.Helper()
+	t.Setenv("ZIPCODE_MCP_SECRET", "leaked")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := Start(ctx, "helper", config.MCPServerConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestHelperServer$"},
+		Env: map[string]string{
+			"ZIPCODE_MCP_HELPER":     "1",
+			"ZIPCODE_MCP_CONFIGURED": "configured",
+		},
+	})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func callText(t *testing.T, client *Client, name, arguments string) CallResult {
+	t.Helper()
+	result, err := client.CallTool(context.Background(), name, json.RawMessage(arguments))
+	if err != nil {
+		t.Fatalf("call %s: %v", name, err)
+	}
+	return result
+}
+
+func TestClientListsAllPages(t *testing.T) {
+	client := startHelper(t)
+
+	list, err := client.ListTools(context.Background())
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var names []string
+	for _, tool := range list {
+		names = append(names, tool.Name)
+	}
+	if got, want := strings.Join(names, ","), "echo,fail,env,image,crash"; got != want {
+		t.Fatalf("tools = %s, want %s", got, want)
+	}
+}
+
+func TestClientCallsTools(t *testing.T) {
+	client := startHelper(t)
+
+	result := callText(t, client, "echo", `{"text": "hello"}`)
+	if text, _ := resultContent(result); result.IsError || text != "hello" {
+		t.Errorf("echo = %q (error %v)", text, result.IsError)
+	}
+
+	result = callText(t, client, "fail", "")
+	if text, _ := resultContent(result); !result.IsError || text != "no such file" {
+		t.Errorf("fail = %q (error %v), want an error result", text, result.IsError)
+	}
+
+	result = callText(t, client, "env", "")
+	if text, _ := resultContent(result); text != "|configured" {
+		t.Errorf("env = %q, want only the configured variable", text)
+	}
+}
+
+func TestToolHandlerReturnsImages(t *testing.T) {
+	client := startHelper(t)
+
+	handler := Handlers(client, []Tool{{Name: "image"}})[0]
+	result, err := handler.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Content != "a screenshot" {
+		t.Errorf("content = %q", result.Content)
+	}
+	if len(result.Attachments) != 1 || !result.Attachments[0].IsImage() ||
+		result.Attachments[0].Data != "iVBORw0KGgo=" {
+		t.Errorf("attachments = %+v, want the image", result.Attachments)
+	}
+}
+
+func TestClientReportsServerExit(t *testing.T) {
+	client := startHelper(t)
+
+	_, err := client.CallTool(context.Background(), "crash", nil)
+	if err == nil || !strings.Contains(err.Error(), "exited") || !strings.Contains(err.Error(), "helper crashed") {
+		t.Fatalf("err = %v, want the exit with the server's stderr", err)
+	}
+
+	if _, err := client.CallTool(context.Background(), "echo", nil); err == nil {
+		t.Fatal("call after exit succeeded")
+	}
+}
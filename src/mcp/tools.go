@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"zipcode/src/tools"
+)
+
+// maxToolNameLength is the longest tool name providers accept.
+const maxToolNameLength = 64
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ToolName is the name an MCP tool is registered under: mcp__<server>__<tool>,
+// restricted to the characters providers allow in function names.
+func ToolName(server, tool string) string {
+	name := "mcp__" + unsafeNameChars.ReplaceAllString(server, "_") +
+		"__" + unsafeNameChars.ReplaceAllString(tool, "_")
+	if len(name) > maxToolNameLength {
+		name = name[:maxToolNameLength]
+	}
+	return name
+}
+
+// ToolHandler exposes one MCP tool to the runtime. Calls go through the
+// executor like any other tool, so permission policy, approvals and
+// logging apply to them unchanged.
+type ToolHandler struct {
+	client *Client
+	tool   Tool
+	name   string
+}
+
+// Handlers wraps every tool of a connected server.
+func Handlers(client *Client, list []Tool) []tools.ToolHandler {
+	handlers := make([]tools.ToolHandler, 0, len(list))
+	for _, tool := range list {
+		handlers = append(handlers, &ToolHandler{
+			client: client,
+			tool:   tool,
+			name:   ToolName(client.Name, tool.Name),
+		})
+	}
+	return handlers
+}
+
+func (h *ToolHandler) Definition() tools.Tool {
+	description := h.tool.Description
+	if description == "" {
+		description = h.tool.Name
+	}
+
+	return tools.Tool{
+		Type: "function",
+		Function: tools.ToolFunction{
+			Name:        h.name,
+			Description: fmt.Sprintf("[MCP server %s] %s", h.client.Name, description),
+			Parameters:  inputSchema(h.tool.InputSchema),
+		},
+	}
+}
+
+func (h *ToolHandler) Run(ctx context.Context, arguments json.RawMessage) (tools.ToolResult, error) {
+	result, err := h.client.CallTool(ctx, h.tool.Name, arguments)
+	if err != nil {
+		return tools.ToolResult{}, err
+	}
+
+	content, attachments := resultContent(result)
+	return tools.ToolResult{
+		Content: content,
+		IsError: result.IsError,
+		Metadata: map[string]any{
+			"mcp_server": h.client.Name,
+			"mcp_tool":   h.tool.Name,
+		},
+		Attachments: attachments,
+	}, nil
+}
+
+// inputSchema keeps the server's schema as is, so keywords the local
+// JSONSchema type lacks (enum, anyOf, nested objects) reach the model. A
+// missing or non-object schema becomes an empty object schema.
+func inputSchema(raw json.RawMessage) tools.JSONSchema {
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil || schema == nil {
+		return tools.JSONSchema{Type: "object", Properties: map[string]tools.Schema{}}
+	}
+	if _, ok := schema["type"]; !ok {
+		schema["type"] = "object"
+	}
+
+	encoded, err := json.Marshal(schema)
+	if err != nil {
+		return tools.JSONSchema{Type: "object", Properties: map[string]tools.Schema{}}
+	}
+	return tools.JSONSchema{Raw: encoded}
+}
+
+// resultContent flattens the result content into the text sent to the
+// model, and returns images as attachments so the model sees them.
+// Structured content is used when the server sent no text.
+func resultContent(result CallResult) (string, []tools.Attachment) {
+	var parts []string
+	var attachments []tools.Attachment
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "image", "audio":
+			if block.Type == "image" && block.Data != "" && strings.HasPrefix(block.MimeType, "image/") {
+				attachments = append(attachments, tools.Attachment{
+					Name:      fmt.Sprintf("image-%d", len(attachments)+1),
+					MediaType: block.MimeType,
+					Data:      block.Data,
+				})
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("[%s content: %s]", block.Type, block.MimeType))
+		case "resource_link":
+			parts = append(parts, fmt.Sprintf("[resource: %s]", block.URI))
+		case "resource":
+			if block.Resource == nil {
+				continue
+			}
+			if block.Resource.Text != "" {
+				parts = append(parts, block.Resource.Text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource: %s]", block.Resource.URI))
+			}
+		}
+	}
+
+	if len(parts) == 0 && len(result.StructuredContent) > 0 {
+		return string(result.StructuredContent), attachments
+	}
+	return strings.Join(parts, "\n"), attachments
+}
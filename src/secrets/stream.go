@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// StreamRedactor redacts text that arrives in fragments, such as a
+// streamed model response. A secret can be split across fragments, so the
+// tail that could still turn into one, or grow, is held back until more
+// text settles it or the stream ends.
+type StreamRedactor struct {
+	pending string
+}
+
+// Push adds a fragment and returns the redacted text that is now safe to
+// show.
+func (s *StreamRedactor) Push(fragment string) string {
+	s.pending += fragment
+	n := settledLen(s.pending)
+	out := RedactForDisplay(s.pending[:n])
+	s.pending = s.pending[n:]
+	return out
+}
+
+// Flush returns the redacted text held back and resets the redactor.
+func (s *StreamRedactor) Flush() string {
+	out := RedactForDisplay(s.pending)
+	s.pending = ""
+	return out
+}
+
+// Patterns that span whitespace. A match of one may not be split, and
+// the open patterns are the prefixes a match could still grow from. The
+// single-word patterns are covered by holding back the last word.
+var (
+	awsSecret         = regexp.MustCompile(`(?i)aws_secret_access_key\s*=\s*\S+`)
+	pemPrivateKey     = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+	openAWSSecret     = regexp.MustCompile(`(?i)^aws_secret_access_key\s*(=\s*\S*)?$`)
+	openGCPPrivateKey = regexp.MustCompile(`^"private_key"\s*(:\s*("[^"]*)?)?$`)
+)
+
+// settledLen returns how much of content redacts the same whatever text
+// follows it.
+func settledLen(content string) int {
+	n := strings.LastIndexFunc(content, unicode.IsSpace) + 1
+
+	if i := strings.LastIndex(strings.ToLower(content), "aws_secret_access_key"); i >= 0 && i < n {
+		if openAWSSecret.MatchString(content[i:]) {
+			n = i
+		}
+	}
+	if i := strings.LastIndex(content, `"private_key"`); i >= 0 && i < n {
+		if openGCPPrivateKey.MatchString(content[i:]) {
+			n = i
+		}
+	}
+	if i := strings.LastIndex(content, "-----BEGIN "); i >= 0 && i < n {
+		header := strings.TrimPrefix(content[i:], "-----BEGIN ")
+		end := strings.Index(header, "-----")
+		if end < 0 || (strings.Contains(header[:end], "PRIVATE KEY") && !pemPrivateKey.MatchString(content[i:])) {
+			n = i
+		}
+	}
+
+	// Moving the cut back can split an earlier match, so repeat until none
+	// spans it.
+	for moved := true; moved; {
+		moved = false
+		for _, re := range []*regexp.Regexp{awsSecret, gcpPrivateKey, pemPrivateKey} {
+			for _, idx := range re.FindAllStringIndex(content, -1) {
+				if idx[0] < n && idx[1] > n {
+					n = idx[0]
+					moved = true
+				}
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package subagents
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+func ensureDir(path string) {
+	_ = os.MkdirAll(path, 0755)
+}
+
+func Init(internalDir, globalDir, projectDir, stateFile string) (*Registry, *Watcher, error) {
+	internalDir = expandHome(internalDir)
+	globalDir = expandHome(globalDir)
+	projectDir = expandHome(projectDir)
+	stateFile = expandHome(stateFile)
+
+	ensureDir(globalDir)
+	ensureDir(projectDir)
+
+	registry := NewRegistry()
+	if err := registry.SetStateFile(stateFile); err != nil {
+		return registry, nil, err
+	}
+
+	merged, err := LoadAll(internalDir, globalDir, projectDir)
+	if err != nil {
+		return registry, nil, err
+	}
+	registry.replace(merged)
+
+	watcher, err := StartWatcher(registry, internalDir, globalDir, projectDir)
+	if err != nil {
+		return registry, nil, err
+	}
+
+	return registry, watcher, nil
+}
@@ -0,0 +1,238 @@
+package subagents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	llm "zipcode/src/llm/provider"
+)
+
+type internalSubagentManifest struct {
+	Name             string   `json:"name"`
+	ShortDescription string   `json:"short_description"`
+	SystemPrompt     string   `json:"system_prompt"`
+	AllowedTools     []string `json:"allowed_tools"`
+	Provider         string   `json:"provider,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	Temperature      float64  `json:"temperature,omitempty"`
+	ReasoningEffort  string   `json:"reasoning_effort,omitempty"`
+}
+
+// parseMarkdownSubagent reads a definition of the form
+//
+//	---
+//	name: reviewer
+//	description: Reviews a diff for bugs and style issues
+//	tools: file_read, code_search
+//	provider: openrouter
+//	model: anthropic/claude-haiku-4.5
+//	max_tokens: 4096
+//	temperature: 0.2
+//	reasoning_effort: low
+//	---
+//	You are a code reviewer...
+//
+// where the body is the system prompt. tools may also be written as a
+// [a, b] list; provider, model, max_tokens, temperature and
+// reasoning_effort are optional.
+func parseMarkdownSubagent(content string) (*Subagent, error) {
+	if !strings.HasPrefix(content, "---") {
+		return nil, errors.New("missing frontmatter")
+	}
+
+	rest := strings.TrimPrefix(content, "---")
+	rest = strings.TrimLeft(rest, "\r\n")
+
+	end := strings.Index(rest, "\n---")
+	if end == -1 {
+		return nil, errors.New("unterminated frontmatter")
+	}
+
+	frontmatter := rest[:end]
+	s := &Subagent{
+		SystemPrompt: strings.TrimSpace(rest[end+len("\n---"):]),
+		Enabled:      true,
+	}
+
+	for _, line := range strings.Split(frontmatter, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx == -1 {
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+
+		switch key {
+		case "name":
+			s.Name = strings.Trim(value, `"'`)
+		case "description":
+			s.Description = strings.Trim(value, `"'`)
+		case "tools":
+			s.AllowedTools = parseList(value)
+		case "provider":
+			s.Provider = strings.Trim(value, `"'`)
+		case "model":
+			s.Model = strings.Trim(value, `"'`)
+		case "max_tokens":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid max_tokens %q", value)
+			}
+			s.MaxTokens = n
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil || t < 0 {
+				return nil, fmt.Errorf("invalid temperature %q", value)
+			}
+			s.Temperature = t
+		case "reasoning_effort":
+			effort, err := llm.ParseEffort(strings.Trim(value, `"'`))
+			if err != nil {
+				return nil, err
+			}
+			s.ReasoningEffort = effort
+		}
+	}
+
+	if s.Name == "" {
+		return nil, errors.New("subagent missing name")
+	}
+	if s.SystemPrompt == "" {
+		return nil, errors.New("subagent missing system prompt")
+	}
+
+	return s, nil
+}
+
+func parseList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"'`)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func loadInternalSubagents(dir string) (map[string]*Subagent, error) {
+	out := map[string]*Subagent{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var m internalSubagentManifest
+		if err := json.Unmarshal(content, &m); err != nil {
+			continue
+		}
+		if m.Name == "" {
+			continue
+		}
+
+		out[m.Name] = &Subagent{
+			Name:            m.Name,
+			Description:     m.ShortDescription,
+			SystemPrompt:    m.SystemPrompt,
+			AllowedTools:    m.AllowedTools,
+			Provider:        m.Provider,
+			Model:           m.Model,
+			MaxTokens:       m.MaxTokens,
+			Temperature:     m.Temperature,
+			ReasoningEffort: m.ReasoningEffort,
+			Source:          SourceInternal,
+			Path:            path,
+			Enabled:         true,
+		}
+	}
+	return out, nil
+}
+
+func loadMarkdownSubagents(dir string, source SubagentSource) (map[string]*Subagent, error) {
+	out := map[string]*Subagent{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		s, err := parseMarkdownSubagent(string(content))
+		if err != nil {
+			continue
+		}
+		s.Source = source
+		s.Path = path
+
+		out[s.Name] = s
+	}
+	return out, nil
+}
+
+// LoadAll merges the three directories. A project definition overrides a
+// global one of the same name, which overrides an internal one.
+func LoadAll(internalDir, globalDir, projectDir string) (map[string]*Subagent, error) {
+	merged := map[string]*Subagent{}
+
+	internal, err := loadInternalSubagents(internalDir)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range internal {
+		merged[k] = v
+	}
+
+	global, err := loadMarkdownSubagents(globalDir, SourceGlobal)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range global {
+		merged[k] = v
+	}
+
+	project, err := loadMarkdownSubagents(projectDir, SourceProject)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range project {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
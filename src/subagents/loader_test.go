@@ -0,0 +1,246 @@
+package subagents
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reviewerMarkdown = `---
+name: reviewer
+description: "Reviews a diff for bugs"
+tools: [file_read, 'code_search']
+provider: openrouter
+model: anthropic/claude-haiku-4.5
+max_tokens: 4096
+temperature: 0.2
+reasoning_effort: low
+---
+
+You are a code reviewer.
+`
+
+func TestParseMarkdownSubagent(t *testing.T) {
+	s, err := parseMarkdownSubagent(reviewerMarkdown)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := &Subagent{
+		Name:            "reviewer",
+		Description:     "Reviews a diff for bugs",
+		SystemPrompt:    "You are a code reviewer.",
+		AllowedTools:    []string{"file_read", "code_search"},
+		Provider:        "openrouter",
+		Model:           "anthropic/claude-haiku-4.5",
+		MaxTokens:       4096,
+		Temperature:     0.2,
+		ReasoningEffort: "low",
+		Enabled:         true,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("parsed\n%+v\nwant\n%+v", s, want)
+	}
+
+	// CRLF line endings and a bare comma list parse the same way.
+	crlf := strings.ReplaceAll("---\nname: a\ntools: file_read, code_search\n---\nPrompt\n", "\n", "\r\n")
+	s, err = parseMarkdownSubagent(crlf)
+	if err != nil || s.Name != "a" || !reflect.DeepEqual(s.AllowedTools, want.AllowedTools) {
+		t.Errorf("CRLF parse = %+v, %v", s, err)
+	}
+}
+
+func TestParseMarkdownSubagentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"no frontmatter", "name: a\n", "missing frontmatter"},
+		{"unterminated", "---\nname: a\n", "unterminated frontmatter"},
+		{"no name", "---\ndescription: x\n---\nPrompt", "missing name"},
+		{"no prompt", "---\nname: a\n---\n", "missing system prompt"},
+		{"bad max_tokens", "---\nname: a\nmax_tokens: lots\n---\nPrompt", "invalid max_tokens"},
+		{"bad temperature", "---\nname: a\ntemperature: -1\n---\nPrompt", "invalid temperature"},
+		{"bad effort", "---\nname: a\nreasoning_effort: extreme\n---\nPrompt", "extreme"},
+	}
+	for _, tt := range tests {
+		if _, err := parseMarkdownSubagent(tt.content); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// subagentDirs creates internal, global and project directories under a
+// temp dir.
+func subagentDirs(t *testing.T) (internal, global, project string) {
+	t.Helper()
+	root := t.TempDir()
+	internal = filepath.Join(root, "internal")
+	global = filepath.Join(root, "global")
+	project = filepath.Join(root, "project")
+	for _, dir := range []string{internal, global, project} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return internal, global, project
+}
+
+func writeInternal(t *testing.T, dir, name, description string) {
+	t.Helper()
+	data, err := json.Marshal(internalSubagentManifest{
+		Name:             name,
+		ShortDescription: description,
+		SystemPrompt:     "Internal " + name,
+		AllowedTools:     []string{"file_read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, name+".json"), string(data))
+}
+
+func writeMarkdown(t *testing.T, dir, name, description string) {
+	t.Helper()
+	writeFile(t, filepath.Join(dir, name+".md"),
+		"---\nname: "+name+"\ndescription: "+description+"\n---\nPrompt for "+name+"\n")
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAllPrecedence(t *testing.T) {
+	internal, global, project := subagentDirs(t)
+	writeInternal(t, internal, "explorer", "internal explorer")
+	writeInternal(t, internal, "investigator", "internal investigator")
+	writeMarkdown(t, global, "explorer", "global explorer")
+	writeMarkdown(t, global, "reviewer", "global reviewer")
+	writeMarkdown(t, project, "reviewer", "project reviewer")
+	writeMarkdown(t, project, "tester", "project tester")
+	// Files that are not definitions, or are invalid, are skipped.
+	writeFile(t, filepath.Join(internal, "notes.txt"), "not a manifest")
+	writeFile(t, filepath.Join(internal, "broken.json"), "{")
+	writeFile(t, filepath.Join(project, "broken.md"), "no frontmatter")
+
+	merged, err := LoadAll(internal, global, project)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := map[string]struct {
+		description string
+		source      SubagentSource
+	}{
+		"explorer":     {"global explorer", SourceGlobal},
+		"investigator": {"internal investigator", SourceInternal},
+		"reviewer":     {"project reviewer", SourceProject},
+		"tester":       {"project tester", SourceProject},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("loaded %d sub-agents, want %d: %v", len(merged), len(want), merged)
+	}
+	for name, w := range want {
+		s := merged[name]
+		if s == nil || s.Description != w.description || s.Source != w.source {
+			t.Errorf("%s = %+v, want %q from %s", name, s, w.description, w.source)
+		}
+	}
+	if path := merged["reviewer"].Path; path != filepath.Join(project, "reviewer.md") {
+		t.Errorf("reviewer path = %s", path)
+	}
+}
+
+func TestLoadAllMissingDirs(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	merged, err := LoadAll(missing, missing, missing)
+	if err != nil || len(merged) != 0 {
+		t.Errorf("LoadAll = %v, %v, want nothing and no error", merged, err)
+	}
+}
+
+func TestTool(t *testing.T) {
+	tool := Tool([]*Subagent{
+		{Name: "explorer", Description: "Maps unfamiliar code"},
+		{Name: "reviewer", Description: "Reviews a diff for bugs"},
+	})
+	if tool.Function.Name != ToolName {
+		t.Errorf("name = %s", tool.Function.Name)
+	}
+	params := tool.Function.Parameters
+	if !reflect.DeepEqual(params.Required, []string{"agent", "task"}) {
+		t.Errorf("required = %v", params.Required)
+	}
+	agent := params.Properties["agent"]
+	if !reflect.DeepEqual(agent.Enum, []any{"explorer", "reviewer"}) {
+		t.Errorf("agent enum = %v", agent.Enum)
+	}
+	wantDescription := "Which specialist to run.\nexplorer: Maps unfamiliar code\nreviewer: Reviews a diff for bugs"
+	if agent.Description != wantDescription {
+		t.Errorf("agent description = %q, want %q", agent.Description, wantDescription)
+	}
+	for _, name := range []string{"task", "context"} {
+		if params.Properties[name].Type != "string" {
+			t.Errorf("%s property = %+v", name, params.Properties[name])
+		}
+	}
+
+	// With nothing registered there is no enum to choose from.
+	empty := Tool(nil).Function.Parameters.Properties["agent"]
+	if empty.Enum != nil || !strings.Contains(empty.Description, "No sub-agents are configured") {
+		t.Errorf("empty agent property = %+v", empty)
+	}
+}
+
+func TestWatcherReloadsDefinitions(t *testing.T) {
+	internal, global, project := subagentDirs(t)
+	writeMarkdown(t, global, "reviewer", "global reviewer")
+
+	registry, watcher, err := Init(internal, global, project, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer watcher.Stop()
+	if s, ok := registry.Get("reviewer"); !ok || s.Source != SourceGlobal {
+		t.Fatalf("reviewer = %+v, want the global definition", s)
+	}
+	if err := registry.Disable("reviewer"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeMarkdown(t, project, "reviewer", "project reviewer")
+	waitFor(t, func() bool {
+		s, _ := registry.Get("reviewer")
+		return s.Source == SourceProject
+	})
+	// A reload keeps the sub-agent disabled.
+	if s, _ := registry.Get("reviewer"); s.Enabled {
+		t.Errorf("reloaded reviewer is enabled again")
+	}
+
+	if err := os.Remove(filepath.Join(project, "reviewer.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		s, _ := registry.Get("reviewer")
+		return s.Source == SourceGlobal
+	})
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the watcher to reload")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
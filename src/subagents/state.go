@@ -0,0 +1,212 @@
+package subagents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type persistedState struct {
+	Disabled []string `json:"disabled"`
+}
+
+func (r *Registry) SetStateFile(path string) error {
+	r.mu.Lock()
+	r.stateFile = path
+	r.mu.Unlock()
+	return r.loadState()
+}
+
+func (r *Registry) loadState() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var st persistedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	disabled := map[string]bool{}
+	for _, name := range st.Disabled {
+		disabled[name] = true
+	}
+	r.disabledSet = disabled
+
+	for name, s := range r.subagents {
+		if disabled[name] {
+			s.Enabled = false
+		}
+	}
+	return nil
+}
+
+func (r *Registry) saveStateLocked() error {
+	if r.stateFile == "" {
+		return nil
+	}
+
+	disabled := []string{}
+	for name := range r.disabledSet {
+		disabled = append(disabled, name)
+	}
+
+	data, err := json.MarshalIndent(persistedState{Disabled: disabled}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.stateFile, data, 0644)
+}
+
+func (r *Registry) Enable(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.subagents[name]
+	if !ok {
+		return errors.New("subagent not found")
+	}
+	s.Enabled = true
+	delete(r.disabledSet, name)
+	return r.saveStateLocked()
+}
+
+func (r *Registry) Disable(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.subagents[name]
+	if !ok {
+		return errors.New("subagent not found")
+	}
+	s.Enabled = false
+	r.disabledSet[name] = true
+	return r.saveStateLocked()
+}
+
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+
+	s, ok := r.subagents[name]
+	if !ok {
+		r.mu.Unlock()
+		return errors.New("subagent not found")
+	}
+
+	if s.Source == SourceInternal {
+		r.mu.Unlock()
+		return errors.New("cannot delete internal subagent")
+	}
+
+	path := s.Path
+	delete(r.subagents, name)
+	delete(r.disabledSet, name)
+	saveErr := r.saveStateLocked()
+	r.mu.Unlock()
+
+	if path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return saveErr
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidName reports whether name can be used for a sub-agent: it becomes
+// both the file name and a value of the subagent tool's enum.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Create writes s as <dir>/<name>.md and adds it to the registry. It does
+// not overwrite an existing definition with the same name in any source.
+func (r *Registry) Create(s *Subagent, dir string) error {
+	if !ValidName(s.Name) {
+		return fmt.Errorf("invalid name %q: use letters, digits, - and _", s.Name)
+	}
+	if strings.TrimSpace(s.SystemPrompt) == "" {
+		return errors.New("system prompt is empty")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.subagents[s.Name]; exists {
+		return fmt.Errorf("subagent %s already exists", s.Name)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, s.Name+".md")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(formatMarkdownSubagent(s))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	s.Path = path
+	s.Enabled = true
+	r.subagents[s.Name] = s
+	return nil
+}
+
+func formatMarkdownSubagent(s *Subagent) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	b.WriteString("name: " + s.Name + "\n")
+	if s.Description != "" {
+		b.WriteString("description: " + s.Description + "\n")
+	}
+	if len(s.AllowedTools) > 0 {
+		b.WriteString("tools: " + strings.Join(s.AllowedTools, ", ") + "\n")
+	}
+	if s.Provider != "" {
+		b.WriteString("provider: " + s.Provider + "\n")
+	}
+	if s.Model != "" {
+		b.WriteString("model: " + s.Model + "\n")
+	}
+	if s.MaxTokens > 0 {
+		b.WriteString("max_tokens: " + strconv.Itoa(s.MaxTokens) + "\n")
+	}
+	if s.Temperature > 0 {
+		b.WriteString("temperature: " + strconv.FormatFloat(s.Temperature, 'f', -1, 64) + "\n")
+	}
+	if s.ReasoningEffort != "" {
+		b.WriteString("reasoning_effort: " + s.ReasoningEffort + "\n")
+	}
+	b.WriteString("---\n")
+	b.WriteString(strings.TrimSpace(s.SystemPrompt) + "\n")
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package subagents
+
+import (
+	"sort"
+	"sync"
+)
+
+type SubagentSource string
+
+const (
+	SourceInternal SubagentSource = "internal"
+	SourceGlobal   SubagentSource = "global"
+	SourceProject  SubagentSource = "project"
+)
+
+// Subagent is a specialist the main agent can delegate a task to. It runs
+// with its own system prompt and only the tools it is allowed.
+type Subagent struct {
+	Name         string
+	Description  string
+	SystemPrompt string
+	AllowedTools []string
+	// Provider and Model override what the sub-agent runs with. An empty
+	// Provider means the active one; an empty Model means the current
+	// model, or the provider's default when Provider is set.
+	Provider string
+	Model    string
+	// MaxTokens and Temperature are sent with each request when non-zero.
+	MaxTokens   int
+	Temperature float64
+	// ReasoningEffort overrides the model's configured reasoning effort.
+	ReasoningEffort string
+	Source          SubagentSource
+	Path            string
+	Enabled         bool
+}
+
+type Registry struct {
+	mu          sync.RWMutex
+	subagents   map[string]*Subagent
+	stateFile   string
+	disabledSet map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		subagents:   map[string]*Subagent{},
+		disabledSet: map[string]bool{},
+	}
+}
+
+func (r *Registry) Get(name string) (*Subagent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.subagents[name]
+	return s, ok
+}
+
+func (r *Registry) List() []*Subagent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Subagent, 0, len(r.subagents))
+	for _, s := range r.subagents {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (r *Registry) ListEnabled() []*Subagent {
+	all := r.List()
+	out := make([]*Subagent, 0, len(all))
+	for _, s := range all {
+		if s.Enabled {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (r *Registry) replace(subagents map[string]*Subagent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range subagents {
+		if r.disabledSet[s.Name] {
+			s.Enabled = false
+		}
+	}
+	r.subagents = subagents
+}
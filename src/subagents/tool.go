@@ -0,0 +1,60 @@
+package subagents
+
+import (
+	"fmt"
+	"strings"
+
+	"zipcode/src/tools"
+)
+
+// ToolName is the tool the model calls to run a sub-agent.
+const ToolName = "subagent"
+
+// Tool builds the subagent tool schema from the given sub-agents, listing
+// each one with its description so the model can pick the right specialist.
+func Tool(list []*Subagent) tools.Tool {
+	var agentDescription strings.Builder
+	agentDescription.WriteString("Which specialist to run.")
+	if len(list) == 0 {
+		agentDescription.WriteString(" No sub-agents are configured.")
+	}
+
+	names := make([]any, 0, len(list))
+	for _, s := range list {
+		names = append(names, s.Name)
+		fmt.Fprintf(&agentDescription, "\n%s: %s", s.Name, s.Description)
+	}
+
+	agent := tools.Schema{
+		Type:        "string",
+		Description: agentDescription.String(),
+	}
+	if len(names) > 0 {
+		agent.Enum = names
+	}
+
+	return tools.Tool{
+		Type: "function",
+		Function: tools.ToolFunction{
+			Name:        ToolName,
+			Description: "Run a registered specialist sub-agent for a bounded task.",
+			Parameters: tools.JSONSchema{
+				Type: "object",
+				Properties: map[string]tools.Schema{
+					"agent": agent,
+					"task": {
+						Type:        "string",
+						Description: "The task to perform",
+					},
+					"context": {
+						Type:        "string",
+						Description: "Scoped context for the sub-agent to be invoked",
+					},
+				},
+				Required: []string{
+					"agent", "task",
+				},
+			},
+		},
+	}
+}
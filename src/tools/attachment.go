@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Attachment is a file sent to the model as content rather than as text:
+// an image or a PDF, base64 encoded.
+type Attachment struct {
+	Name      string
+	MediaType string
+	Data      string
+}
+
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.MediaType, "image/")
+}
+
+// The largest attachments the providers accept: Anthropic caps images at
+// 5MB and PDFs at 32MB per request.
+const (
+	maxImageBytes    = 5 << 20
+	maxDocumentBytes = 32 << 20
+)
+
+var attachmentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".pdf":  "application/pdf",
+}
+
+// AttachmentType returns the media type of path, judged by its extension,
+// if it is a kind of file that can be attached.
+func AttachmentType(path string) (string, bool) {
+	mediaType, ok := attachmentTypes[strings.ToLower(filepath.Ext(path))]
+	return mediaType, ok
+}
+
+// LoadAttachment reads path as an attachment. The content has to match the
+// media type its extension claims, and fit the providers' size limits.
+func LoadAttachment(path string) (Attachment, error) {
+	mediaType, ok := AttachmentType(path)
+	if !ok {
+		return Attachment{}, fmt.Errorf("%s is not an image or PDF", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	limit := maxImageBytes
+	if mediaType == "application/pdf" {
+		limit = maxDocumentBytes
+	}
+	if len(data) > limit {
+		return Attachment{}, fmt.Errorf(
+			"%s is %.1fMB, over the %dMB limit for attachments",
+			path,
+			float64(len(data))/(1<<20),
+			limit>>20,
+		)
+	}
+	if sniffed := http.DetectContentType(data); sniffed != mediaType {
+		return Attachment{}, fmt.Errorf("%s does not contain %s data", path, mediaType)
+	}
+
+	return Attachment{
+		Name:      filepath.Base(path),
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
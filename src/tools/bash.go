@@ -1,8 +1,8 @@
-// this file in deprecated
 package tools
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os/exec"
 	"time"
@@ -11,9 +11,8 @@ import (
 var BashTool = Tool{
 	Type: "function",
 	Function: ToolFunction{
-		Name: "bash_tool",
-		Description: `Execute shell commands in the workspace environment.  
-Used for inspecting the filesystem, building projects, running tests, or executing scripts.`,
+		Name:        "bash",
+		Description: "Execute shell commands in the workspace environment. Used for inspecting the filesystem, building projects, running tests, or executing scripts. ONLY use pure bash scripts, do not use python code inside of the bash scripts",
 		Parameters: JSONSchema{
 			Type: "object",
 			Properties: map[string]Schema{
@@ -62,7 +61,7 @@ type BashOutput struct {
 }
 
 // execution function
-func RunBash(input BashInput) (BashOutput, error) {
+func RunBash(ctx context.Context, input BashInput) (BashOutput, error) {
 
 	if input.Command == "" {
 		return BashOutput{}, errors.New("command cannot be empty")
@@ -76,7 +75,11 @@ func RunBash(input BashInput) (BashOutput, error) {
 
 	start := time.Now()
 
-	cmd := exec.Command("bash", "-c", input.Command)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", input.Command)
+	killOnCancel(cmd)
 
 	if input.WorkingDirectory != "" {
 		cmd.Dir = input.WorkingDirectory
@@ -92,6 +95,10 @@ func RunBash(input BashInput) (BashOutput, error) {
 
 	exitCode := 0
 
+	if ctx.Err() != nil {
+		return BashOutput{}, ctx.Err()
+	}
+
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			exitCode = exitError.ExitCode()
@@ -1,47 +0,0 @@
-import json
-import subprocess
-import argparse
-
-
-def run() -> None:
-    parser = argparse.ArgumentParser()
-    parser.add_argument("--message", type=str, required=True)
-    parser.add_argument("--command", type=str, required=True)
-    parser.add_argument("--working_directory", type=str, default=".")
-    parser.add_argument("--timeout_seconds", type=int, default=10)
-
-    args = parser.parse_args()
-
-    print(args.command.replace('"',"\""))
-    try:
-        result = subprocess.run(
-            ["bash", "-lc", args.command.replace('"',"\"")],
-            cwd=args.working_directory,
-            capture_output=True,
-            text=True,
-            timeout=args.timeout_seconds
-        )
-
-        print(json.dumps({
-            "ok": True,
-            "exit_code": result.returncode,
-            "stdout": result.stdout,
-            "stderr": result.stderr
-        }))
-
-    except subprocess.TimeoutExpired as e:
-        print(json.dumps({
-            "ok": False,
-            "error": "Command timed out",
-            "stdout": e.stdout or "",
-            "stderr": e.stderr or ""
-        }))
-
-    except Exception as e:
-        print(json.dumps({
-            "ok": False,
-            "error": str(e)
-        }))
-
-
-run()
\ No newline at end of file
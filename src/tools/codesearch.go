@@ -1,8 +1,8 @@
 package tools
 
 import (
+	"context"
 	"errors"
-	"fmt"
 )
 
 var CodeSearchTool = Tool{
@@ -41,32 +41,38 @@ type CodeSearchInput struct {
 }
 
 type CodeSearchOutput struct {
-	Matches []grepMatch
+	Matches   []grepMatch `json:"matches"`
+	Truncated bool        `json:"truncated,omitempty"`
 }
 
-func RunCodeSearch(input CodeSearchInput) (CodeSearchOutput, error) {
+func RunCodeSearch(ctx context.Context, input CodeSearchInput) (CodeSearchOutput, error) {
 
 	if input.Query == "" {
 		return CodeSearchOutput{}, errors.New("query cannot be empty")
 	}
 
-	command := fmt.Sprintf("rg %s -i --json", input.Query)
-
-	fmt.Println(command)
+	dir := input.Path
+	if dir == "" {
+		dir = "."
+	}
 
-	result, err := RunBashCommand(command)
+	result, err := runRipgrep(ctx, "-i", "--json", "--", input.Query, dir)
 
 	if err != nil {
 		return CodeSearchOutput{}, err
 	}
 
-	matches, err := ParseGrepMatch([]byte(result))
+	matches, err := ParseGrepMatch(result)
 
 	if err != nil {
 		return CodeSearchOutput{}, err
 	}
 
-	return CodeSearchOutput{
-		Matches: matches,
-	}, nil
+	output := CodeSearchOutput{Matches: matches}
+	if len(matches) > maxSearchResults {
+		output.Matches = matches[:maxSearchResults]
+		output.Truncated = true
+	}
+
+	return output, nil
 }
@@ -3,6 +3,7 @@ package tools
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"os/exec"
@@ -26,6 +27,17 @@ type JSONSchema struct {
 	Required    []string          `json:"required,omitempty"`
 	Items       *Schema           `json:"items,omitempty"`
 	Description string            `json:"description,omitempty"`
+	// Raw, when set, is sent verbatim instead of the fields above. It
+	// carries schemas richer than this struct, such as those of MCP tools.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (s JSONSchema) MarshalJSON() ([]byte, error) {
+	if len(s.Raw) > 0 {
+		return s.Raw, nil
+	}
+	type plain JSONSchema
+	return json.Marshal(plain(s))
 }
 
 type Schema struct {
@@ -54,15 +66,18 @@ type grepOutput struct {
 	LineNumber int `json:"line_number"`
 }
 
-func RunBashCommand(command string) (string, error) {
+// RunBashCommand runs command through bash and returns its stdout. The
+// process and everything it spawned are killed when ctx is cancelled.
+func RunBashCommand(ctx context.Context, command string) (string, error) {
 	var cmd *exec.Cmd
 
 	if strings.HasPrefix(command, "bash") || strings.HasPrefix(command, "sh") {
-		cmd = exec.Command(command)
+		cmd = exec.CommandContext(ctx, command)
 	} else {
-		cmd = exec.Command("bash", "-c", command)
+		cmd = exec.CommandContext(ctx, "bash", "-c", command)
 	}
 
+	killOnCancel(cmd)
 	cmd.Dir = "."
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -71,6 +86,10 @@ func RunBashCommand(command string) (string, error) {
 
 	err := cmd.Run()
 
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
 	if err != nil {
 		return "", errors.New(stderr.String())
 	}
@@ -78,6 +97,42 @@ func RunBashCommand(command string) (string, error) {
 	return stdout.String(), nil
 }
 
+// maxSearchResults caps how many matches a search returns to the model.
+const maxSearchResults = 200
+
+// runRipgrep runs rg with args and returns its stdout. rg exits with 1 when
+// nothing matched, which is an empty result rather than an error.
+func runRipgrep(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "rg", args...)
+	killOnCancel(cmd)
+	cmd.Dir = "."
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil, nil
+	}
+
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return nil, errors.New(message)
+	}
+
+	return stdout.Bytes(), nil
+}
+
 type grepMatch struct {
 	File    string `json:"file"`
 	Content string `json:"content"`
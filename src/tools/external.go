@@ -0,0 +1,230 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultExternalToolTimeout applies when a manifest sets no timeout.
+const defaultExternalToolTimeout = 60 * time.Second
+
+// BaseEnv is passed to every external tool and MCP server so interpreters
+// can start; anything else has to be listed in their configuration.
+var BaseEnv = []string{"PATH", "HOME", "TMPDIR", "LANG", "LC_ALL", "SYSTEMROOT"}
+
+// ToolManifest is the <name>/<name>.json file describing an external tool:
+// the schema shown to the model plus how to run it.
+//
+//	{
+//	  "type": "function",
+//	  "function": { "name": "jira_lookup", "description": "...", "parameters": {...} },
+//	  "interpreter": "node",
+//	  "executable": "index.js",
+//	  "timeout_seconds": 30,
+//	  "env": ["JIRA_TOKEN"]
+//	}
+//
+// The executable is resolved against the manifest's directory. Without an
+// interpreter it is run directly, so it can be a compiled binary or a
+// script with a shebang.
+type ToolManifest struct {
+	Tool
+	Interpreter    string   `json:"interpreter,omitempty"`
+	Executable     string   `json:"executable,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	Env            []string `json:"env,omitempty"`
+}
+
+// toolEnvelope is what an external tool prints on stdout. Content may be a
+// string or any JSON value; the latter is passed on to the model encoded.
+type toolEnvelope struct {
+	Content  json.RawMessage `json:"content"`
+	IsError  bool            `json:"is_error"`
+	Metadata map[string]any  `json:"metadata,omitempty"`
+}
+
+// ExternalHandler runs a tool over the JSON protocol:
+//
+//   - the arguments object is written to stdin, and also to the file named
+//     by ZIPCODE_TOOL_ARGUMENTS_FILE for tools that prefer reading a file;
+//   - ZIPCODE_TOOL_NAME holds the tool name and the working directory is
+//     the workspace root;
+//   - the tool prints a single {"content", "is_error", "metadata"} object
+//     on stdout and exits 0. A non-zero exit is reported with its stderr.
+type ExternalHandler struct {
+	Manifest ToolManifest
+	Dir      string
+}
+
+func (h *ExternalHandler) Definition() Tool {
+	return h.Manifest.Tool
+}
+
+func (h *ExternalHandler) Run(ctx context.Context, arguments json.RawMessage) (ToolResult, error) {
+	name := h.Manifest.Function.Name
+	if len(bytes.TrimSpace(arguments)) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	timeout := defaultExternalToolTimeout
+	if h.Manifest.TimeoutSeconds > 0 {
+		timeout = time.Duration(h.Manifest.TimeoutSeconds) * time.Second
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	argumentsFile, err := os.CreateTemp("", "zipcode-tool-*.json")
+	if err != nil {
+		return ToolResult{}, err
+	}
+	defer os.Remove(argumentsFile.Name())
+	_, err = argumentsFile.Write(arguments)
+	if closeErr := argumentsFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ToolResult{}, err
+	}
+
+	program, args := h.command()
+	cmd := exec.CommandContext(runCtx, program, args...)
+	killOnCancel(cmd)
+	cmd.Dir = "."
+	cmd.Env = h.environment(argumentsFile.Name())
+	cmd.Stdin = bytes.NewReader(arguments)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	if ctx.Err() != nil {
+		return ToolResult{}, ctx.Err()
+	}
+
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return ToolResult{}, fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return ToolResult{}, fmt.Errorf("%s failed: %s", name, message)
+	}
+
+	var envelope toolEnvelope
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &envelope); err != nil || envelope.Content == nil {
+		return ToolResult{}, fmt.Errorf(
+			"%s returned invalid output: expected a JSON object with a content field",
+			name,
+		)
+	}
+
+	content := string(envelope.Content)
+	var text string
+	if err := json.Unmarshal(envelope.Content, &text); err == nil {
+		content = text
+	}
+
+	return ToolResult{
+		Content:  content,
+		IsError:  envelope.IsError,
+		Metadata: envelope.Metadata,
+	}, nil
+}
+
+func (h *ExternalHandler) command() (string, []string) {
+	executable := h.Manifest.Executable
+	if !filepath.IsAbs(executable) {
+		executable = filepath.Join(h.Dir, executable)
+	}
+
+	if h.Manifest.Interpreter == "" {
+		return executable, nil
+	}
+
+	fields := strings.Fields(h.Manifest.Interpreter)
+	return fields[0], append(fields[1:], executable)
+}
+
+func (h *ExternalHandler) environment(argumentsFile string) []string {
+	env := []string{
+		"ZIPCODE_TOOL_NAME=" + h.Manifest.Function.Name,
+		"ZIPCODE_TOOL_ARGUMENTS_FILE=" + argumentsFile,
+	}
+
+	return append(env, InheritEnv(append(append([]string{}, BaseEnv...), h.Manifest.Env...))...)
+}
+
+// InheritEnv returns NAME=value for each of names set in this process.
+func InheritEnv(names []string) []string {
+	var env []string
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// LoadDir reads the tool manifests in dir, laid out as <name>/<name>.json.
+// A manifest that declares an executable becomes an ExternalHandler, and
+// one with a <name>.py next to it a legacy ScriptHandler. A manifest with
+// neither only describes a tool the runtime handles itself, such as a
+// sub-agent, and is returned in definitions. An invalid manifest is
+// skipped and its error joined into err, so one broken tool does not keep
+// the others from loading.
+func LoadDir(dir string) (handlers []ToolHandler, definitions []Tool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var invalid []error
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		toolDir := filepath.Join(dir, name)
+		content, err := os.ReadFile(filepath.Join(toolDir, name+".json"))
+		if err != nil {
+			continue
+		}
+
+		var manifest ToolManifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			invalid = append(invalid, fmt.Errorf("invalid tool manifest %s: %w", name, err))
+			continue
+		}
+
+		if manifest.Executable != "" {
+			handlers = append(handlers, &ExternalHandler{Manifest: manifest, Dir: toolDir})
+			continue
+		}
+
+		script := filepath.Join(toolDir, name+".py")
+		if _, err := os.Stat(script); err == nil {
+			handlers = append(handlers, &ScriptHandler{Tool: manifest.Tool, Script: script})
+			continue
+		}
+
+		definitions = append(definitions, manifest.Tool)
+	}
+
+	return handlers, definitions, errors.Join(invalid...)
+}
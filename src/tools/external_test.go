@@ -0,0 +1,192 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureTools are shell scripts speaking the external tool protocol.
+var fixtureTools = map[string]string{
+	// Echoes the arguments from stdin and from the arguments file.
+	"echo_args": `printf '{"content": {"stdin": %s, "file": %s}, "metadata": {"tool": "%s"}}' ` +
+		`"$(cat)" "$(cat "$ZIPCODE_TOOL_ARGUMENTS_FILE")" "$ZIPCODE_TOOL_NAME"`,
+	"reject":   `echo '{"content": "path is outside the workspace", "is_error": true}'`,
+	"show_env": `printf '{"content": "%s|%s"}' "$ZIPCODE_TEST_LISTED" "$ZIPCODE_TEST_SECRET"`,
+	"plain":    `echo hello`,
+	"crash":    `echo "cannot connect" >&2; exit 3`,
+	"slow":     `sleep 30; echo '{"content": "late"}'`,
+}
+
+// writeToolDir lays out the fixture tools as LoadDir expects them.
+func writeToolDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, script := range fixtureTools {
+		manifest := ToolManifest{
+			Tool:       Tool{Type: "function", Function: ToolFunction{Name: name}},
+			Executable: "run.sh",
+		}
+		switch name {
+		case "show_env":
+			manifest.Env = []string{"ZIPCODE_TEST_LISTED"}
+		case "slow":
+			manifest.TimeoutSeconds = 1
+		}
+		writeTool(t, dir, name, manifest, "#!/bin/sh\n"+script+"\n")
+	}
+	return dir
+}
+
+func writeTool(t *testing.T, dir, name string, manifest any, script string) {
+	t.Helper()
+	toolDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(toolDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(toolDir, name+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if script != "" {
+		if err := os.WriteFile(filepath.Join(toolDir, "run.sh"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadFixtureTools(t *testing.T) map[string]ToolHandler {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fixture tools are shell scripts")
+	}
+	handlers, _, err := LoadDir(writeToolDir(t))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	byName := map[string]ToolHandler{}
+	for _, h := range handlers {
+		byName[h.Definition().Function.Name] = h
+	}
+	return byName
+}
+
+func TestExternalToolReceivesArguments(t *testing.T) {
+	tools := loadFixtureTools(t)
+
+	result, err := tools["echo_args"].Run(context.Background(), json.RawMessage(`{"path": "main.go"}`))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var content struct {
+		Stdin map[string]string `json:"stdin"`
+		File  map[string]string `json:"file"`
+	}
+	if err := json.Unmarshal([]byte(result.Content), &content); err != nil {
+		t.Fatalf("content %q is not the encoded JSON value: %v", result.Content, err)
+	}
+	if content.Stdin["path"] != "main.go" || content.File["path"] != "main.go" {
+		t.Errorf("tool saw stdin %v and file %v, want the arguments in both", content.Stdin, content.File)
+	}
+	if result.IsError || result.Metadata["tool"] != "echo_args" {
+		t.Errorf("result = %+v, want metadata naming the tool", result)
+	}
+
+	// Empty arguments are sent as an empty object.
+	result, err = tools["echo_args"].Run(context.Background(), nil)
+	if err != nil || !strings.Contains(result.Content, `"stdin": {}`) {
+		t.Errorf("empty arguments: %q, %v", result.Content, err)
+	}
+}
+
+func TestExternalToolErrorResult(t *testing.T) {
+	tools := loadFixtureTools(t)
+
+	result, err := tools["reject"].Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !result.IsError || result.Content != "path is outside the workspace" {
+		t.Errorf("result = %+v, want the string content as an error result", result)
+	}
+}
+
+func TestExternalToolEnvironment(t *testing.T) {
+	tools := loadFixtureTools(t)
+	t.Setenv("ZIPCODE_TEST_LISTED", "listed")
+	t.Setenv("ZIPCODE_TEST_SECRET", "secret")
+
+	result, err := tools["show_env"].Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Content != "listed|" {
+		t.Errorf("tool saw %q, want only the variable its manifest lists", result.Content)
+	}
+}
+
+func TestExternalToolFailures(t *testing.T) {
+	tools := loadFixtureTools(t)
+
+	tests := []struct {
+		tool string
+		want string
+	}{
+		{"plain", "plain returned invalid output"},
+		{"crash", "crash failed: cannot connect"},
+		{"slow", "slow timed out after 1s"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		_, err := tools[tt.tool].Run(context.Background(), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.tool, err, tt.want)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("%s took %s, the process was not killed", tt.tool, elapsed)
+		}
+	}
+}
+
+func TestExternalToolCancel(t *testing.T) {
+	tools := loadFixtureTools(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := tools["slow"].Run(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want the caller's context error", err)
+	}
+}
+
+func TestLoadDirSkipsInvalidManifests(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fixture tools are shell scripts")
+	}
+	dir := writeToolDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "broken"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken", "broken.json"), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTool(t, dir, "subagent", Tool{Type: "function", Function: ToolFunction{Name: "subagent"}}, "")
+
+	handlers, definitions, err := LoadDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "invalid tool manifest broken") {
+		t.Errorf("err = %v, want the broken manifest reported", err)
+	}
+	if len(handlers) != len(fixtureTools) {
+		t.Errorf("loaded %d handlers, want %d", len(handlers), len(fixtureTools))
+	}
+	if len(definitions) != 1 || definitions[0].Function.Name != "subagent" {
+		t.Errorf("definitions = %+v, want the manifest without an executable", definitions)
+	}
+}
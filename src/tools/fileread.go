@@ -1,7 +1,10 @@
 package tools
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 )
 
@@ -9,7 +12,7 @@ var FileReadTool = Tool{
 	Type: "function",
 	Function: ToolFunction{
 		Name:        "file_read",
-		Description: "Read the contents of a file from the workspace. Used to inspect code before making modifications. Avoid reading extremely large files unless necessary.",
+		Description: "Read the contents of a file from the workspace. Used to inspect code before making modifications. Avoid reading extremely large files unless necessary. Images (png, jpeg, gif, webp) and PDFs are returned as attachments you can view.",
 		Parameters: JSONSchema{
 			Type: "object",
 			Properties: map[string]Schema{
@@ -39,6 +42,45 @@ type FileReadOutput struct {
 	Content string `json:"content"`
 }
 
+// fileReadHandler returns images and PDFs as attachments and any other file
+// as text.
+type fileReadHandler struct{}
+
+func (fileReadHandler) Definition() Tool {
+	return FileReadTool
+}
+
+func (fileReadHandler) Run(_ context.Context, arguments json.RawMessage) (ToolResult, error) {
+	var input FileReadInput
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &input); err != nil {
+			return ToolResult{}, fmt.Errorf("invalid arguments for %s: %w", FileReadTool.Function.Name, err)
+		}
+	}
+
+	var output FileReadOutput
+	var attachments []Attachment
+	if _, ok := AttachmentType(input.Path); ok {
+		attachment, err := LoadAttachment(input.Path)
+		if err != nil {
+			return ToolResult{}, err
+		}
+		output.Content = fmt.Sprintf("%s is attached (%s).", attachment.Name, attachment.MediaType)
+		attachments = append(attachments, attachment)
+	} else {
+		var err error
+		if output, err = RunFileRead(input); err != nil {
+			return ToolResult{}, err
+		}
+	}
+
+	content, err := json.Marshal(output)
+	if err != nil {
+		return ToolResult{}, err
+	}
+	return ToolResult{Content: string(content), Attachments: attachments}, nil
+}
+
 func RunFileRead(input FileReadInput) (FileReadOutput, error) {
 
 	if input.Path == "" {
@@ -1,10 +1,9 @@
 package tools
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"fmt"
-	"os/exec"
+	"strings"
 )
 
 var FileSearchTool = Tool{
@@ -43,38 +42,47 @@ type FileSearchInput struct {
 }
 
 type FileSearchOutput struct {
-	Matches []grepMatch
+	Files     []string `json:"files"`
+	Truncated bool     `json:"truncated,omitempty"`
 }
 
-type match struct {
-	File    string `json:"file"`
-	Content string `json:"content"`
-}
-
-func RunFileSearch(input FileSearchInput) (FileSearchOutput, error) {
+// RunFileSearch lists the files under input.Path whose names match the
+// query, honoring .gitignore. A query without glob characters matches any
+// name containing it.
+func RunFileSearch(ctx context.Context, input FileSearchInput) (FileSearchOutput, error) {
 
 	if input.Query == "" {
 		return FileSearchOutput{}, errors.New("query cannot be empty")
 	}
 
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("rg --files | rg %s", input.Query))
-
-	if input.Path != "" {
-		cmd.Dir = input.Path
+	dir := input.Path
+	if dir == "" {
+		dir = "."
 	}
 
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	err := cmd.Run()
+	glob := input.Query
+	if !strings.ContainsAny(glob, "*?[") {
+		glob = "*" + glob + "*"
+	}
 
-	matches, err := ParseGrepMatch(stdout.Bytes())
+	result, err := runRipgrep(ctx, "--files", "--iglob", glob, dir)
 
 	if err != nil {
 		return FileSearchOutput{}, err
 	}
 
-	return FileSearchOutput{
-		Matches: matches,
-	}, nil
+	files := []string{}
+	for _, line := range strings.Split(string(result), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	output := FileSearchOutput{Files: files}
+	if len(files) > maxSearchResults {
+		output.Files = files[:maxSearchResults]
+		output.Truncated = true
+	}
+
+	return output, nil
 }
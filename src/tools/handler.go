@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ToolHandler executes one tool. Run gets the raw JSON arguments from the
+// model. A returned error means the tool could not run at all; a tool that
+// ran and failed reports it with ToolResult.IsError.
+type ToolHandler interface {
+	Definition() Tool
+	Run(ctx context.Context, arguments json.RawMessage) (ToolResult, error)
+}
+
+// ToolResult is the outcome of a tool call. Content is sent back to the
+// model, with Attachments when the model can view them; Metadata is for the
+// host and is only logged.
+type ToolResult struct {
+	Content     string
+	IsError     bool
+	Metadata    map[string]any
+	Attachments []Attachment
+}
+
+// nativeHandler adapts a typed Go function to ToolHandler: arguments are
+// decoded into In and the returned Out is encoded as the result.
+type nativeHandler[In any, Out any] struct {
+	tool Tool
+	run  func(context.Context, In) (Out, error)
+}
+
+func NewHandler[In any, Out any](
+	tool Tool,
+	run func(context.Context, In) (Out, error),
+) ToolHandler {
+	return &nativeHandler[In, Out]{tool: tool, run: run}
+}
+
+func (h *nativeHandler[In, Out]) Definition() Tool {
+	return h.tool
+}
+
+func (h *nativeHandler[In, Out]) Run(ctx context.Context, arguments json.RawMessage) (ToolResult, error) {
+	var input In
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &input); err != nil {
+			return ToolResult{}, fmt.Errorf("invalid arguments for %s: %w", h.tool.Function.Name, err)
+		}
+	}
+
+	output, err := h.run(ctx, input)
+	if err != nil {
+		return ToolResult{}, err
+	}
+
+	content, err := json.Marshal(output)
+	if err != nil {
+		return ToolResult{}, err
+	}
+	return ToolResult{Content: string(content)}, nil
+}
+
+// Builtins returns the handlers for the tools implemented in Go.
+func Builtins() []ToolHandler {
+	return []ToolHandler{
+		fileReadHandler{},
+		NewHandler(FileWriteTool, func(_ context.Context, input FileWriteInput) (FileWriteOutput, error) {
+			return RunFileWrite(input)
+		}),
+		NewHandler(CodeSearchTool, RunCodeSearch),
+		NewHandler(FileSearchTool, RunFileSearch),
+		NewHandler(BashTool, RunBash),
+	}
+}
+
+// Registry maps tool names to their handlers. Definitions keep the order
+// handlers were registered in, so the tool list sent to the model is stable.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]ToolHandler
+	order    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]ToolHandler{}}
+}
+
+// Register adds a handler. It fails if the name is already taken, so an
+// external tool cannot shadow a built-in one.
+func (r *Registry) Register(handler ToolHandler) error {
+	name := handler.Definition().Function.Name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.handlers[name]; exists {
+		return fmt.Errorf("tool %q is already registered", name)
+	}
+	r.handlers[name] = handler
+	r.order = append(r.order, name)
+	return nil
+}
+
+func (r *Registry) Get(name string) (ToolHandler, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// Definitions returns the schemas of every registered tool.
+func (r *Registry) Definitions() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.handlers[name].Definition())
+	}
+	return definitions
+}
+
+// Lookup returns the schemas of the named tools, in the order given.
+func (r *Registry) Lookup(names []string) ([]Tool, error) {
+	definitions := make([]Tool, 0, len(names))
+	for _, name := range names {
+		handler, ok := r.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		definitions = append(definitions, handler.Definition())
+	}
+	return definitions, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tools
+
+import "os/exec"
+
+// killOnCancel falls back to exec.CommandContext's default behaviour of
+// killing only the direct child.
+func killOnCancel(cmd *exec.Cmd) {}
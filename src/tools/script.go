@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ScriptHandler runs a legacy tool: a <name>.py script next to a manifest
+// that declares no executable. Every argument is passed as a --name flag
+// (non-string values JSON encoded) and stdout is taken as the raw result.
+// New tools should use ExternalHandler's JSON protocol instead.
+type ScriptHandler struct {
+	Tool   Tool
+	Script string
+}
+
+func (h *ScriptHandler) Definition() Tool {
+	return h.Tool
+}
+
+func (h *ScriptHandler) Run(ctx context.Context, arguments json.RawMessage) (ToolResult, error) {
+	var args map[string]any
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return ToolResult{}, fmt.Errorf("invalid arguments for %s: %w", h.Tool.Function.Name, err)
+		}
+	}
+
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	argv := []string{h.Script}
+	for _, key := range keys {
+		value, ok := args[key].(string)
+		if !ok {
+			encoded, err := json.Marshal(args[key])
+			if err != nil {
+				return ToolResult{}, err
+			}
+			value = string(encoded)
+		}
+		argv = append(argv, "--"+key, value)
+	}
+
+	cmd := exec.CommandContext(ctx, "python3", argv...)
+	killOnCancel(cmd)
+	cmd.Dir = "."
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return ToolResult{}, ctx.Err()
+	}
+
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return ToolResult{}, errors.New(message)
+	}
+
+	return ToolResult{Content: stdout.String()}, nil
+}
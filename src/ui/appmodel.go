@@ -1,10 +1,12 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"zipcode/src/agent"
 	"zipcode/src/config"
+	llm "zipcode/src/llm/provider"
 	"zipcode/src/ui/components"
 	"zipcode/src/utils"
 	"zipcode/src/workspace"
@@ -54,7 +56,10 @@ func Iniaitalize(workspace *workspace.Workspace) AppModel {
 	commands := []string{"/models", "/help", "/exit"}
 	commandDescriptions := []string{"Select model", "Get help", "Exit ZipCode"}
 
-	models := config.Cfg.ModelNames
+	models := []string{}
+	for _, m := range runtime.Registry.Models(llm.ProviderName(config.Cfg.ActiveProviderName)) {
+		models = append(models, m.ID)
+	}
 
 	return AppModel{
 		Workspace:    workspace,
@@ -172,7 +177,7 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			if a.Prompt.Value() != "" {
-				go a.Runtime.Run(a.Prompt.Value())
+				go a.Runtime.Run(context.Background(), a.Prompt.Value())
 				a.ActiveConversation += "⏺ " + a.Prompt.Value() + "\n"
 				a.StatusBar.SetStatus(components.Status_RUNNING)
 				a.ViewPort.SetContent(a.GetConversation())
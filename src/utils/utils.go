@@ -91,23 +91,28 @@ func FlexGap(totalWidth int, subWidth int) string {
 	return gapText
 }
 
+// LogValue prints value to stderr when running headless with --debug.
+// Stdout is reserved for the run's result.
 func LogValue(value any) {
-	if !config.Cfg.Headless {
+	if !config.Cfg.Headless || !config.Cfg.Debug {
 		return
 	}
 
 	if isStruct(value) {
-		PrintStruct(value)
+		result, _ := json.Marshal(value)
+		fmt.Fprintln(os.Stderr, string(result))
 		return
 	}
 
-	fmt.Println(value)
+	fmt.Fprintln(os.Stderr, value)
 
 }
 
 func Log(a ...any) {
 	if config.Cfg.Headless {
-		fmt.Println(a...)
+		if config.Cfg.Debug {
+			fmt.Fprintln(os.Stderr, a...)
+		}
 	} else {
 		f, err := os.OpenFile("./logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
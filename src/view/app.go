@@ -1,10 +1,15 @@
 package view
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
 
 	"zipcode/src/agent"
+	llmerrors "zipcode/src/llm/errors"
 	view "zipcode/src/view/components"
 	"zipcode/src/view/viewctx"
 	"zipcode/src/workspace"
@@ -15,6 +20,7 @@ import (
 
 var promptChan = make(chan string)
 var clearOutputsChan = make(chan struct{}, 1)
+var toggleThinkingChan = make(chan struct{}, 1)
 var queuedPrompts = make(chan string, 32)
 
 func App(props tuix.Props) tuix.Element {
@@ -57,14 +63,17 @@ func App(props tuix.Props) tuix.Element {
 		}
 
 		go func() {
-			if _, err := runtime.Run(send); err != nil {
-				agent.EventManager.WriteToChannel(
-					agent.NOTIFICATION_CHANNEL,
-					agent.Notification{
-						Type:    agent.ERROR,
-						Message: err.Error(),
-					},
-				)
+			_, err := runtime.Run(context.Background(), send)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				notification := agent.Notification{
+					Type:    agent.ERROR,
+					Message: err.Error(),
+				}
+				if classified, ok := llmerrors.AsClassified(err); ok {
+					notification.Message = classified.UserMessage
+					notification.Details = classified.RawDetails
+				}
+				agent.EventManager.WriteToChannel(agent.NOTIFICATION_CHANNEL, notification)
 			}
 		}()
 	}
@@ -85,6 +94,33 @@ func App(props tuix.Props) tuix.Element {
 		}
 	}
 
+	if tuix.CurrentKey.Code == tuix.KeyEscape && activeSession && !menuVisible {
+		if runtime.Cancel() {
+			setQuestionVisible(false)
+		}
+	}
+
+	// Ctrl+C reaches us as SIGINT. During a run it cancels the run like Esc;
+	// otherwise it is raised again with the default handling, which quits.
+	tuix.UseEffect(func() func() {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		go func() {
+			for range interrupts {
+				if runtime.Cancel() {
+					setQuestionVisible(false)
+					continue
+				}
+				signal.Stop(interrupts)
+				if self, err := os.FindProcess(os.Getpid()); err == nil {
+					self.Signal(os.Interrupt)
+				}
+				return
+			}
+		}()
+		return func() { signal.Stop(interrupts) }
+	}, []any{})
+
 	canSubmit := tuix.CurrentKey.Code == tuix.KeyEnter && !menuVisible
 	if canSubmit {
 		if !activeSession {
@@ -98,6 +134,7 @@ func App(props tuix.Props) tuix.Element {
 		go func() {
 			var activeSubAgent string
 			var activeSkill string
+			subAgentLabels := subAgentLanes{}
 			agentOut := make(chan agent.ResponseEvent)
 
 			go func() {
@@ -150,6 +187,22 @@ func App(props tuix.Props) tuix.Element {
 			var acc []tuix.Element
 			var liveLocal string
 			promptIdx := -1
+			// streamIdx is the position in acc of the block showing a
+			// message that is still streaming; -1 when nothing is streaming.
+			var streamed string
+			streamIdx := -1
+			// thinking records the reasoning blocks in acc so /thinking can
+			// redraw them; thinkingIdx is the entry still streaming, or -1.
+			thinking := thinkingBlocks{}
+			thinkingIdx := -1
+			thinkingExpanded := false
+			drawThinking := func(i int) {
+				acc[thinking.at[i]] = view.Thinking(tuix.Props{Values: map[string]any{
+					"text":      thinking.texts[i],
+					"expanded":  thinkingExpanded,
+					"streaming": i == thinkingIdx,
+				}})
+			}
 			for {
 				select {
 
@@ -157,12 +210,24 @@ func App(props tuix.Props) tuix.Element {
 					acc = acc[:0]
 					liveLocal = ""
 					promptIdx = -1
+					streamed = ""
+					streamIdx = -1
+					thinking = thinkingBlocks{}
+					thinkingIdx = -1
 					setLivePrompt("")
 					setLivePromptIdx(-1)
 					setOutputs(nil)
 					continue
 
+				case <-toggleThinkingChan:
+					thinkingExpanded = !thinkingExpanded
+					for i := range thinking.at {
+						drawThinking(i)
+					}
+
 				case p := <-promptChan:
+					subAgentLabels = subAgentLanes{}
+					activeSubAgent = ""
 					liveLocal = p
 					setLivePrompt(p)
 					promptIdx = len(acc)
@@ -171,6 +236,64 @@ func App(props tuix.Props) tuix.Element {
 					msg := ev.Message
 					style := tuix.NewStyle().Foreground(tuix.Hex("#c8c8c8"))
 
+					if ev.EventType == agent.ThinkingDelta || ev.EventType == agent.Thinking {
+						if thinkingIdx < 0 {
+							thinkingIdx = thinking.add(len(acc))
+							acc = append(acc, nil)
+						}
+						if ev.EventType == agent.ThinkingDelta {
+							thinking.texts[thinkingIdx] += msg
+							drawThinking(thinkingIdx)
+						} else {
+							// The complete reasoning replaces what streamed.
+							thinking.texts[thinkingIdx] = msg
+							closing := thinkingIdx
+							thinkingIdx = -1
+							drawThinking(closing)
+						}
+						snap := make([]tuix.Element, len(acc))
+						copy(snap, acc)
+						setOutputs(snap)
+						continue
+					}
+
+					if thinkingIdx >= 0 && ev.EventType != agent.MessageDelta {
+						closing := thinkingIdx
+						thinkingIdx = -1
+						drawThinking(closing)
+					}
+
+					if ev.EventType == agent.MessageDelta {
+						streamed += msg
+						el := tuix.Box(
+							tuix.Props{Padding: [4]int{0, 2, 0, 2}},
+							tuix.NewStyle(),
+							tuix.WrappedText(streamed, style),
+						)
+						if streamIdx < 0 {
+							streamIdx = len(acc)
+							acc = append(acc, el)
+						} else {
+							acc[streamIdx] = el
+						}
+						snap := make([]tuix.Element, len(acc))
+						copy(snap, acc)
+						setOutputs(snap)
+						continue
+					}
+
+					if streamIdx >= 0 {
+						// The final message re-renders the full (redacted)
+						// text, so drop the streamed copy; anything else
+						// just closes the streaming block where it is.
+						if ev.EventType == agent.Message {
+							acc = append(acc[:streamIdx], acc[streamIdx+1:]...)
+							thinking.shift(streamIdx+1, -1)
+						}
+						streamed = ""
+						streamIdx = -1
+					}
+
 					if ev.EventType == agent.Tool {
 
 						if ev.Question != "" {
@@ -182,11 +305,17 @@ func App(props tuix.Props) tuix.Element {
 						}
 
 						if ev.SubAgent {
-							if activeSubAgent != ev.SubAgentName {
-								activeSubAgent = ev.SubAgentName
+							// Parallel sub-agents interleave their events, so
+							// the header is repeated whenever the lane changes.
+							lane := ev.SubAgentID
+							if lane == "" {
+								lane = ev.SubAgentName
+							}
+							if activeSubAgent != lane {
+								activeSubAgent = lane
 								msg = fmt.Sprintf(
 									"    \nsubagent:%s\n  └──%s",
-									ev.SubAgentName,
+									subAgentLabels.label(lane, ev.SubAgentName),
 									msg,
 								)
 								style = style.Foreground(tuix.Hex("#64c3ff")).
@@ -216,7 +345,7 @@ func App(props tuix.Props) tuix.Element {
 					} else {
 						if liveLocal != "" && promptIdx >= 0 {
 							var promptEl tuix.Element
-							if ev.EventType == agent.Error {
+							if ev.EventType == agent.Error || ev.EventType == agent.RunCancelled {
 								promptEl = view.Prompt(tuix.Props{Values: map[string]any{
 									"prompt":  liveLocal,
 									"running": false,
@@ -230,6 +359,7 @@ func App(props tuix.Props) tuix.Element {
 								}})
 							}
 							acc = append(acc[:promptIdx], append([]tuix.Element{promptEl}, acc[promptIdx:]...)...)
+							thinking.shift(promptIdx, 1)
 							// appending an empty line to reduce cluttering
 							acc = append(acc, tuix.Text("", tuix.NewStyle()))
 							liveLocal = ""
@@ -239,11 +369,26 @@ func App(props tuix.Props) tuix.Element {
 						}
 						setActiveSession(false)
 
-						select {
-						case queued := <-queuedPrompts:
-							setQueuedCount(queuedCount - 1)
-							go submitPrompt(queued)
-						default:
+						if ev.EventType == agent.RunCancelled {
+							// Cancelling stops the whole queue, not just the
+							// prompt that happened to be running.
+							style = style.Foreground(tuix.Hex("#848484"))
+						drain:
+							for {
+								select {
+								case <-queuedPrompts:
+								default:
+									break drain
+								}
+							}
+							setQueuedCount(0)
+						} else {
+							select {
+							case queued := <-queuedPrompts:
+								setQueuedCount(queuedCount - 1)
+								go submitPrompt(queued)
+							default:
+							}
 						}
 					}
 
@@ -336,10 +481,23 @@ func App(props tuix.Props) tuix.Element {
 					Foreground(tuix.Hex("#ff8282"))
 			}
 
+			notificationLines := []tuix.Element{
+				tuix.Text(notification.Message, notificationStyle),
+			}
+			if notification.Details != "" {
+				details := []rune(strings.SplitN(notification.Details, "\n", 2)[0])
+				if len(details) > 160 {
+					details = append(details[:160], '…')
+				}
+				notificationLines = append(notificationLines, tuix.Text(
+					string(details),
+					tuix.NewStyle().Foreground(tuix.Hex("#848484")),
+				))
+			}
 			notificationEl := tuix.Box(
-				tuix.Props{Padding: [4]int{1, 0, 0, 0}},
+				tuix.Props{Direction: tuix.Column, Padding: [4]int{1, 0, 0, 0}},
 				tuix.NewStyle().Foreground(tuix.Hex("#9ad8ff")),
-				tuix.Text(notification.Message, notificationStyle),
+				notificationLines...,
 			)
 			if notification.Message != "" {
 				children = append(children, notificationEl)
@@ -393,6 +551,12 @@ func App(props tuix.Props) tuix.Element {
 						"clearPrompt": func() {
 							setPrompt("")
 						},
+						"toggleThinking": func() {
+							select {
+							case toggleThinkingChan <- struct{}{}:
+							default:
+							}
+						},
 						"clearOutputs": func() {
 							select {
 							case clearOutputsChan <- struct{}{}:
@@ -409,13 +573,14 @@ func App(props tuix.Props) tuix.Element {
 					"workspacePath": workspace.AbsToTildePath(
 						props.Get("wd").(string),
 					),
-					"running":               activeSession,
-					"inputTokens":           runtime.InputTokens,
-					"cachedInputTokens":     runtime.CachedInputTokens,
-					"outputTokens":          runtime.OutputTokens,
-					"branch":                runtime.Workspace.GetCurrentBranch(),
-					"hasUncommittedChanges": runtime.Workspace.HasUncommittedChanges(),
-					"activeSkill":           activeSkillName,
+					"running":                  activeSession,
+					"inputTokens":              runtime.InputTokens,
+					"cachedInputTokens":        runtime.CachedInputTokens,
+					"cacheCreationInputTokens": runtime.CacheCreationInputTokens,
+					"outputTokens":             runtime.OutputTokens,
+					"branch":                   runtime.Workspace.GetCurrentBranch(),
+					"hasUncommittedChanges":    runtime.Workspace.HasUncommittedChanges(),
+					"activeSkill":              activeSkillName,
 				},
 			}))
 
@@ -431,3 +596,50 @@ func App(props tuix.Props) tuix.Element {
 		},
 	)
 }
+
+// thinkingBlocks records where reasoning blocks sit in the output, and
+// their text, so they can be redrawn when /thinking toggles them.
+type thinkingBlocks struct {
+	at    []int
+	texts []string
+}
+
+func (t *thinkingBlocks) add(at int) int {
+	t.at = append(t.at, at)
+	t.texts = append(t.texts, "")
+	return len(t.at) - 1
+}
+
+// shift moves the blocks at or after from by n, once n elements have been
+// inserted into (or removed from) the output there.
+func (t *thinkingBlocks) shift(from, n int) {
+	for i, at := range t.at {
+		if at >= from {
+			t.at[i] = at + n
+		}
+	}
+}
+
+// subAgentLanes numbers sub-agent runs that share a name, so two parallel
+// code_explorer calls show up as code_explorer and code_explorer #2.
+type subAgentLanes map[string]string
+
+func (l subAgentLanes) label(id, name string) string {
+	if label, ok := l[id]; ok {
+		return label
+	}
+
+	count := 1
+	for _, label := range l {
+		if label == name || strings.HasPrefix(label, name+" #") {
+			count++
+		}
+	}
+
+	label := name
+	if count > 1 {
+		label = fmt.Sprintf("%s #%d", name, count)
+	}
+	l[id] = label
+	return label
+}
@@ -1,17 +1,404 @@
 package view
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"zipcode/src/agent"
+	"zipcode/src/config"
+	"zipcode/src/subagents"
+	"zipcode/src/tools"
 	view "zipcode/src/ui/components/utils"
+	"zipcode/src/utils"
 
 	"github.com/anirban1809/tuix/tuix"
+	"github.com/anirban1809/tuix/tuix/components"
 )
 
+const createAgentLabel = "+ Create new agent"
+
 func Agent(props tuix.Props) tuix.Element {
-	return tuix.Box(tuix.Props{Direction: tuix.Column, Padding: [4]int{1, 1, 1, 1}},
+	runtime, _ := props.Get("runtime").(*agent.Runtime)
+	visible, _ := props.Get("visible").(bool)
+	mode, setMode := tuix.UseState("list")
+	selected, setSelected := tuix.UseState("")
+	focussedIndex, setFocussedIndex := tuix.UseState(0)
+
+	if !visible && mode != "list" {
+		setMode("list")
+	}
+
+	if runtime == nil || runtime.SubagentRegistry == nil {
+		return tuix.Box(
+			tuix.Props{
+				Direction: tuix.Column,
+				Padding:   [4]int{1, 1, 1, 1},
+			},
+			tuix.NewStyle(),
+			tuix.Text("Agents are unavailable.", tuix.NewStyle()),
+			tuix.Text("Press Esc to go back.", tuix.NewStyle()),
+		)
+	}
+
+	registry := runtime.SubagentRegistry
+	allAgents := registry.List()
+
+	labels := make([]string, 0, len(allAgents)+1)
+	for _, s := range allAgents {
+		state := "on "
+		if !s.Enabled {
+			state = "off"
+		}
+		labels = append(labels, fmt.Sprintf(
+			"[%s] %-20s %-10s %s",
+			state,
+			s.Name,
+			s.Source,
+			s.Description,
+		))
+	}
+	labels = append(labels, createAgentLabel)
+
+	details := view.Empty()
+	if focussedIndex < len(allAgents) {
+		s := allAgents[focussedIndex]
+		details = tuix.Box(
+			tuix.Props{Direction: tuix.Column},
+			tuix.NewStyle(),
+			tuix.Text("Tools: "+agentTools(s), tuix.NewStyle()),
+			tuix.Text("Model: "+agentModel(s), tuix.NewStyle()),
+			tuix.Text("File:  "+s.Path, tuix.NewStyle()),
+		)
+	}
+
+	detailView := AgentDetail(tuix.Props{Values: map[string]any{
+		"visible":  visible && mode == "detail",
+		"registry": registry,
+		"name":     selected,
+		"onDone":   func() { setMode("list") },
+	}})
+
+	wizard := AgentWizard(tuix.Props{Values: map[string]any{
+		"visible": visible && mode == "create",
+		"runtime": runtime,
+		"onDone":  func() { setMode("list") },
+	}})
+
+	list := tuix.Box(
+		tuix.Props{Direction: tuix.Column},
+		tuix.NewStyle(),
+		tuix.Text("Agents", tuix.NewStyle()),
+		view.NewLine(),
+		Menu(tuix.Props{Values: map[string]any{
+			"items":    labels,
+			"visible":  visible && mode == "list",
+			"viewSize": 8,
+		}}, func(_ string, index int) {
+			if index == len(allAgents) {
+				setMode("create")
+				return
+			}
+			setSelected(allAgents[index].Name)
+			setMode("detail")
+		}, func(index int) {
+			setFocussedIndex(index)
+		}),
+		details,
+		view.NewLine(),
+		tuix.Text(
+			"Enter to manage an agent or create one. Esc to go back.",
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		),
+	)
+
+	content := list
+	if mode == "detail" {
+		content = detailView
+	}
+	if mode == "create" {
+		content = wizard
+	}
+
+	return tuix.Box(
+		tuix.Props{
+			Direction: tuix.Column,
+			Padding:   [4]int{1, 1, 1, 1},
+		},
+		tuix.NewStyle(),
+		content,
+	)
+}
+
+// AgentDetail shows one sub-agent with actions to enable, disable or
+// delete it.
+func AgentDetail(props tuix.Props) tuix.Element {
+	visible, _ := props.Get("visible").(bool)
+	registry, _ := props.Get("registry").(*subagents.Registry)
+	name, _ := props.Get("name").(string)
+	onDone, _ := props.Get("onDone").(func())
+
+	if registry == nil {
+		return view.Empty()
+	}
+	s, ok := registry.Get(name)
+	if !ok {
+		return view.Empty()
+	}
+
+	toggle := "Disable"
+	if !s.Enabled {
+		toggle = "Enable"
+	}
+	actions := []string{toggle}
+	if s.Source != subagents.SourceInternal {
+		actions = append(actions, "Delete")
+	}
+	actions = append(actions, "Back")
+
+	return tuix.Box(
+		tuix.Props{Direction: tuix.Column},
+		tuix.NewStyle(),
+		tuix.Text(s.Name, tuix.NewStyle().Bold(true)),
+		view.NewLine(),
+		tuix.Text("Source:      "+string(s.Source), tuix.NewStyle()),
+		tuix.Text("File:        "+s.Path, tuix.NewStyle()),
+		tuix.Text("Description: "+s.Description, tuix.NewStyle()),
+		tuix.Text("Tools:       "+agentTools(s), tuix.NewStyle()),
+		tuix.Text("Model:       "+agentModel(s), tuix.NewStyle()),
+		tuix.Text("Enabled:     "+utils.If(s.Enabled, "yes", "no"), tuix.NewStyle()),
+		view.NewLine(),
+		Menu(tuix.Props{Values: map[string]any{
+			"items":   actions,
+			"visible": visible,
+		}}, func(action string, _ int) {
+			var err error
+			switch action {
+			case "Enable":
+				err = registry.Enable(s.Name)
+			case "Disable":
+				err = registry.Disable(s.Name)
+			case "Delete":
+				err = registry.Delete(s.Name)
+			}
+			if err != nil {
+				go agent.EventManager.WriteToChannel(
+					agent.NOTIFICATION_CHANNEL,
+					agent.Notification{
+						Type:    agent.ERROR,
+						Message: fmt.Sprintf("Failed to update agent %s: %s", s.Name, err.Error()),
+					},
+				)
+			}
+			if onDone != nil {
+				onDone()
+			}
+		}, nil),
+		tuix.Text(
+			"Edit the file in your editor to change the prompt. Esc to go back.",
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		),
+	)
+}
+
+type wizardStep struct {
+	label string
+	hint  string
+}
+
+var wizardSteps = []wizardStep{
+	{"Name", "Letters, digits, - and _. The model picks the agent by this name."},
+	{"Description", "Tells the model when to use this agent."},
+	{"Tools", "Comma separated, leave empty for none."},
+	{"Model", "Leave empty to use the current model."},
+	{"System prompt", "Instructions for the agent. Edit the file later for a longer prompt."},
+	{"Save to", ""},
+}
+
+// AgentWizard collects a new sub-agent definition one field at a time and
+// writes it to the project or global agents directory.
+func AgentWizard(props tuix.Props) tuix.Element {
+	visible, _ := props.Get("visible").(bool)
+	runtime, _ := props.Get("runtime").(*agent.Runtime)
+	onDone, _ := props.Get("onDone").(func())
+	step, setStep := tuix.UseState(0)
+	values, setValues := tuix.UseState([]string{"", "", "", "", ""})
+	status, setStatus := tuix.UseState("")
+
+	if !visible {
+		if step != 0 {
+			setStep(0)
+			setValues([]string{"", "", "", "", ""})
+			setStatus("")
+		}
+		return view.Empty()
+	}
+
+	registry := runtime.SubagentRegistry
+	available := utils.Map(
+		runtime.ToolRegistry.Definitions(),
+		func(t tools.Tool, _ int) string { return t.Function.Name },
+	)
+
+	if step < len(values) && tuix.CurrentKey.Code == tuix.KeyEnter {
+		value := strings.TrimSpace(values[step])
+		if err := validateWizardStep(step, value, registry, runtime); err != nil {
+			setStatus(err.Error())
+		} else {
+			setStatus("")
+			setStep(step + 1)
+		}
+	}
+
+	finish := func(_ string, location int) {
+		dir := config.Cfg.ProjectSubagentsPath
+		source := subagents.SourceProject
+		if location == 1 {
+			dir = config.Cfg.ExternalSubagentsPath
+			source = subagents.SourceGlobal
+		}
+
+		err := registry.Create(&subagents.Subagent{
+			Name:         strings.TrimSpace(values[0]),
+			Description:  strings.TrimSpace(values[1]),
+			AllowedTools: splitTools(values[2]),
+			Model:        strings.TrimSpace(values[3]),
+			SystemPrompt: strings.TrimSpace(values[4]),
+			Source:       source,
+		}, dir)
+		if err != nil {
+			setStatus("Failed to create agent: " + err.Error())
+			return
+		}
+
+		go agent.EventManager.WriteToChannel(
+			agent.NOTIFICATION_CHANNEL,
+			agent.Notification{
+				Type:    agent.INFO,
+				Message: fmt.Sprintf("Created agent %s", strings.TrimSpace(values[0])),
+			},
+		)
+		setStep(0)
+		setValues([]string{"", "", "", "", ""})
+		if onDone != nil {
+			onDone()
+		}
+	}
+
+	entered := []tuix.Element{}
+	for i := 0; i < step && i < len(values); i++ {
+		entered = append(entered, tuix.Text(
+			fmt.Sprintf("%-14s %s", wizardSteps[i].label+":", utils.If(values[i] == "", "-", values[i])),
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		))
+	}
+
+	current := wizardSteps[step]
+	hint := current.hint
+	if step == 2 {
+		hint += " Available: " + strings.Join(available, ", ")
+	}
+
+	var input tuix.Element
+	if step < len(values) {
+		input = components.Input(
+			">",
+			"_",
+			true,
+			values[step],
+			func(value string) {
+				next := append([]string{}, values...)
+				next[step] = value
+				setValues(next)
+			},
+		)
+	} else {
+		input = Menu(tuix.Props{Values: map[string]any{
+			"items":   []string{"Project (.zipcode/agents)", "Global (~/.zipcode/agents)"},
+			"visible": visible,
+		}}, finish, nil)
+	}
+
+	return tuix.Box(
+		tuix.Props{Direction: tuix.Column},
 		tuix.NewStyle(),
-		tuix.Text("All Agents", tuix.NewStyle()),
-		tuix.Text("No Agents found", tuix.NewStyle()),
+		tuix.Text("Create a new agent", tuix.NewStyle()),
+		view.NewLine(),
+		tuix.Box(tuix.Props{Direction: tuix.Column}, tuix.NewStyle(), entered...),
 		view.NewLine(),
-		tuix.Text("Create New Agent", tuix.NewStyle()),
+		tuix.Text(current.label, tuix.NewStyle().Bold(true)),
+		tuix.If(hint != "", tuix.Text(hint, tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb"))), view.Empty()),
+		input,
+		view.NewLine(),
+		tuix.If(status != "", tuix.Text(status, tuix.NewStyle().Foreground(tuix.Hex("#e5534b"))), view.Empty()),
+		tuix.Text(
+			"Enter to continue. Esc to cancel.",
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		),
 	)
 }
+
+func validateWizardStep(
+	step int,
+	value string,
+	registry *subagents.Registry,
+	runtime *agent.Runtime,
+) error {
+	switch step {
+	case 0:
+		if !subagents.ValidName(value) {
+			return errors.New("Use letters, digits, - and _ for the name")
+		}
+		if _, exists := registry.Get(value); exists {
+			return fmt.Errorf("An agent named %s already exists", value)
+		}
+	case 2:
+		for _, name := range splitTools(value) {
+			if _, ok := runtime.ToolRegistry.Get(name); !ok {
+				return fmt.Errorf("Unknown tool %s", name)
+			}
+		}
+	case 4:
+		if value == "" {
+			return errors.New("The system prompt cannot be empty")
+		}
+	}
+	return nil
+}
+
+func splitTools(value string) []string {
+	var out []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func agentTools(s *subagents.Subagent) string {
+	if len(s.AllowedTools) == 0 {
+		return "none"
+	}
+	return strings.Join(s.AllowedTools, ", ")
+}
+
+func agentModel(s *subagents.Subagent) string {
+	model := s.Model
+	if model == "" {
+		model = utils.If(s.Provider == "", "current model", "default model")
+	}
+	if s.Provider != "" {
+		model = s.Provider + " / " + model
+	}
+	if s.MaxTokens > 0 {
+		model += fmt.Sprintf(", max %d tokens", s.MaxTokens)
+	}
+	if s.Temperature > 0 {
+		model += fmt.Sprintf(", temperature %g", s.Temperature)
+	}
+	if s.ReasoningEffort != "" {
+		model += fmt.Sprintf(", %s reasoning", s.ReasoningEffort)
+	}
+	return model
+}
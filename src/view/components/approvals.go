@@ -0,0 +1,94 @@
+package view
+
+import (
+	"fmt"
+	"time"
+
+	"zipcode/src/agent"
+	view "zipcode/src/ui/components/utils"
+	"zipcode/src/utils"
+	"zipcode/src/view/viewctx"
+
+	"github.com/anirban1809/tuix/tuix"
+)
+
+func Approvals(props tuix.Props) tuix.Element {
+	runtime, _ := props.Get("runtime").(*agent.Runtime)
+	visible, _ := props.Get("visible").(bool)
+	setActiveView, _ := props.Get("setActiveView").(func(string))
+	context := tuix.UseContext(viewctx.MainContext)
+
+	if runtime == nil || runtime.Workspace == nil || runtime.Workspace.Session == nil {
+		return tuix.Box(
+			tuix.Props{
+				Direction: tuix.Column,
+				Padding:   [4]int{1, 1, 1, 1},
+			},
+			tuix.NewStyle(),
+			tuix.Text("Approvals are unavailable without a session.", tuix.NewStyle()),
+			tuix.Text("Press Esc to go back.", tuix.NewStyle()),
+		)
+	}
+
+	session := runtime.Workspace.Session
+	approvals := session.ListApprovals()
+
+	if len(approvals) == 0 {
+		return tuix.Box(
+			tuix.Props{
+				Direction: tuix.Column,
+				Padding:   [4]int{1, 1, 1, 1},
+			},
+			tuix.NewStyle(),
+			tuix.Text("Session Approvals", tuix.NewStyle()),
+			view.NewLine(),
+			tuix.Text("Nothing is always allowed in this session.", tuix.NewStyle()),
+			view.NewLine(),
+			tuix.Text(
+				"Choose an \"always allow\" answer when a tool asks for approval to add one.",
+				tuix.NewStyle(),
+			),
+			tuix.Text("Press Esc to go back.", tuix.NewStyle()),
+		)
+	}
+
+	labels := make([]string, len(approvals))
+	for i, a := range approvals {
+		labels[i] = fmt.Sprintf(
+			"%-10s %-40s %s",
+			a.Scope,
+			a.Label(),
+			utils.HumanTime(a.GrantedAt.Format(time.RFC3339)),
+		)
+	}
+
+	return tuix.Box(
+		tuix.Props{
+			Direction: tuix.Column,
+			Padding:   [4]int{1, 1, 1, 1},
+		},
+		tuix.NewStyle(),
+		tuix.Text("Session Approvals", tuix.NewStyle()),
+		view.NewLine(),
+		Menu(tuix.Props{Values: map[string]any{
+			"items":    labels,
+			"visible":  visible,
+			"viewSize": 8,
+		}}, func(selected string, i int) {
+			if err := session.Revoke(i); err != nil {
+				agent.EventManager.WriteToChannel(
+					agent.NOTIFICATION_CHANNEL,
+					agent.Notification{
+						Type:    agent.ERROR,
+						Message: "Failed to revoke approval: " + err.Error(),
+					},
+				)
+			}
+			if setActiveView != nil {
+				context.SetFocusPrompt(true)
+				setActiveView("")
+			}
+		}, nil),
+		tuix.Text("Enter to revoke. Esc to go back.", tuix.NewStyle()),
+	)
+}
@@ -8,16 +8,11 @@ import (
 	"zipcode/src/agent"
 	"zipcode/src/config"
 	llm "zipcode/src/llm/provider"
+	"zipcode/src/utils"
 
 	"github.com/anirban1809/tuix/tuix"
 )
 
-const charsPerToken = 4
-
-func estimateTokens(s string) int {
-	return len(s) / charsPerToken
-}
-
 func formatTokens(n int) string {
 	if n < 1000 {
 		return fmt.Sprintf("%d", n)
@@ -30,8 +25,9 @@ func formatTokens(n int) string {
 
 // Context renders a breakdown of token usage for the current session. The
 // "Used" / "Last response" / "Session output" figures are exact and come from
-// per-message Usage saved in the session file. The category breakdown is a
-// char/4 approximation — no local tokenizer is run.
+// per-message Usage saved in the session file. "Next request" and the
+// category breakdown are counted locally with the model's tokenizer, or
+// estimated (marked with ~) when it is not installed.
 func Context(props tuix.Props) tuix.Element {
 	runtime := props.Get("runtime").(*agent.Runtime)
 
@@ -48,20 +44,35 @@ func Context(props tuix.Props) tuix.Element {
 	}
 
 	messages := sessionMessages(runtime)
-	latestInput, latestOutput, sessionOutput := walkUsage(messages)
+	latestInput, latestOutput, sessionOutput, sessionReasoning := walkUsage(messages)
+
+	modelID := config.Cfg.CurrentModel
+	ctxWindow := lookupContextWindow(runtime, modelID)
+
+	exact := true
+	count := func(text string) int {
+		n, ok := runtime.Registry.CountText(
+			llm.ProviderName(config.Cfg.ActiveProviderName),
+			modelID,
+			text,
+		)
+		exact = exact && ok
+		return n
+	}
 
 	systemPrompt := runtime.Agent.SystemPrompt
-	sysTokens := estimateTokens(systemPrompt)
-	wsTokens, skillsTokens := breakdownSystemPrompt(systemPrompt)
+	sysTokens := count(systemPrompt)
+	wsTokens, skillsTokens := breakdownSystemPrompt(systemPrompt, count)
 
 	toolJSON, _ := json.Marshal(runtime.Tools)
-	toolTokens := estimateTokens(string(toolJSON))
+	toolTokens := count(string(toolJSON))
 
-	userTokens, asstTokens, toolMsgTokens := breakdownMessages(messages)
+	userTokens, asstTokens, toolMsgTokens := breakdownMessages(messages, count)
 	msgTotal := userTokens + asstTokens + toolMsgTokens
 
-	modelID := config.Cfg.CurrentModel
-	ctxWindow := lookupContextWindow(runtime, modelID)
+	next := runtime.Agent.CountTokens()
+	exact = exact && next.Exact
+	approx := utils.If(exact, " ", "~")
 
 	lines := []string{
 		"Context Usage",
@@ -86,27 +97,86 @@ func Context(props tuix.Props) tuix.Element {
 	} else {
 		lines = append(lines, "(no usage recorded yet — send a prompt first)")
 	}
+	if ctxWindow > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"Next request:   %s%s (%.1f%%, compacts at %s)",
+			approx,
+			formatTokens(next.Total()),
+			float64(next.Total())/float64(ctxWindow)*100,
+			formatTokens(runtime.CompactThreshold()),
+		))
+	} else {
+		lines = append(lines, fmt.Sprintf("Next request:   %s%s", approx, formatTokens(next.Total())))
+	}
 
+	breakdown := "Breakdown (model tokenizer):"
+	if !exact {
+		breakdown = "Breakdown (estimated, tokenizer not installed):"
+	}
 	lines = append(lines,
 		"",
-		"Breakdown (approx, char/4):",
-		fmt.Sprintf("  System prompt   ~%s", formatTokens(sysTokens)),
+		breakdown,
+		fmt.Sprintf("  System prompt   %s%s", approx, formatTokens(sysTokens)),
 	)
 	if wsTokens > 0 {
-		lines = append(lines, fmt.Sprintf("    Workspace     ~%s", formatTokens(wsTokens)))
+		lines = append(lines, fmt.Sprintf("    Workspace     %s%s", approx, formatTokens(wsTokens)))
 	}
 	if skillsTokens > 0 {
-		lines = append(lines, fmt.Sprintf("    Skills        ~%s", formatTokens(skillsTokens)))
+		lines = append(lines, fmt.Sprintf("    Skills        %s%s", approx, formatTokens(skillsTokens)))
 	}
 	lines = append(lines,
-		fmt.Sprintf("  Tool schemas    ~%s", formatTokens(toolTokens)),
-		fmt.Sprintf("  Messages        ~%s", formatTokens(msgTotal)),
-		fmt.Sprintf("    user          ~%s", formatTokens(userTokens)),
-		fmt.Sprintf("    assistant     ~%s", formatTokens(asstTokens)),
-		fmt.Sprintf("    tool          ~%s", formatTokens(toolMsgTokens)),
+		fmt.Sprintf("  Tool schemas    %s%s", approx, formatTokens(toolTokens)),
+		fmt.Sprintf("  Messages        %s%s", approx, formatTokens(msgTotal)),
+		fmt.Sprintf("    user          %s%s", approx, formatTokens(userTokens)),
+		fmt.Sprintf("    assistant     %s%s", approx, formatTokens(asstTokens)),
+		fmt.Sprintf("    tool          %s%s", approx, formatTokens(toolMsgTokens)),
 		"",
 		fmt.Sprintf("Last response:   %s tokens", formatTokens(latestOutput)),
 		fmt.Sprintf("Session output:  %s tokens", formatTokens(sessionOutput)),
+	)
+	if sessionReasoning > 0 {
+		lines = append(lines, fmt.Sprintf("  reasoning      %s tokens", formatTokens(sessionReasoning)))
+	}
+	effort := config.Cfg.ReasoningEffort[modelID]
+	if effort == "" {
+		effort = runtime.Registry.EffortFor(llm.ProviderName(config.Cfg.ActiveProviderName), modelID)
+	}
+	if effort != "" {
+		lines = append(lines, fmt.Sprintf("Reasoning effort: %s", effort))
+	}
+
+	if last, session := cacheUsage(messages); session.InputTokens > 0 {
+		lines = append(lines,
+			"",
+			"Prompt cache:",
+			fmt.Sprintf(
+				"  Last request    %.1f%% read, %s written",
+				cacheHitRate(last),
+				formatTokens(last.CacheCreationInputTokens),
+			),
+			fmt.Sprintf(
+				"  Session         %.1f%% read, %s written",
+				cacheHitRate(session),
+				formatTokens(session.CacheCreationInputTokens),
+			),
+		)
+	}
+
+	if usage := runtime.SubagentUsage(); len(usage) > 0 {
+		lines = append(lines, "", "Sub-agents (not in this context):")
+		for _, u := range usage {
+			lines = append(lines, fmt.Sprintf(
+				"  %-20s %-30s %d run(s)  %s\u2191 / %s\u2193",
+				u.Agent,
+				u.Model,
+				u.Runs,
+				formatTokens(u.Usage.InputTokens),
+				formatTokens(u.Usage.OutputTokens),
+			))
+		}
+	}
+
+	lines = append(lines,
 		"",
 		"Press Esc to go back.",
 	)
@@ -136,8 +206,9 @@ func sessionMessages(runtime *agent.Runtime) []llm.Message {
 
 // walkUsage returns the input tokens of the most recent assistant call (i.e.
 // the current context size), the output tokens of that same call, and the
-// sum of output tokens across the whole session.
-func walkUsage(messages []llm.Message) (latestInput, latestOutput, sessionOutput int) {
+// sum of output tokens across the whole session, of which sessionReasoning
+// were spent reasoning.
+func walkUsage(messages []llm.Message) (latestInput, latestOutput, sessionOutput, sessionReasoning int) {
 	seenLatest := false
 	for i := len(messages) - 1; i >= 0; i-- {
 		u := messages[i].Usage
@@ -150,11 +221,40 @@ func walkUsage(messages []llm.Message) (latestInput, latestOutput, sessionOutput
 			seenLatest = true
 		}
 		sessionOutput += u.OutputTokens
+		sessionReasoning += u.ReasoningTokens
 	}
 	return
 }
 
-func breakdownSystemPrompt(prompt string) (workspaceTokens, skillsTokens int) {
+// cacheUsage returns the usage of the most recent call and the sum over the
+// session, for the prompt cache figures.
+func cacheUsage(messages []llm.Message) (last, session llm.Usage) {
+	seenLast := false
+	for i := len(messages) - 1; i >= 0; i-- {
+		u := messages[i].Usage
+		if u == nil {
+			continue
+		}
+		if !seenLast {
+			last = *u
+			seenLast = true
+		}
+		session.InputTokens += u.InputTokens
+		session.CachedInputTokens += u.CachedInputTokens
+		session.CacheCreationInputTokens += u.CacheCreationInputTokens
+	}
+	return
+}
+
+// cacheHitRate is the share of input tokens read from the prompt cache.
+func cacheHitRate(u llm.Usage) float64 {
+	if u.InputTokens == 0 {
+		return 0
+	}
+	return float64(u.CachedInputTokens) / float64(u.InputTokens) * 100
+}
+
+func breakdownSystemPrompt(prompt string, count func(string) int) (workspaceTokens, skillsTokens int) {
 	wsIdx := strings.Index(prompt, "## Workspace")
 	skIdx := strings.Index(prompt, "## Available Skills")
 
@@ -163,17 +263,23 @@ func breakdownSystemPrompt(prompt string) (workspaceTokens, skillsTokens int) {
 		if skIdx > wsIdx {
 			end = skIdx
 		}
-		workspaceTokens = estimateTokens(prompt[wsIdx:end])
+		workspaceTokens = count(prompt[wsIdx:end])
 	}
 	if skIdx >= 0 {
-		skillsTokens = estimateTokens(prompt[skIdx:])
+		skillsTokens = count(prompt[skIdx:])
 	}
 	return
 }
 
-func breakdownMessages(messages []llm.Message) (user, assistant, tool int) {
+func breakdownMessages(messages []llm.Message, count func(string) int) (user, assistant, tool int) {
 	for _, m := range messages {
-		t := estimateTokens(m.Content)
+		t := count(m.Text())
+		for _, use := range m.ToolUses() {
+			t += count(use.Name) + count(use.Input)
+		}
+		for _, result := range m.ToolResults() {
+			t += count(result.Text())
+		}
 		switch m.Role {
 		case "user":
 			user += t
@@ -190,10 +296,5 @@ func lookupContextWindow(runtime *agent.Runtime, modelID string) int {
 	if runtime.CurrentProvider == nil {
 		return 0
 	}
-	for _, m := range runtime.CurrentProvider.Models() {
-		if m.ID == modelID {
-			return m.ContextWindow
-		}
-	}
-	return 0
+	return runtime.Registry.ContextWindowFor(runtime.CurrentProvider.Name(), modelID)
 }
@@ -23,7 +23,7 @@ type renderedLine struct {
 
 func FileDiff(props tuix.Props) tuix.Element {
 	fileDiff, ok := props.Get("fileDiff").(agent.FileChangeEvent)
-	if !ok {
+	if !ok || fileDiff.FileName == "" {
 		return tuix.Box(tuix.Props{}, tuix.NewStyle())
 	}
 
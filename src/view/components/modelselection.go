@@ -2,13 +2,16 @@ package view
 
 import (
 	"fmt"
+	"time"
 
 	"zipcode/src/agent"
 	"zipcode/src/config"
 	llm "zipcode/src/llm/provider"
+	"zipcode/src/utils"
 	"zipcode/src/view/viewctx"
 
 	"github.com/anirban1809/tuix/tuix"
+	"github.com/mattn/go-runewidth"
 )
 
 func ModelSelection(props tuix.Props) tuix.Element {
@@ -16,13 +19,28 @@ func ModelSelection(props tuix.Props) tuix.Element {
 	visible := props.Get("visible").(bool)
 	context := tuix.UseContext(viewctx.MainContext)
 
+	providerName := llm.ProviderName(config.Cfg.ActiveProviderName)
+	models := context.Runtime.Registry.Models(providerName)
+
+	idWidth := 0
+	for _, m := range models {
+		idWidth = max(idWidth, runewidth.StringWidth(m.ID))
+	}
 	items := []string{}
-	provider := context.Runtime.Registry.GetProvider(
-		llm.ProviderName(config.Cfg.ActiveProviderName),
-	)
-	if provider != nil {
-		for _, m := range provider.Models() {
-			items = append(items, m.ID)
+	for _, m := range models {
+		items = append(items, fmt.Sprintf(
+			"%-*s  %10s  %s",
+			idWidth,
+			m.ID,
+			utils.If(m.ContextWindow > 0, formatTokens(m.ContextWindow)+" ctx", ""),
+			modelPricing(m),
+		))
+	}
+
+	source := "built-in list"
+	if context.Runtime.Registry.Catalog != nil {
+		if fetchedAt, ok := context.Runtime.Registry.Catalog.FetchedAt(providerName); ok {
+			source = "fetched " + utils.HumanTime(fetchedAt.Format(time.RFC3339))
 		}
 	}
 
@@ -33,12 +51,17 @@ func ModelSelection(props tuix.Props) tuix.Element {
 		},
 		tuix.NewStyle(),
 		tuix.Text("Choose your model:", tuix.NewStyle()),
+		tuix.Text(
+			fmt.Sprintf("%s, USD per 1M input/output tokens", source),
+			tuix.NewStyle().Foreground(tuix.Hex("#848484")),
+		),
 		tuix.Text("", tuix.NewStyle()),
 		Menu(tuix.Props{Values: map[string]any{
 			"items":    items,
 			"visible":  visible,
 			"viewSize": 6,
-		}}, func(selected string, _ int) {
+		}}, func(_ string, index int) {
+			selected := models[index].ID
 			config.Cfg.CurrentModel = selected
 			if config.Cfg.ProviderModels == nil {
 				config.Cfg.ProviderModels = map[string]string{}
@@ -61,3 +84,12 @@ func ModelSelection(props tuix.Props) tuix.Element {
 		tuix.Text("Press Enter to confirm, Esc to cancel", tuix.NewStyle()),
 	)
 }
+
+// modelPricing renders a model's input and output price, or nothing when
+// the catalog does not know it.
+func modelPricing(m llm.ModelDescriptor) string {
+	if m.InputCostPerMillion == 0 && m.OutputCostPerMillion == 0 {
+		return ""
+	}
+	return fmt.Sprintf("$%.2f / $%.2f", m.InputCostPerMillion, m.OutputCostPerMillion)
+}
@@ -16,14 +16,12 @@ import (
 	"github.com/mattn/go-runewidth"
 )
 
-func resolveModelFor(provider llm.Provider, name string) string {
+func resolveModelFor(registry llm.Registry, name string) string {
 	if saved, ok := config.Cfg.ProviderModels[name]; ok && saved != "" {
 		return saved
 	}
-	if provider != nil {
-		if models := provider.Models(); len(models) > 0 {
-			return models[0].ID
-		}
+	if models := registry.Models(llm.ProviderName(name)); len(models) > 0 {
+		return models[0].ID
 	}
 	return config.Cfg.CurrentModel
 }
@@ -70,6 +68,7 @@ func ProviderView(props tuix.Props) tuix.Element {
 				setValueCheckStatus(
 					fmt.Sprintf("Successfully configured api key for %s", name),
 				)
+				context.Runtime.RefreshModels(true)
 			}
 		}
 
@@ -202,7 +201,7 @@ func Providers(props tuix.Props) tuix.Element {
 			llm.ProviderName(config.Cfg.ActiveProviderName),
 		)
 		config.Cfg.CurrentModel = resolveModelFor(
-			context.Runtime.CurrentProvider,
+			context.Runtime.Registry,
 			config.Cfg.ActiveProviderName,
 		)
 		config.Cfg.Save()
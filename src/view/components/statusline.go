@@ -2,6 +2,7 @@ package view
 
 import (
 	"fmt"
+	"zipcode/src/agent"
 	"zipcode/src/config"
 	llm "zipcode/src/llm/provider"
 	"zipcode/src/view/viewctx"
@@ -14,7 +15,7 @@ func StatusLine(props tuix.Props) tuix.Element {
 	workspacePath, _ := props.Get("workspacePath").(string)
 	status := "Idle"
 	if running, _ := props.Get("running").(bool); running {
-		status = "Running"
+		status = "Running (Esc to cancel)"
 	}
 
 	if skill, _ := props.Get("activeSkill").(string); skill != "" {
@@ -30,6 +31,7 @@ func StatusLine(props tuix.Props) tuix.Element {
 
 	inputTokens := 0
 	cachedInputTokens := 0
+	cacheCreationInputTokens := 0
 	outputTokens := 0
 	if v, ok := props.Get("inputTokens").(int); ok {
 		inputTokens = v
@@ -37,6 +39,9 @@ func StatusLine(props tuix.Props) tuix.Element {
 	if v, ok := props.Get("cachedInputTokens").(int); ok {
 		cachedInputTokens = v
 	}
+	if v, ok := props.Get("cacheCreationInputTokens").(int); ok {
+		cacheCreationInputTokens = v
+	}
 	if v, ok := props.Get("outputTokens").(int); ok {
 		outputTokens = v
 	}
@@ -57,7 +62,28 @@ func StatusLine(props tuix.Props) tuix.Element {
 	var providerName string
 	var modelName string
 	contextWindow := 0
-	var inputCostPerM, outputCostPerM float64
+	var subagents []agent.SubagentUsage
+	if context != nil && context.Runtime != nil {
+		subagents = context.Runtime.SubagentUsage()
+	}
+
+	// Sub-agent tokens are part of the totals shown but may be billed at
+	// another model's rates, and never occupy the main context window.
+	mainUsage := llm.Usage{
+		InputTokens:              inputTokens,
+		CachedInputTokens:        cachedInputTokens,
+		CacheCreationInputTokens: cacheCreationInputTokens,
+		OutputTokens:             outputTokens,
+	}
+	for _, s := range subagents {
+		mainUsage.InputTokens -= s.Usage.InputTokens
+		mainUsage.CachedInputTokens -= s.Usage.CachedInputTokens
+		mainUsage.CacheCreationInputTokens -= s.Usage.CacheCreationInputTokens
+		mainUsage.OutputTokens -= s.Usage.OutputTokens
+	}
+
+	sessionCost := 0.0
+	priced := false
 
 	if config.Cfg.ActiveProviderName == "" {
 		providerName = "Unconfigured"
@@ -70,30 +96,41 @@ func StatusLine(props tuix.Props) tuix.Element {
 				llm.ProviderName(config.Cfg.ActiveProviderName),
 				config.Cfg.CurrentModel,
 			)
-			inputCostPerM, outputCostPerM = context.Runtime.Registry.CostFor(
-				llm.ProviderName(config.Cfg.ActiveProviderName),
+			cost, ok := usageCost(
+				context.Runtime.Registry,
+				config.Cfg.ActiveProviderName,
 				config.Cfg.CurrentModel,
+				mainUsage,
 			)
+			sessionCost += cost
+			priced = priced || ok
 		}
 	}
 
-	// Cache reads bill at ~10% of the input rate on both Anthropic and OpenAI;
-	// keep that as a single constant rather than tracking per-model rates.
-	const cachedInputDiscount = 0.10
-	uncachedInputTokens := inputTokens - cachedInputTokens
-	sessionCost := (float64(uncachedInputTokens)*inputCostPerM +
-		float64(cachedInputTokens)*inputCostPerM*cachedInputDiscount +
-		float64(outputTokens)*outputCostPerM) / 1_000_000
+	for _, s := range subagents {
+		cost, ok := usageCost(context.Runtime.Registry, s.Provider, s.Model, s.Usage)
+		sessionCost += cost
+		priced = priced || ok
+	}
+
 	var costText string
-	if inputCostPerM > 0 || outputCostPerM > 0 {
+	if priced {
 		costText = fmt.Sprintf(" | $%.4f", sessionCost)
 	}
 
+	// The context share is what the next request would send, counted
+	// locally, rather than the session's cumulative usage.
 	var contextPctText string
 	if contextWindow > 0 {
+		next := context.Runtime.Agent.CountTokens()
+		approx := ""
+		if !next.Exact {
+			approx = "~"
+		}
 		contextPctText = fmt.Sprintf(
-			"Context: %0.2f%% (of %d)",
-			float32(totalTokens*100)/float32(contextWindow),
+			"Context: %s%0.2f%% (of %d)",
+			approx,
+			float32(next.Total()*100)/float32(contextWindow),
 			contextWindow,
 		)
 	} else {
@@ -150,3 +187,24 @@ func StatusLine(props tuix.Props) tuix.Element {
 		),
 	)
 }
+
+// usageCost prices usage at the rates of a provider's model. Reports false
+// when the model has no known rates.
+func usageCost(registry llm.Registry, provider, model string, usage llm.Usage) (float64, bool) {
+	inputCostPerM, outputCostPerM := registry.CostFor(llm.ProviderName(provider), model)
+	if inputCostPerM == 0 && outputCostPerM == 0 {
+		return 0, false
+	}
+
+	// Cache reads and writes are billed at their own rates; Anthropic charges
+	// extra to write the cache and a tenth of the input rate to read it.
+	// Reasoning tokens are part of OutputTokens and billed as output.
+	readCostPerM, writeCostPerM := registry.CacheCostFor(llm.ProviderName(provider), model)
+	cached := min(usage.CachedInputTokens, usage.InputTokens)
+	written := min(usage.CacheCreationInputTokens, usage.InputTokens-cached)
+	uncached := usage.InputTokens - cached - written
+	return (float64(uncached)*inputCostPerM +
+		float64(cached)*readCostPerM +
+		float64(written)*writeCostPerM +
+		float64(usage.OutputTokens)*outputCostPerM) / 1_000_000, true
+}
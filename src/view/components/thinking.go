@@ -0,0 +1,48 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anirban1809/tuix/tuix"
+)
+
+// Thinking renders a model's reasoning in the output pane. Collapsed, it is
+// a single line with the size of the reasoning; expanded, the full text.
+// /thinking toggles every block at once.
+func Thinking(props tuix.Props) tuix.Element {
+	text, _ := props.Get("text").(string)
+	expanded, _ := props.Get("expanded").(bool)
+	streaming, _ := props.Get("streaming").(bool)
+
+	text = strings.TrimSpace(text)
+	lines := strings.Count(text, "\n") + 1
+
+	label := "Thought"
+	if streaming {
+		label = "Thinking..."
+	}
+	headerStyle := tuix.NewStyle().Foreground(tuix.Hex("#8a8fa3"))
+
+	if !expanded {
+		return tuix.Box(
+			tuix.Props{Padding: [4]int{0, 2, 0, 2}},
+			tuix.NewStyle(),
+			tuix.Text(
+				fmt.Sprintf("▸ %s (%d lines, /thinking to expand)", label, lines),
+				headerStyle,
+			),
+		)
+	}
+
+	return tuix.Box(
+		tuix.Props{Direction: tuix.Column, Padding: [4]int{0, 2, 0, 2}},
+		tuix.NewStyle(),
+		tuix.Text("▾ "+label, headerStyle),
+		tuix.Box(
+			tuix.Props{Padding: [4]int{0, 0, 0, 2}},
+			tuix.NewStyle(),
+			tuix.WrappedText(text, tuix.NewStyle().Foreground(tuix.Hex("#6f7385"))),
+		),
+	)
+}
@@ -0,0 +1,263 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+
+	"zipcode/src/agent"
+	llm "zipcode/src/llm/provider"
+	view "zipcode/src/ui/components/utils"
+	"zipcode/src/workspace"
+
+	"github.com/anirban1809/tuix/tuix"
+)
+
+// traceViewSize is how many transcript lines are shown at once.
+const traceViewSize = 20
+
+// Traces lists the sub-agent runs of the current session. Selecting one
+// expands its full transcript.
+func Traces(props tuix.Props) tuix.Element {
+	runtime, _ := props.Get("runtime").(*agent.Runtime)
+	visible, _ := props.Get("visible").(bool)
+	selected, setSelected := tuix.UseState(-1)
+
+	if !visible && selected != -1 {
+		setSelected(-1)
+	}
+
+	if runtime == nil || runtime.Workspace == nil || runtime.Workspace.Session == nil {
+		return tuix.Box(
+			tuix.Props{
+				Direction: tuix.Column,
+				Padding:   [4]int{1, 1, 1, 1},
+			},
+			tuix.NewStyle(),
+			tuix.Text("Traces are unavailable without a session.", tuix.NewStyle()),
+			tuix.Text("Press Esc to go back.", tuix.NewStyle()),
+		)
+	}
+
+	runs := runtime.Workspace.Session.ListSubagentRuns()
+	if len(runs) == 0 {
+		return tuix.Box(
+			tuix.Props{
+				Direction: tuix.Column,
+				Padding:   [4]int{1, 1, 1, 1},
+			},
+			tuix.NewStyle(),
+			tuix.Text("Sub-agent Traces", tuix.NewStyle()),
+			view.NewLine(),
+			tuix.Text("No sub-agent has run in this session yet.", tuix.NewStyle()),
+			tuix.Text("Press Esc to go back.", tuix.NewStyle()),
+		)
+	}
+
+	// Newest first, since the run just seen in the output is usually the
+	// one to expand.
+	labels := make([]string, len(runs))
+	for i := range runs {
+		run := runs[len(runs)-1-i]
+		labels[i] = fmt.Sprintf(
+			"%-20s %-10s %8s  %s",
+			run.Agent,
+			run.Status,
+			formatTokens(run.Usage.InputTokens+run.Usage.OutputTokens),
+			firstLine(run.Task, 60),
+		)
+	}
+
+	var run workspace.SubagentRun
+	if selected >= 0 && selected < len(runs) {
+		run = runs[selected]
+	}
+	trace := Trace(tuix.Props{Values: map[string]any{
+		"visible": visible && selected != -1,
+		"run":     run,
+	}})
+
+	content := tuix.Box(
+		tuix.Props{Direction: tuix.Column},
+		tuix.NewStyle(),
+		tuix.Text("Sub-agent Traces", tuix.NewStyle()),
+		view.NewLine(),
+		Menu(tuix.Props{Values: map[string]any{
+			"items":    labels,
+			"visible":  visible && selected == -1,
+			"viewSize": 8,
+		}}, func(_ string, i int) {
+			setSelected(len(runs) - 1 - i)
+		}, nil),
+		view.NewLine(),
+		tuix.Text(
+			"Enter to expand a trace. Esc to go back.",
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		),
+	)
+
+	if selected >= 0 && selected < len(runs) {
+		content = trace
+	}
+
+	return tuix.Box(
+		tuix.Props{
+			Direction: tuix.Column,
+			Padding:   [4]int{1, 1, 1, 1},
+		},
+		tuix.NewStyle(),
+		content,
+	)
+}
+
+// Trace shows one sub-agent transcript, scrolled with the arrow keys.
+// It renders nothing while hidden.
+func Trace(props tuix.Props) tuix.Element {
+	visible, _ := props.Get("visible").(bool)
+	run, _ := props.Get("run").(workspace.SubagentRun)
+	offset, setOffset := tuix.UseState(0)
+	shownID, setShownID := tuix.UseState("")
+
+	if shownID != run.ID {
+		setShownID(run.ID)
+		offset = 0
+		setOffset(0)
+	}
+	if !visible {
+		return view.Empty()
+	}
+
+	lines := traceLines(run)
+	maxOffset := max(len(lines)-traceViewSize, 0)
+
+	switch tuix.CurrentKey.Code {
+	case tuix.KeyUp:
+		if offset > 0 {
+			offset--
+			setOffset(offset)
+		}
+	case tuix.KeyDown:
+		if offset < maxOffset {
+			offset++
+			setOffset(offset)
+		}
+	}
+	offset = min(offset, maxOffset)
+
+	header := fmt.Sprintf(
+		"%s (%s) %s, %s↑ / %s↓ tokens",
+		run.Agent,
+		run.Model,
+		run.Status,
+		formatTokens(run.Usage.InputTokens),
+		formatTokens(run.Usage.OutputTokens),
+	)
+
+	body := make([]tuix.Element, 0, traceViewSize)
+	for _, line := range lines[offset:min(offset+traceViewSize, len(lines))] {
+		body = append(body, tuix.WrappedText(line.text, line.style))
+	}
+
+	return tuix.Box(
+		tuix.Props{Direction: tuix.Column},
+		tuix.NewStyle(),
+		tuix.Text(header, tuix.NewStyle().Bold(true)),
+		view.NewLine(),
+		tuix.Box(tuix.Props{Direction: tuix.Column}, tuix.NewStyle(), body...),
+		view.NewLine(),
+		tuix.Text(
+			fmt.Sprintf(
+				"Lines %d-%d of %d. Up/Down to scroll, Esc to go back.",
+				min(offset+1, len(lines)),
+				min(offset+traceViewSize, len(lines)),
+				len(lines),
+			),
+			tuix.NewStyle().Foreground(tuix.Hex("#cbcbcb")),
+		),
+	)
+}
+
+type traceLine struct {
+	text  string
+	style tuix.Style
+}
+
+func traceLines(run workspace.SubagentRun) []traceLine {
+	dim := tuix.NewStyle().Foreground(tuix.Hex("#848484"))
+	plain := tuix.NewStyle().Foreground(tuix.Hex("#c8c8c8"))
+	role := tuix.NewStyle().Foreground(tuix.Hex("#64c3ff")).Bold(true)
+
+	var lines []traceLine
+	add := func(text string, style tuix.Style) {
+		for _, l := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			lines = append(lines, traceLine{text: l, style: style})
+		}
+	}
+
+	for _, m := range run.Messages {
+		add(traceRole(m), role)
+		for _, b := range m.Content {
+			switch b.Type {
+			case llm.BlockText:
+				if strings.TrimSpace(b.Text) != "" {
+					add(b.Text, plain)
+				}
+			case llm.BlockImage, llm.BlockDocument:
+				add(traceMedia(b), dim)
+			case llm.BlockToolUse:
+				add(fmt.Sprintf("→ %s(%s)", b.ToolUse.Name, b.ToolUse.Input), dim)
+			case llm.BlockToolResult:
+				if b.ToolResult.IsError {
+					add("error:", dim)
+				}
+				for _, c := range b.ToolResult.Content {
+					if c.Type == llm.BlockText {
+						add(c.Text, dim)
+					} else {
+						add(traceMedia(c), dim)
+					}
+				}
+			}
+		}
+		lines = append(lines, traceLine{style: plain})
+	}
+
+	if run.Error != "" {
+		add("error: "+run.Error, tuix.NewStyle().Foreground(tuix.Hex("#ff8282")))
+	}
+	return lines
+}
+
+func traceMedia(b llm.ContentBlock) string {
+	if b.Media == nil {
+		return "[" + string(b.Type) + "]"
+	}
+	return fmt.Sprintf("[%s %s, %s]", b.Type, b.Media.Name, b.Media.MediaType)
+}
+
+func traceRole(m llm.Message) string {
+	switch m.Role {
+	case "tool":
+		return "tool result"
+	case "assistant":
+		if m.Usage != nil {
+			return fmt.Sprintf(
+				"assistant (%s↑ / %s↓)",
+				formatTokens(m.Usage.InputTokens),
+				formatTokens(m.Usage.OutputTokens),
+			)
+		}
+	}
+	return m.Role
+}
+
+// firstLine returns the first line of s, cut to n runes.
+func firstLine(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
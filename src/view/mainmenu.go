@@ -1,6 +1,7 @@
 package view
 
 import (
+	gocontext "context"
 	"os"
 	"strings"
 
@@ -36,6 +37,7 @@ func MainMenu(props tuix.Props) tuix.Element {
 	setFocusPrompt := props.Get("setFocusPrompt").(func(bool))
 	clearPrompt, _ := props.Get("clearPrompt").(func())
 	clearOutputs, _ := props.Get("clearOutputs").(func())
+	toggleThinking, _ := props.Get("toggleThinking").(func())
 
 	dismissMenu := func() {
 		if clearPrompt != nil {
@@ -49,7 +51,9 @@ func MainMenu(props tuix.Props) tuix.Element {
 		{Name: "/models", Kind: CmdView},
 		{Name: "/skills", Kind: CmdView},
 		{Name: "/agents", Kind: CmdView},
+		{Name: "/traces", Kind: CmdView},
 		{Name: "/sessions", Kind: CmdView},
+		{Name: "/approvals", Kind: CmdView},
 		{Name: "/context", Kind: CmdView},
 		{Name: "/settings", Kind: CmdView},
 		{
@@ -57,7 +61,12 @@ func MainMenu(props tuix.Props) tuix.Element {
 			Kind:   CmdPrompt,
 			Prompt: "Tell me about this project.",
 		},
-		{Name: "/exit", Kind: CmdAction, Run: func() { os.Exit(0) }},
+		{Name: "/exit", Kind: CmdAction, Run: func() {
+			if context.Runtime != nil {
+				context.Runtime.Close()
+			}
+			os.Exit(0)
+		}},
 		{Name: "/clear", Kind: CmdAction, Run: func() {
 			if context.Runtime != nil {
 				context.Runtime.Clear()
@@ -74,6 +83,12 @@ func MainMenu(props tuix.Props) tuix.Element {
 				},
 			)
 		}},
+		{Name: "/thinking", Kind: CmdAction, Run: func() {
+			if toggleThinking != nil {
+				toggleThinking()
+			}
+			dismissMenu()
+		}},
 		{Name: "/compact", Kind: CmdAction, Run: func() {
 			if context.Runtime == nil {
 				return
@@ -88,7 +103,8 @@ func MainMenu(props tuix.Props) tuix.Element {
 						Message: "Compacting conversation...",
 					},
 				)
-				if _, err := runtime.Compact(); err != nil {
+				summary, err := runtime.Compact(gocontext.Background())
+				if err != nil {
 					agent.EventManager.WriteToChannel(
 						agent.NOTIFICATION_CHANNEL,
 						agent.Notification{
@@ -98,6 +114,16 @@ func MainMenu(props tuix.Props) tuix.Element {
 					)
 					return
 				}
+				if summary == "" {
+					agent.EventManager.WriteToChannel(
+						agent.NOTIFICATION_CHANNEL,
+						agent.Notification{
+							Type:    agent.INFO,
+							Message: "Nothing older than the recent turns to compact.",
+						},
+					)
+					return
+				}
 				agent.EventManager.WriteToChannel(
 					agent.NOTIFICATION_CHANNEL,
 					agent.Notification{
@@ -152,13 +178,26 @@ func MainMenu(props tuix.Props) tuix.Element {
 		"runtime":       context.Runtime,
 	}})
 
-	agentsView := view.Agent(tuix.Props{})
+	agentsView := view.Agent(tuix.Props{Values: map[string]any{
+		"visible": activeView == "/agents",
+		"runtime": context.Runtime,
+	}})
+	tracesView := view.Traces(tuix.Props{Values: map[string]any{
+		"visible": activeView == "/traces",
+		"runtime": context.Runtime,
+	}})
 	sessionsView := view.Sessions(tuix.Props{Values: map[string]any{
 		"setActiveView": setActiveView,
 		"visible":       activeView == "/sessions",
 		"runtime":       context.Runtime,
 	}})
 
+	approvalsView := view.Approvals(tuix.Props{Values: map[string]any{
+		"setActiveView": setActiveView,
+		"visible":       activeView == "/approvals",
+		"runtime":       context.Runtime,
+	}})
+
 	providersView := view.Providers(
 		tuix.Props{
 			Values: map[string]any{
@@ -177,6 +216,9 @@ func MainMenu(props tuix.Props) tuix.Element {
 	}
 
 	if activeView == "/exit" {
+		if context.Runtime != nil {
+			context.Runtime.Close()
+		}
 		os.Exit(0)
 	}
 
@@ -188,10 +230,18 @@ func MainMenu(props tuix.Props) tuix.Element {
 		return agentsView
 	}
 
+	if activeView == "/traces" {
+		return tracesView
+	}
+
 	if activeView == "/sessions" {
 		return sessionsView
 	}
 
+	if activeView == "/approvals" {
+		return approvalsView
+	}
+
 	if activeView == "/providers" {
 		return providersView
 	}
@@ -0,0 +1,131 @@
+package workspace
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ApprovalScope is how much of future tool calls a session approval covers.
+type ApprovalScope string
+
+const (
+	ApprovalFile      ApprovalScope = "file"
+	ApprovalDirectory ApprovalScope = "directory"
+	ApprovalTool      ApprovalScope = "tool"
+)
+
+// Approval is an "always allow for this session" answer to a tool call
+// prompt. File and directory approvals only cover the tool they were given
+// for.
+type Approval struct {
+	Scope     ApprovalScope `json:"scope"`
+	Tool      string        `json:"tool"`
+	Path      string        `json:"path,omitempty"`
+	GrantedAt time.Time     `json:"granted_at"`
+}
+
+// Label describes the approval for prompts and the approvals view.
+func (a Approval) Label() string {
+	switch a.Scope {
+	case ApprovalFile:
+		return fmt.Sprintf("%s on %s", a.Tool, a.Path)
+	case ApprovalDirectory:
+		return fmt.Sprintf("%s in %s/", a.Tool, strings.TrimSuffix(a.Path, "/"))
+	}
+	return fmt.Sprintf("all %s calls", a.Tool)
+}
+
+// Covers reports whether the approval allows tool on every one of paths.
+// Paths are workspace-relative and slash-separated.
+func (a Approval) Covers(tool string, paths []string) bool {
+	if a.Tool != tool {
+		return false
+	}
+	if a.Scope == ApprovalTool {
+		return true
+	}
+	if len(paths) == 0 {
+		return false
+	}
+
+	for _, p := range paths {
+		switch a.Scope {
+		case ApprovalFile:
+			if p != a.Path {
+				return false
+			}
+		case ApprovalDirectory:
+			if !inDirectory(a.Path, p) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func inDirectory(dir, p string) bool {
+	if p == ".." || strings.HasPrefix(p, "../") || path.IsAbs(p) != path.IsAbs(dir) {
+		return false
+	}
+	if dir == "." {
+		return true
+	}
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// Approved returns the first session approval covering the call.
+func (s *Session) Approved(tool string, paths []string) (Approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.Approvals {
+		if a.Covers(tool, paths) {
+			return a, true
+		}
+	}
+	return Approval{}, false
+}
+
+// ListApprovals returns a copy of the session approvals.
+func (s *Session) ListApprovals() []Approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Approval{}, s.Approvals...)
+}
+
+// Grant records an approval and saves the session. Granting the same
+// approval twice is a no-op.
+func (s *Session) Grant(a Approval) error {
+	s.mu.Lock()
+	for _, existing := range s.Approvals {
+		if existing.Scope == a.Scope && existing.Tool == a.Tool && existing.Path == a.Path {
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	if a.GrantedAt.IsZero() {
+		a.GrantedAt = time.Now()
+	}
+	s.Approvals = append(s.Approvals, a)
+	s.mu.Unlock()
+
+	return s.Save()
+}
+
+// Revoke removes the approval at index i and saves the session.
+func (s *Session) Revoke(i int) error {
+	s.mu.Lock()
+	if i < 0 || i >= len(s.Approvals) {
+		s.mu.Unlock()
+		return fmt.Errorf("approval %d does not exist", i)
+	}
+	s.Approvals = append(s.Approvals[:i], s.Approvals[i+1:]...)
+	s.mu.Unlock()
+
+	return s.Save()
+}
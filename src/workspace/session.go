@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	llm "zipcode/src/llm/provider"
@@ -17,12 +18,28 @@ import (
 
 const sessionsDir = ".zipcode/sessions"
 
+// sessionVersion is the format sessions are saved in. Version 2 stores
+// messages as content blocks; files without a version hold the older
+// chat-style messages, which llm.Message still decodes.
+const sessionVersion = 2
+
 type Session struct {
+	Version   int           `json:"version"`
 	ID        string        `json:"id"`
 	StartedAt time.Time     `json:"started_at"`
 	Workspace string        `json:"workspace"`
 	Messages  []llm.Message `json:"messages,omitempty"`
-	Path      string        `json:"-"`
+	Approvals []Approval    `json:"approvals,omitempty"`
+	// SubagentRuns holds the transcripts of sub-agent calls made in this
+	// session.
+	SubagentRuns []SubagentRun `json:"subagent_runs,omitempty"`
+	Path         string        `json:"-"`
+
+	// mu guards Approvals, which the executor reads while a run is in
+	// flight and the approvals view edits, and SubagentRuns, which
+	// parallel sub-agents append to. Save holds it while writing so
+	// concurrent saves cannot interleave in the file.
+	mu sync.Mutex
 }
 
 func NewSession(workspaceRoot string) (*Session, error) {
@@ -41,6 +58,7 @@ func NewSession(workspaceRoot string) (*Session, error) {
 	}
 
 	s := &Session{
+		Version:   sessionVersion,
 		ID:        id,
 		StartedAt: time.Now(),
 		Workspace: workspaceRoot,
@@ -81,6 +99,8 @@ func addSessionsToGitignore(workspaceRoot string) error {
 }
 
 func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return err
@@ -126,6 +146,12 @@ func LoadSession(path string) (*Session, error) {
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, err
 	}
+	if s.Version > sessionVersion {
+		return nil, fmt.Errorf("session %s was saved by a newer version of zipcode", path)
+	}
+	// Messages were upgraded as they were decoded; the next save writes
+	// them back in the current format.
+	s.Version = sessionVersion
 	s.Path = path
 	return &s, nil
 }
@@ -0,0 +1,101 @@
+package workspace
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	llm "zipcode/src/llm/provider"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestLoadLegacySession loads a session saved before messages were made of
+// content blocks and checks that it is written back as the golden
+// version 2 file, and that the file reads back unchanged.
+func TestLoadLegacySession(t *testing.T) {
+	s, err := LoadSession(filepath.Join("testdata", "legacy_session.json"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if s.Version != sessionVersion {
+		t.Errorf("version = %d, want %d", s.Version, sessionVersion)
+	}
+
+	s.Path = filepath.Join(t.TempDir(), "session.json")
+	if err := s.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	saved, err := os.ReadFile(s.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "legacy_session.golden.json")
+	if *update {
+		if err := os.WriteFile(golden, saved, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, want) {
+		t.Errorf("upgraded session differs from %s:\n%s", golden, saved)
+	}
+
+	reloaded, err := LoadSession(s.Path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded.Messages, s.Messages) {
+		t.Errorf("messages changed on the round trip:\n%+v\nwant\n%+v", reloaded.Messages, s.Messages)
+	}
+}
+
+func TestLegacySessionBlocks(t *testing.T) {
+	s, err := LoadSession(filepath.Join("testdata", "legacy_session.json"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(s.Messages) != 6 {
+		t.Fatalf("loaded %d messages, want 6", len(s.Messages))
+	}
+
+	user := s.Messages[1].Content
+	if len(user) != 2 || user[0].Type != llm.BlockText || user[1].Type != llm.BlockImage ||
+		user[1].Media.Name != "screen.png" {
+		t.Errorf("user blocks = %+v, want the text then the image", user)
+	}
+
+	// Reasoning, then text, then the tool uses in call order.
+	var types []llm.BlockType
+	for _, b := range s.Messages[2].Content {
+		types = append(types, b.Type)
+	}
+	wantTypes := []llm.BlockType{llm.BlockReasoning, llm.BlockText, llm.BlockToolUse, llm.BlockToolUse}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("assistant blocks = %v, want %v", types, wantTypes)
+	}
+	uses := s.Messages[2].ToolUses()
+	if len(uses) != 2 || uses[0].ID != "call_a" || uses[1].Input != `{"path":"missing.go"}` {
+		t.Errorf("tool uses = %+v", uses)
+	}
+	if usage := s.Messages[2].Usage; usage == nil || usage.InputTokens != 120 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	for i, want := range []struct {
+		id      string
+		isError bool
+	}{{"call_a", false}, {"call_b", true}} {
+		results := s.Messages[3+i].ToolResults()
+		if len(results) != 1 || results[0].ToolUseID != want.id || results[0].IsError != want.isError {
+			t.Errorf("tool message %d = %+v, want %s with IsError %v", i, results, want.id, want.isError)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package workspace
+
+import (
+	"time"
+
+	llm "zipcode/src/llm/provider"
+)
+
+// SubagentStatus is how a sub-agent run ended.
+type SubagentStatus string
+
+const (
+	SubagentCompleted SubagentStatus = "completed"
+	SubagentFailed    SubagentStatus = "failed"
+	SubagentCancelled SubagentStatus = "cancelled"
+)
+
+// SubagentRun is the transcript of one sub-agent call. Only the final
+// answer goes back to the main conversation, so the full exchange is kept
+// here for inspection.
+type SubagentRun struct {
+	// ID is the id of the subagent tool call that started the run.
+	ID         string         `json:"id"`
+	Agent      string         `json:"agent"`
+	Task       string         `json:"task"`
+	Context    string         `json:"context,omitempty"`
+	Provider   string         `json:"provider,omitempty"`
+	Model      string         `json:"model,omitempty"`
+	Status     SubagentStatus `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Messages   []llm.Message  `json:"messages,omitempty"`
+	Usage      llm.Usage      `json:"usage"`
+}
+
+// RecordSubagentRun adds a finished run to the session and saves it.
+// Parallel sub-agents call this as each one finishes.
+func (s *Session) RecordSubagentRun(run SubagentRun) error {
+	s.mu.Lock()
+	s.SubagentRuns = append(s.SubagentRuns, run)
+	s.mu.Unlock()
+
+	return s.Save()
+}
+
+// ListSubagentRuns returns a copy of the recorded runs, oldest first.
+func (s *Session) ListSubagentRuns() []SubagentRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]SubagentRun{}, s.SubagentRuns...)
+}